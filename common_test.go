@@ -1,6 +1,8 @@
 package git
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -18,6 +20,14 @@ import (
 
 func Test(t *testing.T) { TestingT(t) }
 
+func init() {
+	// the tests must not depend on the global and system configuration of
+	// the environment running them
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	os.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(os.TempDir(), "go-git-no-config"))
+}
+
 type BaseSuite struct {
 	fixtures.Suite
 	Repository *Repository
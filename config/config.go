@@ -50,6 +50,13 @@ type Config struct {
 		Window uint
 	}
 
+	Extensions struct {
+		// PartialClone is the name of the promisor remote of a partial
+		// clone, the objects missing in the repository are fetched from it
+		// on demand.
+		PartialClone string
+	}
+
 	// Remotes list of repository remotes, the key of the map is the name
 	// of the remote, should equal to RemoteConfig.Name.
 	Remotes map[string]*RemoteConfig
@@ -59,6 +66,10 @@ type Config struct {
 	// Branches list of branches, the key is the branch name and should
 	// equal Branch.Name
 	Branches map[string]*Branch
+	// URLs list of url.<base>.insteadOf rewriting rules, the key is the base
+	// and should equal URL.Name. The rules are applied to the URLs of the
+	// Remotes when the config is unmarshaled.
+	URLs map[string]*URL
 	// Raw contains the raw information of a config file. The main goal is
 	// preserve the parsed information from the original format, to avoid
 	// dropping unsupported fields.
@@ -71,6 +82,7 @@ func NewConfig() *Config {
 		Remotes:    make(map[string]*RemoteConfig),
 		Submodules: make(map[string]*Submodule),
 		Branches:   make(map[string]*Branch),
+		URLs:       make(map[string]*URL),
 		Raw:        format.New(),
 	}
 
@@ -101,6 +113,16 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for name, u := range c.URLs {
+		if u.Name != name {
+			return ErrInvalid
+		}
+
+		if err := u.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -108,6 +130,7 @@ const (
 	remoteSection    = "remote"
 	submoduleSection = "submodule"
 	branchSection    = "branch"
+	urlSection       = "url"
 	coreSection      = "core"
 	packSection      = "pack"
 	fetchKey         = "fetch"
@@ -116,12 +139,20 @@ const (
 	worktreeKey      = "worktree"
 	windowKey        = "window"
 	mergeKey         = "merge"
+	insteadOfKey     = "insteadOf"
 
 	// DefaultPackWindow holds the number of previous objects used to
 	// generate deltas. The value 10 is the same used by git command.
 	DefaultPackWindow = uint(10)
 )
 
+const (
+	extensionsSection     = "extensions"
+	partialCloneKey       = "partialclone"
+	promisorKey           = "promisor"
+	partialCloneFilterKey = "partialclonefilter"
+)
+
 // Unmarshal parses a git-config file and stores it.
 func (c *Config) Unmarshal(b []byte) error {
 	r := bytes.NewBuffer(b)
@@ -132,16 +163,23 @@ func (c *Config) Unmarshal(b []byte) error {
 		return err
 	}
 
+	return c.unmarshalRaw()
+}
+
+// unmarshalRaw fills the fields of the config with the options of Raw.
+func (c *Config) unmarshalRaw() error {
 	c.unmarshalCore()
 	if err := c.unmarshalPack(); err != nil {
 		return err
 	}
+	c.unmarshalExtensions()
 	unmarshalSubmodules(c.Raw, c.Submodules)
 
 	if err := c.unmarshalBranches(); err != nil {
 		return err
 	}
 
+	c.unmarshalURLs()
 	return c.unmarshalRemotes()
 }
 
@@ -169,6 +207,11 @@ func (c *Config) unmarshalPack() error {
 	return nil
 }
 
+func (c *Config) unmarshalExtensions() {
+	s := c.Raw.Section(extensionsSection)
+	c.Extensions.PartialClone = s.Options.Get(partialCloneKey)
+}
+
 func (c *Config) unmarshalRemotes() error {
 	s := c.Raw.Section(remoteSection)
 	for _, sub := range s.Subsections {
@@ -177,6 +220,7 @@ func (c *Config) unmarshalRemotes() error {
 			return err
 		}
 
+		r.applyURLRules(c.URLs)
 		c.Remotes[r.Name] = r
 	}
 
@@ -211,13 +255,29 @@ func (c *Config) unmarshalBranches() error {
 	return nil
 }
 
+func (c *Config) unmarshalURLs() {
+	s := c.Raw.Section(urlSection)
+	for _, sub := range s.Subsections {
+		u := &URL{}
+		u.unmarshal(sub)
+
+		if u.Validate() != nil {
+			continue
+		}
+
+		c.URLs[u.Name] = u
+	}
+}
+
 // Marshal returns Config encoded as a git-config file.
 func (c *Config) Marshal() ([]byte, error) {
 	c.marshalCore()
 	c.marshalPack()
+	c.marshalExtensions()
 	c.marshalRemotes()
 	c.marshalSubmodules()
 	c.marshalBranches()
+	c.marshalURLs()
 
 	buf := bytes.NewBuffer(nil)
 	if err := format.NewEncoder(buf).Encode(c.Raw); err != nil {
@@ -243,6 +303,15 @@ func (c *Config) marshalPack() {
 	}
 }
 
+func (c *Config) marshalExtensions() {
+	s := c.Raw.Section(extensionsSection)
+	if c.Extensions.PartialClone == "" {
+		s.RemoveOption(partialCloneKey)
+	} else {
+		s.SetOption(partialCloneKey, c.Extensions.PartialClone)
+	}
+}
+
 func (c *Config) marshalRemotes() {
 	s := c.Raw.Section(remoteSection)
 	newSubsections := make(format.Subsections, 0, len(c.Remotes))
@@ -312,6 +381,33 @@ func (c *Config) marshalBranches() {
 	s.Subsections = newSubsections
 }
 
+func (c *Config) marshalURLs() {
+	s := c.Raw.Section(urlSection)
+	newSubsections := make(format.Subsections, 0, len(c.URLs))
+	added := make(map[string]bool)
+	for _, subsection := range s.Subsections {
+		if u, ok := c.URLs[subsection.Name]; ok {
+			newSubsections = append(newSubsections, u.marshal())
+			added[subsection.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(c.URLs))
+	for name := range c.URLs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !added[name] {
+			newSubsections = append(newSubsections, c.URLs[name].marshal())
+		}
+	}
+
+	s.Subsections = newSubsections
+}
+
 // RemoteConfig contains the configuration for a given remote repository.
 type RemoteConfig struct {
 	// Name of the remote
@@ -321,10 +417,19 @@ type RemoteConfig struct {
 	URLs []string
 	// Fetch the default set of "refspec" for fetch operation
 	Fetch []RefSpec
+	// Promisor is true for the remote of a partial clone, which promises to
+	// have the objects missing in the repository.
+	Promisor bool
+	// PartialCloneFilter is the filter of the partial clone, used by
+	// default fetching from the remote, see packp.Filter.
+	PartialCloneFilter string
 
 	// raw representation of the subsection, filled by marshal or unmarshal are
 	// called
 	raw *format.Subsection
+	// rewritten are the URLs after applying the url.<base>.insteadOf rules,
+	// if any was applied, while raw keeps the original ones
+	rewritten []string
 }
 
 // Validate validates the fields and sets the default values.
@@ -366,19 +471,38 @@ func (c *RemoteConfig) unmarshal(s *format.Subsection) error {
 	c.Name = c.raw.Name
 	c.URLs = append([]string(nil), c.raw.Options.GetAll(urlKey)...)
 	c.Fetch = fetch
+	c.Promisor = c.raw.Options.Get(promisorKey) == "true"
+	c.PartialCloneFilter = c.raw.Options.Get(partialCloneFilterKey)
 
 	return nil
 }
 
+// applyURLRules rewrites the URLs of the remote with the given
+// url.<base>.insteadOf rules.
+func (c *RemoteConfig) applyURLRules(rules map[string]*URL) {
+	var rewritten bool
+	for i, u := range c.URLs {
+		c.URLs[i] = RewriteURL(rules, u)
+		rewritten = rewritten || c.URLs[i] != u
+	}
+
+	if rewritten {
+		c.rewritten = append([]string(nil), c.URLs...)
+	}
+}
+
 func (c *RemoteConfig) marshal() *format.Subsection {
 	if c.raw == nil {
 		c.raw = &format.Subsection{}
 	}
 
 	c.raw.Name = c.Name
-	if len(c.URLs) == 0 {
+	switch {
+	case len(c.URLs) == 0:
 		c.raw.RemoveOption(urlKey)
-	} else {
+	case c.rewritten != nil && equalStrings(c.URLs, c.rewritten):
+		// the original URLs are kept, instead of the rewritten ones
+	default:
 		c.raw.SetOption(urlKey, c.URLs...)
 	}
 
@@ -393,9 +517,35 @@ func (c *RemoteConfig) marshal() *format.Subsection {
 		c.raw.SetOption(fetchKey, values...)
 	}
 
+	if c.Promisor {
+		c.raw.SetOption(promisorKey, "true")
+	} else {
+		c.raw.RemoveOption(promisorKey)
+	}
+
+	if c.PartialCloneFilter == "" {
+		c.raw.RemoveOption(partialCloneFilterKey)
+	} else {
+		c.raw.SetOption(partialCloneFilterKey, c.PartialCloneFilter)
+	}
+
 	return c.raw
 }
 
 func (c *RemoteConfig) IsFirstURLLocal() bool {
 	return url.IsLocalEndpoint(c.URLs[0])
 }
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -235,3 +235,37 @@ func (s *ConfigSuite) TestRemoteConfigDefaultValues(c *C) {
 	c.Assert(config.Raw, NotNil)
 	c.Assert(config.Pack.Window, Equals, DefaultPackWindow)
 }
+
+func (s *ConfigSuite) TestPartialClone(c *C) {
+	input := []byte(`[core]
+	bare = false
+[extensions]
+	partialclone = origin
+[remote "origin"]
+	url = git@github.com:src-d/go-git.git
+	promisor = true
+	partialclonefilter = blob:none
+`)
+
+	cfg := NewConfig()
+	c.Assert(cfg.Unmarshal(input), IsNil)
+	c.Assert(cfg.Extensions.PartialClone, Equals, "origin")
+	c.Assert(cfg.Remotes["origin"].Promisor, Equals, true)
+	c.Assert(cfg.Remotes["origin"].PartialCloneFilter, Equals, "blob:none")
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	cfg.Extensions.PartialClone = ""
+	cfg.Remotes["origin"].Promisor = false
+	cfg.Remotes["origin"].PartialCloneFilter = ""
+
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, `[core]
+	bare = false
+[remote "origin"]
+	url = git@github.com:src-d/go-git.git
+`)
+}
@@ -127,6 +127,20 @@ func (s RefSpec) Dst(n plumbing.ReferenceName) plumbing.ReferenceName {
 	return plumbing.ReferenceName(dst[0:wd] + match + dst[wd+1:])
 }
 
+// Reverse returns the RefSpec with the source and the destination swapped,
+// mapping the destination references to the source ones.
+func (s RefSpec) Reverse() RefSpec {
+	spec := string(s)
+	dst := spec[strings.Index(spec, refSpecSeparator)+1:]
+
+	var force string
+	if s.IsForceUpdate() {
+		force = refSpecForce
+	}
+
+	return RefSpec(force + dst + refSpecSeparator + s.Src())
+}
+
 func (s RefSpec) String() string {
 	return string(s)
 }
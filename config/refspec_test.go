@@ -116,6 +116,18 @@ func (s *RefSpecSuite) TestRefSpecDstBlob(c *C) {
 		"refs/remotes/origin/foo",
 	)
 }
+func (s *RefSpecSuite) TestRefSpecReverse(c *C) {
+	spec := RefSpec("+refs/heads/*:refs/remotes/origin/*").Reverse()
+	c.Assert(spec, Equals, RefSpec("+refs/remotes/origin/*:refs/heads/*"))
+	c.Assert(
+		spec.Dst(plumbing.ReferenceName("refs/remotes/origin/foo")).String(), Equals,
+		"refs/heads/foo",
+	)
+
+	spec = RefSpec("refs/heads/master:refs/heads/foo").Reverse()
+	c.Assert(spec, Equals, RefSpec("refs/heads/foo:refs/heads/master"))
+}
+
 func (s *RefSpecSuite) TestMatchAny(c *C) {
 	specs := []RefSpec{
 		"refs/heads/bar:refs/remotes/origin/foo",
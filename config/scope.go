@@ -0,0 +1,399 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	format "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+)
+
+// Scope defines the scope of a config file, from the system wide one to the
+// one of a worktree.
+type Scope int
+
+const (
+	// LocalScope is the configuration of the repository, .git/config.
+	LocalScope Scope = iota
+	// GlobalScope is the configuration of the user, $HOME/.gitconfig and
+	// $XDG_CONFIG_HOME/git/config.
+	GlobalScope
+	// SystemScope is the configuration of the system, /etc/gitconfig.
+	SystemScope
+	// WorktreeScope is the configuration of the worktree,
+	// .git/config.worktree, only used when extensions.worktreeConfig is
+	// enabled in the configuration of the repository.
+	WorktreeScope
+)
+
+// String returns the name of the scope, as used by git config --show-scope.
+func (s Scope) String() string {
+	switch s {
+	case LocalScope:
+		return "local"
+	case GlobalScope:
+		return "global"
+	case SystemScope:
+		return "system"
+	case WorktreeScope:
+		return "worktree"
+	}
+
+	return "unknown"
+}
+
+// maxIncludeDepth is the maximum number of nested include directives, the
+// same used by git.
+const maxIncludeDepth = 10
+
+var (
+	// ErrInvalidScope is returned when a scope is not one of the known
+	// scopes, or is not read from fixed paths, as LocalScope and
+	// WorktreeScope, where only GlobalScope and SystemScope are allowed.
+	ErrInvalidScope = errors.New("config: the scope is not read from a fixed path")
+	// ErrIncludeDepth is returned when the include and includeIf directives
+	// are nested more than 10 levels, usually because of an include cycle.
+	ErrIncludeDepth = errors.New("config: exceeded maximum include depth")
+)
+
+const (
+	includeSection   = "include"
+	includeIfSection = "includeIf"
+)
+
+// IncludeConditions are the properties of the repository the conditions of the
+// includeIf directives are evaluated against.
+type IncludeConditions struct {
+	// GitDir is the path of the .git directory of the repository, used by the
+	// gitdir and gitdir/i conditions.
+	GitDir string
+	// Branch is the short name of the checked out branch, used by the
+	// onbranch conditions.
+	Branch string
+}
+
+// Paths returns the paths of the config files of the given scope, GlobalScope
+// or SystemScope, in increasing order of precedence. As git does, the global
+// files can be overridden with $GIT_CONFIG_GLOBAL, the system one with
+// $GIT_CONFIG_SYSTEM, and the system one is ignored if $GIT_CONFIG_NOSYSTEM
+// is set.
+func Paths(scope Scope) ([]string, error) {
+	switch scope {
+	case GlobalScope:
+		if p := os.Getenv("GIT_CONFIG_GLOBAL"); p != "" {
+			return []string{p}, nil
+		}
+
+		home, err := homeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			xdg = filepath.Join(home, ".config")
+		}
+
+		return []string{
+			filepath.Join(xdg, "git", "config"),
+			filepath.Join(home, ".gitconfig"),
+		}, nil
+	case SystemScope:
+		if ok, _ := strconv.ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); ok {
+			return nil, nil
+		}
+
+		if p := os.Getenv("GIT_CONFIG_SYSTEM"); p != "" {
+			return []string{p}, nil
+		}
+
+		return []string{"/etc/gitconfig"}, nil
+	}
+
+	return nil, ErrInvalidScope
+}
+
+// LoadConfig reads the config files of the given scope, GlobalScope or
+// SystemScope, resolving their include and includeIf directives with the
+// given conditions, that can be nil. The missing files are ignored.
+func LoadConfig(scope Scope, cond *IncludeConditions) (*Config, error) {
+	paths, err := Paths(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var raws []*format.Config
+	for _, p := range paths {
+		raw, err := readFile(p, cond, 0)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		raws = append(raws, raw)
+	}
+
+	return Merge(raws...)
+}
+
+// Merge returns a new Config with the options of the given raw configs, the
+// values of the later ones taking precedence over the values of the former
+// ones, as git does when reading the config files of several scopes. The
+// multi-valued options, such as remote.<name>.fetch, accumulate the values of
+// all the configs.
+func Merge(raws ...*format.Config) (*Config, error) {
+	c := NewConfig()
+	for _, raw := range raws {
+		mergeRaw(c.Raw, raw)
+		c.Raw.Includes = append(c.Raw.Includes, raw.Includes...)
+	}
+
+	if err := c.unmarshalRaw(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ResolveIncludes returns a new raw config with the options of the given one
+// and of the files included by its include.path and includeIf.<cond>.path
+// options, whose values take precedence over the ones of the options before
+// the directive. The includeIf directives are only followed if their condition
+// matches the given conditions. Relative paths are resolved from the given
+// directory and ignored if it's empty. The included files are recorded in
+// Includes.
+func ResolveIncludes(raw *format.Config, dir string, cond *IncludeConditions) (*format.Config, error) {
+	return resolveIncludes(raw, dir, cond, 0)
+}
+
+func resolveIncludes(raw *format.Config, dir string, cond *IncludeConditions, depth int) (*format.Config, error) {
+	result := format.New()
+	result.Comment = raw.Comment
+	for _, s := range raw.Sections {
+		mergeRaw(result, &format.Config{Sections: format.Sections{s}})
+
+		paths, err := includePaths(s, dir, cond)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range paths {
+			if depth >= maxIncludeDepth {
+				return nil, ErrIncludeDepth
+			}
+
+			included, err := readFile(p, cond, depth+1)
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			mergeRaw(result, included)
+			result.Includes = append(result.Includes, &format.Include{
+				Path:   p,
+				Config: included,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func readFile(path string, cond *IncludeConditions, depth int) (*format.Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := format.New()
+	if err := format.NewDecoder(bytes.NewReader(b)).Decode(raw); err != nil {
+		return nil, err
+	}
+
+	return resolveIncludes(raw, filepath.Dir(path), cond, depth)
+}
+
+// mergeRaw appends the options of src to the ones of dst.
+func mergeRaw(dst, src *format.Config) {
+	for _, s := range src.Sections {
+		ds := dst.Section(s.Name)
+		for _, o := range s.Options {
+			ds.AddOption(o.Key, o.Value)
+		}
+
+		for _, ss := range s.Subsections {
+			dss := ds.Subsection(ss.Name)
+			for _, o := range ss.Options {
+				dss.AddOption(o.Key, o.Value)
+			}
+		}
+	}
+}
+
+// includePaths returns the paths of the files included by the given section.
+func includePaths(s *format.Section, dir string, cond *IncludeConditions) ([]string, error) {
+	var values []string
+	switch {
+	case s.IsName(includeSection):
+		values = s.Options.GetAll(pathKey)
+	case s.IsName(includeIfSection):
+		for _, ss := range s.Subsections {
+			ok, err := matchIncludeCondition(ss.Name, dir, cond)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				values = append(values, ss.Options.GetAll(pathKey)...)
+			}
+		}
+	}
+
+	var paths []string
+	for _, v := range values {
+		p, err := expandPath(v, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths, nil
+}
+
+// matchIncludeCondition returns true if the given includeIf condition, such
+// as gitdir:~/work/ or onbranch:release/*, matches.
+func matchIncludeCondition(condition, dir string, cond *IncludeConditions) (bool, error) {
+	if cond == nil {
+		return false, nil
+	}
+
+	var pattern, value string
+	var fold bool
+	switch {
+	case strings.HasPrefix(condition, "gitdir:"):
+		pattern, value = condition[len("gitdir:"):], cond.GitDir
+	case strings.HasPrefix(condition, "gitdir/i:"):
+		pattern, value, fold = condition[len("gitdir/i:"):], cond.GitDir, true
+	case strings.HasPrefix(condition, "onbranch:"):
+		pattern, value = condition[len("onbranch:"):], cond.Branch
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+
+		return value != "" && matchGlob(pattern, value, false), nil
+	default:
+		return false, nil
+	}
+
+	if value == "" {
+		return false, nil
+	}
+
+	dirOnly := strings.HasSuffix(pattern, "/")
+	switch {
+	case strings.HasPrefix(pattern, "./"):
+		pattern = filepath.Join(dir, pattern[2:])
+	case strings.HasPrefix(pattern, "~/"):
+		home, err := homeDir()
+		if err != nil {
+			return false, err
+		}
+
+		pattern = filepath.Join(home, pattern[2:])
+	}
+
+	pattern = filepath.ToSlash(pattern)
+	if !strings.HasPrefix(pattern, "/") && !filepath.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+
+	if dirOnly {
+		pattern = strings.TrimSuffix(pattern, "/") + "/**"
+	}
+
+	return matchGlob(pattern, filepath.ToSlash(value), fold), nil
+}
+
+// matchGlob matches the given value with a wildcard pattern where * and ?
+// don't match slashes and ** matches any number of directories.
+func matchGlob(pattern, value string, fold bool) bool {
+	var expr bytes.Buffer
+	if fold {
+		expr.WriteString("(?i)")
+	}
+
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	return err == nil && re.MatchString(value)
+}
+
+// expandPath expands a leading ~/ to the home directory of the user and
+// resolves relative paths from dir, returning an empty path if dir is empty.
+func expandPath(path, dir string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := homeDir()
+		if err != nil {
+			return "", err
+		}
+
+		return filepath.Join(home, path[2:]), nil
+	}
+
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+		return path, nil
+	}
+
+	if dir == "" {
+		return "", nil
+	}
+
+	return filepath.Join(dir, path), nil
+}
+
+func homeDir() (string, error) {
+	if home := os.Getenv("HOME"); home != "" {
+		return home, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return u.HomeDir, nil
+}
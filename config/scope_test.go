@@ -0,0 +1,183 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	format "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+)
+
+type ScopeSuite struct {
+	dir string
+	env map[string]string
+}
+
+var _ = Suite(&ScopeSuite{})
+
+var scopeEnv = []string{
+	"HOME", "XDG_CONFIG_HOME",
+	"GIT_CONFIG_GLOBAL", "GIT_CONFIG_SYSTEM", "GIT_CONFIG_NOSYSTEM",
+}
+
+func (s *ScopeSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.env = make(map[string]string)
+	for _, k := range scopeEnv {
+		s.env[k] = os.Getenv(k)
+		os.Unsetenv(k)
+	}
+
+	os.Setenv("HOME", filepath.Join(s.dir, "home"))
+}
+
+func (s *ScopeSuite) TearDownTest(c *C) {
+	for k, v := range s.env {
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func (s *ScopeSuite) writeFile(c *C, name, content string) string {
+	path := filepath.Join(s.dir, name)
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+	return path
+}
+
+func (s *ScopeSuite) TestPaths(c *C) {
+	home := filepath.Join(s.dir, "home")
+
+	paths, err := Paths(GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{
+		filepath.Join(home, ".config", "git", "config"),
+		filepath.Join(home, ".gitconfig"),
+	})
+
+	os.Setenv("XDG_CONFIG_HOME", "/xdg")
+	paths, err = Paths(GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(paths[0], Equals, filepath.Join("/xdg", "git", "config"))
+
+	os.Setenv("GIT_CONFIG_GLOBAL", "/global")
+	paths, err = Paths(GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/global"})
+
+	paths, err = Paths(SystemScope)
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/etc/gitconfig"})
+
+	os.Setenv("GIT_CONFIG_SYSTEM", "/system")
+	paths, err = Paths(SystemScope)
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/system"})
+
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	paths, err = Paths(SystemScope)
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 0)
+
+	_, err = Paths(LocalScope)
+	c.Assert(err, Equals, ErrInvalidScope)
+}
+
+func (s *ScopeSuite) TestLoadConfig(c *C) {
+	s.writeFile(c, "home/.config/git/config", "[user]\n\tname = xdg\n\temail = xdg@foo\n")
+	s.writeFile(c, "home/.gitconfig", "[user]\n\tname = home\n")
+
+	cfg, err := LoadConfig(GlobalScope, nil)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "home")
+	c.Assert(cfg.Raw.Section("user").Option("email"), Equals, "xdg@foo")
+
+	os.Setenv("GIT_CONFIG_SYSTEM", filepath.Join(s.dir, "missing"))
+	cfg, err = LoadConfig(SystemScope, nil)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Options, HasLen, 0)
+}
+
+func (s *ScopeSuite) TestLoadConfigIncludes(c *C) {
+	s.writeFile(c, "home/work.inc", "[user]\n\temail = work@foo\n")
+	s.writeFile(c, "home/release.inc", "[user]\n\tname = release\n")
+	s.writeFile(c, "home/other.inc", "[user]\n\tname = other\n")
+	s.writeFile(c, "home/.gitconfig", `[user]
+	name = foo
+	email = foo@foo
+[include]
+	path = missing.inc
+[includeIf "gitdir:~/work/"]
+	path = work.inc
+[includeIf "onbranch:release/"]
+	path = ~/release.inc
+[includeIf "gitdir:/other/"]
+	path = other.inc
+`)
+
+	cond := &IncludeConditions{
+		GitDir: filepath.Join(s.dir, "home", "work", "repo", ".git"),
+		Branch: "release/v1",
+	}
+
+	cfg, err := LoadConfig(GlobalScope, cond)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "release")
+	c.Assert(cfg.Raw.Section("user").Option("email"), Equals, "work@foo")
+	c.Assert(cfg.Raw.Includes, HasLen, 2)
+
+	cfg, err = LoadConfig(GlobalScope, nil)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "foo")
+	c.Assert(cfg.Raw.Section("user").Option("email"), Equals, "foo@foo")
+}
+
+func (s *ScopeSuite) TestLoadConfigIncludeDepth(c *C) {
+	s.writeFile(c, "home/.gitconfig", "[include]\n\tpath = .gitconfig\n")
+
+	_, err := LoadConfig(GlobalScope, nil)
+	c.Assert(err, Equals, ErrIncludeDepth)
+}
+
+func (s *ScopeSuite) TestMerge(c *C) {
+	system := format.New()
+	system.Section("user").SetOption("name", "system")
+	system.Section("user").SetOption("email", "system@foo")
+	system.Section("url").Subsection("git@github.com:").SetOption("insteadOf", "gh:")
+
+	local := format.New()
+	local.Section("user").SetOption("name", "local")
+	local.Section("remote").Subsection("origin").SetOption("url", "gh:foo/bar")
+
+	cfg, err := Merge(system, local)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "local")
+	c.Assert(cfg.Raw.Section("user").Option("email"), Equals, "system@foo")
+	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"git@github.com:foo/bar"})
+}
+
+func (s *ScopeSuite) TestMatchIncludeCondition(c *C) {
+	cond := &IncludeConditions{GitDir: "/home/foo/src/repo/.git", Branch: "feature/foo"}
+
+	for condition, expected := range map[string]bool{
+		"gitdir:/home/foo/src/":        true,
+		"gitdir:/home/foo/src":         false,
+		"gitdir:src/repo/":             true,
+		"gitdir:/home/*/src/**":        true,
+		"gitdir:/HOME/foo/":            false,
+		"gitdir/i:/HOME/foo/":          true,
+		"gitdir:./repo/.git":           true,
+		"onbranch:feature/*":           true,
+		"onbranch:feature/":            true,
+		"onbranch:master":              false,
+		"hasconfig:remote.*.url:foo/*": false,
+	} {
+		ok, err := matchIncludeCondition(condition, "/home/foo/src", cond)
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, expected, Commentf("condition %q", condition))
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	format "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+)
+
+var (
+	errURLEmptyInsteadOf = errors.New("url config: empty insteadOf")
+)
+
+// URL defines url.<base>.insteadOf rewriting rules: any URL starting with one
+// of the InsteadOf prefixes is rewritten to start with Name instead.
+type URL struct {
+	// Name is the new base of the rewritten URLs.
+	Name string
+	// InsteadOf are the prefixes of the URLs to be rewritten.
+	InsteadOf []string
+
+	raw *format.Subsection
+}
+
+// Validate validates the fields of the URL rule.
+func (u *URL) Validate() error {
+	if len(u.InsteadOf) == 0 {
+		return errURLEmptyInsteadOf
+	}
+
+	return nil
+}
+
+func (u *URL) unmarshal(s *format.Subsection) {
+	u.raw = s
+
+	u.Name = s.Name
+	u.InsteadOf = append([]string(nil), s.Options.GetAll(insteadOfKey)...)
+}
+
+func (u *URL) marshal() *format.Subsection {
+	if u.raw == nil {
+		u.raw = &format.Subsection{}
+	}
+
+	u.raw.Name = u.Name
+	if len(u.InsteadOf) == 0 {
+		u.raw.RemoveOption(insteadOfKey)
+	} else {
+		u.raw.SetOption(insteadOfKey, u.InsteadOf...)
+	}
+
+	return u.raw
+}
+
+// RewriteURL applies the url.<base>.insteadOf rules to the given URL. When
+// several rules match, the one with the longest prefix is used.
+func RewriteURL(rules map[string]*URL, url string) string {
+	var base, prefix string
+	for _, u := range rules {
+		for _, p := range u.InsteadOf {
+			if strings.HasPrefix(url, p) && len(p) > len(prefix) {
+				base, prefix = u.Name, p
+			}
+		}
+	}
+
+	if prefix == "" {
+		return url
+	}
+
+	return base + url[len(prefix):]
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type URLSuite struct{}
+
+var _ = Suite(&URLSuite{})
+
+func (s *URLSuite) TestValidate(c *C) {
+	u := &URL{Name: "git@github.com:"}
+	c.Assert(u.Validate(), Equals, errURLEmptyInsteadOf)
+
+	u.InsteadOf = []string{"gh:"}
+	c.Assert(u.Validate(), IsNil)
+}
+
+func (s *URLSuite) TestRewriteURL(c *C) {
+	rules := map[string]*URL{
+		"git@github.com:": {
+			Name:      "git@github.com:",
+			InsteadOf: []string{"https://github.com/", "gh:"},
+		},
+		"git@github.com:src-d/": {
+			Name:      "git@github.com:src-d/",
+			InsteadOf: []string{"https://github.com/src-d/"},
+		},
+	}
+
+	c.Assert(RewriteURL(rules, "gh:foo/bar"), Equals, "git@github.com:foo/bar")
+	c.Assert(RewriteURL(rules, "https://github.com/foo/bar"), Equals, "git@github.com:foo/bar")
+	c.Assert(RewriteURL(rules, "https://github.com/src-d/go-git"), Equals, "git@github.com:src-d/go-git")
+	c.Assert(RewriteURL(rules, "https://example.com/foo"), Equals, "https://example.com/foo")
+}
+
+func (s *URLSuite) TestUnmarshalRewritesRemotes(c *C) {
+	input := []byte(`[url "git@github.com:"]
+	insteadOf = gh:
+[remote "origin"]
+	url = gh:src-d/go-git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+`)
+
+	cfg := NewConfig()
+	c.Assert(cfg.Unmarshal(input), IsNil)
+	c.Assert(cfg.URLs, HasLen, 1)
+	c.Assert(cfg.URLs["git@github.com:"].InsteadOf, DeepEquals, []string{"gh:"})
+	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"git@github.com:src-d/go-git"})
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Matches, "(?s).*url = gh:src-d/go-git\n.*")
+
+	cfg.Remotes["origin"].URLs = []string{"https://example.com/foo"}
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Matches, "(?s).*url = https://example.com/foo\n.*")
+}
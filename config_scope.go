@@ -0,0 +1,178 @@
+package git
+
+import (
+	"bytes"
+	stdioutil "io/ioutil"
+	"os"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	formatcfg "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// worktreeConfigFile is the file, in the .git directory, of the configuration
+// of the worktree.
+const worktreeConfigFile = "config.worktree"
+
+// configScopes are the config scopes from the least to the most specific.
+var configScopes = []config.Scope{
+	config.SystemScope,
+	config.GlobalScope,
+	config.LocalScope,
+	config.WorktreeScope,
+}
+
+// ConfigScoped returns the configuration of the given scope merged with the
+// configuration of the more specific ones, the values of the more specific
+// scopes taking precedence: SystemScope returns the system, global, local and
+// worktree configurations merged, GlobalScope the global, local and worktree
+// ones, LocalScope the local and worktree ones and WorktreeScope only the
+// worktree one. The include and includeIf directives are resolved. The system
+// and global configurations are read once by the Repository, their later
+// changes aren't seen.
+//
+// The returned config is meant to be read, storing it with the SetConfig of
+// the storer would copy the options of the other scopes to the configuration
+// of the repository. Use Config to modify the configuration of the repository.
+func (r *Repository) ConfigScoped(scope config.Scope) (*config.Config, error) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+
+	local, err := r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	// Marshal updates the raw config with the fields of the config
+	if _, err := local.Marshal(); err != nil {
+		return nil, err
+	}
+
+	cond := r.includeConditions()
+
+	var raws []*formatcfg.Config
+	found := false
+	for _, s := range configScopes {
+		found = found || s == scope
+		if !found {
+			continue
+		}
+
+		raw, err := r.scopeConfig(s, local.Raw, cond)
+		if err != nil {
+			return nil, err
+		}
+
+		if raw != nil {
+			raws = append(raws, raw)
+		}
+	}
+
+	if !found {
+		return nil, config.ErrInvalidScope
+	}
+
+	return config.Merge(raws...)
+}
+
+// scopeConfig returns the raw configuration of the given scope, nil if
+// there is none.
+func (r *Repository) scopeConfig(scope config.Scope, local *formatcfg.Config, cond *config.IncludeConditions) (*formatcfg.Config, error) {
+	switch scope {
+	case config.LocalScope:
+		return config.ResolveIncludes(local, r.gitDir(), cond)
+	case config.WorktreeScope:
+		return r.worktreeConfig(local, cond)
+	}
+
+	key := scopedConfigKey{scope: scope, cond: *cond}
+	if raw, ok := r.scoped[key]; ok {
+		return raw, nil
+	}
+
+	cfg, err := config.LoadConfig(scope, cond)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.scoped == nil {
+		r.scoped = make(map[scopedConfigKey]*formatcfg.Config)
+	}
+
+	r.scoped[key] = cfg.Raw
+	return cfg.Raw, nil
+}
+
+// scopedConfigKey identifies a config read from outside the repository, the
+// includeIf directives of the global and system configs depend on the
+// conditions.
+type scopedConfigKey struct {
+	scope config.Scope
+	cond  config.IncludeConditions
+}
+
+// worktreeConfig returns the raw configuration of the worktree if
+// extensions.worktreeConfig is enabled, nil otherwise.
+func (r *Repository) worktreeConfig(local *formatcfg.Config, cond *config.IncludeConditions) (*formatcfg.Config, error) {
+	if configOption(local, "extensions", "worktreeConfig") != "true" {
+		return nil, nil
+	}
+
+	fs, ok := r.Storer.(interface {
+		Filesystem() billy.Filesystem
+	})
+	if !ok {
+		return nil, nil
+	}
+
+	f, err := fs.Filesystem().Open(worktreeConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	b, err := stdioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := formatcfg.New()
+	if err := formatcfg.NewDecoder(bytes.NewReader(b)).Decode(raw); err != nil {
+		return nil, err
+	}
+
+	return config.ResolveIncludes(raw, r.gitDir(), cond)
+}
+
+// includeConditions returns the properties of the repository the includeIf
+// directives are evaluated against.
+func (r *Repository) includeConditions() *config.IncludeConditions {
+	cond := &config.IncludeConditions{GitDir: r.gitDir()}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		cond.Branch = head.Target().Short()
+	}
+
+	return cond
+}
+
+// gitDir returns the path of the .git directory, empty if the storer isn't
+// based on a filesystem.
+func (r *Repository) gitDir() string {
+	fs, ok := r.Storer.(interface {
+		Filesystem() billy.Filesystem
+	})
+	if !ok {
+		return ""
+	}
+
+	return fs.Filesystem().Root()
+}
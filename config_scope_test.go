@@ -0,0 +1,215 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// withGlobalConfig writes the given global configuration in a temporary file
+// used until the returned function is called.
+func withGlobalConfig(c *C, content string) func() {
+	path := filepath.Join(c.MkDir(), "gitconfig")
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	c.Assert(err, IsNil)
+
+	old := os.Getenv("GIT_CONFIG_GLOBAL")
+	os.Setenv("GIT_CONFIG_GLOBAL", path)
+	return func() { os.Setenv("GIT_CONFIG_GLOBAL", old) }
+}
+
+func (s *RepositorySuite) TestConfigScoped(c *C) {
+	defer withGlobalConfig(c, "[user]\n\tname = global\n\temail = global@foo\n")()
+
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("user").SetOption("name", "local")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	scoped, err := r.ConfigScoped(config.SystemScope)
+	c.Assert(err, IsNil)
+	c.Assert(scoped.Raw.Section("user").Option("name"), Equals, "local")
+	c.Assert(scoped.Raw.Section("user").Option("email"), Equals, "global@foo")
+
+	scoped, err = r.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(scoped.Raw.Section("user").Option("name"), Equals, "local")
+	c.Assert(scoped.Raw.Section("user").Option("email"), Equals, "")
+
+	_, err = r.ConfigScoped(config.Scope(42))
+	c.Assert(err, Equals, config.ErrInvalidScope)
+}
+
+func (s *RepositorySuite) TestConfigScopedWorktree(c *C) {
+	dir, err := ioutil.TempDir("", "config-worktree")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(dir, ".git", "config.worktree"), []byte("[user]\n\tname = worktree\n"), 0644)
+	c.Assert(err, IsNil)
+
+	scoped, err := r.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(scoped.Raw.Section("user").Option("name"), Equals, "")
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("extensions").SetOption("worktreeConfig", "true")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	scoped, err = r.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(scoped.Raw.Section("user").Option("name"), Equals, "worktree")
+}
+
+func (s *RepositorySuite) TestConfigScopedCommitAuthor(c *C) {
+	defer withGlobalConfig(c, "[user]\n\tname = global\n\temail = global@foo\n")()
+
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(w.Filesystem, "foo", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit("foo\n", &CommitOptions{})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "global")
+	c.Assert(commit.Author.Email, Equals, "global@foo")
+	c.Assert(commit.Committer.Name, Equals, "global")
+}
+
+func (s *RepositorySuite) TestConfigScopedRemoteInsteadOf(c *C) {
+	defer withGlobalConfig(c, "[url \"https://github.com/\"]\n\tinsteadOf = gh:\n")()
+
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{"gh:src-d/go-git"},
+	})
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote("origin")
+	c.Assert(err, IsNil)
+	c.Assert(remote.Config().URLs, DeepEquals, []string{"https://github.com/src-d/go-git"})
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"gh:src-d/go-git"})
+}
+
+func (s *RepositorySuite) TestConfigScopedExcludesFile(c *C) {
+	excludes := filepath.Join(c.MkDir(), "ignore")
+	err := ioutil.WriteFile(excludes, []byte("*.log\n"), 0644)
+	c.Assert(err, IsNil)
+
+	defer withGlobalConfig(c, "[core]\n\texcludesfile = "+filepath.ToSlash(excludes)+"\n")()
+
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(w.Filesystem, "foo.log", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
+	err = util.WriteFile(w.Filesystem, "bar", []byte("bar"), 0644)
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("bar").Worktree, Equals, Untracked)
+}
+
+func (s *RepositorySuite) TestConfigScopedReadOnce(c *C) {
+	defer withGlobalConfig(c, "[user]\n\tname = global\n")()
+
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.ConfigScoped(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "global")
+
+	err = ioutil.WriteFile(os.Getenv("GIT_CONFIG_GLOBAL"), []byte("[user]\n\tname = other\n"), 0644)
+	c.Assert(err, IsNil)
+
+	cfg, err = r.ConfigScoped(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "global")
+
+	r, err = Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	cfg, err = r.ConfigScoped(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("user").Option("name"), Equals, "other")
+}
+
+func (s *RepositorySuite) TestConfigScopedConcurrent(c *C) {
+	defer withGlobalConfig(c, "[url \"https://github.com/\"]\n\tinsteadOf = gh:\n")()
+
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{"gh:src-d/go-git"},
+	})
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Remote("origin")
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *RepositorySuite) TestConfigScopedStatusMalformed(c *C) {
+	defer withGlobalConfig(c, "[user\n")()
+
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	_, err = w.Status()
+	c.Assert(err, NotNil)
+}
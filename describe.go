@@ -0,0 +1,359 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// maxDescribeCandidates is the maximum number of candidate tags considered by
+// Describe, each of them uses one bit of the flags of the commits.
+const maxDescribeCandidates = 31
+
+var (
+	// ErrNoDescription is returned when no tag describes the commit.
+	ErrNoDescription = errors.New("no tags can describe the commit")
+)
+
+// Description is the result of a describe operation, a human readable name of
+// a commit based on the nearest tag reachable from it.
+type Description struct {
+	// Name is the name of the tag describing the commit, such as v1.2.3, or,
+	// with DescribeOptions.All, the name of the ref without the refs/ prefix,
+	// such as tags/v1.2.3 or heads/master.
+	Name string
+	// Reference is the full name of the ref describing the commit.
+	Reference plumbing.ReferenceName
+	// Distance is the number of commits reachable from the commit and not
+	// from the tag.
+	Distance int
+	// Hash is the hash of the described commit.
+	Hash plumbing.Hash
+	// Dirty is true when the described commit is HEAD and the worktree has
+	// local changes, it's only checked if DescribeOptions.Dirty is set.
+	Dirty bool
+
+	abbrev int
+	suffix string
+}
+
+// String returns the description in the format of git describe, such as
+// v1.2.3-14-gabcdef0, or only the name of the tag if the commit is tagged.
+func (d *Description) String() string {
+	s := d.Name
+	if d.Distance != 0 && d.abbrev > 0 {
+		s = fmt.Sprintf("%s-%d-g%s", s, d.Distance, d.Hash.String()[:d.abbrev])
+	}
+
+	if d.Dirty {
+		s += d.suffix
+	}
+
+	return s
+}
+
+// describeName is a ref that can describe the commit it points to.
+type describeName struct {
+	name      string
+	ref       plumbing.ReferenceName
+	annotated bool
+	when      time.Time
+}
+
+// better returns true if n should describe its commit instead of other.
+func (n *describeName) better(other *describeName) bool {
+	if n.annotated != other.annotated {
+		return n.annotated
+	}
+
+	if !n.when.Equal(other.when) {
+		return n.when.After(other.when)
+	}
+
+	return n.name < other.name
+}
+
+// describeCandidate is a tag found walking the history of the commit.
+type describeCandidate struct {
+	name   *describeName
+	depth  int
+	within uint32
+	order  int
+}
+
+// Describe finds the most recent tag reachable from the given commit, or from
+// HEAD if the hash is zero, and returns a description of the commit based on
+// it. Among the tags found walking the history of the commit, the one with the
+// fewest commits between it and the commit is used. By default only annotated
+// tags are used, if several tags point to the same commit the annotated ones
+// and then the most recent ones are preferred. ErrNoDescription is returned if
+// no tag can describe the commit.
+func (r *Repository) Describe(h plumbing.Hash, opts *DescribeOptions) (*Description, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil && (h.IsZero() || err != plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	if h.IsZero() {
+		h = head.Hash()
+	}
+
+	commit, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := r.describeNames(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Description{Hash: h, abbrev: opts.Abbrev, suffix: opts.Dirty}
+	if n, ok := names[h]; ok {
+		d.Name, d.Reference = n.name, n.ref
+	} else {
+		if opts.Candidates < 0 {
+			return nil, ErrNoDescription
+		}
+
+		best, err := describeNearest(commit, names, opts.Candidates)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Name, d.Reference, d.Distance = best.name.name, best.name.ref, best.depth
+	}
+
+	if opts.Dirty != "" && head != nil && head.Hash() == h {
+		w, err := r.Worktree()
+		if err != nil {
+			return nil, err
+		}
+
+		err = w.checkCleanForMerge()
+		if err != nil && err != ErrWorktreeNotClean {
+			return nil, err
+		}
+
+		d.Dirty = err == ErrWorktreeNotClean
+	}
+
+	return d, nil
+}
+
+// describeNames returns the refs that can be used to describe the commits,
+// by the hash of the commit they point to.
+func (r *Repository) describeNames(opts *DescribeOptions) (map[plumbing.Hash]*describeName, error) {
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[plumbing.Hash]*describeName)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		var short string
+		switch {
+		case ref.Name().IsTag():
+			short = strings.TrimPrefix(ref.Name().String(), "refs/tags/")
+		case opts.All && ref.Name().IsBranch():
+			short = strings.TrimPrefix(ref.Name().String(), "refs/heads/")
+		case opts.All && ref.Name().IsRemote():
+			short = strings.TrimPrefix(ref.Name().String(), "refs/remotes/")
+		default:
+			return nil
+		}
+
+		if !describeMatch(opts.Match, short) {
+			return nil
+		}
+
+		n := &describeName{name: short, ref: ref.Name()}
+		if opts.All {
+			n.name = strings.TrimPrefix(ref.Name().String(), "refs/")
+		}
+
+		target := ref.Hash()
+		tag, err := r.TagObject(target)
+		switch err {
+		case nil:
+			n.annotated, n.when = true, tag.Tagger.When
+		case plumbing.ErrObjectNotFound:
+			if !opts.Tags && !opts.All {
+				return nil
+			}
+		default:
+			return err
+		}
+
+		target, err = r.resolveToCommitHash(target)
+		if err == ErrUnableToResolveCommit {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if old, ok := names[target]; !ok || n.better(old) {
+			names[target] = n
+		}
+
+		return nil
+	})
+
+	return names, err
+}
+
+// describeNearest walks the history of the commit, in commit time order, until
+// the given number of candidates are found and returns the one with the
+// fewest commits not reachable from it, as git describe does.
+func describeNearest(c *object.Commit, names map[plumbing.Hash]*describeName, max int) (*describeCandidate, error) {
+	const seen uint32 = 1
+
+	flags := map[plumbing.Hash]uint32{c.Hash: seen}
+	list := []*object.Commit{c}
+
+	var candidates []*describeCandidate
+	var gaveUpOn *object.Commit
+	commits := 0
+	for len(list) != 0 {
+		c := list[0]
+		list = list[1:]
+		commits++
+
+		if n, ok := names[c.Hash]; ok {
+			if len(candidates) == max {
+				gaveUpOn = c
+				break
+			}
+
+			t := &describeCandidate{
+				name:   n,
+				depth:  commits - 1,
+				within: 1 << uint(len(candidates)+1),
+				order:  len(candidates),
+			}
+
+			candidates = append(candidates, t)
+			flags[c.Hash] |= t.within
+		}
+
+		for _, t := range candidates {
+			if flags[c.Hash]&t.within == 0 {
+				t.depth++
+			}
+		}
+
+		var err error
+		if list, err = describeQueueParents(c, list, flags); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrNoDescription
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].depth != candidates[j].depth {
+			return candidates[i].depth < candidates[j].depth
+		}
+
+		return candidates[i].order < candidates[j].order
+	})
+
+	best := candidates[0]
+	if gaveUpOn != nil {
+		list = insertCommitByDate(list, gaveUpOn)
+	}
+
+	return best, finishDescribeDepth(list, flags, best)
+}
+
+// finishDescribeDepth keeps walking the history until all the pending commits
+// are reachable from the best candidate, counting the ones that aren't.
+func finishDescribeDepth(list []*object.Commit, flags map[plumbing.Hash]uint32, best *describeCandidate) error {
+	for len(list) != 0 {
+		c := list[0]
+		list = list[1:]
+
+		if flags[c.Hash]&best.within != 0 {
+			within := true
+			for _, p := range list {
+				if flags[p.Hash]&best.within == 0 {
+					within = false
+					break
+				}
+			}
+
+			if within {
+				return nil
+			}
+		} else {
+			best.depth++
+		}
+
+		var err error
+		if list, err = describeQueueParents(c, list, flags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// describeQueueParents propagates the flags of the commit to its parents and
+// inserts the ones not seen yet in the list.
+func describeQueueParents(c *object.Commit, list []*object.Commit, flags map[plumbing.Hash]uint32) ([]*object.Commit, error) {
+	err := c.Parents().ForEach(func(p *object.Commit) error {
+		if _, ok := flags[p.Hash]; !ok {
+			list = insertCommitByDate(list, p)
+		}
+
+		flags[p.Hash] |= flags[c.Hash]
+		return nil
+	})
+
+	return list, err
+}
+
+// insertCommitByDate inserts the commit in the list sorted from the most
+// recent commit time, after the commits with the same time.
+func insertCommitByDate(list []*object.Commit, c *object.Commit) []*object.Commit {
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].Committer.When.Before(c.Committer.When)
+	})
+
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = c
+	return list
+}
+
+func describeMatch(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
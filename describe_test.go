@@ -0,0 +1,167 @@
+package git
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type DescribeSuite struct {
+	r       *Repository
+	w       *Worktree
+	commits int
+}
+
+var _ = Suite(&DescribeSuite{})
+
+func (s *DescribeSuite) SetUpTest(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	s.r = r
+	s.w, err = r.Worktree()
+	c.Assert(err, IsNil)
+	s.commits = 0
+}
+
+func (s *DescribeSuite) signature() *object.Signature {
+	return &object.Signature{
+		Name:  "foo",
+		Email: "foo@foo.foo",
+		When:  time.Date(2018, 1, 1, 0, s.commits, 0, 0, time.UTC),
+	}
+}
+
+func (s *DescribeSuite) commit(c *C, parents ...plumbing.Hash) plumbing.Hash {
+	s.commits++
+	err := util.WriteFile(s.w.Filesystem, "foo", []byte(fmt.Sprintf("%d", s.commits)), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = s.w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err := s.w.Commit(fmt.Sprintf("commit %d\n", s.commits), &CommitOptions{
+		Author:  s.signature(),
+		Parents: parents,
+	})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *DescribeSuite) tag(c *C, name string, h plumbing.Hash, annotated bool) {
+	s.commits++
+
+	var opts *CreateTagOptions
+	if annotated {
+		opts = &CreateTagOptions{Tagger: s.signature(), Message: name}
+	}
+
+	_, err := s.r.CreateTag(name, h, opts)
+	c.Assert(err, IsNil)
+}
+
+func (s *DescribeSuite) describe(c *C, h plumbing.Hash, opts *DescribeOptions) string {
+	d, err := s.r.Describe(h, opts)
+	c.Assert(err, IsNil)
+	return d.String()
+}
+
+func (s *DescribeSuite) TestDescribe(c *C) {
+	a := s.commit(c)
+	s.tag(c, "v1.0", a, true)
+	s.commit(c)
+	b := s.commit(c)
+	s.tag(c, "v1.1-rc", b, false)
+	d := s.commit(c)
+
+	desc, err := s.r.Describe(plumbing.ZeroHash, &DescribeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(desc.Name, Equals, "v1.0")
+	c.Assert(desc.Reference, Equals, plumbing.ReferenceName("refs/tags/v1.0"))
+	c.Assert(desc.Distance, Equals, 3)
+	c.Assert(desc.Hash, Equals, d)
+	c.Assert(desc.String(), Equals, "v1.0-3-g"+d.String()[:7])
+
+	c.Assert(s.describe(c, a, &DescribeOptions{}), Equals, "v1.0")
+	c.Assert(s.describe(c, d, &DescribeOptions{Tags: true}), Equals, "v1.1-rc-1-g"+d.String()[:7])
+	c.Assert(s.describe(c, d, &DescribeOptions{Abbrev: 10}), Equals, "v1.0-3-g"+d.String()[:10])
+	c.Assert(s.describe(c, d, &DescribeOptions{Abbrev: -1}), Equals, "v1.0")
+	c.Assert(s.describe(c, d, &DescribeOptions{All: true}), Equals, "heads/master")
+	c.Assert(s.describe(c, b, &DescribeOptions{All: true}), Equals, "tags/v1.1-rc")
+}
+
+func (s *DescribeSuite) TestDescribePrefersAnnotatedAndRecentTags(c *C) {
+	a := s.commit(c)
+	s.tag(c, "a-light", a, false)
+	s.tag(c, "v1.0", a, true)
+	s.tag(c, "v1.0-final", a, true)
+
+	c.Assert(s.describe(c, a, &DescribeOptions{Tags: true}), Equals, "v1.0-final")
+	c.Assert(s.describe(c, a, &DescribeOptions{Tags: true, Match: []string{"v1.0", "a-*"}}), Equals, "v1.0")
+	c.Assert(s.describe(c, a, &DescribeOptions{Tags: true, Match: []string{"a-*"}}), Equals, "a-light")
+}
+
+func (s *DescribeSuite) TestDescribeNearestTag(c *C) {
+	a := s.commit(c)
+	s.tag(c, "v1.0", a, true)
+	b := s.commit(c)
+
+	err := s.w.Checkout(&CheckoutOptions{Hash: a})
+	c.Assert(err, IsNil)
+	x := s.commit(c)
+	y := s.commit(c)
+	s.tag(c, "v1.1", x, true)
+
+	m := s.commit(c, y, b)
+	desc, err := s.r.Describe(m, &DescribeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(desc.Name, Equals, "v1.1")
+	c.Assert(desc.Distance, Equals, 3)
+
+	desc, err = s.r.Describe(m, &DescribeOptions{Match: []string{"v1.0"}})
+	c.Assert(err, IsNil)
+	c.Assert(desc.Name, Equals, "v1.0")
+	c.Assert(desc.Distance, Equals, 4)
+}
+
+func (s *DescribeSuite) TestDescribeDirty(c *C) {
+	a := s.commit(c)
+	s.tag(c, "v1.0", a, true)
+
+	c.Assert(s.describe(c, plumbing.ZeroHash, &DescribeOptions{Dirty: "-dirty"}), Equals, "v1.0")
+
+	err := util.WriteFile(s.w.Filesystem, "foo", []byte("changed"), 0644)
+	c.Assert(err, IsNil)
+
+	desc, err := s.r.Describe(plumbing.ZeroHash, &DescribeOptions{Dirty: "-dirty"})
+	c.Assert(err, IsNil)
+	c.Assert(desc.Dirty, Equals, true)
+	c.Assert(desc.String(), Equals, "v1.0-dirty")
+
+	c.Assert(s.describe(c, plumbing.ZeroHash, &DescribeOptions{}), Equals, "v1.0")
+}
+
+func (s *DescribeSuite) TestDescribeErrors(c *C) {
+	a := s.commit(c)
+	s.tag(c, "light", a, false)
+	b := s.commit(c)
+
+	_, err := s.r.Describe(b, &DescribeOptions{})
+	c.Assert(err, Equals, ErrNoDescription)
+
+	_, err = s.r.Describe(b, &DescribeOptions{Tags: true, Candidates: -1})
+	c.Assert(err, Equals, ErrNoDescription)
+
+	_, err = s.r.Describe(b, &DescribeOptions{Tags: true, Match: []string{"v*"}})
+	c.Assert(err, Equals, ErrNoDescription)
+
+	_, err = s.r.Describe(b, &DescribeOptions{Match: []string{"["}})
+	c.Assert(err, Equals, ErrInvalidPattern)
+}
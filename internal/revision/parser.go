@@ -8,7 +8,9 @@ import (
 	"io"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // ErrInvalidRevision is emitted if string doesn't match valid revision
@@ -315,13 +317,15 @@ func (p *Parser) parseAt() (Revisioner, error) {
 
 			switch {
 			case tok == cbrace:
-				t, err := time.Parse("2006-01-02T15:04:05Z", date)
+				t, err := parseDate(date)
 
 				if err != nil {
-					return nil, &ErrInvalidRevision{fmt.Sprintf(`wrong date "%s" must fit ISO-8601 format : 2006-01-02T15:04:05Z`, date)}
+					return nil, err
 				}
 
 				return AtDate{t}, nil
+			case tok == eof:
+				return nil, &ErrInvalidRevision{`missing "}" in @{<date>} structure`}
 			default:
 				date += lit
 			}
@@ -329,6 +333,58 @@ func (p *Parser) parseAt() (Revisioner, error) {
 	}
 }
 
+// now returns the current time, the relative dates are computed from it.
+var now = time.Now
+
+// dateUnits are the units of the relative dates.
+var dateUnits = map[string]func(t time.Time, n int) time.Time{
+	"second": func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Second) },
+	"minute": func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Minute) },
+	"hour":   func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Hour) },
+	"day":    func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -n) },
+	"week":   func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -7*n) },
+	"month":  func(t time.Time, n int) time.Time { return t.AddDate(0, -n, 0) },
+	"year":   func(t time.Time, n int) time.Time { return t.AddDate(-n, 0, 0) },
+}
+
+// parseDate parses the dates of the @{<date>} statements, which can be an
+// ISO-8601 date, a date as 2006-01-02 or 2006-01-02 15:04:05 in the local
+// time zone, "now", "yesterday" or a relative date as "2.weeks.ago" or
+// "3 days ago".
+func parseDate(date string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02T15:04:05Z", date); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	fields := strings.FieldsFunc(strings.ToLower(date), func(r rune) bool {
+		return r == '.' || unicode.IsSpace(r)
+	})
+
+	switch {
+	case len(fields) == 1 && fields[0] == "now":
+		return now(), nil
+	case len(fields) == 1 && fields[0] == "yesterday":
+		return now().AddDate(0, 0, -1), nil
+	case len(fields) == 2 || len(fields) == 3 && fields[2] == "ago":
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			break
+		}
+
+		if unit, ok := dateUnits[strings.TrimSuffix(fields[1], "s")]; ok {
+			return unit(now(), n), nil
+		}
+	}
+
+	return time.Time{}, &ErrInvalidRevision{fmt.Sprintf(`wrong date "%s" must fit ISO-8601 format : 2006-01-02T15:04:05Z`, date)}
+}
+
 // parseTilde extract ~ statements
 func (p *Parser) parseTilde() (Revisioner, error) {
 	var tok token
@@ -230,10 +230,40 @@ func (s *ParserSuite) TestParseAtWithValidExpression(c *C) {
 	}
 }
 
+func (s *ParserSuite) TestParseAtWithRelativeDate(c *C) {
+	reference := time.Date(2016, 12, 16, 21, 42, 47, 0, time.UTC)
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return reference }
+
+	datas := map[string]Revisioner{
+		"{now}":            AtDate{reference},
+		"{yesterday}":      AtDate{reference.AddDate(0, 0, -1)},
+		"{2.weeks.ago}":    AtDate{reference.AddDate(0, 0, -14)},
+		"{1.day.ago}":      AtDate{reference.AddDate(0, 0, -1)},
+		"{3 hours ago}":    AtDate{reference.Add(-3 * time.Hour)},
+		"{1.month.ago}":    AtDate{reference.AddDate(0, -1, 0)},
+		"{10.minutes}":     AtDate{reference.Add(-10 * time.Minute)},
+		"{2016-12-01}":     AtDate{time.Date(2016, 12, 1, 0, 0, 0, 0, time.Local)},
+		"{1.year.ago}":     AtDate{reference.AddDate(-1, 0, 0)},
+		"{30.seconds.ago}": AtDate{reference.Add(-30 * time.Second)},
+	}
+
+	for d, expected := range datas {
+		parser := NewParser(bytes.NewBufferString(d))
+
+		result, err := parser.parseAt()
+
+		c.Assert(err, Equals, nil)
+		c.Assert(result, DeepEquals, expected, Commentf(d))
+	}
+}
+
 func (s *ParserSuite) TestParseAtWithUnValidExpression(c *C) {
 	datas := map[string]error{
-		"{test}": &ErrInvalidRevision{`wrong date "test" must fit ISO-8601 format : 2006-01-02T15:04:05Z`},
-		"{-1":    &ErrInvalidRevision{`missing "}" in @{-n} structure`},
+		"{test}":         &ErrInvalidRevision{`wrong date "test" must fit ISO-8601 format : 2006-01-02T15:04:05Z`},
+		"{2.lights.ago}": &ErrInvalidRevision{`wrong date "2.lights.ago" must fit ISO-8601 format : 2006-01-02T15:04:05Z`},
+		"{-1":            &ErrInvalidRevision{`missing "}" in @{-n} structure`},
+		"{yesterday":     &ErrInvalidRevision{`missing "}" in @{<date>} structure`},
 	}
 
 	for s, e := range datas {
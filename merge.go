@@ -0,0 +1,342 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+	"gopkg.in/src-d/go-git.v4/utils/merge"
+)
+
+var (
+	// ErrMergeConflict is returned when the changes can't be merged
+	// automatically, the conflicts are recorded in the index and the worktree.
+	ErrMergeConflict = errors.New("merge conflict")
+	// ErrUnrelatedHistories is returned when the commits being merged don't
+	// have any common ancestor.
+	ErrUnrelatedHistories = errors.New("refusing to merge unrelated histories")
+	// ErrMergeDirectoryFileConflict is returned when a path is a file in one
+	// side of the merge and a directory in the other one.
+	ErrMergeDirectoryFileConflict = errors.New("directory/file conflict")
+)
+
+// treeMerger performs three-way merges of trees, merging the content of the
+// text files changed in both sides line by line.
+type treeMerger struct {
+	s storer.EncodedObjectStorer
+	// labels used in the conflict markers.
+	ours, theirs string
+}
+
+// treeMergeResult is the result of a three-way merge of trees.
+type treeMergeResult struct {
+	// Index contains the merged files at stage 0, and the base, ours and
+	// theirs versions of the conflicting files at the stages 1, 2 and 3.
+	Index *index.Index
+	// Conflicts contains the conflicting files, sorted by path.
+	Conflicts []*mergeConflict
+}
+
+// mergeConflict is a file that could not be merged.
+type mergeConflict struct {
+	Name string
+	// Content to be written in the worktree, if nil the version of our side
+	// is kept.
+	Content []byte
+	// Mode of the file written in the worktree.
+	Mode filemode.FileMode
+}
+
+// Merge merges the changes from base to theirs into ours. base can be nil,
+// in which case the files added in both sides are merged against an empty
+// file.
+func (m *treeMerger) Merge(base, ours, theirs *object.Tree) (*treeMergeResult, error) {
+	files := make([]map[string]*object.TreeEntry, 3)
+	for i, t := range []*object.Tree{base, ours, theirs} {
+		var err error
+		if files[i], err = treeFiles(t); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, f := range files {
+		for name := range f {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	r := &treeMergeResult{Index: &index.Index{Version: 2}}
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+
+		if err := m.mergeFile(r, name, files[0][name], files[1][name], files[2][name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkDirectoryFileConflicts(r.Index); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (m *treeMerger) mergeFile(r *treeMergeResult, name string, base, ours, theirs *object.TreeEntry) error {
+	switch {
+	case sameEntry(ours, theirs):
+		r.add(name, ours)
+		return nil
+	case sameEntry(base, ours):
+		r.add(name, theirs)
+		return nil
+	case sameEntry(base, theirs):
+		r.add(name, ours)
+		return nil
+	}
+
+	c := &mergeConflict{Name: name}
+	switch {
+	case ours == nil:
+		// deleted by us, the modified version is kept in the worktree
+		c.Mode = theirs.Mode
+		if theirs.Mode.IsFile() {
+			var err error
+			if c.Content, err = m.content(theirs); err != nil {
+				return err
+			}
+		}
+	case theirs == nil:
+		c.Mode = ours.Mode
+	case ours.Mode.IsFile() && ours.Mode != filemode.Symlink &&
+		theirs.Mode.IsFile() && theirs.Mode != filemode.Symlink:
+		ok, err := m.mergeContent(r, c, name, base, ours, theirs)
+		if err != nil || ok {
+			return err
+		}
+	default:
+		c.Mode = ours.Mode
+	}
+
+	r.addConflict(c, base, ours, theirs)
+	return nil
+}
+
+// mergeContent merges the content of two regular files, if the merge is clean
+// the merged file is added to the result and true is returned, otherwise the
+// content with the conflict markers is set to the given mergeConflict.
+func (m *treeMerger) mergeContent(
+	r *treeMergeResult, c *mergeConflict, name string,
+	base, ours, theirs *object.TreeEntry,
+) (bool, error) {
+	mode, modeOk := mergeMode(base, ours, theirs)
+	c.Mode = mode
+
+	var baseContent []byte
+	if base != nil && base.Mode.IsFile() {
+		var err error
+		if baseContent, err = m.content(base); err != nil {
+			return false, err
+		}
+	}
+
+	oursContent, err := m.content(ours)
+	if err != nil {
+		return false, err
+	}
+
+	theirsContent, err := m.content(theirs)
+	if err != nil {
+		return false, err
+	}
+
+	if isBinaryContent(baseContent) || isBinaryContent(oursContent) ||
+		isBinaryContent(theirsContent) {
+		c.Mode = ours.Mode
+		return false, nil
+	}
+
+	result := merge.Do(
+		string(baseContent), string(oursContent), string(theirsContent),
+		&merge.Options{OursLabel: m.ours, TheirsLabel: m.theirs},
+	)
+
+	if result.Conflicts != 0 || !modeOk {
+		c.Content = []byte(result.Content)
+		return false, nil
+	}
+
+	h, err := m.writeBlob([]byte(result.Content))
+	if err != nil {
+		return false, err
+	}
+
+	r.add(name, &object.TreeEntry{Name: name, Mode: mode, Hash: h})
+	return true, nil
+}
+
+// mergeMode returns the mode resulting of merging the mode of both sides, if
+// the mode was changed in both sides in a different way the mode of our side
+// and false are returned.
+func mergeMode(base, ours, theirs *object.TreeEntry) (filemode.FileMode, bool) {
+	switch {
+	case ours.Mode == theirs.Mode:
+		return ours.Mode, true
+	case base != nil && base.Mode == ours.Mode:
+		return theirs.Mode, true
+	case base != nil && base.Mode == theirs.Mode:
+		return ours.Mode, true
+	}
+
+	return ours.Mode, false
+}
+
+func (m *treeMerger) content(e *object.TreeEntry) (content []byte, err error) {
+	b, err := object.GetBlob(m.s, e.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	buf := bytes.NewBuffer(nil)
+	if _, err = io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (m *treeMerger) writeBlob(content []byte) (h plumbing.Hash, err error) {
+	obj := m.s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		_ = w.Close()
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return m.s.SetEncodedObject(obj)
+}
+
+func (r *treeMergeResult) add(name string, e *object.TreeEntry) {
+	if e == nil {
+		return
+	}
+
+	r.Index.Entries = append(r.Index.Entries, &index.Entry{
+		Name: name,
+		Hash: e.Hash,
+		Mode: e.Mode,
+	})
+}
+
+func (r *treeMergeResult) addConflict(c *mergeConflict, base, ours, theirs *object.TreeEntry) {
+	for i, e := range []*object.TreeEntry{base, ours, theirs} {
+		if e == nil {
+			continue
+		}
+
+		r.Index.Entries = append(r.Index.Entries, &index.Entry{
+			Name:  c.Name,
+			Hash:  e.Hash,
+			Mode:  e.Mode,
+			Stage: index.AncestorMode + index.Stage(i),
+		})
+	}
+
+	r.Conflicts = append(r.Conflicts, c)
+}
+
+// treeFiles returns all the non directory entries of a tree by its full path.
+func treeFiles(t *object.Tree) (map[string]*object.TreeEntry, error) {
+	files := make(map[string]*object.TreeEntry)
+	if t == nil {
+		return files, nil
+	}
+
+	w := object.NewTreeWalker(t, true, nil)
+	defer w.Close()
+
+	for {
+		name, e, err := w.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Mode == filemode.Dir {
+			continue
+		}
+
+		entry := e
+		files[name] = &entry
+	}
+}
+
+// checkDirectoryFileConflicts checks that none of the merged files is also a
+// directory of another merged file.
+func checkDirectoryFileConflicts(idx *index.Index) error {
+	files := make(map[string]bool, len(idx.Entries))
+	for _, e := range idx.Entries {
+		files[e.Name] = true
+	}
+
+	for _, e := range idx.Entries {
+		for i := len(e.Name) - 1; i > 0; i-- {
+			if e.Name[i] == '/' && files[e.Name[:i]] {
+				return ErrMergeDirectoryFileConflict
+			}
+		}
+	}
+
+	return nil
+}
+
+func sameEntry(a, b *object.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// isBinaryContent applies the same heuristic used by git, a file is binary if
+// a NUL byte is found in the first 8000 bytes.
+func isBinaryContent(content []byte) bool {
+	const sniffLen = 8000
+	if len(content) > sniffLen {
+		content = content[:sniffLen]
+	}
+
+	return bytes.IndexByte(content, 0) != -1
+}
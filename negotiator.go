@@ -0,0 +1,275 @@
+package git
+
+import (
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// flags of the commits walked by the commitNegotiator
+const (
+	// negotiationSeen commits are, or were, in the queue
+	negotiationSeen = 1 << iota
+	// negotiationCommon commits are known to be in the remote
+	negotiationCommon
+	// negotiationCommonRef commits are pointed by a remote reference
+	negotiationCommonRef
+	// negotiationPopped commits were taken from the queue
+	negotiationPopped
+)
+
+// commitNegotiator is a packp.Negotiator walking the local history from the
+// tips of the local references, newest commits first, the way git does by
+// default. The commits reachable from the ones known to be in the remote,
+// either because a remote reference points to them or because the server
+// acknowledged them, aren't sent.
+type commitNegotiator struct {
+	s     storer.EncodedObjectStorer
+	queue *binaryheap.Heap
+	flags map[plumbing.Hash]int
+	// nonCommon is the number of commits in the queue not known to be in
+	// the remote, the negotiation is over when there are none.
+	nonCommon int
+}
+
+// newCommitNegotiator returns a commitNegotiator starting at the given local
+// references, knowing the remote has the given hashes.
+func newCommitNegotiator(
+	s storer.EncodedObjectStorer,
+	localRefs []*plumbing.Reference,
+	remoteRefs map[plumbing.Hash]bool,
+) (*commitNegotiator, error) {
+	n := &commitNegotiator{
+		s:     s,
+		queue: binaryheap.NewWith(newerCommitFirst),
+		flags: make(map[plumbing.Hash]int),
+	}
+
+	for h := range remoteRefs {
+		c, err := peelToCommit(s, h)
+		if err != nil {
+			return nil, err
+		}
+
+		if c == nil || n.flags[c.Hash]&negotiationSeen != 0 {
+			continue
+		}
+
+		n.push(c, negotiationCommonRef|negotiationSeen)
+		if err := n.markCommon(c, true); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ref := range localRefs {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		c, err := peelToCommit(s, ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		if c != nil {
+			n.push(c, negotiationSeen)
+		}
+	}
+
+	return n, nil
+}
+
+// Next returns at most max commits to send as haves, the newest ones not
+// known to be in the remote.
+func (n *commitNegotiator) Next(max int) ([]plumbing.Hash, error) {
+	var haves []plumbing.Hash
+	for len(haves) < max && n.nonCommon > 0 {
+		c, err := n.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if c == nil {
+			break
+		}
+
+		haves = append(haves, c.Hash)
+	}
+
+	return haves, nil
+}
+
+func (n *commitNegotiator) next() (*object.Commit, error) {
+	for {
+		v, ok := n.queue.Pop()
+		if !ok {
+			return nil, nil
+		}
+
+		c := v.(*object.Commit)
+		flags := n.flags[c.Hash]
+		n.flags[c.Hash] |= negotiationPopped
+		if flags&negotiationCommon == 0 {
+			n.nonCommon--
+		}
+
+		// the parents of the commits in the remote are in the remote too
+		mark := negotiationSeen
+		if flags&(negotiationCommon|negotiationCommonRef) != 0 {
+			mark |= negotiationCommon
+		}
+
+		parents, err := n.parents(c)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range parents {
+			if n.flags[p.Hash]&negotiationSeen == 0 {
+				n.push(p, mark)
+			}
+
+			if mark&negotiationCommon != 0 {
+				if err := n.markCommon(p, true); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if flags&negotiationCommon == 0 {
+			return c, nil
+		}
+	}
+}
+
+// Ack marks the given commit and its ancestors as common.
+func (n *commitNegotiator) Ack(h plumbing.Hash) error {
+	c, err := object.GetCommit(n.s, h)
+	if err == plumbing.ErrObjectNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return n.markCommon(c, false)
+}
+
+// markCommon marks the given commit, unless ancestorsOnly, and its ancestors
+// in the queue as common, the ones never queued are queued.
+func (n *commitNegotiator) markCommon(c *object.Commit, ancestorsOnly bool) error {
+	type pending struct {
+		c             *object.Commit
+		ancestorsOnly bool
+	}
+
+	stack := []pending{{c, ancestorsOnly}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		flags := n.flags[p.c.Hash]
+		if flags&negotiationCommon != 0 {
+			continue
+		}
+
+		if !p.ancestorsOnly {
+			n.flags[p.c.Hash] |= negotiationCommon
+		}
+
+		if flags&negotiationSeen == 0 {
+			n.push(p.c, negotiationSeen)
+			continue
+		}
+
+		if !p.ancestorsOnly && flags&negotiationPopped == 0 {
+			n.nonCommon--
+		}
+
+		parents, err := n.parents(p.c)
+		if err != nil {
+			return err
+		}
+
+		for _, parent := range parents {
+			stack = append(stack, pending{parent, false})
+		}
+	}
+
+	return nil
+}
+
+// push queues the commit with the given flags, unless it has any of them.
+func (n *commitNegotiator) push(c *object.Commit, mark int) {
+	flags := n.flags[c.Hash]
+	if flags&mark != 0 {
+		return
+	}
+
+	flags |= mark
+	n.flags[c.Hash] = flags
+	n.queue.Push(c)
+	if flags&negotiationCommon == 0 {
+		n.nonCommon++
+	}
+}
+
+// parents returns the parents of the commit present in the storage, the
+// missing ones, such as the ones beyond a shallow boundary, are skipped.
+func (n *commitNegotiator) parents(c *object.Commit) ([]*object.Commit, error) {
+	var parents []*object.Commit
+	for _, h := range c.ParentHashes {
+		p, err := object.GetCommit(n.s, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		parents = append(parents, p)
+	}
+
+	return parents, nil
+}
+
+// peelToCommit returns the commit the given hash points to, peeling the
+// annotated tags, or nil if it isn't a commit or it's missing.
+func peelToCommit(s storer.EncodedObjectStorer, h plumbing.Hash) (*object.Commit, error) {
+	for {
+		o, err := object.GetObject(s, h)
+		if err == plumbing.ErrObjectNotFound {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch o := o.(type) {
+		case *object.Commit:
+			return o, nil
+		case *object.Tag:
+			h = o.Target
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// newerCommitFirst orders the commits by committer time, newest first.
+func newerCommitFirst(a, b interface{}) int {
+	ca, cb := a.(*object.Commit), b.(*object.Commit)
+	switch {
+	case ca.Committer.When.After(cb.Committer.When):
+		return -1
+	case ca.Committer.When.Before(cb.Committer.When):
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type NegotiatorSuite struct {
+	BaseSuite
+	r      *Repository
+	master []plumbing.Hash
+	side   []plumbing.Hash
+}
+
+var _ = Suite(&NegotiatorSuite{})
+
+// SetUpTest creates a history of six commits, with a side branch of three
+// newer commits forking from the second one.
+func (s *NegotiatorSuite) SetUpTest(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	s.r = r
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	when := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(msg string, parents ...plumbing.Hash) plumbing.Hash {
+		when = when.Add(time.Hour)
+		h, err := w.Commit(msg, &CommitOptions{
+			Author:  &object.Signature{Name: "foo", Email: "foo@foo.foo", When: when},
+			Parents: parents,
+		})
+		c.Assert(err, IsNil)
+		return h
+	}
+
+	s.master = nil
+	for i := 0; i < 6; i++ {
+		s.master = append(s.master, commit(fmt.Sprintf("master %d", i)))
+	}
+
+	s.side = nil
+	parent := s.master[1]
+	for i := 0; i < 3; i++ {
+		parent = commit(fmt.Sprintf("side %d", i), parent)
+		s.side = append(s.side, parent)
+	}
+}
+
+func (s *NegotiatorSuite) newNegotiator(c *C, remoteRefs ...plumbing.Hash) *commitNegotiator {
+	localRefs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/master", s.master[5]),
+		plumbing.NewHashReference("refs/heads/side", s.side[2]),
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/master"),
+	}
+
+	remote := make(map[plumbing.Hash]bool)
+	for _, h := range remoteRefs {
+		remote[h] = true
+	}
+
+	n, err := newCommitNegotiator(s.r.Storer, localRefs, remote)
+	c.Assert(err, IsNil)
+	return n
+}
+
+func (s *NegotiatorSuite) TestNextNewestFirst(c *C) {
+	n := s.newNegotiator(c)
+
+	haves, err := n.Next(4)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{
+		s.side[2], s.side[1], s.side[0], s.master[5],
+	})
+
+	haves, err = n.Next(100)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{
+		s.master[4], s.master[3], s.master[2], s.master[1], s.master[0],
+	})
+
+	haves, err = n.Next(100)
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 0)
+}
+
+func (s *NegotiatorSuite) TestNextRemoteRefs(c *C) {
+	n := s.newNegotiator(c, s.side[0])
+
+	// the commit of the remote reference is sent, its ancestors aren't
+	haves, err := n.Next(100)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{
+		s.side[2], s.side[1], s.side[0],
+		s.master[5], s.master[4], s.master[3], s.master[2],
+	})
+}
+
+func (s *NegotiatorSuite) TestAck(c *C) {
+	n := s.newNegotiator(c)
+
+	haves, err := n.Next(3)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{s.side[2], s.side[1], s.side[0]})
+
+	c.Assert(n.Ack(s.side[0]), IsNil)
+	c.Assert(n.Ack(plumbing.NewHash("1111111111111111111111111111111111111111")), IsNil)
+
+	haves, err = n.Next(100)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{
+		s.master[5], s.master[4], s.master[3], s.master[2],
+	})
+}
@@ -2,13 +2,19 @@ package git
 
 import (
 	"errors"
+	"fmt"
+	"path"
 	"regexp"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 )
 
@@ -28,6 +34,14 @@ const (
 
 var (
 	ErrMissingURL = errors.New("URL field is required")
+	// ErrShallowOptionsConflict is returned when more than one of Depth,
+	// Deepen and Unshallow are given, or any of them along with ShallowSince
+	// or ShallowExclude.
+	ErrShallowOptionsConflict = errors.New("conflicting shallow options")
+	// ErrTreeFilterNotSupported is returned when the filter of a partial
+	// clone or fetch omits trees, only the missing blobs are fetched on
+	// demand.
+	ErrTreeFilterNotSupported = errors.New("tree filters are not supported")
 )
 
 // CloneOptions describes how a clone should be performed.
@@ -46,6 +60,12 @@ type CloneOptions struct {
 	NoCheckout bool
 	// Limit fetching to the specified number of commits.
 	Depth int
+	// ShallowSince, if not zero, limits fetching to the commits newer than
+	// it.
+	ShallowSince time.Time
+	// ShallowExclude limits fetching to the commits not reachable from any
+	// of the given remote references, such as branches or tags.
+	ShallowExclude []string
 	// RecurseSubmodules after the clone is created, initialize all submodules
 	// within, using their default settings. This option is ignored if the
 	// cloned repository does not have a worktree.
@@ -57,6 +77,12 @@ type CloneOptions struct {
 	// Tags describe how the tags will be fetched from the remote repository,
 	// by default is AllTags.
 	Tags TagMode
+	// Filter, if not empty, makes the clone a partial clone: the objects not
+	// matching the filter, such as the blobs with packp.FilterBlobNone, are
+	// omitted and fetched on demand from the remote, the promisor remote.
+	// Only the blob filters are supported, the ones omitting trees, such as
+	// packp.FilterTreeDepth, return ErrTreeFilterNotSupported.
+	Filter packp.Filter
 }
 
 // Validate validates the fields and sets the default values.
@@ -65,6 +91,14 @@ func (o *CloneOptions) Validate() error {
 		return ErrMissingURL
 	}
 
+	if err := validateShallow(o.Depth, 0, false, o.ShallowSince, o.ShallowExclude); err != nil {
+		return err
+	}
+
+	if err := validateFilter(o.Filter); err != nil {
+		return err
+	}
+
 	if o.RemoteName == "" {
 		o.RemoteName = DefaultRemoteName
 	}
@@ -90,6 +124,12 @@ type PullOptions struct {
 	SingleBranch bool
 	// Limit fetching to the specified number of commits.
 	Depth int
+	// ShallowSince, ShallowExclude, Deepen and Unshallow change the depth of
+	// the history fetched, see FetchOptions.
+	ShallowSince   time.Time
+	ShallowExclude []string
+	Deepen         int
+	Unshallow      bool
 	// Auth credentials, if required, to use with the remote repository.
 	Auth transport.AuthMethod
 	// RecurseSubmodules controls if new commits of all populated submodules
@@ -102,6 +142,14 @@ type PullOptions struct {
 	// Force allows the pull to update a local branch even when the remote
 	// branch does not descend from it.
 	Force bool
+	// Mode defines how the fetched changes are integrated into the current
+	// branch, by default only fast-forward updates are allowed.
+	Mode PullMode
+	// Author is the author's signature of the merge commit created when Mode
+	// is MergePull and the update is not a fast-forward. If Author is nil,
+	// the identity of the user section of the config is used, the global and
+	// system configs included.
+	Author *object.Signature
 }
 
 // Validate validates the fields and sets the default values.
@@ -114,9 +162,22 @@ func (o *PullOptions) Validate() error {
 		o.ReferenceName = plumbing.HEAD
 	}
 
-	return nil
+	return validateShallow(o.Depth, o.Deepen, o.Unshallow, o.ShallowSince, o.ShallowExclude)
 }
 
+// PullMode defines how a pull integrates the fetched changes.
+type PullMode int8
+
+const (
+	// FastForwardPull only allows updates that can be resolved as a
+	// fast-forward, ErrNonFastForwardUpdate is returned otherwise. This is the
+	// default mode.
+	FastForwardPull PullMode = iota
+	// MergePull performs a three-way merge of the fetched changes when the
+	// update is not a fast-forward, creating a merge commit.
+	MergePull
+)
+
 type TagMode int
 
 const (
@@ -140,6 +201,18 @@ type FetchOptions struct {
 	// Depth limit fetching to the specified number of commits from the tip of
 	// each remote branch history.
 	Depth int
+	// ShallowSince, if not zero, limits fetching to the commits newer than
+	// it.
+	ShallowSince time.Time
+	// ShallowExclude limits fetching to the commits not reachable from any
+	// of the given remote references, such as branches or tags.
+	ShallowExclude []string
+	// Deepen fetches the specified number of commits more from the current
+	// shallow boundary of a shallow repository.
+	Deepen int
+	// Unshallow fetches the complete history of a shallow repository,
+	// making it a complete one. It has no effect on a complete repository.
+	Unshallow bool
 	// Auth credentials, if required, to use with the remote repository.
 	Auth transport.AuthMethod
 	// Progress is where the human readable information sent by the server is
@@ -159,6 +232,10 @@ type FetchOptions struct {
 	// exist yet.  If packed-refs already exists, the fetch will
 	// return an error.
 	PackRefs bool
+	// Filter, if not empty, omits the objects not matching it, see
+	// CloneOptions.Filter. The filter of the partial clone is used by
+	// default fetching from its promisor remote.
+	Filter packp.Filter
 }
 
 // Validate validates the fields and sets the default values.
@@ -167,6 +244,14 @@ func (o *FetchOptions) Validate() error {
 		o.RemoteName = DefaultRemoteName
 	}
 
+	if err := validateFilter(o.Filter); err != nil {
+		return err
+	}
+
+	if err := validateShallow(o.Depth, o.Deepen, o.Unshallow, o.ShallowSince, o.ShallowExclude); err != nil {
+		return err
+	}
+
 	if o.Tags == InvalidTagMode {
 		o.Tags = TagFollowing
 	}
@@ -180,6 +265,54 @@ func (o *FetchOptions) Validate() error {
 	return nil
 }
 
+// isShallow returns true if the options limit the history fetched, or
+// deepen it.
+func (o *FetchOptions) isShallow() bool {
+	return o.Depth != 0 || o.Deepen != 0 || o.Unshallow ||
+		!o.ShallowSince.IsZero() || len(o.ShallowExclude) != 0
+}
+
+// validateFilter returns an error if the given filter, if not empty, isn't
+// valid or omits trees, see CloneOptions.Filter.
+func validateFilter(f packp.Filter) error {
+	if f == "" {
+		return nil
+	}
+
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(string(f), "tree:") {
+		return ErrTreeFilterNotSupported
+	}
+
+	return nil
+}
+
+// validateShallow returns ErrShallowOptionsConflict if more than one of the
+// depth, deepen and unshallow options are given, or any of them along with
+// the since or exclude ones, as git does.
+func validateShallow(depth, deepen int, unshallow bool, since time.Time, exclude []string) error {
+	var n int
+	for _, given := range []bool{
+		depth != 0,
+		deepen != 0,
+		unshallow,
+		!since.IsZero() || len(exclude) != 0,
+	} {
+		if given {
+			n++
+		}
+	}
+
+	if n > 1 {
+		return ErrShallowOptionsConflict
+	}
+
+	return nil
+}
+
 // PushOptions describes how a push should be performed.
 type PushOptions struct {
 	// RemoteName is the name of the remote to be pushed to.
@@ -193,6 +326,37 @@ type PushOptions struct {
 	// stored, if nil nothing is stored.
 	Progress   sideband.Progress
 	StatusChan plumbing.StatusChan
+	// Atomic requests the server to update either all the references or
+	// none of them.
+	Atomic bool
+	// Options are sent to the server as push options, passed to its hooks,
+	// for example to trigger or skip a CI pipeline.
+	Options map[string]string
+	// ForceWithLease, if not nil, allows the non-fast-forward updates, as
+	// long as the remote references to update have the expected values, see
+	// ForceWithLease.
+	ForceWithLease *ForceWithLease
+	// Prune deletes the remote references matched by the destination of the
+	// refspecs without a local reference matching their source.
+	Prune bool
+	// FollowTags pushes the annotated tags missing in the remote pointing to
+	// commits reachable from the ones pushed too, the history is walked up
+	// to the commits of the remote references.
+	FollowTags bool
+	// SignKey, if not nil, signs a push certificate of the reference updates
+	// with the nonce advertised by the server, recording who pushed what. The
+	// private key must be present and already decrypted.
+	SignKey *openpgp.Entity
+}
+
+// ForceWithLease holds the values expected for the remote references updated
+// by a push with PushOptions.ForceWithLease, the push fails with a
+// StaleLeaseError if any of them has changed.
+type ForceWithLease struct {
+	// Expected are the values expected by remote reference name, the zero
+	// hash expects the reference not to exist. The references missing in it
+	// are expected to have the value of their remote-tracking references.
+	Expected map[plumbing.ReferenceName]plumbing.Hash
 }
 
 // Validate validates the fields and sets the default values.
@@ -348,13 +512,16 @@ type CommitOptions struct {
 	// All automatically stage files that have been modified and deleted, but
 	// new files you have not told Git about are not affected.
 	All bool
-	// Author is the author's signature of the commit.
+	// Author is the author's signature of the commit. If Author is nil, the
+	// identity of the user section of the config is used, the global and
+	// system configs included.
 	Author *object.Signature
 	// Committer is the committer's signature of the commit. If Committer is
 	// nil the Author signature is used.
 	Committer *object.Signature
 	// Parents are the parents commits for the new commit, by default when
-	// len(Parents) is zero, the hash of HEAD reference is used.
+	// len(Parents) is zero, the hash of HEAD reference is used, followed by
+	// the one of MERGE_HEAD when concluding a merge stopped by conflicts.
 	Parents []plumbing.Hash
 	// A key to sign the commit with. A nil value here means the commit will not
 	// be signed. The private key must be present and already decrypted.
@@ -364,7 +531,16 @@ type CommitOptions struct {
 // Validate validates the fields and sets the default values.
 func (o *CommitOptions) Validate(r *Repository) error {
 	if o.Author == nil {
-		return ErrMissingAuthor
+		author, err := r.configSignature()
+		if err != nil {
+			return err
+		}
+
+		if author.Name == "" {
+			return ErrMissingAuthor
+		}
+
+		o.Author = author
 	}
 
 	if o.Committer == nil {
@@ -380,6 +556,341 @@ func (o *CommitOptions) Validate(r *Repository) error {
 		if head != nil {
 			o.Parents = []plumbing.Hash{head.Hash()}
 		}
+
+		merging, _, err := r.mergeState()
+		if err != nil {
+			return err
+		}
+
+		if !merging.IsZero() {
+			o.Parents = append(o.Parents, merging)
+		}
+	}
+
+	return nil
+}
+
+var (
+	// ErrMissingMergeCommit is returned by MergeOptions.Validate when no
+	// commit to merge is given.
+	ErrMissingMergeCommit = errors.New("commit to merge is required")
+)
+
+// MergeOptions describes how a merge operation should be performed.
+type MergeOptions struct {
+	// Commit is the commit to be merged into the current branch.
+	Commit plumbing.Hash
+	// Message is the message of the merge commit, if empty a default message
+	// is used.
+	Message string
+	// Author is the author's signature of the merge commit. If Author is
+	// nil, the identity of the user section of the config is used, the
+	// global and system configs included.
+	Author *object.Signature
+	// Committer is the committer's signature of the merge commit. If
+	// Committer is nil the Author signature is used.
+	Committer *object.Signature
+	// A key to sign the merge commit with. A nil value here means the commit
+	// will not be signed. The private key must be present and already
+	// decrypted.
+	SignKey *openpgp.Entity
+	// NoFastForward creates a merge commit even when the merge can be
+	// resolved as a fast-forward.
+	NoFastForward bool
+	// FastForwardOnly refuses to merge, returning ErrNonFastForwardUpdate,
+	// unless the merge can be resolved as a fast-forward.
+	FastForwardOnly bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *MergeOptions) Validate(r *Repository) error {
+	if o.Commit.IsZero() {
+		return ErrMissingMergeCommit
+	}
+
+	author, err := validateCommitter(r, o.Author)
+	if err == ErrMissingCommitter {
+		return ErrMissingAuthor
+	}
+
+	if err != nil {
+		return err
+	}
+
+	o.Author = author
+	if o.Committer == nil {
+		o.Committer = o.Author
+	}
+
+	if o.Message == "" {
+		o.Message = fmt.Sprintf("Merge commit '%s'\n", o.Commit)
+	}
+
+	return nil
+}
+
+var (
+	ErrMissingCommitter = errors.New("committer field is required")
+)
+
+// RebaseOptions describes how a rebase operation should be performed.
+type RebaseOptions struct {
+	// Upstream is the commit the branch is rebased on, the commits of the
+	// branch not reachable from it are replayed. If empty, the upstream
+	// branch configured for the branch is used.
+	Upstream plumbing.Hash
+	// Onto is the commit the commits are replayed onto. If empty, Upstream
+	// is used.
+	Onto plumbing.Hash
+	// Branch is the branch to be rebased, it is checked out before the
+	// rebase. If empty, the current HEAD is rebased.
+	Branch plumbing.ReferenceName
+	// Committer is the committer's signature of the replayed commits, their
+	// authors are preserved. If Committer is nil, the identity of the user
+	// section of the config is used.
+	Committer *object.Signature
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RebaseOptions) Validate(r *Repository) error {
+	if o.Branch != "" && !o.Branch.IsBranch() {
+		return ErrInvalidReference
+	}
+
+	if o.Upstream.IsZero() {
+		name := o.Branch.Short()
+		if o.Branch == "" {
+			var err error
+			if name, err = r.upstreamBranchName(""); err != nil {
+				return err
+			}
+		}
+
+		upstream, err := r.upstream(name)
+		if err != nil {
+			return err
+		}
+
+		ref, err := storer.ResolveReference(r.Storer, upstream)
+		if err != nil {
+			return err
+		}
+
+		o.Upstream = ref.Hash()
+	}
+
+	if o.Onto.IsZero() {
+		o.Onto = o.Upstream
+	}
+
+	committer, err := validateCommitter(r, o.Committer)
+	if err != nil {
+		return err
+	}
+
+	o.Committer = committer
+	return nil
+}
+
+// RebaseContinueOptions describes how a stopped rebase should be continued.
+type RebaseContinueOptions struct {
+	// Committer is the committer's signature of the replayed commits. If
+	// Committer is nil, the identity of the user section of the config is
+	// used.
+	Committer *object.Signature
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RebaseContinueOptions) Validate(r *Repository) error {
+	committer, err := validateCommitter(r, o.Committer)
+	if err != nil {
+		return err
+	}
+
+	o.Committer = committer
+	return nil
+}
+
+// validateCommitter returns the given committer or, if nil, the identity of
+// the user section of the config, the global and system configs included.
+// ErrMissingCommitter is returned if there is no identity configured.
+func validateCommitter(r *Repository, committer *object.Signature) (*object.Signature, error) {
+	if committer != nil {
+		return committer, nil
+	}
+
+	committer, err := r.configSignature()
+	if err != nil {
+		return nil, err
+	}
+
+	if committer.Name == "" {
+		return nil, ErrMissingCommitter
+	}
+
+	return committer, nil
+}
+
+var (
+	ErrMissingTagger  = errors.New("tagger field is required")
+	ErrMissingMessage = errors.New("message field is required")
+)
+
+// CreateTagOptions describes how an annotated tag object should be created.
+type CreateTagOptions struct {
+	// Tagger defines the signature of the tag creator.
+	Tagger *object.Signature
+	// Message defines the annotation of the tag. It is canonicalized during
+	// validation into the format expected by git - no leading whitespace and
+	// ending in a newline.
+	Message string
+	// SignKey denotes a key to sign the tag with. A nil value here means the
+	// tag will not be signed. The private key must be present and already
+	// decrypted.
+	SignKey *openpgp.Entity
+}
+
+// Validate validates the fields and sets the default values.
+func (o *CreateTagOptions) Validate() error {
+	if o.Tagger == nil {
+		return ErrMissingTagger
+	}
+
+	o.Message = strings.TrimSpace(o.Message)
+	if o.Message == "" {
+		return ErrMissingMessage
+	}
+
+	o.Message += "\n"
+	return nil
+}
+
+// StashOptions describes how a stash push operation should be performed.
+type StashOptions struct {
+	// Message is the description of the stash entry, if empty a default
+	// message with the current branch and HEAD is used.
+	Message string
+	// Author is the author's signature of the stash commits.
+	Author *object.Signature
+	// Committer is the committer's signature of the stash commits. If
+	// Committer is nil the Author signature is used.
+	Committer *object.Signature
+	// IncludeUntracked stashes also the untracked files, removing them from
+	// the worktree. The ignored files are never stashed.
+	IncludeUntracked bool
+	// KeepIndex keeps the changes added to the index, in the index and in
+	// the worktree.
+	KeepIndex bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *StashOptions) Validate() error {
+	if o.Author == nil {
+		return ErrMissingAuthor
+	}
+
+	if o.Committer == nil {
+		o.Committer = o.Author
+	}
+
+	return nil
+}
+
+// StashApplyOptions describes how a stash entry should be applied.
+type StashApplyOptions struct {
+	// Index of the stash entry to apply, 0 being the most recent one, as in
+	// stash@{0}.
+	Index int
+	// RestoreIndex restores also the changes that were added to the index
+	// when the entry was created, like the --index flag of git stash apply.
+	RestoreIndex bool
+}
+
+var (
+	// ErrInvalidMainline is returned by CherryPick and Revert when the
+	// mainline given is not the number of a parent of the commit.
+	ErrInvalidMainline = errors.New("mainline is not a parent of the commit")
+)
+
+// CherryPickOptions describes how a cherry-pick operation should be performed.
+type CherryPickOptions struct {
+	// Mainline is the number, starting from 1, of the parent the changes of
+	// a merge commit are computed against. It's required for merge commits
+	// and must be 0 for the rest.
+	Mainline int
+	// Committer is the committer's signature of the new commit, the author
+	// of the picked commit is preserved. If Committer is nil, the identity of
+	// the user section of the config is used.
+	Committer *object.Signature
+	// RecordOrigin appends a "(cherry picked from commit ...)" line to the
+	// message of the new commit, like the -x flag of git cherry-pick.
+	RecordOrigin bool
+	// NoCommit applies the changes to the index and the worktree without
+	// creating a commit.
+	NoCommit bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *CherryPickOptions) Validate(r *Repository) error {
+	if o.Mainline < 0 {
+		return ErrInvalidMainline
+	}
+
+	if o.NoCommit {
+		return nil
+	}
+
+	committer, err := validateCommitter(r, o.Committer)
+	if err != nil {
+		return err
+	}
+
+	o.Committer = committer
+	return nil
+}
+
+// RevertOptions describes how a revert operation should be performed.
+type RevertOptions struct {
+	// Mainline is the number, starting from 1, of the parent the changes of
+	// a merge commit are computed against. It's required for merge commits
+	// and must be 0 for the rest.
+	Mainline int
+	// Message is the message of the new commit, if empty a default message
+	// referencing the reverted commit is used.
+	Message string
+	// Author is the author's signature of the new commit. If Author is nil,
+	// the identity of the user section of the config is used.
+	Author *object.Signature
+	// Committer is the committer's signature of the new commit. If
+	// Committer is nil the Author signature is used.
+	Committer *object.Signature
+	// NoCommit applies the changes to the index and the worktree without
+	// creating a commit.
+	NoCommit bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RevertOptions) Validate(r *Repository) error {
+	if o.Mainline < 0 {
+		return ErrInvalidMainline
+	}
+
+	if o.NoCommit {
+		return nil
+	}
+
+	author, err := validateCommitter(r, o.Author)
+	if err == ErrMissingCommitter {
+		return ErrMissingAuthor
+	}
+
+	if err != nil {
+		return err
+	}
+
+	o.Author = author
+	if o.Committer == nil {
+		o.Committer = o.Author
 	}
 
 	return nil
@@ -389,6 +900,22 @@ func (o *CommitOptions) Validate(r *Repository) error {
 type ListOptions struct {
 	// Auth credentials, if required, to use with the remote repository.
 	Auth transport.AuthMethod
+	// RefSpecs limits the listed references to the ones matched by the
+	// sources of the refspecs, all the references are listed if empty. Only
+	// the references starting with their prefixes are requested to the
+	// servers speaking the protocol version 2.
+	RefSpecs []config.RefSpec
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ListOptions) Validate() error {
+	for _, r := range o.RefSpecs {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // CleanOptions describes how a clean should be performed.
@@ -443,3 +970,123 @@ type PlainOpenOptions struct {
 
 // Validate validates the fields and sets the default values.
 func (o *PlainOpenOptions) Validate() error { return nil }
+
+var (
+	ErrInvalidPattern = errors.New("invalid match pattern")
+)
+
+// DescribeOptions describes how a describe operation should be performed.
+type DescribeOptions struct {
+	// Tags describes the commit using also the lightweight tags, by default
+	// only the annotated tags are used.
+	Tags bool
+	// All describes the commit using any ref, also the branches and the
+	// remote branches.
+	All bool
+	// Candidates is the number of tags, found walking the history from the
+	// most recent commit, considered to describe the commit, 10 if 0 and 31
+	// at most. If negative, the commit is described only by the tags
+	// pointing to it, like the --exact-match flag of git describe.
+	Candidates int
+	// Abbrev is the number of hexadecimal digits of the abbreviated hash of
+	// the description, 7 if 0. If negative, the description is only the name
+	// of the tag.
+	Abbrev int
+	// Dirty is the suffix appended to the description, such as "-dirty",
+	// when the commit described is HEAD and the worktree has local changes.
+	// If empty, the worktree isn't checked.
+	Dirty string
+	// Match are glob patterns, the tags must match at least one of them to
+	// describe the commit. The patterns are matched against the name of the
+	// tags without the refs/tags/ prefix or, with All, of the branches and
+	// remote branches without the refs/heads/ and refs/remotes/ prefixes.
+	Match []string
+}
+
+// Validate validates the fields and sets the default values.
+func (o *DescribeOptions) Validate() error {
+	if o.Candidates == 0 {
+		o.Candidates = 10
+	}
+
+	if o.Candidates > maxDescribeCandidates {
+		o.Candidates = maxDescribeCandidates
+	}
+
+	if o.Abbrev == 0 {
+		o.Abbrev = 7
+	}
+
+	if o.Abbrev > 40 {
+		o.Abbrev = 40
+	}
+
+	for _, p := range o.Match {
+		if _, err := path.Match(p, ""); err != nil {
+			return ErrInvalidPattern
+		}
+	}
+
+	return nil
+}
+
+// ApplyOptions describes how a patch should be applied.
+type ApplyOptions struct {
+	// Index applies the patch to the index as well as to the worktree, the
+	// patched files must be the same in both, like the --index flag of git
+	// apply. By default only the worktree is modified.
+	Index bool
+	// Check only verifies that the patch applies, without modifying the
+	// worktree or the index.
+	Check bool
+	// Reverse applies the patch in reverse, undoing its changes.
+	Reverse bool
+	// ThreeWay falls back to a three-way merge when the hunks of a file don't
+	// apply, using the blob the patch was created from, found by the hash of
+	// its index line. The conflicts are recorded in the index and in the
+	// worktree. It implies Index.
+	ThreeWay bool
+	// Fuzz is the number of context lines, at the beginning and at the end
+	// of a hunk, that can be ignored when the hunk doesn't apply with its
+	// whole context. By default the whole context must match.
+	Fuzz int
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ApplyOptions) Validate() error {
+	if o.ThreeWay {
+		o.Index = true
+	}
+
+	if o.Fuzz < 0 {
+		o.Fuzz = 0
+	}
+
+	return nil
+}
+
+// ApplyMailboxOptions describes how the patches of a mailbox should be
+// applied.
+type ApplyMailboxOptions struct {
+	// Committer is the committer's signature of the new commits, the author
+	// and the date of the patches are preserved. If Committer is nil, the
+	// identity of the user section of the config is used.
+	Committer *object.Signature
+	// ThreeWay falls back to a three-way merge when a patch doesn't apply,
+	// see ApplyOptions.ThreeWay.
+	ThreeWay bool
+	// Fuzz is the number of context lines that can be ignored, see
+	// ApplyOptions.Fuzz.
+	Fuzz int
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ApplyMailboxOptions) Validate(r *Repository) error {
+	committer, err := validateCommitter(r, o.Committer)
+	if err != nil {
+		return err
+	}
+
+	o.Committer = committer
+	return nil
+}
@@ -1,8 +1,11 @@
 package git
 
 import (
+	"time"
+
 	. "gopkg.in/check.v1"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 )
 
 type OptionsSuite struct {
@@ -19,11 +22,37 @@ func (s *OptionsSuite) TestCommitOptionsParentsFromHEAD(c *C) {
 }
 
 func (s *OptionsSuite) TestCommitOptionsMissingAuthor(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
 	o := CommitOptions{}
-	err := o.Validate(s.Repository)
+	err = o.Validate(r)
 	c.Assert(err, Equals, ErrMissingAuthor)
 }
 
+func (s *OptionsSuite) TestCommitOptionsAuthorFromConfig(c *C) {
+	o := CommitOptions{}
+	err := o.Validate(s.Repository)
+	c.Assert(err, IsNil)
+	c.Assert(o.Author.Name, Equals, "A")
+	c.Assert(o.Author.Email, Equals, "a@b.c")
+	c.Assert(o.Committer, Equals, o.Author)
+}
+
+func (s *OptionsSuite) TestFetchOptionsShallowConflict(c *C) {
+	for _, o := range []*FetchOptions{
+		{Depth: 1, Deepen: 1},
+		{Deepen: 1, Unshallow: true},
+		{Depth: 1, ShallowSince: time.Now()},
+		{Unshallow: true, ShallowExclude: []string{"v1"}},
+	} {
+		c.Assert(o.Validate(), Equals, ErrShallowOptionsConflict)
+	}
+
+	o := &FetchOptions{ShallowSince: time.Now(), ShallowExclude: []string{"v1"}}
+	c.Assert(o.Validate(), IsNil)
+}
+
 func (s *OptionsSuite) TestCommitOptionsCommitter(c *C) {
 	sig := &object.Signature{}
 
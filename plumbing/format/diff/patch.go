@@ -39,6 +39,16 @@ type FilePatch interface {
 	Chunks() []Chunk
 }
 
+// RenameFilePatch is an optional interface implemented by the FilePatches of
+// renamed or copied files, the files returned by Files have different paths.
+type RenameFilePatch interface {
+	FilePatch
+	// Similarity returns the percentage of the content shared by both files.
+	Similarity() int
+	// IsCopy returns true if "to" is a copy of "from" instead of a rename.
+	IsCopy() bool
+}
+
 // File contains all the file metadata necessary to print some patch formats.
 type File interface {
 	// Hash returns the File Hash.
@@ -0,0 +1,630 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+)
+
+var (
+	// ErrInvalidHunkHeader is returned when the header of a hunk, the @@ line,
+	// can't be parsed.
+	ErrInvalidHunkHeader = errors.New("diff: invalid hunk header")
+	// ErrCorruptPatch is returned when a hunk contains fewer or different
+	// lines than the ones announced by its header.
+	ErrCorruptPatch = errors.New("diff: corrupt patch")
+	// ErrInvalidFileHeader is returned when an extended header line of a git
+	// diff, such as the modes or the index line, can't be parsed.
+	ErrInvalidFileHeader = errors.New("diff: invalid file header")
+)
+
+const (
+	gitDiffPrefix   = "diff --git "
+	fromFilePrefix  = "--- "
+	toFilePrefix    = "+++ "
+	hunkPrefix      = "@@ -"
+	noNewlinePrefix = "\\"
+	binaryPatch     = "GIT binary patch"
+)
+
+// Hunk is a portion of a file transformation as found in a unified diff: the
+// FromCount lines of the from file starting at FromLine are replaced by the
+// ToCount lines of the to file starting at ToLine. The line numbers start at
+// 1, a count of 0 means that the hunk is inserted after the given line.
+type Hunk struct {
+	FromLine, FromCount int
+	ToLine, ToCount     int
+	// Section is the text following the ranges in the hunk header, usually
+	// the function the hunk belongs to.
+	Section string
+	// Lines are the lines of the hunk.
+	Lines []HunkLine
+}
+
+// HunkLine is a line of a Hunk, its content includes the line terminator
+// except for the last line of a file without one.
+type HunkLine struct {
+	Type    Operation
+	Content string
+}
+
+// UnifiedFilePatch is the FilePatch decoded from a unified diff, it contains
+// only the changed portions of the files, instead of its whole content, as
+// Hunks. Its Chunks are the lines of the hunks.
+type UnifiedFilePatch interface {
+	FilePatch
+	// Hunks returns the hunks of the patch, in the order of the file.
+	Hunks() []*Hunk
+	// Index returns the hashes of the from and to blobs as found in the index
+	// line of the patch, usually abbreviated, empty if the patch has no index
+	// line. The Hash of the Files is only set when the hashes are complete.
+	Index() (from, to string)
+}
+
+// UnifiedDecoder decodes unified diffs, as produced by UnifiedEncoder, git
+// diff, git format-patch or diff -u, from the provided Reader.
+type UnifiedDecoder struct {
+	r *bufio.Reader
+
+	line   string
+	peeked bool
+	err    error
+}
+
+// NewUnifiedDecoder returns a new UnifiedDecoder that reads from r.
+func NewUnifiedDecoder(r io.Reader) *UnifiedDecoder {
+	return &UnifiedDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the whole input and returns the Patch it contains. The text
+// before the first file patch is returned as the Message of the patch, the
+// text between the file patches, such as the signature of a mail, is ignored.
+func (d *UnifiedDecoder) Decode() (Patch, error) {
+	p := &unifiedPatch{}
+
+	var msg bytes.Buffer
+	for {
+		line, ok := d.next()
+		if !ok {
+			break
+		}
+
+		var fp *unifiedFilePatch
+		var err error
+		switch {
+		case strings.HasPrefix(line, gitDiffPrefix):
+			fp, err = d.decodeGitHeader(line)
+		case strings.HasPrefix(line, fromFilePrefix) && d.peekPrefix(toFilePrefix):
+			fp, err = d.decodeHeader(line)
+		default:
+			if len(p.filePatches) == 0 {
+				msg.WriteString(line)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.decodeHunks(fp); err != nil {
+			return nil, err
+		}
+
+		p.filePatches = append(p.filePatches, fp.filePatch())
+	}
+
+	if d.err != nil && d.err != io.EOF {
+		return nil, d.err
+	}
+
+	p.message = msg.String()
+	return p, nil
+}
+
+// next returns the next line of the input, including its terminator.
+func (d *UnifiedDecoder) next() (string, bool) {
+	if d.peeked {
+		d.peeked = false
+		return d.line, true
+	}
+
+	if d.err != nil {
+		return "", false
+	}
+
+	d.line, d.err = d.r.ReadString('\n')
+	if d.err != nil && d.line == "" {
+		return "", false
+	}
+
+	return d.line, true
+}
+
+// peek returns the next line of the input without consuming it.
+func (d *UnifiedDecoder) peek() (string, bool) {
+	line, ok := d.next()
+	d.peeked = ok
+	return line, ok
+}
+
+func (d *UnifiedDecoder) peekPrefix(prefix string) bool {
+	line, ok := d.peek()
+	return ok && strings.HasPrefix(line, prefix)
+}
+
+// decodeGitHeader decodes the diff --git line and the extended header lines
+// following it.
+func (d *UnifiedDecoder) decodeGitHeader(line string) (*unifiedFilePatch, error) {
+	fp := &unifiedFilePatch{fromMode: filemode.Regular, toMode: filemode.Regular}
+	fp.fromPath, fp.toPath = parseGitDiffPaths(trimEOL(line[len(gitDiffPrefix):]))
+
+	for {
+		line, ok := d.peek()
+		if !ok || strings.HasPrefix(line, hunkPrefix) ||
+			strings.HasPrefix(line, gitDiffPrefix) {
+			return fp, nil
+		}
+
+		d.next()
+		line = trimEOL(line)
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			fp.fromMode, err = parseMode(line[len("old mode "):])
+		case strings.HasPrefix(line, "new mode "):
+			fp.toMode, err = parseMode(line[len("new mode "):])
+		case strings.HasPrefix(line, "deleted file mode "):
+			fp.deleted = true
+			fp.fromMode, err = parseMode(line[len("deleted file mode "):])
+		case strings.HasPrefix(line, "new file mode "):
+			fp.created = true
+			fp.toMode, err = parseMode(line[len("new file mode "):])
+		case strings.HasPrefix(line, "rename from "):
+			fp.renamed = true
+			fp.fromPath = unquotePath(line[len("rename from "):])
+		case strings.HasPrefix(line, "rename to "):
+			fp.renamed = true
+			fp.toPath = unquotePath(line[len("rename to "):])
+		case strings.HasPrefix(line, "copy from "):
+			fp.copied = true
+			fp.fromPath = unquotePath(line[len("copy from "):])
+		case strings.HasPrefix(line, "copy to "):
+			fp.copied = true
+			fp.toPath = unquotePath(line[len("copy to "):])
+		case strings.HasPrefix(line, "similarity index "):
+			fp.similarity, err = parsePercentage(line[len("similarity index "):])
+		case strings.HasPrefix(line, "dissimilarity index "):
+			_, err = parsePercentage(line[len("dissimilarity index "):])
+		case strings.HasPrefix(line, "index "):
+			err = fp.parseIndex(line[len("index "):])
+		case strings.HasPrefix(line, fromFilePrefix):
+			if p := parseFilePath(line[len(fromFilePrefix):]); p != "" {
+				fp.fromPath = p
+			} else {
+				fp.created = true
+			}
+		case strings.HasPrefix(line, toFilePrefix):
+			if p := parseFilePath(line[len(toFilePrefix):]); p != "" {
+				fp.toPath = p
+			} else {
+				fp.deleted = true
+			}
+		case strings.HasPrefix(line, "Binary files "):
+			fp.binary = true
+		case line == binaryPatch:
+			fp.binary = true
+			d.skipBinaryPatch()
+		default:
+			d.peeked = true
+			return fp, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeHeader decodes the --- and +++ lines of a traditional unified diff.
+func (d *UnifiedDecoder) decodeHeader(line string) (*unifiedFilePatch, error) {
+	fp := &unifiedFilePatch{fromMode: filemode.Regular, toMode: filemode.Regular}
+	fp.fromPath = parseFilePath(trimEOL(line[len(fromFilePrefix):]))
+
+	line, _ = d.next()
+	fp.toPath = parseFilePath(trimEOL(line[len(toFilePrefix):]))
+
+	switch {
+	case fp.fromPath == "":
+		fp.created, fp.fromPath = true, fp.toPath
+	case fp.toPath == "":
+		fp.deleted, fp.toPath = true, fp.fromPath
+	}
+
+	return fp, nil
+}
+
+// skipBinaryPatch skips the data of a GIT binary patch, the literal or delta
+// of the forward and reverse transformations, each one ended by an empty line.
+func (d *UnifiedDecoder) skipBinaryPatch() {
+	for {
+		line, ok := d.peek()
+		if !ok || strings.HasPrefix(line, gitDiffPrefix) {
+			return
+		}
+
+		d.next()
+	}
+}
+
+func (d *UnifiedDecoder) decodeHunks(fp *unifiedFilePatch) error {
+	for d.peekPrefix(hunkPrefix) {
+		line, _ := d.next()
+		h, err := parseHunkHeader(trimEOL(line))
+		if err != nil {
+			return err
+		}
+
+		if err := d.decodeHunkLines(h); err != nil {
+			return err
+		}
+
+		fp.hunks = append(fp.hunks, h)
+	}
+
+	return nil
+}
+
+func (d *UnifiedDecoder) decodeHunkLines(h *Hunk) error {
+	from, to := h.FromCount, h.ToCount
+	for from > 0 || to > 0 {
+		line, ok := d.next()
+		if !ok {
+			return ErrCorruptPatch
+		}
+
+		var t Operation
+		switch line[0] {
+		case ' ', '\n', '\r':
+			// some mail clients remove the trailing space of the empty
+			// context lines
+			if line[0] != ' ' {
+				line = " " + line
+			}
+
+			t = Equal
+			from--
+			to--
+		case '-':
+			t = Delete
+			from--
+		case '+':
+			t = Add
+			to--
+		default:
+			return ErrCorruptPatch
+		}
+
+		if from < 0 || to < 0 {
+			return ErrCorruptPatch
+		}
+
+		h.Lines = append(h.Lines, HunkLine{Type: t, Content: line[1:]})
+		d.decodeNoNewline(h)
+	}
+
+	return nil
+}
+
+// decodeNoNewline removes the terminator of the last line of the hunk if it's
+// followed by the "\ No newline at end of file" marker.
+func (d *UnifiedDecoder) decodeNoNewline(h *Hunk) {
+	if !d.peekPrefix(noNewlinePrefix) {
+		return
+	}
+
+	d.next()
+	l := &h.Lines[len(h.Lines)-1]
+	l.Content = strings.TrimSuffix(l.Content, "\n")
+}
+
+// parseHunkHeader parses a hunk header such as @@ -1,3 +1,4 @@ func main().
+func parseHunkHeader(line string) (*Hunk, error) {
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) < 4 || fields[0] != "@@" || fields[3] != "@@" ||
+		!strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return nil, ErrInvalidHunkHeader
+	}
+
+	h := &Hunk{}
+	var err error
+	if h.FromLine, h.FromCount, err = parseRange(fields[1][1:]); err != nil {
+		return nil, err
+	}
+
+	if h.ToLine, h.ToCount, err = parseRange(fields[2][1:]); err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 5 {
+		h.Section = fields[4]
+	}
+
+	return h, nil
+}
+
+// parseRange parses the range of a hunk header, such as 12,3, the count
+// defaults to 1.
+func parseRange(s string) (line, count int, err error) {
+	count = 1
+	if i := strings.IndexByte(s, ','); i != -1 {
+		if count, err = strconv.Atoi(s[i+1:]); err != nil {
+			return 0, 0, ErrInvalidHunkHeader
+		}
+
+		s = s[:i]
+	}
+
+	if line, err = strconv.Atoi(s); err != nil {
+		return 0, 0, ErrInvalidHunkHeader
+	}
+
+	return line, count, nil
+}
+
+// parseGitDiffPaths returns the paths found in the diff --git line, without
+// their a/ and b/ prefixes. When the paths aren't quoted and contain spaces
+// they are only found if both are the same.
+func parseGitDiffPaths(s string) (from, to string) {
+	if strings.HasPrefix(s, `"`) {
+		if i := quotedEnd(s); i != -1 {
+			return parsePath(s[:i]), parsePath(strings.TrimSpace(s[i:]))
+		}
+	}
+
+	if n := (len(s) - 1) / 2; len(s)%2 == 1 && s[n] == ' ' &&
+		stripPrefix(s[:n]) == stripPrefix(s[n+1:]) {
+		return stripPrefix(s[:n]), stripPrefix(s[n+1:])
+	}
+
+	if i := strings.Index(s, " b/"); i != -1 {
+		return parsePath(s[:i]), parsePath(s[i+1:])
+	}
+
+	return "", ""
+}
+
+// quotedEnd returns the index following the quoted string at the beginning
+// of s, -1 if it's not terminated.
+func quotedEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// parseFilePath parses the path of a --- or +++ line, returning an empty path
+// for /dev/null.
+func parseFilePath(s string) string {
+	// traditional diffs append the modification time after a tab
+	if i := strings.IndexByte(s, '\t'); i != -1 {
+		s = s[:i]
+	}
+
+	if s == noFilePath {
+		return ""
+	}
+
+	return parsePath(s)
+}
+
+// parsePath unquotes the given path and removes its a/ or b/ prefix.
+func parsePath(s string) string {
+	return stripPrefix(unquotePath(s))
+}
+
+// unquotePath unquotes the given path, quoted by git when it contains special
+// characters.
+func unquotePath(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+
+	return s
+}
+
+func stripPrefix(s string) string {
+	if strings.HasPrefix(s, aDir) || strings.HasPrefix(s, bDir) {
+		return s[len(aDir):]
+	}
+
+	return s
+}
+
+func parseMode(s string) (filemode.FileMode, error) {
+	m, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil {
+		return filemode.Empty, ErrInvalidFileHeader
+	}
+
+	return filemode.FileMode(m), nil
+}
+
+func parsePercentage(s string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return 0, ErrInvalidFileHeader
+	}
+
+	return p, nil
+}
+
+func trimEOL(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+type unifiedPatch struct {
+	message     string
+	filePatches []FilePatch
+}
+
+func (p *unifiedPatch) FilePatches() []FilePatch {
+	return p.filePatches
+}
+
+func (p *unifiedPatch) Message() string {
+	return p.message
+}
+
+type unifiedFilePatch struct {
+	fromPath, toPath   string
+	fromMode, toMode   filemode.FileMode
+	fromIndex, toIndex string
+
+	created, deleted bool
+	renamed, copied  bool
+	similarity       int
+	binary           bool
+	hunks            []*Hunk
+}
+
+// parseIndex parses the index line, such as abc1234..def5678 100644.
+func (fp *unifiedFilePatch) parseIndex(s string) error {
+	fields := strings.Fields(s)
+	hashes := strings.SplitN(fields[0], "..", 2)
+	if len(hashes) != 2 {
+		return ErrInvalidFileHeader
+	}
+
+	fp.fromIndex, fp.toIndex = hashes[0], hashes[1]
+	if len(fields) > 1 {
+		m, err := parseMode(fields[1])
+		if err != nil {
+			return err
+		}
+
+		fp.fromMode, fp.toMode = m, m
+	}
+
+	return nil
+}
+
+// filePatch returns the FilePatch, implementing RenameFilePatch if the file
+// was renamed or copied.
+func (fp *unifiedFilePatch) filePatch() FilePatch {
+	if fp.renamed || fp.copied {
+		return &unifiedRenameFilePatch{fp}
+	}
+
+	return fp
+}
+
+func (fp *unifiedFilePatch) IsBinary() bool {
+	return fp.binary
+}
+
+func (fp *unifiedFilePatch) Files() (from, to File) {
+	if !fp.created {
+		from = &unifiedFile{path: fp.fromPath, mode: fp.fromMode, hash: fullHash(fp.fromIndex)}
+	}
+
+	if !fp.deleted {
+		to = &unifiedFile{path: fp.toPath, mode: fp.toMode, hash: fullHash(fp.toIndex)}
+	}
+
+	return
+}
+
+func (fp *unifiedFilePatch) Chunks() []Chunk {
+	var chunks []Chunk
+	for _, h := range fp.hunks {
+		for _, l := range h.Lines {
+			if n := len(chunks); n != 0 && chunks[n-1].Type() == l.Type {
+				chunks[n-1].(*unifiedChunk).content += l.Content
+				continue
+			}
+
+			chunks = append(chunks, &unifiedChunk{content: l.Content, op: l.Type})
+		}
+	}
+
+	return chunks
+}
+
+func (fp *unifiedFilePatch) Hunks() []*Hunk {
+	return fp.hunks
+}
+
+func (fp *unifiedFilePatch) Index() (from, to string) {
+	return fp.fromIndex, fp.toIndex
+}
+
+type unifiedRenameFilePatch struct {
+	*unifiedFilePatch
+}
+
+func (fp *unifiedRenameFilePatch) Similarity() int {
+	return fp.similarity
+}
+
+func (fp *unifiedRenameFilePatch) IsCopy() bool {
+	return fp.copied
+}
+
+// fullHash returns the hash of the given hex string, or the zero hash if it
+// isn't a complete hash.
+func fullHash(s string) plumbing.Hash {
+	if len(s) != len(plumbing.ZeroHash)*2 {
+		return plumbing.ZeroHash
+	}
+
+	return plumbing.NewHash(s)
+}
+
+type unifiedFile struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+	path string
+}
+
+func (f *unifiedFile) Hash() plumbing.Hash {
+	return f.hash
+}
+
+func (f *unifiedFile) Mode() filemode.FileMode {
+	return f.mode
+}
+
+func (f *unifiedFile) Path() string {
+	return f.path
+}
+
+type unifiedChunk struct {
+	content string
+	op      Operation
+}
+
+func (c *unifiedChunk) Content() string {
+	return c.content
+}
+
+func (c *unifiedChunk) Type() Operation {
+	return c.op
+}
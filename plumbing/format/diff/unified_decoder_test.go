@@ -0,0 +1,191 @@
+package diff
+
+import (
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+type UnifiedDecoderTestSuite struct{}
+
+var _ = Suite(&UnifiedDecoderTestSuite{})
+
+func (s *UnifiedDecoderTestSuite) decode(c *C, input string) Patch {
+	p, err := NewUnifiedDecoder(strings.NewReader(input)).Decode()
+	c.Assert(err, IsNil)
+	return p
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecode(c *C) {
+	p := s.decode(c, `From 1234 Mon Sep 17 00:00:00 2001
+Subject: [PATCH] foo
+
+---
+ foo | 3 ++-
+ 1 file changed
+
+diff --git a/foo b/foo
+index 30d74d2..f079749 100644
+--- a/foo
++++ b/foo
+@@ -1,4 +1,5 @@ func foo()
+ a
+-b
++B
++C
+ c
+
+@@ -10 +11,0 @@
+-j
+--
+2.17.0
+`)
+
+	c.Assert(p.Message(), Equals, "From 1234 Mon Sep 17 00:00:00 2001\nSubject: [PATCH] foo\n\n---\n foo | 3 ++-\n 1 file changed\n\n")
+	c.Assert(p.FilePatches(), HasLen, 1)
+
+	fp := p.FilePatches()[0].(UnifiedFilePatch)
+	c.Assert(fp.IsBinary(), Equals, false)
+
+	from, to := fp.Files()
+	c.Assert(from.Path(), Equals, "foo")
+	c.Assert(from.Mode(), Equals, filemode.Regular)
+	c.Assert(from.Hash(), Equals, plumbing.ZeroHash)
+	c.Assert(to.Path(), Equals, "foo")
+
+	fromIndex, toIndex := fp.Index()
+	c.Assert(fromIndex, Equals, "30d74d2")
+	c.Assert(toIndex, Equals, "f079749")
+
+	hunks := fp.Hunks()
+	c.Assert(hunks, HasLen, 2)
+	c.Assert(*hunks[0], DeepEquals, Hunk{
+		FromLine: 1, FromCount: 4, ToLine: 1, ToCount: 5,
+		Section: "func foo()",
+		Lines: []HunkLine{
+			{Equal, "a\n"}, {Delete, "b\n"}, {Add, "B\n"}, {Add, "C\n"},
+			{Equal, "c\n"}, {Equal, "\n"},
+		},
+	})
+	c.Assert(*hunks[1], DeepEquals, Hunk{
+		FromLine: 10, FromCount: 1, ToLine: 11, ToCount: 0,
+		Lines: []HunkLine{{Delete, "j\n"}},
+	})
+
+	chunks := fp.Chunks()
+	c.Assert(chunks, HasLen, 5)
+	c.Assert(chunks[2].Type(), Equals, Add)
+	c.Assert(chunks[2].Content(), Equals, "B\nC\n")
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeGitHeaders(c *C) {
+	p := s.decode(c, `diff --git a/new b/new
+new file mode 100755
+index 0000000..e69de29
+diff --git a/old b/old
+deleted file mode 100644
+index 257cc56..0000000
+--- a/old
++++ /dev/null
+@@ -1 +0,0 @@
+-foo
+\ No newline at end of file
+diff --git a/a/x b/a/y
+similarity index 90%
+rename from a/x
+rename to a/y
+diff --git a/mode b/mode
+old mode 100644
+new mode 100755
+diff --git "a/sp\303\251cial" "b/sp\303\251cial"
+index 257cc5642cb1a054f08cc83f2d943e56fd3ebe99..5716ca5987cbf97d6bb54920bea6adde242d87e6
+Binary files "a/sp\303\251cial" and "b/sp\303\251cial" differ
+`)
+
+	fps := p.FilePatches()
+	c.Assert(fps, HasLen, 5)
+
+	from, to := fps[0].Files()
+	c.Assert(from, IsNil)
+	c.Assert(to.Path(), Equals, "new")
+	c.Assert(to.Mode(), Equals, filemode.Executable)
+
+	from, to = fps[1].Files()
+	c.Assert(from.Path(), Equals, "old")
+	c.Assert(to, IsNil)
+	c.Assert(fps[1].(UnifiedFilePatch).Hunks()[0].Lines, DeepEquals, []HunkLine{{Delete, "foo"}})
+
+	rename, ok := fps[2].(RenameFilePatch)
+	c.Assert(ok, Equals, true)
+	c.Assert(rename.Similarity(), Equals, 90)
+	c.Assert(rename.IsCopy(), Equals, false)
+	from, to = rename.Files()
+	c.Assert(from.Path(), Equals, "a/x")
+	c.Assert(to.Path(), Equals, "a/y")
+
+	from, to = fps[3].Files()
+	c.Assert(from.Mode(), Equals, filemode.Regular)
+	c.Assert(to.Mode(), Equals, filemode.Executable)
+
+	c.Assert(fps[4].IsBinary(), Equals, true)
+	from, to = fps[4].Files()
+	c.Assert(from.Path(), Equals, "spécial")
+	c.Assert(to.Hash(), Equals, plumbing.NewHash("5716ca5987cbf97d6bb54920bea6adde242d87e6"))
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeTraditional(c *C) {
+	p := s.decode(c, `--- foo.orig	2018-01-01 00:00:00.000000000 +0100
++++ foo	2018-01-01 00:00:00.000000000 +0100
+@@ -1,2 +1,2 @@
+-foo
++bar
+ baz
+--- /dev/null
++++ b/bar
+@@ -0,0 +1 @@
++bar
+`)
+
+	fps := p.FilePatches()
+	c.Assert(fps, HasLen, 2)
+
+	from, to := fps[0].Files()
+	c.Assert(from.Path(), Equals, "foo.orig")
+	c.Assert(to.Path(), Equals, "foo")
+
+	from, to = fps[1].Files()
+	c.Assert(from, IsNil)
+	c.Assert(to.Path(), Equals, "bar")
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeEncoded(c *C) {
+	for _, f := range fixtures {
+		p, err := NewUnifiedDecoder(strings.NewReader(f.diff)).Decode()
+		c.Assert(err, IsNil, Commentf("%s", f.diff))
+
+		var expected int
+		for _, fp := range f.patch.FilePatches() {
+			if from, to := fp.Files(); from != nil || to != nil {
+				expected++
+			}
+		}
+
+		c.Assert(p.FilePatches(), HasLen, expected, Commentf("%s", f.diff))
+	}
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeErrors(c *C) {
+	for input, expected := range map[string]error{
+		"--- a/foo\n+++ b/foo\n@@ -1 +1 @\n":                    ErrInvalidHunkHeader,
+		"--- a/foo\n+++ b/foo\n@@ -a +1 @@\n":                   ErrInvalidHunkHeader,
+		"--- a/foo\n+++ b/foo\n@@ -1,2 +1,2 @@\n-foo\n+bar\n":   ErrCorruptPatch,
+		"--- a/foo\n+++ b/foo\n@@ -1 +1 @@\n-foo\n-bar\n+bar\n": ErrCorruptPatch,
+		"diff --git a/foo b/foo\nold mode foo\n":                ErrInvalidFileHeader,
+	} {
+		_, err := NewUnifiedDecoder(strings.NewReader(input)).Decode()
+		c.Assert(err, Equals, expected, Commentf("%s", input))
+	}
+}
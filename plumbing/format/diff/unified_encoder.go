@@ -34,9 +34,11 @@ const (
 	deletedFileMode = "deleted file mode %o\n"
 	newFileMode     = "new file mode %o\n"
 
-	renameFrom     = "from"
-	renameTo       = "to"
-	renameFileMode = "rename %s %s\n"
+	renameFrom      = "from"
+	renameTo        = "to"
+	renameFileMode  = "rename %s %s\n"
+	copyFileMode    = "copy %s %s\n"
+	similarityIndex = "similarity index %d%%\n"
 
 	indexAndMode = "index %s..%s %o\n"
 	indexNoMode  = "index %s..%s\n"
@@ -46,7 +48,6 @@ const (
 
 // UnifiedEncoder encodes an unified diff into the provided Writer.
 // There are some unsupported features:
-//     - Similarity index for renames
 //     - Sort hash representation
 type UnifiedEncoder struct {
 	io.Writer
@@ -77,7 +78,7 @@ func (e *UnifiedEncoder) Encode(patch Patch) error {
 func (e *UnifiedEncoder) encodeFilePatch(filePatches []FilePatch) error {
 	for _, p := range filePatches {
 		f, t := p.Files()
-		if err := e.header(f, t, p.IsBinary()); err != nil {
+		if err := e.header(p, f, t, p.IsBinary()); err != nil {
 			return err
 		}
 
@@ -100,7 +101,7 @@ func (e *UnifiedEncoder) printMessage(message string) {
 	e.buf.WriteString(message)
 }
 
-func (e *UnifiedEncoder) header(from, to File, isBinary bool) error {
+func (e *UnifiedEncoder) header(p FilePatch, from, to File, isBinary bool) error {
 	switch {
 	case from == nil && to == nil:
 		return nil
@@ -114,9 +115,7 @@ func (e *UnifiedEncoder) header(from, to File, isBinary bool) error {
 		}
 
 		if from.Path() != to.Path() {
-			fmt.Fprintf(&e.buf,
-				renameFileMode+renameFileMode,
-				renameFrom, from.Path(), renameTo, to.Path())
+			e.rename(p, from, to)
 		}
 
 		if from.Mode() != to.Mode() && !hashEquals {
@@ -143,6 +142,18 @@ func (e *UnifiedEncoder) header(from, to File, isBinary bool) error {
 	return nil
 }
 
+func (e *UnifiedEncoder) rename(p FilePatch, from, to File) {
+	format := renameFileMode
+	if rp, ok := p.(RenameFilePatch); ok {
+		fmt.Fprintf(&e.buf, similarityIndex, rp.Similarity())
+		if rp.IsCopy() {
+			format = copyFileMode
+		}
+	}
+
+	fmt.Fprintf(&e.buf, format+format, renameFrom, from.Path(), renameTo, to.Path())
+}
+
 func (e *UnifiedEncoder) pathLines(isBinary bool, fromPath, toPath string) {
 	format := fPath + tPath
 	if isBinary {
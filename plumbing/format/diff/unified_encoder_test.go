@@ -51,6 +51,66 @@ Binary files a/binary and b/binary differ
 `)
 }
 
+func (s *UnifiedEncoderTestSuite) TestRenameWithSimilarity(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	e := NewUnifiedEncoder(buffer, 1)
+	p := testRenamePatch{
+		filePatches: []testRenameFilePatch{{
+			testFilePatch: testFilePatch{
+				from: &testFile{mode: filemode.Regular, path: "test.txt", seed: "test"},
+				to:   &testFile{mode: filemode.Regular, path: "test1.txt", seed: "test1"},
+				chunks: []testChunk{
+					{content: "test\n", op: Delete},
+					{content: "test1\n", op: Add},
+				},
+			},
+			similarity: 72,
+		}},
+	}
+
+	err := e.Encode(p)
+	c.Assert(err, IsNil)
+
+	c.Assert(buffer.String(), Equals, `diff --git a/test.txt b/test1.txt
+similarity index 72%
+rename from test.txt
+rename to test1.txt
+index 30d74d258442c7c65512eafab474568dd706c430..f079749c42ffdcc5f52ed2d3a6f15b09307e975e 100644
+--- a/test.txt
++++ b/test1.txt
+@@ -1 +1 @@
+-test
++test1
+`)
+}
+
+func (s *UnifiedEncoderTestSuite) TestCopy(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	e := NewUnifiedEncoder(buffer, 1)
+	p := testRenamePatch{
+		filePatches: []testRenameFilePatch{{
+			testFilePatch: testFilePatch{
+				from: &testFile{mode: filemode.Regular, path: "test.txt", seed: "test"},
+				to:   &testFile{mode: filemode.Regular, path: "test1.txt", seed: "test"},
+				chunks: []testChunk{
+					{content: "test\n", op: Equal},
+				},
+			},
+			similarity: 100,
+			copy:       true,
+		}},
+	}
+
+	err := e.Encode(p)
+	c.Assert(err, IsNil)
+
+	c.Assert(buffer.String(), Equals, `diff --git a/test.txt b/test1.txt
+similarity index 100%
+copy from test.txt
+copy to test1.txt
+`)
+}
+
 func (s *UnifiedEncoderTestSuite) TestEncode(c *C) {
 	for _, f := range fixtures {
 		c.Log("executing: ", f.desc)
@@ -827,6 +887,37 @@ func (t testFilePatch) Chunks() []Chunk {
 	return result
 }
 
+type testRenamePatch struct {
+	filePatches []testRenameFilePatch
+}
+
+func (t testRenamePatch) FilePatches() []FilePatch {
+	var result []FilePatch
+	for _, f := range t.filePatches {
+		result = append(result, f)
+	}
+
+	return result
+}
+
+func (t testRenamePatch) Message() string {
+	return ""
+}
+
+type testRenameFilePatch struct {
+	testFilePatch
+	similarity int
+	copy       bool
+}
+
+func (t testRenameFilePatch) Similarity() int {
+	return t.similarity
+}
+
+func (t testRenameFilePatch) IsCopy() bool {
+	return t.copy
+}
+
 type testFile struct {
 	path string
 	mode filemode.FileMode
@@ -134,3 +134,53 @@ func LoadGlobalPatterns(fs billy.Filesystem) (ps []Pattern, err error) {
 func LoadSystemPatterns(fs billy.Filesystem) (ps []Pattern, err error) {
 	return loadPatterns(fs, systemFile)
 }
+
+// LoadExcludesFile loads gitignore patterns from the given file, as declared
+// by the core.excludesfile property. A leading ~/ in the path is expanded to
+// the home directory of the user and, if the path is empty, the default
+// $XDG_CONFIG_HOME/git/ignore file is used.  If the file does not exist the
+// function will return nil.
+//
+// The function assumes fs is rooted at the root filesystem.
+func LoadExcludesFile(fs billy.Filesystem, path string) (ps []Pattern, err error) {
+	if path == "" {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			home, err := homeDir()
+			if err != nil {
+				return nil, err
+			}
+
+			xdg = fs.Join(home, ".config")
+		}
+
+		path = fs.Join(xdg, "git", "ignore")
+	} else if strings.HasPrefix(path, "~/") {
+		home, err := homeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		path = fs.Join(home, path[2:])
+	}
+
+	ps, err = readIgnoreFile(fs, nil, path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return
+}
+
+func homeDir() (string, error) {
+	if home := os.Getenv("HOME"); home != "" {
+		return home, nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return usr.HomeDir, nil
+}
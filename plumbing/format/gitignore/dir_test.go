@@ -211,3 +211,33 @@ func (s *MatcherSuite) TestDir_LoadSystemPatterns(c *C) {
 	c.Assert(m.Match([]string{"go-git.v4.iml"}, true), Equals, true)
 	c.Assert(m.Match([]string{".idea"}, true), Equals, true)
 }
+
+func (s *MatcherSuite) TestDir_LoadExcludesFile(c *C) {
+	usr, err := user.Current()
+	c.Assert(err, IsNil)
+
+	ps, err := LoadExcludesFile(s.RFS, s.RFS.Join(usr.HomeDir, ".gitignore_global"))
+	c.Assert(err, IsNil)
+	c.Assert(ps, HasLen, 2)
+
+	ps, err = LoadExcludesFile(s.MIFS, s.MIFS.Join(usr.HomeDir, ".gitignore_global"))
+	c.Assert(err, IsNil)
+	c.Assert(ps, HasLen, 0)
+}
+
+func (s *MatcherSuite) TestDir_LoadExcludesFileDefault(c *C) {
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", "/xdg")
+
+	fs := memfs.New()
+	f, err := fs.Create("/xdg/git/ignore")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("*.log\n"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	ps, err := LoadExcludesFile(fs, "")
+	c.Assert(err, IsNil)
+	c.Assert(ps, HasLen, 1)
+	c.Assert(NewMatcher(ps).Match([]string{"foo.log"}, false), Equals, true)
+}
@@ -100,6 +100,7 @@ func (w *Writer) createIndex() (*MemoryIndex, error) {
 
 	idx := new(MemoryIndex)
 	w.index = idx
+	w.offset64 = 0
 
 	sort.Sort(w.objects)
 
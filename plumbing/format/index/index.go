@@ -28,7 +28,7 @@ type Stage int
 
 const (
 	// Merged is the default stage, fully merged
-	Merged Stage = 1
+	Merged Stage = 0
 	// AncestorMode is the base revision
 	AncestorMode Stage = 1
 	// OurMode is the first tree revision, ours
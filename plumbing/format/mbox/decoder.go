@@ -0,0 +1,135 @@
+// Package mbox implements the decoding of mailboxes in mbox format, such as
+// the ones generated by git format-patch, and the extraction of the commit
+// information from the patches sent by email, as git mailinfo does.
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+const fromLinePrefix = "From "
+
+// Message is a message of a mailbox.
+type Message struct {
+	// Header is the header of the message.
+	Header mail.Header
+	// Body is the body of the message, decoded from its transfer encoding.
+	Body []byte
+}
+
+// Decoder reads the messages of a mailbox. The messages are separated by the
+// "From " lines, input without them is read as a single message.
+type Decoder struct {
+	r *bufio.Reader
+
+	line string
+	err  error
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next message of the mailbox, io.EOF is returned when there
+// are no more messages.
+func (d *Decoder) Decode() (*Message, error) {
+	raw, err := d.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := decodeBody(m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{Header: m.Header, Body: body}, nil
+}
+
+// readMessage returns the raw lines of the next message, without its "From "
+// line.
+func (d *Decoder) readMessage() ([]byte, error) {
+	if d.line == "" {
+		d.readLine()
+	}
+
+	// the empty lines between the messages are skipped
+	for d.line != "" && strings.TrimSpace(d.line) == "" {
+		d.readLine()
+	}
+
+	if d.line == "" {
+		if d.err == io.EOF {
+			return nil, io.EOF
+		}
+
+		return nil, d.err
+	}
+
+	if isFromLine(d.line) {
+		d.readLine()
+	}
+
+	var buf bytes.Buffer
+	blank := false
+	for d.line != "" {
+		if blank && isFromLine(d.line) {
+			break
+		}
+
+		blank = strings.TrimSpace(d.line) == ""
+		buf.WriteString(d.line)
+		d.readLine()
+	}
+
+	if d.err != nil && d.err != io.EOF {
+		return nil, d.err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isFromLine returns true if the line separates two messages, such as
+// "From 0123abc Mon Sep 17 00:00:00 2001", like git mailsplit the line must end
+// with a time and a year.
+func isFromLine(line string) bool {
+	if !strings.HasPrefix(line, fromLinePrefix) {
+		return false
+	}
+
+	fields := strings.Fields(line)
+	return len(fields) >= 7 && strings.Count(fields[len(fields)-2], ":") == 2
+}
+
+func (d *Decoder) readLine() {
+	if d.err != nil {
+		d.line = ""
+		return
+	}
+
+	d.line, d.err = d.r.ReadString('\n')
+}
+
+func decodeBody(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	return ioutil.ReadAll(body)
+}
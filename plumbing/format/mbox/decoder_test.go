@@ -0,0 +1,125 @@
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DecoderSuite struct{}
+
+var _ = Suite(&DecoderSuite{})
+
+const mailbox = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: John Doe <john@doe.com>
+Date: Mon, 1 Jan 2018 10:00:00 +0100
+Subject: [PATCH 1/2] Add foo
+
+Adds foo.
+
+From the body, not a new message.
+---
+ foo | 1 +
+ 1 file changed, 1 insertion(+)
+
+diff --git a/foo b/foo
+new file mode 100644
+index 0000000..257cc56
+--- /dev/null
++++ b/foo
+@@ -0,0 +1 @@
++foo
+--
+2.17.0
+
+From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Sender <sender@foo.com>
+Date: Tue, 2 Jan 2018 10:00:00 +0100
+Subject: Re: [PATCH 2/2]
+ =?UTF-8?q?Change_f=C3=B6o?=
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: quoted-printable
+
+From: J=C3=B6rg <jorg@foo.com>
+
+diff --git a/foo b/foo
+--- a/foo
++++ b/foo
+@@ -1 +1 @@
+-foo
++f=C3=B6o
+`
+
+func (s *DecoderSuite) TestDecode(c *C) {
+	d := NewDecoder(strings.NewReader(mailbox))
+
+	m, err := d.Decode()
+	c.Assert(err, IsNil)
+	c.Assert(m.Header.Get("Subject"), Equals, "[PATCH 1/2] Add foo")
+
+	info, err := m.Info()
+	c.Assert(err, IsNil)
+	c.Assert(info.Name, Equals, "John Doe")
+	c.Assert(info.Email, Equals, "john@doe.com")
+	c.Assert(info.Date.Equal(time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)), Equals, true)
+	c.Assert(info.Subject, Equals, "Add foo")
+	c.Assert(info.Message, Equals, "Add foo\n\nAdds foo.\n\nFrom the body, not a new message.\n")
+	c.Assert(strings.HasPrefix(info.Patch, " foo | 1 +\n"), Equals, true)
+	c.Assert(strings.Contains(info.Patch, "+foo\n"), Equals, true)
+
+	m, err = d.Decode()
+	c.Assert(err, IsNil)
+
+	info, err = m.Info()
+	c.Assert(err, IsNil)
+	c.Assert(info.Name, Equals, "Jörg")
+	c.Assert(info.Email, Equals, "jorg@foo.com")
+	c.Assert(info.Subject, Equals, "Change föo")
+	c.Assert(info.Message, Equals, "Change föo\n")
+	c.Assert(strings.HasPrefix(info.Patch, "diff --git a/foo b/foo\n"), Equals, true)
+	c.Assert(strings.HasSuffix(info.Patch, "+föo\n"), Equals, true)
+
+	_, err = d.Decode()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *DecoderSuite) TestDecodeSingleMessage(c *C) {
+	d := NewDecoder(strings.NewReader("From: foo@foo.com\nSubject: foo\n\nbar\n"))
+
+	m, err := d.Decode()
+	c.Assert(err, IsNil)
+
+	info, err := m.Info()
+	c.Assert(err, IsNil)
+	c.Assert(info.Name, Equals, "foo@foo.com")
+	c.Assert(info.Message, Equals, "foo\n\nbar\n")
+	c.Assert(info.Patch, Equals, "")
+
+	_, err = d.Decode()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *DecoderSuite) TestInfoMissingAuthor(c *C) {
+	m, err := NewDecoder(strings.NewReader("Subject: foo\n\nbar\n")).Decode()
+	c.Assert(err, IsNil)
+
+	_, err = m.Info()
+	c.Assert(err, Equals, ErrMissingAuthor)
+}
+
+func (s *DecoderSuite) TestCleanSubject(c *C) {
+	for subject, expected := range map[string]string{
+		"foo":                      "foo",
+		"[PATCH] foo":              "foo",
+		"Re: [PATCH v2 3/4] foo":   "foo",
+		"[RFC][PATCH]  foo\n bar":  "foo bar",
+		"[PATCH] [subsystem]: foo": ": foo",
+	} {
+		c.Assert(cleanSubject(subject), Equals, expected, Commentf("subject %q", subject))
+	}
+}
@@ -0,0 +1,159 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingAuthor is returned when the author of a patch can't be found
+	// in its From header.
+	ErrMissingAuthor = errors.New("mbox: missing author")
+)
+
+// Info is the commit information of a patch sent by email.
+type Info struct {
+	// Name and Email are the author of the patch, from the From header or an
+	// in-body From line.
+	Name, Email string
+	// Date is the author date of the patch, from the Date header or an
+	// in-body Date line.
+	Date time.Time
+	// Subject is the subject of the message, without the Re: and the
+	// bracketed prefixes such as [PATCH v2 1/3].
+	Subject string
+	// Message is the commit message: the subject followed by the body of the
+	// message until the --- separator.
+	Message string
+	// Patch is the part of the body following the --- separator, containing
+	// the diff.
+	Patch string
+}
+
+// Info extracts the commit information from the message, as git mailinfo
+// does. The From, Date and Subject headers can be overridden by lines at the
+// beginning of the body, as git format-patch writes when the sender isn't the
+// author of the patch.
+func (m *Message) Info() (*Info, error) {
+	from, date, subject := m.Header.Get("From"), m.Header.Get("Date"), m.Header.Get("Subject")
+
+	body := string(m.Body)
+	from, date, subject, body = inBodyHeaders(from, date, subject, body)
+
+	addr, err := mail.ParseAddress(decodeHeader(from))
+	if err != nil {
+		return nil, ErrMissingAuthor
+	}
+
+	info := &Info{
+		Name:    addr.Name,
+		Email:   addr.Address,
+		Subject: cleanSubject(decodeHeader(subject)),
+	}
+
+	if info.Name == "" {
+		info.Name = addr.Address
+	}
+
+	if date != "" {
+		if info.Date, err = mail.ParseDate(date); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, patch := splitBody(body)
+	info.Message = info.Subject + "\n"
+	if msg != "" {
+		info.Message += "\n" + msg + "\n"
+	}
+
+	info.Patch = patch
+	return info, nil
+}
+
+// inBodyHeaders returns the given headers overridden by the From, Date and
+// Subject lines found at the beginning of the body, and the rest of the body.
+func inBodyHeaders(from, date, subject, body string) (string, string, string, string) {
+	body = strings.TrimLeft(body, "\r\n")
+	for {
+		i := strings.IndexByte(body, '\n')
+		if i == -1 {
+			return from, date, subject, body
+		}
+
+		line := strings.TrimRight(body[:i], "\r")
+		switch {
+		case strings.HasPrefix(line, "From: "):
+			from = line[len("From: "):]
+		case strings.HasPrefix(line, "Date: "):
+			date = line[len("Date: "):]
+		case strings.HasPrefix(line, "Subject: "):
+			subject = line[len("Subject: "):]
+		default:
+			return from, date, subject, body
+		}
+
+		body = strings.TrimLeft(body[i+1:], "\r\n")
+	}
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header.
+func decodeHeader(s string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+
+	return decoded
+}
+
+// cleanSubject removes the Re: and the bracketed prefixes of the subject and
+// joins its lines.
+func cleanSubject(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	for {
+		switch {
+		case strings.HasPrefix(strings.ToLower(s), "re:"):
+			s = strings.TrimSpace(s[len("re:"):])
+		case strings.HasPrefix(s, "["):
+			i := strings.IndexByte(s, ']')
+			if i == -1 {
+				return s
+			}
+
+			s = strings.TrimSpace(s[i+1:])
+		default:
+			return s
+		}
+	}
+}
+
+// splitBody splits the body in the message, ended by the --- separator or by
+// the beginning of the diff, and the patch.
+func splitBody(body string) (msg, patch string) {
+	var buf bytes.Buffer
+	for rest := body; rest != ""; {
+		line := rest
+		if i := strings.IndexByte(rest, '\n'); i != -1 {
+			line, rest = rest[:i+1], rest[i+1:]
+		} else {
+			rest = ""
+		}
+
+		switch {
+		case strings.TrimRight(line, " \t\r\n") == "---":
+			return strings.TrimSpace(buf.String()), rest
+		case strings.HasPrefix(line, "diff -"), strings.HasPrefix(line, "Index: "):
+			return strings.TrimSpace(buf.String()), line + rest
+		}
+
+		buf.WriteString(strings.TrimRight(line, "\r\n"))
+		buf.WriteByte('\n')
+	}
+
+	return strings.TrimSpace(buf.String()), ""
+}
@@ -40,6 +40,27 @@ func UpdateObjectStorage(s storer.Storer, packfile io.Reader, statusChan plumbin
 	return err
 }
 
+// UpdatePromisorObjectStorage is like UpdateObjectStorage for the packfiles
+// fetched from a promisor remote, the storer keeps track of them if it
+// implements storer.PromisorPackfileWriter.
+func UpdatePromisorObjectStorage(s storer.Storer, packfile io.Reader, statusChan plumbing.StatusChan) error {
+	if pw, ok := s.(storer.PromisorPackfileWriter); ok {
+		return WritePackfileToObjectStorage(promisorPackfileWriter{pw}, packfile, statusChan)
+	}
+
+	return UpdateObjectStorage(s, packfile, statusChan)
+}
+
+// promisorPackfileWriter is a storer.PackfileWriter writing promisor
+// packfiles.
+type promisorPackfileWriter struct {
+	storer.PromisorPackfileWriter
+}
+
+func (w promisorPackfileWriter) PackfileWriter(statusChan plumbing.StatusChan) (io.WriteCloser, error) {
+	return w.PromisorPackfileWriter.PromisorPackfileWriter(statusChan)
+}
+
 // WritePackfileToObjectStorage writes all the packfile objects into the given
 // object storage.
 func WritePackfileToObjectStorage(
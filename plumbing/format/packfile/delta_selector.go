@@ -37,6 +37,17 @@ func (dw *deltaSelector) ObjectsToPack(
 	hashes []plumbing.Hash,
 	packWindow uint,
 	statusChan plumbing.StatusChan,
+) ([]*ObjectToPack, error) {
+	return dw.ObjectsToThinPack(hashes, nil, packWindow, statusChan)
+}
+
+// ObjectsToThinPack is like ObjectsToPack, the objects can also be deltas of
+// the given bases, which are returned as external objects to not be packed.
+func (dw *deltaSelector) ObjectsToThinPack(
+	hashes []plumbing.Hash,
+	bases []plumbing.Hash,
+	packWindow uint,
+	statusChan plumbing.StatusChan,
 ) ([]*ObjectToPack, error) {
 	update := plumbing.StatusUpdate{
 		Stage:        plumbing.StatusRead,
@@ -44,7 +55,7 @@ func (dw *deltaSelector) ObjectsToPack(
 	}
 	statusChan.SendUpdate(update)
 
-	otp, err := dw.objectsToPack(hashes, packWindow, statusChan, update)
+	otp, err := dw.objectsToPack(hashes, bases, packWindow, statusChan, update)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +117,7 @@ func (dw *deltaSelector) ObjectsToPack(
 
 func (dw *deltaSelector) objectsToPack(
 	hashes []plumbing.Hash,
+	bases []plumbing.Hash,
 	packWindow uint,
 	statusChan plumbing.StatusChan,
 	update plumbing.StatusUpdate,
@@ -138,9 +150,12 @@ func (dw *deltaSelector) objectsToPack(
 		return objectsToPack, nil
 	}
 
-	if packWindow == 0 {
-		return objectsToPack, nil
+	external, err := dw.externalObjectsToPack(hashes, bases)
+	if err != nil {
+		return nil, err
 	}
+
+	objectsToPack = append(objectsToPack, external...)
 	if err := dw.fixAndBreakChains(objectsToPack, statusChan); err != nil {
 		return nil, err
 	}
@@ -148,6 +163,49 @@ func (dw *deltaSelector) objectsToPack(
 	return objectsToPack, nil
 }
 
+// externalObjectsToPack returns the bases of a thin packfile not packed, the
+// ones of the applyDelta types not in hashes and present in the storage.
+func (dw *deltaSelector) externalObjectsToPack(
+	hashes []plumbing.Hash,
+	bases []plumbing.Hash,
+) ([]*ObjectToPack, error) {
+	if len(bases) == 0 {
+		return nil, nil
+	}
+
+	packed := make(map[plumbing.Hash]bool, len(hashes))
+	for _, h := range hashes {
+		packed[h] = true
+	}
+
+	var external []*ObjectToPack
+	for _, h := range bases {
+		if packed[h] {
+			continue
+		}
+
+		packed[h] = true
+		o, err := dw.encodedObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !applyDelta[o.Type()] {
+			continue
+		}
+
+		otp := newObjectToPack(o)
+		otp.external = true
+		external = append(external, otp)
+	}
+
+	return external, nil
+}
+
 func (dw *deltaSelector) encodedDeltaObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
 	edos, ok := dw.storer.(storer.DeltaObjectStorer)
 	if !ok {
@@ -289,8 +347,8 @@ func (dw *deltaSelector) walk(
 
 		// If we already have a delta, we don't try to find a new one for this
 		// object. This happens when a delta is set to be reused from an existing
-		// packfile.
-		if target.IsDelta() {
+		// packfile. The external objects aren't packed, they're only bases.
+		if target.IsDelta() || target.external {
 			sendUpdate()
 			continue
 		}
@@ -417,5 +475,11 @@ func (a byTypeAndSize) Less(i, j int) bool {
 		return true
 	}
 
+	// the external objects are only bases, they go first to be in the
+	// window of the ones to pack
+	if a[i].external != a[j].external {
+		return a[i].external
+	}
+
 	return a[i].Size() > a[j].Size()
 }
@@ -212,7 +212,7 @@ func (s *DeltaSelectorSuite) TestObjectsToPack(c *C) {
 	// Don't sort so we can easily check the sliding window without
 	// creating a bunch of new objects.
 	otp, err = s.ds.objectsToPack(
-		hashes, deltaWindowSize, nil, plumbing.StatusUpdate{})
+		hashes, nil, deltaWindowSize, nil, plumbing.StatusUpdate{})
 	c.Assert(err, IsNil)
 	u := plumbing.StatusUpdate{}
 	var m sync.Mutex
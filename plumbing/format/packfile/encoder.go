@@ -59,21 +59,48 @@ func (e *Encoder) Encode(
 	return e.encode(objects, statusChan)
 }
 
+// EncodeThin is like Encode, but the objects can also be deltas of the given
+// bases, which aren't included in the packfile. The resulting thin packfile
+// can only be read by a receiver having the bases, which must be "thickened"
+// appending them, see Thicken. The deltas of the bases are always
+// REFDeltaObject.
+func (e *Encoder) EncodeThin(
+	hashes []plumbing.Hash,
+	bases []plumbing.Hash,
+	packWindow uint,
+	statusChan plumbing.StatusChan,
+) (plumbing.Hash, error) {
+	objects, err := e.selector.ObjectsToThinPack(
+		hashes, bases, packWindow, statusChan)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return e.encode(objects, statusChan)
+}
+
 func (e *Encoder) encode(
 	objects []*ObjectToPack,
 	statusChan plumbing.StatusChan,
 ) (plumbing.Hash, error) {
+	var packed []*ObjectToPack
+	for _, o := range objects {
+		if !o.external {
+			packed = append(packed, o)
+		}
+	}
+
 	update := plumbing.StatusUpdate{
 		Stage:        plumbing.StatusSend,
-		ObjectsTotal: len(objects),
+		ObjectsTotal: len(packed),
 	}
 	statusChan.SendUpdate(update)
 
-	if err := e.head(len(objects)); err != nil {
+	if err := e.head(len(packed)); err != nil {
 		return plumbing.ZeroHash, err
 	}
 
-	for _, o := range objects {
+	for _, o := range packed {
 		if err := e.entry(o); err != nil {
 			return plumbing.ZeroHash, err
 		}
@@ -146,7 +173,7 @@ func (e *Encoder) entry(o *ObjectToPack) error {
 }
 
 func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
-	if o.IsDelta() && !o.Base.IsWritten() {
+	if o.IsDelta() && !o.Base.IsWritten() && !o.Base.external {
 		// We must write base first
 		return e.entry(o.Base)
 	}
@@ -155,9 +182,11 @@ func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
 }
 
 func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
-	// Write offset deltas by default
+	// Write offset deltas by default, the bases not packed can only be
+	// referenced by hash
+	useRefDeltas := e.useRefDeltas || o.Base.external
 	t := plumbing.OFSDeltaObject
-	if e.useRefDeltas {
+	if useRefDeltas {
 		t = plumbing.REFDeltaObject
 	}
 
@@ -165,7 +194,7 @@ func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
 		return err
 	}
 
-	if e.useRefDeltas {
+	if useRefDeltas {
 		return e.writeRefDeltaHeader(o.Base.Hash())
 	} else {
 		return e.writeOfsDeltaHeader(o)
@@ -188,6 +217,11 @@ func (e *Encoder) writeOfsDeltaHeader(o *ObjectToPack) error {
 }
 
 func (e *Encoder) entryHead(typeNum plumbing.ObjectType, size int64) error {
+	return writeEntryHead(e.w, typeNum, size)
+}
+
+// writeEntryHead writes the header of an object entry: its type and size.
+func writeEntryHead(w io.Writer, typeNum plumbing.ObjectType, size int64) error {
 	t := int64(typeNum)
 	header := []byte{}
 	c := (t << firstLengthBits) | (size & maskFirstLength)
@@ -202,7 +236,7 @@ func (e *Encoder) entryHead(typeNum plumbing.ObjectType, size int64) error {
 	}
 
 	header = append(header, byte(c))
-	_, err := e.w.Write(header)
+	_, err := w.Write(header)
 
 	return err
 }
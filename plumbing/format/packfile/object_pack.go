@@ -24,6 +24,10 @@ type ObjectToPack struct {
 	// has not been written yet
 	Offset int64
 
+	// external objects are bases of the deltas of a thin packfile, which
+	// aren't written
+	external bool
+
 	// Information from the original object
 	resolvedOriginal bool
 	originalType     plumbing.ObjectType
@@ -8,6 +8,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 )
 
 var (
@@ -67,6 +68,7 @@ func (so *StatusObserver) OnFooter(plumbing.Hash) error {
 // to generate indexes.
 type Parser struct {
 	storage          storer.EncodedObjectStorer
+	bases            storer.EncodedObjectStorer
 	scanner          *Scanner
 	count            uint32
 	oi               []*objectInfo
@@ -74,9 +76,12 @@ type Parser struct {
 	oiByOffset       map[int64]*objectInfo
 	hashOffset       map[plumbing.Hash]int64
 	pendingRefDeltas map[plumbing.Hash][]*objectInfo
+	external         []*objectInfo
 	checksum         plumbing.Hash
 
 	cache *cache.BufferLRU
+	// content of the objects missing in the packfile by hash
+	externalCache map[plumbing.Hash][]byte
 	// delta content by offset, only used if source is not seekable
 	deltas map[int64][]byte
 
@@ -90,11 +95,34 @@ func NewParser(scanner *Scanner, ob ...Observer) (*Parser, error) {
 }
 
 // NewParserWithStorage creates a new Parser. The scanner source must either
-// be seekable or a storage must be provided.
+// be seekable or a storage must be provided. The objects are stored in the
+// storage, the bases of the deltas missing in a thin packfile are read from
+// it.
 func NewParserWithStorage(
 	scanner *Scanner,
 	storage storer.EncodedObjectStorer,
 	ob ...Observer,
+) (*Parser, error) {
+	return newParser(scanner, storage, storage, ob...)
+}
+
+// NewParserWithBases creates a new Parser of a thin packfile, the bases of its
+// deltas missing in it are read from the given storage, see ExternalBases.
+// Unlike NewParserWithStorage, the objects aren't stored in it, so the scanner
+// source must be seekable.
+func NewParserWithBases(
+	scanner *Scanner,
+	bases storer.EncodedObjectStorer,
+	ob ...Observer,
+) (*Parser, error) {
+	return newParser(scanner, nil, bases, ob...)
+}
+
+func newParser(
+	scanner *Scanner,
+	storage storer.EncodedObjectStorer,
+	bases storer.EncodedObjectStorer,
+	ob ...Observer,
 ) (*Parser, error) {
 	if !scanner.IsSeekable && storage == nil {
 		return nil, ErrNotSeekableSource
@@ -107,6 +135,7 @@ func NewParserWithStorage(
 
 	return &Parser{
 		storage:          storage,
+		bases:            bases,
 		scanner:          scanner,
 		ob:               ob,
 		count:            0,
@@ -116,6 +145,18 @@ func NewParserWithStorage(
 	}, nil
 }
 
+// ExternalBases returns the hashes of the bases of the deltas missing in the
+// packfile, read from the storage, once parsed. A thin packfile has to be
+// "thickened" appending them before being stored, see Thicken.
+func (p *Parser) ExternalBases() []plumbing.Hash {
+	hashes := make([]plumbing.Hash, len(p.external))
+	for i, o := range p.external {
+		hashes[i] = o.SHA1
+	}
+
+	return hashes
+}
+
 func (p *Parser) forEachObserver(f func(o Observer) error) error {
 	for _, o := range p.ob {
 		if err := f(o); err != nil {
@@ -290,9 +331,61 @@ func (p *Parser) indexObjects() error {
 		p.oi[i] = ota
 	}
 
+	return p.resolvePendingRefDeltas()
+}
+
+// resolvePendingRefDeltas sets the bases of the REF_DELTA objects written
+// before them and of the ones based on objects missing in the packfile, read
+// from the storage. The ones based on deltas are set once these are resolved.
+func (p *Parser) resolvePendingRefDeltas() error {
+	for h, pending := range p.pendingRefDeltas {
+		parent, ok := p.oiByHash[h]
+		if !ok {
+			var err error
+			parent, err = p.externalObject(h)
+			if err != nil {
+				return err
+			}
+		}
+
+		if parent == nil {
+			continue
+		}
+
+		for _, po := range pending {
+			po.Parent = parent
+			parent.Children = append(parent.Children, po)
+		}
+
+		delete(p.pendingRefDeltas, h)
+	}
+
 	return nil
 }
 
+// externalObject returns the object with the given hash from the storage, or
+// nil if there isn't any storage or it isn't found.
+func (p *Parser) externalObject(h plumbing.Hash) (*objectInfo, error) {
+	if p.bases == nil {
+		return nil, nil
+	}
+
+	o, err := p.bases.EncodedObject(plumbing.AnyObject, h)
+	if err == plumbing.ErrObjectNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ota := newBaseObject(-1, o.Size(), o.Type())
+	ota.SHA1 = h
+	ota.External = true
+	p.external = append(p.external, ota)
+	return ota, nil
+}
+
 func (p *Parser) resolveDeltas() error {
 	for _, obj := range p.oi {
 		content, err := p.get(obj)
@@ -326,25 +419,19 @@ func (p *Parser) resolveDeltas() error {
 }
 
 func (p *Parser) get(o *objectInfo) ([]byte, error) {
+	if o.External {
+		return p.readExternal(o)
+	}
+
 	b, ok := p.cache.Get(o.Offset)
 	// If it's not on the cache and is not a delta we can try to find it in the
 	// storage, if there's one.
 	if !ok && p.storage != nil && !o.Type.IsDelta() {
 		var err error
-		e, err := p.storage.EncodedObject(plumbing.AnyObject, o.SHA1)
-		if err != nil {
-			return nil, err
-		}
-
-		r, err := e.Reader()
+		b, err = readObject(p.storage, o.SHA1)
 		if err != nil {
 			return nil, err
 		}
-
-		b = make([]byte, e.Size())
-		if _, err = r.Read(b); err != nil {
-			return nil, err
-		}
 	}
 
 	if b != nil {
@@ -353,6 +440,10 @@ func (p *Parser) get(o *objectInfo) ([]byte, error) {
 
 	var data []byte
 	if o.DiskType.IsDelta() {
+		if o.Parent == nil {
+			return nil, ErrReferenceDeltaNotFound
+		}
+
 		base, err := p.get(o.Parent)
 		if err != nil {
 			return nil, err
@@ -377,6 +468,50 @@ func (p *Parser) get(o *objectInfo) ([]byte, error) {
 	return data, nil
 }
 
+// readExternal returns the content of an object missing in the packfile,
+// caching it while it has children.
+func (p *Parser) readExternal(o *objectInfo) ([]byte, error) {
+	if b, ok := p.externalCache[o.SHA1]; ok {
+		return b, nil
+	}
+
+	b, err := readObject(p.bases, o.SHA1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(o.Children) > 0 {
+		if p.externalCache == nil {
+			p.externalCache = make(map[plumbing.Hash][]byte)
+		}
+
+		p.externalCache[o.SHA1] = b
+	}
+
+	return b, nil
+}
+
+func readObject(s storer.EncodedObjectStorer, h plumbing.Hash) (b []byte, err error) {
+	e, err := s.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := e.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	b = make([]byte, e.Size())
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
 func (p *Parser) resolveObject(
 	o *objectInfo,
 	base []byte,
@@ -479,6 +614,8 @@ type objectInfo struct {
 	Length   int64
 	Type     plumbing.ObjectType
 	DiskType plumbing.ObjectType
+	// External is true for the bases missing in a thin packfile
+	External bool
 
 	Crc32 uint32
 
@@ -0,0 +1,122 @@
+package packfile
+
+import (
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/binary"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+const (
+	headerLength   = 12
+	checksumLength = 20
+)
+
+// Thicken turns the thin packfile in f into a self-contained one appending the
+// given objects, the bases of its deltas missing in it, read from the storage,
+// see Parser.ExternalBases. The count of objects of its header and its
+// checksum are rewritten. It returns the new checksum and the index entries of
+// the appended objects.
+func Thicken(
+	f io.ReadWriteSeeker,
+	s storer.EncodedObjectStorer,
+	hashes []plumbing.Hash,
+) (plumbing.Hash, []idxfile.Entry, error) {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	if end < headerLength+checksumLength {
+		return plumbing.ZeroHash, nil, ErrEmptyPackfile
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	_, count, err := NewScanner(io.LimitReader(f, headerLength)).Header()
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	// the appended objects overwrite the checksum
+	offset, err := f.Seek(end-checksumLength, io.SeekStart)
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	entries := make([]idxfile.Entry, 0, len(hashes))
+	for _, h := range hashes {
+		o, err := s.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+
+		crc := crc32.NewIEEE()
+		ow := newOffsetWriter(io.MultiWriter(f, crc))
+		if err := writeObjectEntry(ow, o); err != nil {
+			return plumbing.ZeroHash, nil, fmt.Errorf("appending base %s: %s", h, err)
+		}
+
+		entries = append(entries, idxfile.Entry{
+			Hash:   h,
+			CRC32:  crc.Sum32(),
+			Offset: uint64(offset),
+		})
+
+		offset += ow.Offset()
+	}
+
+	if _, err := f.Seek(headerLength-4, io.SeekStart); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	if err := binary.WriteUint32(f, count+uint32(len(hashes))); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	h := plumbing.Hasher{Hash: sha1.New()}
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	checksum := h.Sum()
+	if err := binary.Write(f, checksum); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	return checksum, entries, nil
+}
+
+// writeObjectEntry writes the given object as a non-delta entry.
+func writeObjectEntry(w io.Writer, o plumbing.EncodedObject) (err error) {
+	if err := writeEntryHead(w, o.Type(), o.Size()); err != nil {
+		return err
+	}
+
+	r, err := o.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	zw := zlib.NewWriter(w)
+	if _, err := io.Copy(zw, r); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
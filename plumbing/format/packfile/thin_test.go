@@ -0,0 +1,144 @@
+package packfile_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type ThinSuite struct {
+	store  *memory.Storage
+	base   plumbing.Hash
+	target plumbing.Hash
+}
+
+var _ = Suite(&ThinSuite{})
+
+func (s *ThinSuite) SetUpTest(c *C) {
+	s.store = memory.NewStorage()
+	content := strings.Repeat("this is the content of the base blob\n", 100)
+	s.base = s.setBlob(c, content)
+	s.target = s.setBlob(c, content+"and the new line of the target\n")
+}
+
+func (s *ThinSuite) setBlob(c *C, content string) plumbing.Hash {
+	o := s.store.NewEncodedObject()
+	o.SetType(plumbing.BlobObject)
+	w, err := o.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h, err := s.store.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+	return h
+}
+
+// encodeThin returns a thin packfile with the target as a delta of the base,
+// and a storage having only the base.
+func (s *ThinSuite) encodeThin(c *C) ([]byte, *memory.Storage) {
+	var buf bytes.Buffer
+	e := packfile.NewEncoder(&buf, s.store, false)
+	_, err := e.EncodeThin([]plumbing.Hash{s.target}, []plumbing.Hash{s.base}, 10, nil)
+	c.Assert(err, IsNil)
+
+	bases := memory.NewStorage()
+	o, err := s.store.EncodedObject(plumbing.AnyObject, s.base)
+	c.Assert(err, IsNil)
+	_, err = bases.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+
+	return buf.Bytes(), bases
+}
+
+func (s *ThinSuite) TestEncodeThin(c *C) {
+	pack, bases := s.encodeThin(c)
+
+	scanner := packfile.NewScanner(bytes.NewReader(pack))
+	_, count, err := scanner.Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(1))
+
+	oh, err := scanner.NextObjectHeader()
+	c.Assert(err, IsNil)
+	c.Assert(oh.Type, Equals, plumbing.REFDeltaObject)
+	c.Assert(oh.Reference, Equals, s.base)
+
+	p, err := packfile.NewParserWithBases(packfile.NewScanner(bytes.NewReader(pack)), bases)
+	c.Assert(err, IsNil)
+	_, err = p.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(p.ExternalBases(), DeepEquals, []plumbing.Hash{s.base})
+}
+
+func (s *ThinSuite) TestEncodeThinWithoutBases(c *C) {
+	var buf bytes.Buffer
+	e := packfile.NewEncoder(&buf, s.store, false)
+	_, err := e.EncodeThin([]plumbing.Hash{s.base, s.target}, []plumbing.Hash{s.base}, 10, nil)
+	c.Assert(err, IsNil)
+
+	_, count, err := packfile.NewScanner(bytes.NewReader(buf.Bytes())).Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(2))
+
+	p, err := packfile.NewParser(packfile.NewScanner(bytes.NewReader(buf.Bytes())))
+	c.Assert(err, IsNil)
+	_, err = p.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(p.ExternalBases(), HasLen, 0)
+}
+
+func (s *ThinSuite) TestParseWithStorage(c *C) {
+	pack, bases := s.encodeThin(c)
+
+	p, err := packfile.NewParserWithStorage(packfile.NewScanner(bytes.NewReader(pack)), bases)
+	c.Assert(err, IsNil)
+	_, err = p.Parse()
+	c.Assert(err, IsNil)
+
+	_, err = bases.EncodedObject(plumbing.BlobObject, s.target)
+	c.Assert(err, IsNil)
+}
+
+func (s *ThinSuite) TestParseMissingBase(c *C) {
+	pack, _ := s.encodeThin(c)
+
+	p, err := packfile.NewParserWithBases(
+		packfile.NewScanner(bytes.NewReader(pack)), memory.NewStorage())
+	c.Assert(err, IsNil)
+	_, err = p.Parse()
+	c.Assert(err, Equals, packfile.ErrReferenceDeltaNotFound)
+}
+
+func (s *ThinSuite) TestThicken(c *C) {
+	pack, bases := s.encodeThin(c)
+
+	f, err := memfs.New().Create("pack")
+	c.Assert(err, IsNil)
+	_, err = f.Write(pack)
+	c.Assert(err, IsNil)
+
+	checksum, entries, err := packfile.Thicken(f, bases, []plumbing.Hash{s.base})
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Hash, Equals, s.base)
+	c.Assert(entries[0].Offset, Equals, uint64(len(pack)-20))
+
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+
+	p, err := packfile.NewParser(packfile.NewScanner(f))
+	c.Assert(err, IsNil)
+	footer, err := p.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(footer, Equals, checksum)
+	c.Assert(p.ExternalBases(), HasLen, 0)
+}
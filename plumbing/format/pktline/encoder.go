@@ -29,6 +29,13 @@ var (
 	Flush = []byte{}
 	// FlushString is the payload to use with the EncodeString method to encode a flush-pkt.
 	FlushString = ""
+	// DelimPkt are the contents of a delim-pkt pkt-line, which separates the
+	// sections of the messages of the protocol version 2.
+	DelimPkt = []byte{'0', '0', '0', '1'}
+	// ResponseEndPkt are the contents of a response-end-pkt pkt-line, which
+	// ends the responses of the protocol version 2 over stateless
+	// connections.
+	ResponseEndPkt = []byte{'0', '0', '0', '2'}
 	// ErrPayloadTooLong is returned by the Encode methods when any of the
 	// provided payloads is bigger than MaxPayloadSize.
 	ErrPayloadTooLong = errors.New("payload is too long")
@@ -47,6 +54,12 @@ func (e *Encoder) Flush() error {
 	return err
 }
 
+// Delim encodes a delim-pkt to the output stream.
+func (e *Encoder) Delim() error {
+	_, err := e.w.Write(DelimPkt)
+	return err
+}
+
 // Encode encodes a pkt-line with the payload specified and write it to
 // the output stream.  If several payloads are specified, each of them
 // will get streamed in their own pkt-lines.
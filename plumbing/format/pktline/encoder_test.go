@@ -28,6 +28,15 @@ func (s *SuiteEncoder) TestFlush(c *C) {
 	c.Assert(obtained, DeepEquals, pktline.FlushPkt)
 }
 
+func (s *SuiteEncoder) TestDelim(c *C) {
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+
+	err := e.Delim()
+	c.Assert(err, IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktline.DelimPkt)
+}
+
 func (s *SuiteEncoder) TestEncode(c *C) {
 	for i, test := range [...]struct {
 		input    [][]byte
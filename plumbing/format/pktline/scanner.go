@@ -6,7 +6,9 @@ import (
 )
 
 const (
-	lenSize = 4
+	lenSize        = 4
+	delimLen       = 1
+	responseEndLen = 2
 )
 
 // ErrInvalidPktLen is returned by Err() when an invalid pkt-len is found.
@@ -27,6 +29,8 @@ type Scanner struct {
 	err     error         // Sticky error
 	payload []byte        // Last pkt-payload
 	len     [lenSize]byte // Last pkt-len
+	v2      bool          // Accept the special pkt-lines of the version 2
+	special int           // pkt-len of the last special pkt-line, if any
 }
 
 // NewScanner returns a new Scanner to read from r.
@@ -36,6 +40,17 @@ func NewScanner(r io.Reader) *Scanner {
 	}
 }
 
+// NewV2Scanner returns a new Scanner to read from r, that also accepts the
+// delim-pkt and the response-end-pkt of the protocol version 2. They are
+// represented by empty byte slices like the flush-pkt, use the IsDelim and
+// IsResponseEnd methods to tell them apart.
+func NewV2Scanner(r io.Reader) *Scanner {
+	return &Scanner{
+		r:  r,
+		v2: true,
+	}
+}
+
 // Err returns the first error encountered by the Scanner.
 func (s *Scanner) Err() error {
 	return s.err
@@ -57,6 +72,13 @@ func (s *Scanner) Scan() bool {
 		return false
 	}
 
+	if l < 0 {
+		s.special = -l
+		s.payload = s.payload[:0]
+		return true
+	}
+
+	s.special = 0
 	if cap(s.payload) < l {
 		s.payload = make([]byte, 0, l)
 	}
@@ -76,8 +98,22 @@ func (s *Scanner) Bytes() []byte {
 	return s.payload
 }
 
+// IsDelim returns true if the most recent pkt-line read by a call to Scan is
+// a delim-pkt, the separator of the sections of the protocol version 2.
+func (s *Scanner) IsDelim() bool {
+	return s.special == delimLen
+}
+
+// IsResponseEnd returns true if the most recent pkt-line read by a call to
+// Scan is a response-end-pkt, which ends the responses of the protocol
+// version 2 over stateless connections.
+func (s *Scanner) IsResponseEnd() bool {
+	return s.special == responseEndLen
+}
+
 // Method readPayloadLen returns the payload length by reading the
-// pkt-len and subtracting the pkt-len size.
+// pkt-len and subtracting the pkt-len size, the special pkt-lines of the
+// version 2 are returned as their negative pkt-len.
 func (s *Scanner) readPayloadLen() (int, error) {
 	if _, err := io.ReadFull(s.r, s.len[:]); err != nil {
 		if err == io.ErrUnexpectedEOF {
@@ -95,6 +131,8 @@ func (s *Scanner) readPayloadLen() (int, error) {
 	switch {
 	case n == 0:
 		return 0, nil
+	case s.v2 && (n == delimLen || n == responseEndLen):
+		return -n, nil
 	case n <= lenSize:
 		return 0, ErrInvalidPktLen
 	case n > OversizePayloadMax+lenSize:
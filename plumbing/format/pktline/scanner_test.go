@@ -69,6 +69,39 @@ func (s *SuiteScanner) TestFlush(c *C) {
 	c.Assert(len(payload), Equals, 0)
 }
 
+func (s *SuiteScanner) TestV2SpecialPkts(c *C) {
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+	c.Assert(e.EncodeString("foo\n"), IsNil)
+	c.Assert(e.Delim(), IsNil)
+	c.Assert(e.Flush(), IsNil)
+	buf.Write(pktline.ResponseEndPkt)
+
+	sc := pktline.NewV2Scanner(&buf)
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(string(sc.Bytes()), Equals, "foo\n")
+	c.Assert(sc.IsDelim(), Equals, false)
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.Bytes(), HasLen, 0)
+	c.Assert(sc.IsDelim(), Equals, true)
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.Bytes(), HasLen, 0)
+	c.Assert(sc.IsDelim(), Equals, false)
+	c.Assert(sc.IsResponseEnd(), Equals, false)
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.IsResponseEnd(), Equals, true)
+
+	c.Assert(sc.Scan(), Equals, false)
+	c.Assert(sc.Err(), IsNil)
+
+	sc = pktline.NewV2Scanner(strings.NewReader("0003"))
+	c.Assert(sc.Scan(), Equals, false)
+	c.Assert(sc.Err(), Equals, pktline.ErrInvalidPktLen)
+}
+
 func (s *SuiteScanner) TestPktLineTooShort(c *C) {
 	r := strings.NewReader("010cfoobar")
 
@@ -0,0 +1,94 @@
+package rebase
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// ErrMalformedCommand is returned by Decode when a line of the todo list
+// can't be parsed.
+var ErrMalformedCommand = errors.New("malformed rebase command")
+
+// actions are the supported actions by name and abbreviation.
+var actions = map[string]Action{
+	"pick": Pick,
+	"p":    Pick,
+}
+
+// A Decoder reads and decodes the commands of a todo list from an input
+// stream.
+type Decoder struct {
+	s *bufio.Scanner
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{s: bufio.NewScanner(r)}
+}
+
+// Decode reads the next command of the todo list and stores it in the value
+// pointed to by cmd. io.EOF is returned when there are no more commands.
+func (d *Decoder) Decode(cmd *Command) error {
+	for d.s.Scan() {
+		line := strings.TrimSpace(d.s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		return decodeCommand(line, cmd)
+	}
+
+	if err := d.s.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// DecodeAll reads all the commands of the todo list, in order.
+func (d *Decoder) DecodeAll() ([]*Command, error) {
+	var cmds []*Command
+	for {
+		cmd := &Command{}
+		err := d.Decode(cmd)
+		if err == io.EOF {
+			return cmds, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		cmds = append(cmds, cmd)
+	}
+}
+
+func decodeCommand(line string, cmd *Command) error {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 {
+		return ErrMalformedCommand
+	}
+
+	action, ok := actions[fields[0]]
+	if !ok {
+		return ErrMalformedCommand
+	}
+
+	h := plumbing.NewHash(fields[1])
+	if h.String() != fields[1] {
+		return ErrMalformedCommand
+	}
+
+	cmd.Action = action
+	cmd.Hash = h
+	cmd.Subject = ""
+	if len(fields) == 3 {
+		cmd.Subject = strings.TrimSpace(fields[2])
+	}
+
+	return nil
+}
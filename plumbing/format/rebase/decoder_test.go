@@ -0,0 +1,72 @@
+package rebase
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type RebaseSuite struct{}
+
+var _ = Suite(&RebaseSuite{})
+
+const todoFixture = `pick 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 vendor stuff
+
+# a comment
+p e8d3ffab552895c19b9fcf7aa264d277cde33881
+`
+
+func (s *RebaseSuite) TestDecode(c *C) {
+	d := NewDecoder(strings.NewReader(todoFixture))
+
+	cmd := &Command{}
+	c.Assert(d.Decode(cmd), IsNil)
+	c.Assert(cmd.Action, Equals, Pick)
+	c.Assert(cmd.Hash, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(cmd.Subject, Equals, "vendor stuff")
+
+	c.Assert(d.Decode(cmd), IsNil)
+	c.Assert(cmd.Action, Equals, Pick)
+	c.Assert(cmd.Hash, Equals, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(cmd.Subject, Equals, "")
+
+	c.Assert(d.Decode(cmd), Equals, io.EOF)
+}
+
+func (s *RebaseSuite) TestDecodeMalformed(c *C) {
+	for _, line := range []string{
+		"pick",
+		"squash 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 foo",
+		"pick 6ecf0ef foo",
+	} {
+		_, err := NewDecoder(strings.NewReader(line)).DecodeAll()
+		c.Assert(err, Equals, ErrMalformedCommand, Commentf(line))
+	}
+}
+
+func (s *RebaseSuite) TestEncodeDecode(c *C) {
+	cmds := []*Command{
+		{Action: Pick, Hash: plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"), Subject: "foo\nbar"},
+		{Action: Pick, Hash: plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf).EncodeAll(cmds), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"pick 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 foo bar\n"+
+		"pick e8d3ffab552895c19b9fcf7aa264d277cde33881\n",
+	)
+
+	decoded, err := NewDecoder(buf).DecodeAll()
+	c.Assert(err, IsNil)
+	c.Assert(decoded, HasLen, 2)
+	c.Assert(decoded[0].Subject, Equals, "foo bar")
+	c.Assert(decoded[1], DeepEquals, cmds[1])
+}
@@ -0,0 +1,38 @@
+package rebase
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An Encoder writes the commands of a todo list to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the given command as a line of the todo list. The line breaks
+// of the subject are replaced by spaces.
+func (e *Encoder) Encode(cmd *Command) error {
+	c := *cmd
+	c.Subject = strings.Join(strings.Fields(c.Subject), " ")
+
+	_, err := fmt.Fprintf(e.w, "%s\n", &c)
+	return err
+}
+
+// EncodeAll writes all the given commands, in order.
+func (e *Encoder) EncodeAll(cmds []*Command) error {
+	for _, cmd := range cmds {
+		if err := e.Encode(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+// Package rebase implements encoding and decoding of the todo lists of a
+// rebase, stored in the git-rebase-todo and done files of the rebase-merge
+// directory of a git repository, and describes the state of a rebase.
+//
+// Every line of a todo list is a command with the following format:
+//
+//	<action> SP <hash> [SP <subject>] LF
+//
+// The empty lines and the lines starting with "#" are ignored. Only full
+// hashes are supported.
+package rebase
+
+import (
+	"fmt"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Action is the action of a command of a todo list.
+type Action string
+
+const (
+	// Pick applies the changes of the commit.
+	Pick Action = "pick"
+)
+
+// Command is a line of a todo list.
+type Command struct {
+	// Action to perform.
+	Action Action
+	// Hash of the commit the action is performed on.
+	Hash plumbing.Hash
+	// Subject is the first line of the message of the commit, it is only
+	// informative.
+	Subject string
+}
+
+// String returns the command as written in a todo list, without line break.
+func (c *Command) String() string {
+	if c.Subject == "" {
+		return fmt.Sprintf("%s %s", c.Action, c.Hash)
+	}
+
+	return fmt.Sprintf("%s %s %s", c.Action, c.Hash, c.Subject)
+}
+
+// State is the state of an in-progress rebase.
+type State struct {
+	// HeadName is the branch being rebased, empty if HEAD was detached when
+	// the rebase started.
+	HeadName plumbing.ReferenceName
+	// Onto is the commit the commands are being applied onto.
+	Onto plumbing.Hash
+	// OrigHead is the commit HEAD pointed to when the rebase started.
+	OrigHead plumbing.Hash
+	// Todo are the commands pending to be performed.
+	Todo []*Command
+	// Done are the commands already performed, the last one is the command
+	// being performed if the rebase is stopped.
+	Done []*Command
+	// Stopped is the commit whose changes couldn't be applied cleanly, the
+	// zero hash if the rebase isn't stopped.
+	Stopped plumbing.Hash
+}
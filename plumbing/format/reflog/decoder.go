@@ -0,0 +1,110 @@
+package reflog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// ErrMalformedEntry is returned by Decode when a line of the reflog can't be
+// parsed.
+var ErrMalformedEntry = errors.New("malformed reflog entry")
+
+// A Decoder reads and decodes reflog entries from an input stream.
+type Decoder struct {
+	s *bufio.Scanner
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{s: bufio.NewScanner(r)}
+}
+
+// Decode reads the next entry of the reflog and stores it in the value
+// pointed to by e. io.EOF is returned when there are no more entries.
+func (d *Decoder) Decode(e *Entry) error {
+	for d.s.Scan() {
+		line := d.s.Text()
+		if line == "" {
+			continue
+		}
+
+		return decodeEntry(line, e)
+	}
+
+	if err := d.s.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// DecodeAll reads all the entries of the reflog, the oldest first.
+func (d *Decoder) DecodeAll() ([]*Entry, error) {
+	var entries []*Entry
+	for {
+		e := &Entry{}
+		err := d.Decode(e)
+		if err == io.EOF {
+			return entries, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+func decodeEntry(line string, e *Entry) error {
+	// hexadecimal length of a hash
+	const hexSize = 40
+	const hashes = 2*hexSize + 2
+	if len(line) < hashes || line[hexSize] != ' ' || line[hashes-1] != ' ' {
+		return ErrMalformedEntry
+	}
+
+	e.Old = plumbing.NewHash(line[:hexSize])
+	e.New = plumbing.NewHash(line[hexSize+1 : hashes-1])
+
+	line = line[hashes:]
+	e.Message = ""
+	if i := strings.IndexByte(line, '\t'); i != -1 {
+		e.Message = line[i+1:]
+		line = line[:i]
+	}
+
+	open := strings.IndexByte(line, '<')
+	end := strings.LastIndexByte(line, '>')
+	if open == -1 || end < open {
+		return ErrMalformedEntry
+	}
+
+	e.Name = strings.TrimSpace(line[:open])
+	e.Email = line[open+1 : end]
+
+	fields := strings.Fields(line[end+1:])
+	if len(fields) != 2 {
+		return ErrMalformedEntry
+	}
+
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return ErrMalformedEntry
+	}
+
+	// a dummy year is included to avoid https://github.com/golang/go/issues/19750
+	tz, err := time.Parse("2006 -0700", "1970 "+fields[1])
+	if err != nil {
+		return ErrMalformedEntry
+	}
+
+	e.When = time.Unix(ts, 0).In(tz.Location())
+	return nil
+}
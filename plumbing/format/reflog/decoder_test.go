@@ -0,0 +1,102 @@
+package reflog
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReflogSuite struct{}
+
+var _ = Suite(&ReflogSuite{})
+
+const reflogFixture = `0000000000000000000000000000000000000000 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 Máximo Cuadros <mcuadros@gmail.com> 1427802494 +0200	clone: from https://github.com/git-fixtures/basic.git
+6ecf0ef2c2dffb796033e5a02219af86ec6584e5 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@doe.org> 1427802600 -0500	checkout: moving from master to branch
+e8d3ffab552895c19b9fcf7aa264d277cde33881 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@doe.org> 1427802700 -0500
+`
+
+func (s *ReflogSuite) TestDecode(c *C) {
+	d := NewDecoder(strings.NewReader(reflogFixture))
+
+	e := &Entry{}
+	c.Assert(d.Decode(e), IsNil)
+	c.Assert(e.Old, Equals, plumbing.ZeroHash)
+	c.Assert(e.New, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(e.Name, Equals, "Máximo Cuadros")
+	c.Assert(e.Email, Equals, "mcuadros@gmail.com")
+	c.Assert(e.When.Unix(), Equals, int64(1427802494))
+	c.Assert(e.When.Format("-0700"), Equals, "+0200")
+	c.Assert(e.Message, Equals, "clone: from https://github.com/git-fixtures/basic.git")
+
+	c.Assert(d.Decode(e), IsNil)
+	c.Assert(e.Old, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(e.New, Equals, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(e.Name, Equals, "John Doe")
+	c.Assert(e.When.Format("-0700"), Equals, "-0500")
+	c.Assert(e.Message, Equals, "checkout: moving from master to branch")
+
+	c.Assert(d.Decode(e), IsNil)
+	c.Assert(e.Message, Equals, "")
+
+	c.Assert(d.Decode(e), Equals, io.EOF)
+}
+
+func (s *ReflogSuite) TestDecodeAll(c *C) {
+	entries, err := NewDecoder(strings.NewReader(reflogFixture)).DecodeAll()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Assert(entries[2].New, Equals, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+
+	entries, err = NewDecoder(strings.NewReader("")).DecodeAll()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *ReflogSuite) TestDecodeMalformed(c *C) {
+	for _, line := range []string{
+		"foo",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe 1427802600 -0500",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@doe.org> foo -0500",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@doe.org> 1427802600",
+	} {
+		err := NewDecoder(strings.NewReader(line)).Decode(&Entry{})
+		c.Assert(err, Equals, ErrMalformedEntry, Commentf("%s", line))
+	}
+}
+
+func (s *ReflogSuite) TestDecodeEncoded(c *C) {
+	entries, err := NewDecoder(strings.NewReader(reflogFixture)).DecodeAll()
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	for _, entry := range entries {
+		c.Assert(e.Encode(entry), IsNil)
+	}
+
+	c.Assert(buf.String(), Equals, reflogFixture)
+}
+
+func (s *ReflogSuite) TestEncodeMultilineMessage(c *C) {
+	buf := bytes.NewBuffer(nil)
+	err := NewEncoder(buf).Encode(&Entry{
+		New:     plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		Name:    "John Doe",
+		Email:   "john@doe.org",
+		When:    time.Unix(1427802494, 0).UTC(),
+		Message: "commit: foo\n\nbar\n",
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "0000000000000000000000000000000000000000 "+
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 John Doe <john@doe.org> "+
+		"1427802494 +0000\tcommit: foo bar\n")
+}
@@ -0,0 +1,48 @@
+package reflog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An Encoder writes reflog entries to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the given entry as a line of the reflog. The line breaks of
+// the message are replaced by spaces, like git does.
+func (e *Encoder) Encode(entry *Entry) error {
+	ts := entry.When.Unix()
+	if ts < 0 {
+		ts = 0
+	}
+
+	_, err := fmt.Fprintf(e.w, "%s %s %s <%s> %d %s",
+		entry.Old, entry.New, entry.Name, entry.Email,
+		ts, entry.When.Format("-0700"),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if msg := cleanMessage(entry.Message); msg != "" {
+		if _, err := fmt.Fprintf(e.w, "\t%s", msg); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(e.w, "\n")
+	return err
+}
+
+func cleanMessage(msg string) string {
+	return strings.Join(strings.Fields(msg), " ")
+}
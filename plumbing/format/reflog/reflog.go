@@ -0,0 +1,33 @@
+// Package reflog implements encoding and decoding of reflog files, the logs
+// of the updates of the references stored in the logs directory of a git
+// repository.
+//
+// Every line of a reflog file is an entry with the following format:
+//
+//	<old hash> SP <new hash> SP <name> SP "<" <email> ">" SP <timestamp> SP <timezone> [TAB <message>] LF
+//
+// The entries are appended to the file, the oldest entry being the first one.
+package reflog
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Entry is an update of a reference.
+type Entry struct {
+	// Old is the value of the reference before the update, the zero hash if
+	// the reference was created.
+	Old plumbing.Hash
+	// New is the value of the reference after the update.
+	New plumbing.Hash
+	// Name of the committer of the update.
+	Name string
+	// Email of the committer of the update.
+	Email string
+	// When is the moment of the update.
+	When time.Time
+	// Message describes the update, e.g. "commit: initial commit".
+	Message string
+}
@@ -13,9 +13,17 @@ import (
 // modifications, From is the original status of the node and To is its
 // final status.  For insertions, From is the zero value and for
 // deletions To is the zero value.
+//
+// Renames and copies, see DetectRenames, are modifications where From and To
+// have different names.
 type Change struct {
 	From ChangeEntry
 	To   ChangeEntry
+	// Similarity is the percentage of the content shared by From and To in
+	// the renames and copies.
+	Similarity int
+	// Copy is true if To is a copy of From, instead of a rename.
+	Copy bool
 }
 
 var empty = ChangeEntry{}
@@ -76,9 +84,23 @@ func (c *Change) String() string {
 		return fmt.Sprintf("malformed change")
 	}
 
+	if c.IsRename() || c.IsCopy() {
+		return fmt.Sprintf("<Action: %s, Path: %s => %s>", action, c.From.Name, c.To.Name)
+	}
+
 	return fmt.Sprintf("<Action: %s, Path: %s>", action, c.name())
 }
 
+// IsRename returns true if the change is a rename of From to To.
+func (c *Change) IsRename() bool {
+	return !c.Copy && c.From != empty && c.To != empty && c.From.Name != c.To.Name
+}
+
+// IsCopy returns true if the change is a copy of From to To.
+func (c *Change) IsCopy() bool {
+	return c.Copy && c.From != empty && c.To != empty
+}
+
 // Patch returns a Patch with all the file changes in chunks. This
 // representation can be used to create several diff outputs.
 func (c *Change) Patch() (*Patch, error) {
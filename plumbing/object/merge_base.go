@@ -0,0 +1,268 @@
+package object
+
+import (
+	"bytes"
+
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// flags used to paint the commits while looking for the merge bases.
+const (
+	mergeBaseParent1 = 1 << iota
+	mergeBaseParent2
+	mergeBaseStale
+	mergeBaseResult
+)
+
+// MergeBase returns the best common ancestors of the commit and the given
+// one, this is, the common ancestors that are not reachable from any other
+// common ancestor. In the case of criss-cross merges more than one best
+// common ancestor can be found. If both commits don't share any history an
+// empty slice is returned.
+func (c *Commit) MergeBase(other *Commit) ([]*Commit, error) {
+	if c.Hash == other.Hash {
+		return []*Commit{c}, nil
+	}
+
+	candidates, _, err := paintDownToCommon(c, []*Commit{other})
+	if err != nil {
+		return nil, err
+	}
+
+	return removeRedundant(candidates)
+}
+
+// IsAncestor returns true if the commit is reachable from the given one,
+// walking only the commits needed to take the decision. A commit is
+// considered an ancestor of itself, like `git merge-base --is-ancestor` does.
+func (c *Commit) IsAncestor(other *Commit) (bool, error) {
+	if c.Hash == other.Hash {
+		return true, nil
+	}
+
+	_, flags, err := paintDownToCommon(c, []*Commit{other})
+	if err != nil {
+		return false, err
+	}
+
+	return flags[c.Hash]&mergeBaseParent2 != 0, nil
+}
+
+// AheadBehind returns the number of commits reachable from the commit but not
+// from the given one, and the number of commits reachable from the given one
+// but not from the commit, like `git rev-list --left-right --count c...other`.
+// Only the history down to the common ancestors is walked.
+func (c *Commit) AheadBehind(other *Commit) (ahead, behind int, err error) {
+	if c.Hash == other.Hash {
+		return 0, 0, nil
+	}
+
+	_, flags, err := paintDownToCommon(c, []*Commit{other})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, f := range flags {
+		switch f & (mergeBaseParent1 | mergeBaseParent2 | mergeBaseStale) {
+		case mergeBaseParent1:
+			ahead++
+		case mergeBaseParent2:
+			behind++
+		}
+	}
+
+	return ahead, behind, nil
+}
+
+// MergeBaseOctopus returns the best common ancestors of all the given commits,
+// like `git merge-base --octopus` does. It is useful to compute the merge base
+// of an octopus merge.
+func MergeBaseOctopus(commits []*Commit) ([]*Commit, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+
+	result := []*Commit{commits[0]}
+	for _, c := range commits[1:] {
+		var bases []*Commit
+		for _, r := range result {
+			b, err := r.MergeBase(c)
+			if err != nil {
+				return nil, err
+			}
+
+			bases = append(bases, b...)
+		}
+
+		var err error
+		if result, err = Independents(bases); err != nil {
+			return nil, err
+		}
+
+		if len(result) == 0 {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// Independents returns the subset of the given commits that are not reachable
+// from any other commit in the list, like `git merge-base --independent` does.
+// The order of the given commits is preserved and duplicates are removed.
+func Independents(commits []*Commit) ([]*Commit, error) {
+	seen := make(map[plumbing.Hash]bool, len(commits))
+	var unique []*Commit
+	for _, c := range commits {
+		if seen[c.Hash] {
+			continue
+		}
+
+		seen[c.Hash] = true
+		unique = append(unique, c)
+	}
+
+	return removeRedundant(unique)
+}
+
+// removeRedundant removes the commits reachable from any other commit of the
+// list.
+func removeRedundant(commits []*Commit) ([]*Commit, error) {
+	if len(commits) < 2 {
+		return commits, nil
+	}
+
+	redundant := make([]bool, len(commits))
+	for i, c := range commits {
+		if redundant[i] {
+			continue
+		}
+
+		var others []*Commit
+		for j, o := range commits {
+			if i != j && !redundant[j] {
+				others = append(others, o)
+			}
+		}
+
+		_, flags, err := paintDownToCommon(c, others)
+		if err != nil {
+			return nil, err
+		}
+
+		if flags[c.Hash]&mergeBaseParent2 != 0 {
+			redundant[i] = true
+		}
+
+		for j, o := range commits {
+			if i != j && flags[o.Hash]&mergeBaseParent1 != 0 {
+				redundant[j] = true
+			}
+		}
+	}
+
+	var result []*Commit
+	for i, c := range commits {
+		if !redundant[i] {
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
+}
+
+// paintDownToCommon walks the history from one and twos at the same time,
+// newest commits first, painting the commits reachable from one with the
+// parent1 flag and the ones reachable from twos with the parent2 flag. The
+// commits painted with both flags are common ancestors, their ancestors are
+// marked as stale and the walk stops when only stale commits are pending, as
+// known by a count of the pending entries of the queue that aren't stale.
+//
+// The common ancestors found and the flags of all the visited commits are
+// returned, the common ancestors can contain commits reachable from others.
+func paintDownToCommon(one *Commit, twos []*Commit) ([]*Commit, map[plumbing.Hash]int, error) {
+	flags := make(map[plumbing.Hash]int)
+	queue := binaryheap.NewWith(newerCommitFirst)
+
+	// pending counts the entries of each commit in the queue, a commit can be
+	// pushed again when new flags reach it
+	pending := make(map[plumbing.Hash]int)
+	var nonStale int
+	push := func(c *Commit, f int) {
+		if flags[c.Hash]&mergeBaseStale == 0 {
+			if f&mergeBaseStale != 0 {
+				nonStale -= pending[c.Hash]
+			} else {
+				nonStale++
+			}
+		}
+
+		flags[c.Hash] |= f
+		pending[c.Hash]++
+		queue.Push(c)
+	}
+
+	push(one, mergeBaseParent1)
+	for _, two := range twos {
+		push(two, mergeBaseParent2)
+	}
+
+	var result []*Commit
+	for nonStale > 0 {
+		v, _ := queue.Pop()
+		c := v.(*Commit)
+
+		pending[c.Hash]--
+		if flags[c.Hash]&mergeBaseStale == 0 {
+			nonStale--
+		}
+
+		f := flags[c.Hash] & (mergeBaseParent1 | mergeBaseParent2 | mergeBaseStale)
+		if f == mergeBaseParent1|mergeBaseParent2 {
+			if flags[c.Hash]&mergeBaseResult == 0 {
+				flags[c.Hash] |= mergeBaseResult
+				result = append(result, c)
+			}
+
+			// the ancestors of a common ancestor are not interesting
+			f |= mergeBaseStale
+		}
+
+		for _, h := range c.ParentHashes {
+			if flags[h]&f == f {
+				continue
+			}
+
+			p, err := GetCommit(c.s, h)
+			if err == plumbing.ErrObjectNotFound {
+				// the history of shallow repositories is incomplete, the
+				// missing parents are handled as if they didn't exist
+				continue
+			}
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			push(p, f)
+		}
+	}
+
+	return result, flags, nil
+}
+
+// newerCommitFirst orders the commits by committer time, newest first,
+// and by hash in the case of equal times.
+func newerCommitFirst(a, b interface{}) int {
+	ca, cb := a.(*Commit), b.(*Commit)
+	switch {
+	case ca.Committer.When.After(cb.Committer.When):
+		return -1
+	case ca.Committer.When.Before(cb.Committer.When):
+		return 1
+	}
+
+	return bytes.Compare(ca.Hash[:], cb.Hash[:])
+}
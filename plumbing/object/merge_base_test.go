@@ -0,0 +1,188 @@
+package object
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type MergeBaseSuite struct {
+	s       *memory.Storage
+	commits map[string]*Commit
+	when    time.Time
+}
+
+var _ = Suite(&MergeBaseSuite{})
+
+// SetUpTest builds the following history, the commits are created in
+// alphabetical order:
+//
+//	A---B---C---D---F---H  master
+//	     \     /   /
+//	      E---G---I        feature
+//	       \
+//	        J---K          topic
+//	L                      orphan
+func (s *MergeBaseSuite) SetUpTest(c *C) {
+	s.s = memory.NewStorage()
+	s.commits = make(map[string]*Commit)
+	s.when = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.commit(c, "A")
+	s.commit(c, "B", "A")
+	s.commit(c, "C", "B")
+	s.commit(c, "E", "B")
+	s.commit(c, "D", "C", "E")
+	s.commit(c, "G", "E")
+	s.commit(c, "F", "D", "G")
+	s.commit(c, "H", "F")
+	s.commit(c, "I", "G")
+	s.commit(c, "J", "E")
+	s.commit(c, "K", "J")
+	s.commit(c, "L")
+}
+
+func (s *MergeBaseSuite) commit(c *C, name string, parents ...string) {
+	s.when = s.when.Add(time.Hour)
+	sig := Signature{Name: "foo", Email: "foo@foo.foo", When: s.when}
+
+	commit := &Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   name,
+		TreeHash:  plumbing.ZeroHash,
+	}
+
+	for _, p := range parents {
+		commit.ParentHashes = append(commit.ParentHashes, s.commits[p].Hash)
+	}
+
+	obj := s.s.NewEncodedObject()
+	c.Assert(commit.Encode(obj), IsNil)
+	h, err := s.s.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	commit, err = GetCommit(s.s, h)
+	c.Assert(err, IsNil)
+	s.commits[name] = commit
+}
+
+func (s *MergeBaseSuite) names(commits []*Commit) []string {
+	var names []string
+	for _, commit := range commits {
+		names = append(names, commit.Message)
+	}
+
+	return names
+}
+
+func (s *MergeBaseSuite) TestMergeBase(c *C) {
+	for _, t := range []struct {
+		a, b     string
+		expected []string
+	}{
+		{"H", "I", []string{"G"}},
+		{"I", "H", []string{"G"}},
+		{"D", "K", []string{"E"}},
+		{"C", "E", []string{"B"}},
+		{"H", "B", []string{"B"}},
+		{"B", "H", []string{"B"}},
+		{"H", "H", []string{"H"}},
+		{"H", "L", nil},
+	} {
+		bases, err := s.commits[t.a].MergeBase(s.commits[t.b])
+		c.Assert(err, IsNil)
+		c.Assert(s.names(bases), DeepEquals, t.expected, Commentf("%s %s", t.a, t.b))
+	}
+}
+
+func (s *MergeBaseSuite) TestMergeBaseCrissCross(c *C) {
+	// X and Y merge C and E in a different order, both are best common
+	// ancestors of their children
+	s.commit(c, "X", "C", "E")
+	s.commit(c, "Y", "E", "C")
+	s.commit(c, "X1", "X")
+	s.commit(c, "Y1", "Y")
+
+	bases, err := s.commits["X1"].MergeBase(s.commits["Y1"])
+	c.Assert(err, IsNil)
+	c.Assert(s.names(bases), DeepEquals, []string{"E", "C"})
+}
+
+func (s *MergeBaseSuite) TestMergeBaseClockSkew(c *C) {
+	// M is older than its parent, the walk must not stop before finding B
+	s.when = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.commit(c, "M", "C")
+	s.commit(c, "N", "M")
+
+	bases, err := s.commits["N"].MergeBase(s.commits["K"])
+	c.Assert(err, IsNil)
+	c.Assert(s.names(bases), DeepEquals, []string{"B"})
+}
+
+func (s *MergeBaseSuite) TestIsAncestor(c *C) {
+	for _, t := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"A", "H", true},
+		{"E", "H", true},
+		{"I", "H", false},
+		{"H", "A", false},
+		{"J", "H", false},
+		{"L", "H", false},
+		{"H", "H", true},
+	} {
+		ok, err := s.commits[t.a].IsAncestor(s.commits[t.b])
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, t.expected, Commentf("%s %s", t.a, t.b))
+	}
+}
+
+func (s *MergeBaseSuite) TestAheadBehind(c *C) {
+	for _, t := range []struct {
+		a, b          string
+		ahead, behind int
+	}{
+		{"H", "I", 4, 1},
+		{"I", "H", 1, 4},
+		{"K", "H", 2, 5},
+		{"H", "B", 6, 0},
+		{"B", "H", 0, 6},
+		{"H", "H", 0, 0},
+		{"H", "L", 8, 1},
+	} {
+		ahead, behind, err := s.commits[t.a].AheadBehind(s.commits[t.b])
+		c.Assert(err, IsNil)
+		c.Assert(ahead, Equals, t.ahead, Commentf("%s %s", t.a, t.b))
+		c.Assert(behind, Equals, t.behind, Commentf("%s %s", t.a, t.b))
+	}
+}
+
+func (s *MergeBaseSuite) TestMergeBaseOctopus(c *C) {
+	bases, err := MergeBaseOctopus([]*Commit{
+		s.commits["H"], s.commits["I"], s.commits["K"],
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(bases), DeepEquals, []string{"E"})
+
+	bases, err = MergeBaseOctopus([]*Commit{s.commits["H"], s.commits["L"]})
+	c.Assert(err, IsNil)
+	c.Assert(bases, HasLen, 0)
+
+	bases, err = MergeBaseOctopus(nil)
+	c.Assert(err, IsNil)
+	c.Assert(bases, HasLen, 0)
+}
+
+func (s *MergeBaseSuite) TestIndependents(c *C) {
+	bases, err := Independents([]*Commit{
+		s.commits["C"], s.commits["H"], s.commits["I"], s.commits["K"],
+		s.commits["E"], s.commits["H"], s.commits["L"],
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(bases), DeepEquals, []string{"H", "I", "K", "L"})
+}
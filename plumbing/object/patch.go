@@ -62,7 +62,7 @@ func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, erro
 	}
 
 	if fIsBinary || tIsBinary {
-		return &textFilePatch{from: c.From, to: c.To}, nil
+		return &textFilePatch{from: c.From, to: c.To, similarity: c.Similarity, copy: c.Copy}, nil
 	}
 
 	diffs := diff.Do(fromContent, toContent)
@@ -89,9 +89,11 @@ func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, erro
 	}
 
 	return &textFilePatch{
-		chunks: chunks,
-		from:   c.From,
-		to:     c.To,
+		chunks:     chunks,
+		from:       c.From,
+		to:         c.To,
+		similarity: c.Similarity,
+		copy:       c.Copy,
 	}, nil
 
 }
@@ -179,8 +181,10 @@ func (f *changeEntryWrapper) Empty() bool {
 
 // textFilePatch is an implementation of fdiff.FilePatch interface
 type textFilePatch struct {
-	chunks   []fdiff.Chunk
-	from, to ChangeEntry
+	chunks     []fdiff.Chunk
+	from, to   ChangeEntry
+	similarity int
+	copy       bool
 }
 
 func (tf *textFilePatch) Files() (from fdiff.File, to fdiff.File) {
@@ -206,6 +210,14 @@ func (t *textFilePatch) Chunks() []fdiff.Chunk {
 	return t.chunks
 }
 
+func (t *textFilePatch) Similarity() int {
+	return t.similarity
+}
+
+func (t *textFilePatch) IsCopy() bool {
+	return t.copy
+}
+
 // textChunk is an implementation of fdiff.Chunk interface
 type textChunk struct {
 	content string
@@ -313,8 +325,8 @@ func getFileStatsFromFilePatches(filePatches []fdiff.FilePatch) FileStats {
 			// File is deleted.
 			cs.Name = from.Path()
 		} else if from.Path() != to.Path() {
-			// File is renamed. Not supported.
-			// cs.Name = fmt.Sprintf("%s => %s", from.Path(), to.Path())
+			// File is renamed or copied.
+			cs.Name = fmt.Sprintf("%s => %s", from.Path(), to.Path())
 		} else {
 			cs.Name = from.Path()
 		}
@@ -0,0 +1,461 @@
+package object
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+// DiffTreeOptions are the options used when comparing two trees.
+type DiffTreeOptions struct {
+	// DetectRenames pairs the deleted and inserted files that are renames
+	// of each other, see DetectRenames.
+	DetectRenames bool
+	// DetectCopies finds the inserted files that are copies of a modified
+	// or renamed file, like the -C flag of git diff. It requires
+	// DetectRenames.
+	DetectCopies bool
+	// RenameScore is the minimum similarity, from 0 to 100, of the content
+	// of two files to consider them a rename or a copy.
+	RenameScore uint
+	// RenameLimit is the maximum number of files in both sides to compare
+	// by content, if exceeded only the exact renames are detected. Zero
+	// means no limit.
+	RenameLimit uint
+	// OnlyExactRenames detects only the renames of files whose content was
+	// not modified.
+	OnlyExactRenames bool
+}
+
+// DefaultDiffTreeOptions are the options used by git diff -M.
+var DefaultDiffTreeOptions = &DiffTreeOptions{
+	DetectRenames: true,
+	RenameScore:   50,
+	RenameLimit:   1000,
+}
+
+// DiffTreeWithOptions compares the content and mode of the blobs found via
+// two tree objects, detecting renames and copies as configured by the given
+// options. If opts is nil, DefaultDiffTreeOptions is used.
+func DiffTreeWithOptions(ctx context.Context, a, b *Tree, opts *DiffTreeOptions) (Changes, error) {
+	changes, err := DiffTreeContext(ctx, a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = DefaultDiffTreeOptions
+	}
+
+	if !opts.DetectRenames {
+		return changes, nil
+	}
+
+	var s storer.EncodedObjectStorer
+	switch {
+	case a != nil:
+		s = a.s
+	case b != nil:
+		s = b.s
+	default:
+		return changes, nil
+	}
+
+	return DetectRenames(s, changes, opts)
+}
+
+// DetectRenames returns the given changes replacing the pairs of deleted and
+// inserted files that are renames of each other by a single change, with From
+// and To having different names. The renames are found comparing first the
+// hashes of the files and then the similarity of their content, as git does.
+// If opts.DetectCopies is true, the inserted files that are copies of a
+// modified or renamed file are also reported, with Copy set to true.
+//
+// The content of the files is read from the given storer. If opts is nil,
+// DefaultDiffTreeOptions is used.
+func DetectRenames(s storer.EncodedObjectStorer, changes Changes, opts *DiffTreeOptions) (Changes, error) {
+	if opts == nil {
+		opts = DefaultDiffTreeOptions
+	}
+
+	d := &renameDetector{
+		s:       s,
+		opts:    opts,
+		paired:  make(map[*Change]*Change),
+		used:    make(map[*Change]bool),
+		indexes: make(map[plumbing.Hash]*similarityIndex),
+	}
+
+	return d.detect(changes)
+}
+
+type renameDetector struct {
+	s    storer.EncodedObjectStorer
+	opts *DiffTreeOptions
+
+	added, deleted, modified []*Change
+	// paired contains the rename or copy change of each paired insertion.
+	paired map[*Change]*Change
+	// used contains the deletions used as the source of a rename.
+	used    map[*Change]bool
+	indexes map[plumbing.Hash]*similarityIndex
+}
+
+func (d *renameDetector) detect(changes Changes) (Changes, error) {
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			if c.To.TreeEntry.Mode.IsFile() {
+				d.added = append(d.added, c)
+			}
+		case merkletrie.Delete:
+			if c.From.TreeEntry.Mode.IsFile() {
+				d.deleted = append(d.deleted, c)
+			}
+		case merkletrie.Modify:
+			if c.From.TreeEntry.Mode.IsFile() {
+				d.modified = append(d.modified, c)
+			}
+		}
+	}
+
+	d.detectExactRenames()
+	if !d.opts.OnlyExactRenames {
+		if err := d.detectContentRenames(); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.opts.DetectCopies {
+		if err := d.detectCopies(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the renames and copies take the place of the insertion, keeping the
+	// order of the destination paths
+	result := make(Changes, 0, len(changes))
+	for _, c := range changes {
+		if d.used[c] {
+			continue
+		}
+
+		if p, ok := d.paired[c]; ok {
+			c = p
+		}
+
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+func (d *renameDetector) detectExactRenames() {
+	byHash := make(map[plumbing.Hash][]*Change)
+	for _, c := range d.deleted {
+		h := c.From.TreeEntry.Hash
+		byHash[h] = append(byHash[h], c)
+	}
+
+	for _, add := range d.added {
+		var best *Change
+		for _, del := range byHash[add.To.TreeEntry.Hash] {
+			if d.used[del] || !compatibleModes(del.From, add.To) {
+				continue
+			}
+
+			if best == nil || sameBaseName(del.From, add.To) {
+				best = del
+			}
+
+			if sameBaseName(del.From, add.To) {
+				break
+			}
+		}
+
+		if best != nil {
+			d.pair(best, add, 100, false)
+		}
+	}
+}
+
+// detectContentRenames pairs the remaining insertions and deletions by the
+// similarity of their content, the most similar pairs first.
+func (d *renameDetector) detectContentRenames() error {
+	var sources []*Change
+	for _, c := range d.deleted {
+		if !d.used[c] {
+			sources = append(sources, c)
+		}
+	}
+
+	matches, err := d.findMatches(sources)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		if d.used[m.source] || d.paired[m.dest] != nil {
+			continue
+		}
+
+		d.pair(m.source, m.dest, m.score, false)
+	}
+
+	return nil
+}
+
+// detectCopies finds the copies of the modified files and of the sources of
+// the renames between the remaining insertions.
+func (d *renameDetector) detectCopies() error {
+	var sources []*Change
+	sources = append(sources, d.modified...)
+	for _, c := range d.deleted {
+		if d.used[c] {
+			sources = append(sources, c)
+		}
+	}
+
+	byHash := make(map[plumbing.Hash]*Change)
+	for _, c := range sources {
+		if _, ok := byHash[c.From.TreeEntry.Hash]; !ok {
+			byHash[c.From.TreeEntry.Hash] = c
+		}
+	}
+
+	for _, add := range d.added {
+		if d.paired[add] != nil {
+			continue
+		}
+
+		if src, ok := byHash[add.To.TreeEntry.Hash]; ok && compatibleModes(src.From, add.To) {
+			d.pair(src, add, 100, true)
+		}
+	}
+
+	if d.opts.OnlyExactRenames {
+		return nil
+	}
+
+	matches, err := d.findMatches(sources)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		if d.paired[m.dest] == nil {
+			d.pair(m.source, m.dest, m.score, true)
+		}
+	}
+
+	return nil
+}
+
+type renameMatch struct {
+	source, dest *Change
+	score        int
+}
+
+// findMatches returns the pairs of the given sources and the unpaired
+// insertions with a similarity over the configured threshold, sorted by
+// score.
+func (d *renameDetector) findMatches(sources []*Change) ([]*renameMatch, error) {
+	var dests []*Change
+	for _, c := range d.added {
+		if d.paired[c] == nil {
+			dests = append(dests, c)
+		}
+	}
+
+	if len(sources) == 0 || len(dests) == 0 {
+		return nil, nil
+	}
+
+	limit := d.opts.RenameLimit
+	if limit != 0 && uint(len(sources))*uint(len(dests)) > limit*limit {
+		return nil, nil
+	}
+
+	var matches []*renameMatch
+	for _, dest := range dests {
+		to, err := d.similarityIndex(dest.To.TreeEntry.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, src := range sources {
+			if !compatibleModes(src.From, dest.To) {
+				continue
+			}
+
+			from, err := d.similarityIndex(src.From.TreeEntry.Hash)
+			if err != nil {
+				return nil, err
+			}
+
+			score, err := from.score(to, int(d.opts.RenameScore))
+			if err != nil {
+				return nil, err
+			}
+
+			if score == 0 || score < int(d.opts.RenameScore) {
+				continue
+			}
+
+			matches = append(matches, &renameMatch{source: src, dest: dest, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+
+		return sameBaseName(matches[i].source.From, matches[i].dest.To) &&
+			!sameBaseName(matches[j].source.From, matches[j].dest.To)
+	})
+
+	return matches, nil
+}
+
+func (d *renameDetector) pair(src, dest *Change, score int, isCopy bool) {
+	if !isCopy {
+		d.used[src] = true
+	}
+
+	d.paired[dest] = &Change{
+		From:       src.From,
+		To:         dest.To,
+		Similarity: score,
+		Copy:       isCopy,
+	}
+}
+
+func (d *renameDetector) similarityIndex(h plumbing.Hash) (*similarityIndex, error) {
+	if idx, ok := d.indexes[h]; ok {
+		return idx, nil
+	}
+
+	blob, err := GetBlob(d.s, h)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &similarityIndex{blob: blob}
+	d.indexes[h] = idx
+	return idx, nil
+}
+
+// compatibleModes returns true if both entries are regular files or both are
+// symlinks, a file can't be renamed to a symlink.
+func compatibleModes(a, b ChangeEntry) bool {
+	return (a.TreeEntry.Mode == filemode.Symlink) == (b.TreeEntry.Mode == filemode.Symlink)
+}
+
+func sameBaseName(a, b ChangeEntry) bool {
+	return path.Base(a.Name) == path.Base(b.Name)
+}
+
+// maxChunkSize is the maximum length of the chunks of content hashed to
+// compare files, the same used by git.
+const maxChunkSize = 64
+
+// similarityIndex contains the number of bytes of each chunk of the content of
+// a file, by the hash of the chunk. The chunks are the lines of the file,
+// split at maxChunkSize bytes. The content is only read when needed.
+type similarityIndex struct {
+	blob   *Blob
+	chunks map[uint32]int64
+}
+
+func (idx *similarityIndex) load() (err error) {
+	if idx.chunks != nil {
+		return nil
+	}
+
+	r, err := idx.blob.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	const offset32, prime32 = 2166136261, 16777619
+
+	chunks := make(map[uint32]int64)
+	h, n := uint32(offset32), int64(0)
+	buf := make([]byte, 32*1024)
+	for {
+		read, err := r.Read(buf)
+		for _, c := range buf[:read] {
+			// FNV-1a
+			h = (h ^ uint32(c)) * prime32
+			n++
+
+			if c == '\n' || n == maxChunkSize {
+				chunks[h] += n
+				h, n = offset32, 0
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if n != 0 {
+		chunks[h] += n
+	}
+
+	idx.chunks = chunks
+	return nil
+}
+
+// score returns the percentage of the content shared by both files, relative
+// to the size of the biggest one. Files whose sizes are too different to reach
+// the given minimum score are not read and zero is returned.
+func (idx *similarityIndex) score(other *similarityIndex, min int) (int, error) {
+	small, big := idx.blob.Size, other.blob.Size
+	if small > big {
+		small, big = big, small
+	}
+
+	if small == 0 || small*100 < big*int64(min) {
+		return 0, nil
+	}
+
+	if err := idx.load(); err != nil {
+		return 0, err
+	}
+
+	if err := other.load(); err != nil {
+		return 0, err
+	}
+
+	var common int64
+	for h, n := range idx.chunks {
+		if o := other.chunks[h]; o < n {
+			n = o
+		}
+
+		common += n
+	}
+
+	return int(common * 100 / big), nil
+}
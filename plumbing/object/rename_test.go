@@ -0,0 +1,241 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type RenameSuite struct {
+	s *memory.Storage
+}
+
+var _ = Suite(&RenameSuite{})
+
+func (s *RenameSuite) SetUpTest(c *C) {
+	s.s = memory.NewStorage()
+}
+
+const renameTestContent = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("one")
+	fmt.Println("two")
+	fmt.Println("three")
+	fmt.Println("four")
+	fmt.Println("five")
+}
+`
+
+// tree writes a tree with the given files, by path, to the storage.
+func (s *RenameSuite) tree(c *C, files map[string]string) *Tree {
+	dirs := make(map[string]map[string]string)
+	t := &Tree{}
+	for name, content := range files {
+		if i := strings.IndexByte(name, '/'); i != -1 {
+			dir := name[:i]
+			if dirs[dir] == nil {
+				dirs[dir] = make(map[string]string)
+			}
+
+			dirs[dir][name[i+1:]] = content
+			continue
+		}
+
+		obj := s.s.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, err := obj.Writer()
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte(content))
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+
+		h, err := s.s.SetEncodedObject(obj)
+		c.Assert(err, IsNil)
+		t.Entries = append(t.Entries, TreeEntry{Name: name, Mode: filemode.Regular, Hash: h})
+	}
+
+	for dir, files := range dirs {
+		sub := s.tree(c, files)
+		t.Entries = append(t.Entries, TreeEntry{Name: dir, Mode: filemode.Dir, Hash: sub.Hash})
+	}
+
+	sort.Slice(t.Entries, func(i, j int) bool {
+		return treeEntrySortName(t.Entries[i]) < treeEntrySortName(t.Entries[j])
+	})
+
+	obj := s.s.NewEncodedObject()
+	c.Assert(t.Encode(obj), IsNil)
+	h, err := s.s.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	t, err = GetTree(s.s, h)
+	c.Assert(err, IsNil)
+	return t
+}
+
+func treeEntrySortName(e TreeEntry) string {
+	if e.Mode == filemode.Dir {
+		return e.Name + "/"
+	}
+
+	return e.Name
+}
+
+func (s *RenameSuite) diff(c *C, from, to map[string]string, opts *DiffTreeOptions) Changes {
+	changes, err := DiffTreeWithOptions(context.Background(), s.tree(c, from), s.tree(c, to), opts)
+	c.Assert(err, IsNil)
+	return changes
+}
+
+func (s *RenameSuite) TestExactRename(c *C) {
+	changes := s.diff(c,
+		map[string]string{"a": renameTestContent, "b": "b\n"},
+		map[string]string{"dir/c": renameTestContent, "b": "b\n"},
+		nil,
+	)
+
+	c.Assert(changes, HasLen, 1)
+	c.Assert(changes[0].IsRename(), Equals, true)
+	c.Assert(changes[0].From.Name, Equals, "a")
+	c.Assert(changes[0].To.Name, Equals, "dir/c")
+	c.Assert(changes[0].Similarity, Equals, 100)
+	c.Assert(changes[0].String(), Equals, "<Action: Modify, Path: a => dir/c>")
+}
+
+func (s *RenameSuite) TestExactRenamePrefersSameBaseName(c *C) {
+	changes := s.diff(c,
+		map[string]string{"a": renameTestContent, "b": renameTestContent},
+		map[string]string{"dir/b": renameTestContent},
+		nil,
+	)
+
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[0].String(), Equals, "<Action: Delete, Path: a>")
+	c.Assert(changes[1].From.Name, Equals, "b")
+	c.Assert(changes[1].To.Name, Equals, "dir/b")
+}
+
+func (s *RenameSuite) TestContentRename(c *C) {
+	modified := strings.Replace(renameTestContent, "five", "six", 1)
+	changes := s.diff(c,
+		map[string]string{"a": renameTestContent, "other": "foo\n"},
+		map[string]string{"b": modified, "new": "bar\n"},
+		nil,
+	)
+
+	c.Assert(changes, HasLen, 3)
+	c.Assert(changes[0].IsRename(), Equals, true)
+	c.Assert(changes[0].From.Name, Equals, "a")
+	c.Assert(changes[0].To.Name, Equals, "b")
+	c.Assert(changes[0].Similarity > 80, Equals, true)
+	c.Assert(changes[0].Similarity < 100, Equals, true)
+	c.Assert(changes[1].String(), Equals, "<Action: Insert, Path: new>")
+	c.Assert(changes[2].String(), Equals, "<Action: Delete, Path: other>")
+}
+
+func (s *RenameSuite) TestContentRenameScore(c *C) {
+	from := map[string]string{"a": renameTestContent}
+	to := map[string]string{"b": renameTestContent[:len(renameTestContent)/2]}
+
+	changes := s.diff(c, from, to, &DiffTreeOptions{DetectRenames: true, RenameScore: 90})
+	c.Assert(changes, HasLen, 2)
+
+	changes = s.diff(c, from, to, &DiffTreeOptions{DetectRenames: true, RenameScore: 30})
+	c.Assert(changes, HasLen, 1)
+	c.Assert(changes[0].IsRename(), Equals, true)
+
+	changes = s.diff(c, from, to, &DiffTreeOptions{DetectRenames: true, OnlyExactRenames: true})
+	c.Assert(changes, HasLen, 2)
+}
+
+func (s *RenameSuite) TestRenameLimit(c *C) {
+	modified := strings.Replace(renameTestContent, "five", "six", 1)
+	from := map[string]string{"a": renameTestContent, "b": "b\n"}
+	to := map[string]string{"c": modified, "d": "d\n"}
+
+	changes := s.diff(c, from, to, &DiffTreeOptions{
+		DetectRenames: true,
+		RenameScore:   50,
+		RenameLimit:   1,
+	})
+	c.Assert(changes, HasLen, 4)
+}
+
+func (s *RenameSuite) TestNoRenames(c *C) {
+	changes := s.diff(c,
+		map[string]string{"a": renameTestContent},
+		map[string]string{"b": renameTestContent},
+		&DiffTreeOptions{},
+	)
+
+	c.Assert(changes, HasLen, 2)
+}
+
+func (s *RenameSuite) TestCopies(c *C) {
+	modified := strings.Replace(renameTestContent, "five", "six", 1)
+	changes := s.diff(c,
+		map[string]string{"a": renameTestContent},
+		map[string]string{"a": modified, "b": renameTestContent, "c": modified + "seven\n"},
+		&DiffTreeOptions{DetectRenames: true, DetectCopies: true, RenameScore: 50},
+	)
+
+	c.Assert(changes, HasLen, 3)
+	c.Assert(changes[0].String(), Equals, "<Action: Modify, Path: a>")
+	c.Assert(changes[1].IsCopy(), Equals, true)
+	c.Assert(changes[1].From.Name, Equals, "a")
+	c.Assert(changes[1].To.Name, Equals, "b")
+	c.Assert(changes[1].Similarity, Equals, 100)
+	c.Assert(changes[2].IsCopy(), Equals, true)
+	c.Assert(changes[2].To.Name, Equals, "c")
+
+	changes = s.diff(c,
+		map[string]string{"a": renameTestContent},
+		map[string]string{"a": modified, "b": renameTestContent},
+		nil,
+	)
+
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[1].String(), Equals, "<Action: Insert, Path: b>")
+}
+
+func (s *RenameSuite) TestRenamePatch(c *C) {
+	modified := strings.Replace(renameTestContent, "five", "six", 1)
+	changes := s.diff(c,
+		map[string]string{"a": renameTestContent},
+		map[string]string{"b": modified},
+		nil,
+	)
+
+	p, err := changes.Patch()
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(p.Encode(buf), IsNil)
+
+	c.Assert(buf.String(), Matches, `diff --git a/a b/b
+similarity index \d+%
+rename from a
+rename to b
+index [0-9a-f]{40}..[0-9a-f]{40} 100644
+--- a/a
+\+\+\+ b/b
+(?s).*-	fmt.Println\("five"\)
+\+	fmt.Println\("six"\)
+.*`)
+
+	stats := p.Stats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].Name, Equals, "a => b")
+	c.Assert(stats[0].Addition, Equals, 1)
+	c.Assert(stats[0].Deletion, Equals, 1)
+}
@@ -131,31 +131,17 @@ func (t *Tag) Decode(o plumbing.EncodedObject) (err error) {
 		return err
 	}
 
-	var pgpsig bool
-	// Check if data contains PGP signature.
-	if bytes.Contains(data, []byte(beginpgp)) {
-		// Split the lines at newline.
-		messageAndSig := bytes.Split(data, []byte("\n"))
-
-		for _, l := range messageAndSig {
-			if pgpsig {
-				if bytes.Contains(l, []byte(endpgp)) {
-					t.PGPSignature += endpgp + "\n"
-					pgpsig = false
-				} else {
-					t.PGPSignature += string(l) + "\n"
-				}
-				continue
+	// Check if data contains PGP signature, the message is everything
+	// before it.
+	if i := bytes.Index(data, []byte(beginpgp)); i != -1 {
+		t.Message = string(data[:i])
+
+		// Split the signature lines at newline.
+		for _, l := range bytes.Split(data[i:], []byte("\n")) {
+			t.PGPSignature += string(l) + "\n"
+			if bytes.Contains(l, []byte(endpgp)) {
+				break
 			}
-
-			// Check if it's the beginning of a PGP signature.
-			if bytes.Contains(l, []byte(beginpgp)) {
-				t.PGPSignature += beginpgp + "\n"
-				pgpsig = true
-				continue
-			}
-
-			t.Message += string(l) + "\n"
 		}
 	} else {
 		t.Message = string(data)
@@ -193,6 +193,14 @@ func (s *TagSuite) TestTagEncodeDecodeIdempotent(c *C) {
 			TargetType: plumbing.BlobObject,
 			Target:     plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
 		},
+		{
+			Name:         "foo",
+			Tagger:       Signature{Name: "Foo", Email: "foo@example.local", When: ts},
+			Message:      "Message\n",
+			PGPSignature: "-----BEGIN PGP SIGNATURE-----\n\nfoo\n-----END PGP SIGNATURE-----\n",
+			TargetType:   plumbing.CommitObject,
+			Target:       plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+		},
 	}
 	for _, tag := range tags {
 		obj := &plumbing.MemoryObject{}
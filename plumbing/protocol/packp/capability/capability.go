@@ -84,6 +84,9 @@ const (
 	// understood thin packs. Adding 'no-thin' later allowed receive-pack
 	// to disable the feature in a backwards-compatible manner.
 	ThinPack Capability = "thin-pack"
+	// NoThin is advertised by the receive-pack servers not accepting thin
+	// packs, see ThinPack.
+	NoThin Capability = "no-thin"
 	// Sideband means that server can send, and client understand multiplexed
 	// progress reports and error info interleaved with the packfile itself.
 	//
@@ -230,6 +233,23 @@ const (
 	PushCert Capability = "push-cert"
 	// SymRef symbolic reference support for better negotiation.
 	SymRef Capability = "symref"
+	// Filter if the upload-pack server advertises it, fetch-pack may send
+	// "filter" commands to request a partial clone or partial fetch and
+	// request that the server omit various objects from the packfile.
+	Filter Capability = "filter"
+	// LsRefs is the command of the protocol version 2 listing the references
+	// of the repository, advertised by the servers supporting it.
+	LsRefs Capability = "ls-refs"
+	// Fetch is the command of the protocol version 2 requesting a packfile,
+	// the values advertised are the features supported by the server, such
+	// as shallow or filter.
+	Fetch Capability = "fetch"
+	// ServerOption the server advertising it in the protocol version 2 accepts
+	// server specific options sent along with the commands.
+	ServerOption Capability = "server-option"
+	// ObjectFormat is the hash algorithm of the repository, advertised by the
+	// servers speaking the protocol version 2.
+	ObjectFormat Capability = "object-format"
 )
 
 const DefaultAgent = "go-git/4.x"
@@ -240,7 +260,8 @@ var known = map[Capability]bool{
 	Shallow: true, DeepenSince: true, DeepenNot: true, DeepenRelative: true,
 	NoProgress: true, IncludeTag: true, ReportStatus: true, DeleteRefs: true,
 	Quiet: true, Atomic: true, PushOptions: true, AllowTipSHA1InWant: true,
-	AllowReachableSHA1InWant: true, PushCert: true, SymRef: true,
+	AllowReachableSHA1InWant: true, PushCert: true, SymRef: true, NoThin: true,
+	Filter: true,
 }
 
 var requiresArgument = map[Capability]bool{
@@ -0,0 +1,248 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+)
+
+var (
+	// common
+	errLine = []byte("ERR ")
+
+	// capability-advertisement
+	version1 = []byte("version 1")
+	version2 = []byte("version 2")
+)
+
+// CapabilityAdvertisement values represent the first message sent by a server
+// speaking the protocol version 2, advertising the commands and the features
+// it supports instead of the references. Values from this type are not
+// zero-value safe, use the New function instead.
+type CapabilityAdvertisement struct {
+	// Capabilities are the capabilities, one per line in the message, the
+	// values of a capability are separated by spaces, such as the features
+	// of the fetch command.
+	Capabilities *capability.List
+}
+
+// NewCapabilityAdvertisement returns a pointer to a new
+// CapabilityAdvertisement value, ready to be used.
+func NewCapabilityAdvertisement() *CapabilityAdvertisement {
+	return &CapabilityAdvertisement{
+		Capabilities: capability.NewList(),
+	}
+}
+
+// Decode reads a capability advertisement from its input and stores it in
+// the CapabilityAdvertisement. The smart HTTP prefix, if present, is skipped.
+func (a *CapabilityAdvertisement) Decode(r io.Reader) error {
+	s := pktline.NewScanner(r)
+	if !s.Scan() {
+		return scannerError(s)
+	}
+
+	line := bytes.TrimSuffix(s.Bytes(), eol)
+	for isPrefix(line) || isFlush(line) {
+		if !s.Scan() {
+			return scannerError(s)
+		}
+
+		line = bytes.TrimSuffix(s.Bytes(), eol)
+	}
+
+	if !bytes.Equal(line, version2) {
+		return NewErrUnexpectedData("missing version 2", line)
+	}
+
+	return a.decodeCapabilities(s)
+}
+
+// decodeCapabilities reads the capability lines following the version line.
+func (a *CapabilityAdvertisement) decodeCapabilities(s *pktline.Scanner) error {
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) {
+			return nil
+		}
+
+		if err := addCapabilityLine(a.Capabilities, string(line)); err != nil {
+			return err
+		}
+	}
+
+	return scannerError(s)
+}
+
+// addCapabilityLine adds a capability line, a name optionally followed by an
+// equal sign and values separated by spaces, to the list.
+func addCapabilityLine(l *capability.List, line string) error {
+	parts := strings.SplitN(line, "=", 2)
+	if parts[0] == "" {
+		return NewErrUnexpectedData("empty capability", []byte(line))
+	}
+
+	if len(parts) == 1 {
+		return l.Add(capability.Capability(parts[0]))
+	}
+
+	c := capability.Capability(parts[0])
+	if c == capability.Agent || c == capability.ObjectFormat {
+		// the agent can contain spaces
+		return l.Add(c, parts[1])
+	}
+
+	return l.Add(c, strings.Fields(parts[1])...)
+}
+
+// Encode writes the capability advertisement to w, without the smart HTTP
+// prefix.
+func (a *CapabilityAdvertisement) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	if err := e.Encodef("%s\n", version2); err != nil {
+		return err
+	}
+
+	for _, c := range a.Capabilities.All() {
+		values := a.Capabilities.Get(c)
+		if len(values) == 0 {
+			if err := e.Encodef("%s\n", c); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := e.Encodef("%s=%s\n", c, strings.Join(values, " ")); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// fetchCapabilities are the capabilities of the protocol versions 0 and 1
+// always implied by the fetch command of the protocol version 2.
+var fetchCapabilities = []capability.Capability{
+	capability.OFSDelta,
+	capability.Sideband64k,
+	capability.NoProgress,
+	capability.IncludeTag,
+	capability.ThinPack,
+}
+
+// shallowCapabilities are the capabilities of the protocol versions 0 and 1
+// implied by the shallow feature of the fetch command.
+var shallowCapabilities = []capability.Capability{
+	capability.Shallow,
+	capability.DeepenSince,
+	capability.DeepenNot,
+	capability.DeepenRelative,
+}
+
+// AdvRefs returns the references of the ls-refs response as an AdvRefs, see
+// LsRefsResponse.AdvRefs, along with the capabilities of the protocol
+// versions 0 and 1 equivalent to the advertised features of the fetch
+// command, so the requests can be built the same way for every version.
+func (a *CapabilityAdvertisement) AdvRefs(res *LsRefsResponse) *AdvRefs {
+	ar := res.AdvRefs()
+	if !a.Capabilities.Supports(capability.Fetch) {
+		return ar
+	}
+
+	caps := fetchCapabilities
+	for _, f := range a.Capabilities.Get(capability.Fetch) {
+		switch f {
+		case capability.Shallow.String():
+			caps = append(caps[:len(caps):len(caps)], shallowCapabilities...)
+		case capability.Filter.String():
+			caps = append(caps[:len(caps):len(caps)], capability.Filter)
+		}
+	}
+
+	for _, c := range caps {
+		_ = ar.Capabilities.Set(c)
+	}
+
+	if agent := a.Capabilities.Get(capability.Agent); len(agent) != 0 {
+		_ = ar.Capabilities.Set(capability.Agent, agent...)
+	}
+
+	return ar
+}
+
+// DecodeAdvertisement reads the first message sent by a server: the
+// references advertisement of the protocol versions 0 and 1, returned as an
+// AdvRefs, or the capability advertisement of the protocol version 2. The
+// version line sent by the servers speaking the protocol version 1 is
+// skipped. The errors are the ones returned by AdvRefs.Decode, along with the
+// AdvRefs decoded so far.
+func DecodeAdvertisement(r io.Reader) (*AdvRefs, *CapabilityAdvertisement, error) {
+	// the lines read are encoded back, so the references advertisement can
+	// be decoded from the beginning
+	var read bytes.Buffer
+	e := pktline.NewEncoder(&read)
+
+	s := pktline.NewScanner(r)
+	afterPrefix := false
+	for s.Scan() {
+		payload := s.Bytes()
+		line := bytes.TrimSuffix(payload, eol)
+		switch {
+		case bytes.Equal(line, version2):
+			ca := NewCapabilityAdvertisement()
+			if err := ca.decodeCapabilities(s); err != nil {
+				return nil, nil, err
+			}
+
+			return nil, ca, nil
+		case bytes.Equal(line, version1):
+			continue
+		}
+
+		if err := e.Encode(payload); err != nil {
+			return nil, nil, err
+		}
+
+		// the smart HTTP prefix can be followed by a flush-pkt, any other
+		// line is the beginning of the references advertisement
+		if isPrefix(line) || (afterPrefix && isFlush(line)) {
+			afterPrefix = isPrefix(line)
+			continue
+		}
+
+		break
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	ar := NewAdvRefs()
+	err := ar.Decode(io.MultiReader(&read, r))
+	return ar, nil, err
+}
+
+// scannerError returns the error of the scanner, or io.ErrUnexpectedEOF if
+// the input ended.
+func scannerError(s *pktline.Scanner) error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+// isErrLine returns true if the line is an error sent by the server.
+func isErrLine(line []byte) bool {
+	return bytes.HasPrefix(line, errLine)
+}
+
+// newErrLineError returns the error sent by the server in an ERR line.
+func newErrLineError(line []byte) error {
+	return fmt.Errorf("remote error: %s", bytes.TrimPrefix(line, errLine))
+}
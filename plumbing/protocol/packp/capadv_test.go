@@ -0,0 +1,134 @@
+package packp
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type CapabilityAdvertisementSuite struct{}
+
+var _ = Suite(&CapabilityAdvertisementSuite{})
+
+func (s *CapabilityAdvertisementSuite) TestDecode(c *C) {
+	raw := pktlines(c,
+		"# service=git-upload-pack\n",
+		pktline.FlushString,
+		"version 2\n",
+		"agent=git/2.20.1 (foo)\n",
+		"ls-refs\n",
+		"fetch=shallow filter\n",
+		"server-option\n",
+		pktline.FlushString,
+	)
+
+	a := NewCapabilityAdvertisement()
+	err := a.Decode(bytes.NewReader(raw))
+	c.Assert(err, IsNil)
+	c.Assert(a.Capabilities.Get(capability.Agent), DeepEquals, []string{"git/2.20.1 (foo)"})
+	c.Assert(a.Capabilities.Supports(capability.LsRefs), Equals, true)
+	c.Assert(a.Capabilities.Get(capability.Fetch), DeepEquals, []string{"shallow", "filter"})
+	c.Assert(a.Capabilities.Supports(capability.ServerOption), Equals, true)
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeNotV2(c *C) {
+	raw := pktlines(c, "version 1\n", pktline.FlushString)
+
+	err := NewCapabilityAdvertisement().Decode(bytes.NewReader(raw))
+	c.Assert(err, FitsTypeOf, &ErrUnexpectedData{})
+}
+
+func (s *CapabilityAdvertisementSuite) TestEncode(c *C) {
+	a := NewCapabilityAdvertisement()
+	c.Assert(a.Capabilities.Add(capability.Agent, "git/2.20.1"), IsNil)
+	c.Assert(a.Capabilities.Add(capability.LsRefs), IsNil)
+	c.Assert(a.Capabilities.Add(capability.Fetch, "shallow", "filter"), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(a.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c,
+		"version 2\n",
+		"agent=git/2.20.1\n",
+		"ls-refs\n",
+		"fetch=shallow filter\n",
+		pktline.FlushString,
+	))
+
+	decoded := NewCapabilityAdvertisement()
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded, DeepEquals, a)
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeAdvertisementV2(c *C) {
+	raw := pktlines(c,
+		"version 2\n",
+		"ls-refs\n",
+		pktline.FlushString,
+		"rest",
+	)
+
+	r := bytes.NewReader(raw)
+	ar, ca, err := DecodeAdvertisement(r)
+	c.Assert(err, IsNil)
+	c.Assert(ar, IsNil)
+	c.Assert(ca.Capabilities.Supports(capability.LsRefs), Equals, true)
+
+	rest, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, pktlines(c, "rest"))
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeAdvertisementV0(c *C) {
+	for _, prefix := range [][]string{
+		nil,
+		{"version 1\n"},
+		{"# service=git-upload-pack\n", pktline.FlushString},
+		{"# service=git-upload-pack\n", pktline.FlushString, "version 1\n"},
+	} {
+		payloads := append(prefix,
+			"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\x00ofs-delta\n",
+			"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+			pktline.FlushString,
+		)
+
+		ar, ca, err := DecodeAdvertisement(bytes.NewReader(pktlines(c, payloads...)))
+		c.Assert(err, IsNil, Commentf("prefix %q", prefix))
+		c.Assert(ca, IsNil)
+		c.Assert(ar.Capabilities.Supports(capability.OFSDelta), Equals, true)
+		c.Assert(ar.References, DeepEquals, map[string]plumbing.Hash{
+			"refs/heads/master": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		})
+	}
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeAdvertisementErrors(c *C) {
+	_, _, err := DecodeAdvertisement(bytes.NewReader(nil))
+	c.Assert(err, Equals, ErrEmptyInput)
+
+	raw := pktlines(c, "# service=git-upload-pack\n", pktline.FlushString, pktline.FlushString)
+	ar, _, err := DecodeAdvertisement(bytes.NewReader(raw))
+	c.Assert(err, Equals, ErrEmptyAdvRefs)
+	c.Assert(ar, NotNil)
+}
+
+func (s *CapabilityAdvertisementSuite) TestAdvRefs(c *C) {
+	a := NewCapabilityAdvertisement()
+	c.Assert(a.Capabilities.Add(capability.Fetch, "shallow", "filter"), IsNil)
+
+	ar := a.AdvRefs(NewLsRefsResponse())
+	c.Assert(ar.Capabilities.Supports(capability.OFSDelta), Equals, true)
+	c.Assert(ar.Capabilities.Supports(capability.Shallow), Equals, true)
+	c.Assert(ar.Capabilities.Supports(capability.Filter), Equals, true)
+
+	a = NewCapabilityAdvertisement()
+	c.Assert(a.Capabilities.Add(capability.Fetch), IsNil)
+
+	ar = a.AdvRefs(NewLsRefsResponse())
+	c.Assert(ar.Capabilities.Supports(capability.Shallow), Equals, false)
+	c.Assert(ar.Capabilities.Supports(capability.Filter), Equals, false)
+}
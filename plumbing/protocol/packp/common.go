@@ -33,6 +33,11 @@ var (
 	deepenCommits   = []byte("deepen ")
 	deepenSince     = []byte("deepen-since ")
 	deepenReference = []byte("deepen-not ")
+	filter          = []byte("filter ")
+
+	// upload-haves
+	have = []byte("have ")
+	done = []byte("done")
 
 	// shallow-update
 	unshallow = []byte("unshallow ")
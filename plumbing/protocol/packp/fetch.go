@@ -0,0 +1,195 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+const (
+	// fetch
+	fetchCommand           = "fetch"
+	acknowledgmentsSection = "acknowledgments"
+	shallowInfoSection     = "shallow-info"
+	packfileSection        = "packfile"
+	readyLine              = "ready"
+)
+
+// fetchArguments are the capabilities of the protocol versions 0 and 1 sent
+// as arguments of the fetch command in the protocol version 2.
+var fetchArguments = []capability.Capability{
+	capability.ThinPack,
+	capability.NoProgress,
+	capability.IncludeTag,
+	capability.OFSDelta,
+	capability.DeepenRelative,
+}
+
+// EncodeV2 writes the request to w as a fetch command of the protocol version
+// 2. The capabilities of the request that have an equivalent argument, such
+// as ofs-delta, are sent as arguments and the agent as a capability of the
+// command. The request always ends with done, since the negotiation isn't
+// supported.
+func (r *UploadPackRequest) EncodeV2(w io.Writer) error {
+	if len(r.Wants) == 0 {
+		return fmt.Errorf("empty wants provided")
+	}
+
+	caps := capability.NewList()
+	if agent := r.Capabilities.Get(capability.Agent); len(agent) != 0 {
+		if err := caps.Set(capability.Agent, agent...); err != nil {
+			return err
+		}
+	}
+
+	var args []string
+	for _, c := range fetchArguments {
+		if r.Capabilities.Supports(c) {
+			args = append(args, c.String())
+		}
+	}
+
+	plumbing.HashesSort(r.Shallows)
+	for _, h := range r.Shallows {
+		args = append(args, fmt.Sprintf("shallow %s", h))
+	}
+
+	switch depth := r.Depth.(type) {
+	case DepthCommits:
+		if depth != 0 {
+			args = append(args, fmt.Sprintf("deepen %d", int(depth)))
+		}
+	case DepthSince:
+		args = append(args, fmt.Sprintf("deepen-since %d", time.Time(depth).UTC().Unix()))
+	case DepthReference:
+		args = append(args, fmt.Sprintf("deepen-not %s", string(depth)))
+	case DepthRevisions:
+		if !depth.Since.IsZero() {
+			args = append(args, fmt.Sprintf("deepen-since %d", depth.Since.UTC().Unix()))
+		}
+
+		for _, reference := range depth.Not {
+			args = append(args, fmt.Sprintf("deepen-not %s", reference))
+		}
+	}
+
+	if r.Filter != "" {
+		args = append(args, fmt.Sprintf("filter %s", r.Filter))
+	}
+
+	plumbing.HashesSort(r.Wants)
+	for _, h := range r.Wants {
+		args = append(args, fmt.Sprintf("want %s", h))
+	}
+
+	plumbing.HashesSort(r.Haves)
+	for _, h := range r.Haves {
+		args = append(args, fmt.Sprintf("have %s", h))
+	}
+
+	args = append(args, "done")
+	return encodeCommand(w, fetchCommand, caps, args)
+}
+
+// DecodeV2 reads the response of a fetch command of the protocol version 2,
+// storing its acknowledgments and shallow information, and prepares it to
+// read the packfile. The packfile is always multiplexed by the server: it's
+// left as is if the request has the side-band-64k capability, the response
+// demultiplexes it otherwise.
+func (r *UploadPackResponse) DecodeV2(reader io.ReadCloser) error {
+	s := pktline.NewV2Scanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		switch {
+		case isErrLine(line):
+			return newErrLineError(line)
+		case string(line) == packfileSection:
+			var pack io.Reader = reader
+			if !r.isSideband64k {
+				pack = sideband.NewDemuxer(sideband.Sideband64k, reader)
+			}
+
+			r.r = ioutil.NewReadCloser(pack, reader)
+			return nil
+		}
+
+		end, err := r.decodeSection(s, string(line))
+		if err != nil {
+			return err
+		}
+
+		if end {
+			break
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return NewErrUnexpectedData("missing packfile section", nil)
+}
+
+// decodeSection reads a section of the response until its end, returning
+// true if the response ends with it.
+func (r *UploadPackResponse) decodeSection(s *pktline.Scanner, section string) (bool, error) {
+	for s.Scan() {
+		if s.IsDelim() {
+			return false, nil
+		}
+
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) || s.IsResponseEnd() {
+			return true, nil
+		}
+
+		var err error
+		switch section {
+		case acknowledgmentsSection:
+			err = r.decodeAcknowledgment(line)
+		case shallowInfoSection:
+			err = r.decodeShallowInfo(line)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, scannerError(s)
+}
+
+func (r *UploadPackResponse) decodeAcknowledgment(line []byte) error {
+	switch {
+	case bytes.Equal(line, nak), string(line) == readyLine:
+		return nil
+	case bytes.HasPrefix(line, ack):
+		fields := bytes.Fields(line)
+		if len(fields) < 2 || len(fields[1]) != hashSize {
+			return NewErrUnexpectedData("malformed ACK", line)
+		}
+
+		r.ACKs = append(r.ACKs, plumbing.NewHash(string(fields[1])))
+		return nil
+	}
+
+	return NewErrUnexpectedData("unexpected acknowledgment", line)
+}
+
+func (r *UploadPackResponse) decodeShallowInfo(line []byte) error {
+	switch {
+	case bytes.HasPrefix(line, shallow):
+		return r.decodeShallowLine(line)
+	case bytes.HasPrefix(line, unshallow):
+		return r.decodeUnshallowLine(line)
+	}
+
+	return NewErrUnexpectedData("unexpected shallow-info", line)
+}
@@ -0,0 +1,150 @@
+package packp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+
+	. "gopkg.in/check.v1"
+)
+
+type FetchV2Suite struct{}
+
+var _ = Suite(&FetchV2Suite{})
+
+func (s *FetchV2Suite) TestEncodeV2(c *C) {
+	req := NewUploadPackRequest()
+	c.Assert(req.Capabilities.Set(capability.Agent, "go-git/4.x"), IsNil)
+	c.Assert(req.Capabilities.Set(capability.OFSDelta), IsNil)
+	c.Assert(req.Capabilities.Set(capability.Sideband64k), IsNil)
+	c.Assert(req.Capabilities.Set(capability.Shallow), IsNil)
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.Haves = []plumbing.Hash{plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47")}
+	req.Shallows = []plumbing.Hash{plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")}
+	req.Depth = DepthCommits(1)
+	req.Filter = FilterBlobLimit(1024)
+
+	var buf bytes.Buffer
+	c.Assert(req.EncodeV2(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=fetch\n", "agent=go-git/4.x\n")) +
+		string(pktline.DelimPkt) +
+		string(pktlines(c,
+			"ofs-delta\n",
+			"shallow 1669dce138d9b841a518c64b10914d88f5e488ea\n",
+			"deepen 1\n",
+			"filter blob:limit=1024\n",
+			"want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+			"have b8e471f58bcbca63b07bda20e428190409c2db47\n",
+			"done\n",
+			pktline.FlushString,
+		))
+
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *FetchV2Suite) TestEncodeV2DepthRevisions(c *C) {
+	req := NewUploadPackRequest()
+	c.Assert(req.Capabilities.Set(capability.DeepenRelative), IsNil)
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.Depth = DepthRevisions{
+		Since: time.Date(2015, time.January, 2, 3, 4, 5, 0, time.UTC),
+		Not:   []string{"refs/heads/master"},
+	}
+
+	var buf bytes.Buffer
+	c.Assert(req.EncodeV2(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=fetch\n")) +
+		string(pktline.DelimPkt) +
+		string(pktlines(c,
+			"deepen-relative\n",
+			"deepen-since 1420167845\n",
+			"deepen-not refs/heads/master\n",
+			"want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+			"done\n",
+			pktline.FlushString,
+		))
+
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *FetchV2Suite) TestEncodeV2EmptyWants(c *C) {
+	var buf bytes.Buffer
+	c.Assert(NewUploadPackRequest().EncodeV2(&buf), NotNil)
+}
+
+func (s *FetchV2Suite) testResponse(c *C, sections string, pack []byte) string {
+	var buf bytes.Buffer
+	buf.WriteString(sections)
+	buf.Write(pktlines(c, "packfile\n"))
+
+	m := sideband.NewMuxer(sideband.Sideband64k, &buf)
+	_, err := m.WriteChannel(sideband.ProgressMessage, []byte("progress"))
+	c.Assert(err, IsNil)
+	_, err = m.Write(pack)
+	c.Assert(err, IsNil)
+	buf.Write(pktline.FlushPkt)
+
+	return buf.String()
+}
+
+func (s *FetchV2Suite) TestDecodeV2(c *C) {
+	sections := string(pktlines(c,
+		"acknowledgments\n",
+		"ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"ready\n",
+	)) + string(pktline.DelimPkt) + string(pktlines(c,
+		"shallow-info\n",
+		"shallow 1669dce138d9b841a518c64b10914d88f5e488ea\n",
+		"unshallow b8e471f58bcbca63b07bda20e428190409c2db47\n",
+	)) + string(pktline.DelimPkt)
+
+	req := NewUploadPackRequest()
+	req.Depth = DepthCommits(1)
+
+	res := NewUploadPackResponse(req)
+	err := res.DecodeV2(ioutil.NopCloser(bytes.NewBufferString(s.testResponse(c, sections, []byte("PACK")))))
+	c.Assert(err, IsNil)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")})
+	c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")})
+	c.Assert(res.Unshallows, DeepEquals, []plumbing.Hash{plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47")})
+
+	pack, err := ioutil.ReadAll(res)
+	c.Assert(err, IsNil)
+	c.Assert(string(pack), Equals, "PACK")
+}
+
+func (s *FetchV2Suite) TestDecodeV2Sideband(c *C) {
+	req := NewUploadPackRequest()
+	c.Assert(req.Capabilities.Set(capability.Sideband64k), IsNil)
+
+	res := NewUploadPackResponse(req)
+	err := res.DecodeV2(ioutil.NopCloser(bytes.NewBufferString(s.testResponse(c, "", []byte("PACK")))))
+	c.Assert(err, IsNil)
+
+	d := sideband.NewDemuxer(sideband.Sideband64k, res)
+	var progress bytes.Buffer
+	d.Progress = &progress
+
+	pack, err := ioutil.ReadAll(d)
+	c.Assert(err, IsNil)
+	c.Assert(string(pack), Equals, "PACK")
+	c.Assert(progress.String(), Equals, "progress")
+}
+
+func (s *FetchV2Suite) TestDecodeV2Errors(c *C) {
+	res := NewUploadPackResponse(NewUploadPackRequest())
+	err := res.DecodeV2(ioutil.NopCloser(bytes.NewReader(pktlines(c, "ERR upload-pack: not our ref\n"))))
+	c.Assert(err, ErrorMatches, "remote error: upload-pack: not our ref")
+
+	res = NewUploadPackResponse(NewUploadPackRequest())
+	raw := pktlines(c, "acknowledgments\n", "NAK\n", pktline.FlushString)
+	err = res.DecodeV2(ioutil.NopCloser(bytes.NewReader(raw)))
+	c.Assert(err, FitsTypeOf, &ErrUnexpectedData{})
+}
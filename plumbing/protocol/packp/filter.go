@@ -0,0 +1,66 @@
+package packp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedFilter is returned by Filter.Validate for the filter
+// specifications not supported.
+var ErrUnsupportedFilter = errors.New("unsupported filter")
+
+const (
+	filterBlobNone  = "blob:none"
+	filterBlobLimit = "blob:limit="
+	filterTree      = "tree:"
+)
+
+// Filter is a filter specification of a partial clone or fetch, requesting
+// the server to omit some objects from the packfile. See FilterBlobNone,
+// FilterBlobLimit and FilterTreeDepth.
+type Filter string
+
+// FilterBlobNone omits all the blobs.
+func FilterBlobNone() Filter {
+	return Filter(filterBlobNone)
+}
+
+// FilterBlobLimit omits the blobs of at least the given size in bytes.
+func FilterBlobLimit(size int64) Filter {
+	return Filter(fmt.Sprintf("%s%d", filterBlobLimit, size))
+}
+
+// FilterTreeDepth omits the blobs and trees deeper than the given depth from
+// the root trees, a depth of 0 omits all the blobs and trees.
+func FilterTreeDepth(depth uint) Filter {
+	return Filter(fmt.Sprintf("%s%d", filterTree, depth))
+}
+
+// Validate returns ErrUnsupportedFilter if the filter isn't one of the ones
+// returned by FilterBlobNone, FilterBlobLimit and FilterTreeDepth. The size of
+// blob:limit can have a k, m or g suffix, as git accepts.
+func (f Filter) Validate() error {
+	s := string(f)
+	switch {
+	case s == filterBlobNone:
+		return nil
+	case strings.HasPrefix(s, filterBlobLimit):
+		size := strings.TrimRight(strings.TrimPrefix(s, filterBlobLimit), "kKmMgG")
+		if len(s)-len(filterBlobLimit)-len(size) <= 1 && isUint(size) {
+			return nil
+		}
+	case strings.HasPrefix(s, filterTree):
+		if isUint(strings.TrimPrefix(s, filterTree)) {
+			return nil
+		}
+	}
+
+	return ErrUnsupportedFilter
+}
+
+func isUint(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
@@ -0,0 +1,29 @@
+package packp
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type FilterSuite struct{}
+
+var _ = Suite(&FilterSuite{})
+
+func (s *FilterSuite) TestFilters(c *C) {
+	c.Assert(FilterBlobNone(), Equals, Filter("blob:none"))
+	c.Assert(FilterBlobLimit(1024), Equals, Filter("blob:limit=1024"))
+	c.Assert(FilterTreeDepth(0), Equals, Filter("tree:0"))
+}
+
+func (s *FilterSuite) TestValidate(c *C) {
+	for _, f := range []Filter{
+		FilterBlobNone(), FilterBlobLimit(0), FilterTreeDepth(3), "blob:limit=1k", "blob:limit=10M",
+	} {
+		c.Assert(f.Validate(), IsNil, Commentf("filter %q", f))
+	}
+
+	for _, f := range []Filter{
+		"", "blob", "blob:limit=", "blob:limit=1kk", "blob:limit=-1", "tree:", "tree:a", "sparse:oid=foo",
+	} {
+		c.Assert(f.Validate(), Equals, ErrUnsupportedFilter, Commentf("filter %q", f))
+	}
+}
@@ -0,0 +1,285 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+)
+
+const (
+	// ls-refs
+	lsRefsCommand  = "ls-refs"
+	peelArg        = "peel"
+	symrefsArg     = "symrefs"
+	refPrefixArg   = "ref-prefix "
+	symrefTarget   = "symref-target:"
+	peeledAttr     = "peeled:"
+	unbornRefValue = "unborn"
+)
+
+// LsRefsRequest values represent the ls-refs command of the protocol version
+// 2, which requests the references of the server. Values from this type are
+// not zero-value safe, use the New function instead.
+type LsRefsRequest struct {
+	// Capabilities are the capabilities sent along with the command, such as
+	// the agent.
+	Capabilities *capability.List
+	// Peel requests the peeled hashes of the annotated tags.
+	Peel bool
+	// Symrefs requests the targets of the symbolic references.
+	Symrefs bool
+	// Prefixes limits the references to the ones starting with any of them,
+	// all the references are requested if empty.
+	Prefixes []string
+}
+
+// NewLsRefsRequest returns a pointer to a new LsRefsRequest value, requesting
+// all the references with their peeled hashes and symbolic targets.
+func NewLsRefsRequest() *LsRefsRequest {
+	return &LsRefsRequest{
+		Capabilities: capability.NewList(),
+		Peel:         true,
+		Symrefs:      true,
+	}
+}
+
+// Encode writes the ls-refs command to w.
+func (r *LsRefsRequest) Encode(w io.Writer) error {
+	var args []string
+	if r.Peel {
+		args = append(args, peelArg)
+	}
+
+	if r.Symrefs {
+		args = append(args, symrefsArg)
+	}
+
+	for _, p := range r.Prefixes {
+		args = append(args, refPrefixArg+p)
+	}
+
+	return encodeCommand(w, lsRefsCommand, r.Capabilities, args)
+}
+
+// Decode reads an ls-refs command from r and stores it in the LsRefsRequest.
+func (r *LsRefsRequest) Decode(reader io.Reader) error {
+	args, err := decodeCommand(reader, lsRefsCommand, r.Capabilities)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		switch {
+		case arg == peelArg:
+			r.Peel = true
+		case arg == symrefsArg:
+			r.Symrefs = true
+		case strings.HasPrefix(arg, refPrefixArg):
+			r.Prefixes = append(r.Prefixes, arg[len(refPrefixArg):])
+		default:
+			return NewErrUnexpectedData("unknown ls-refs argument", []byte(arg))
+		}
+	}
+
+	return nil
+}
+
+// LsRefsResponse values represent the response of the ls-refs command.
+// Values from this type are not zero-value safe, use the New function
+// instead.
+type LsRefsResponse struct {
+	// References are the references in the order sent by the server, the
+	// symbolic ones, such as HEAD, with the hash they resolve to.
+	References []*plumbing.Reference
+	// Symrefs are the targets of the symbolic references by their names.
+	Symrefs map[string]string
+	// Peeled are the peeled hashes of the annotated tags by their names.
+	Peeled map[string]plumbing.Hash
+}
+
+// NewLsRefsResponse returns a pointer to a new LsRefsResponse value, ready
+// to be used.
+func NewLsRefsResponse() *LsRefsResponse {
+	return &LsRefsResponse{
+		Symrefs: make(map[string]string),
+		Peeled:  make(map[string]plumbing.Hash),
+	}
+}
+
+// Decode reads the response of an ls-refs command from r and stores it in the
+// LsRefsResponse. The unborn symbolic references are skipped.
+func (r *LsRefsResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) {
+			return nil
+		}
+
+		if isErrLine(line) {
+			return newErrLineError(line)
+		}
+
+		if err := r.decodeLine(string(line)); err != nil {
+			return err
+		}
+	}
+
+	return scannerError(s)
+}
+
+func (r *LsRefsResponse) decodeLine(line string) error {
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 {
+		return NewErrUnexpectedData("malformed ls-refs line", []byte(line))
+	}
+
+	if fields[0] == unbornRefValue {
+		return nil
+	}
+
+	if len(fields[0]) != hashSize {
+		return NewErrUnexpectedData("malformed ls-refs hash", []byte(line))
+	}
+
+	name := fields[1]
+	r.References = append(r.References, plumbing.NewReferenceFromStrings(name, fields[0]))
+	for _, attr := range fields[2:] {
+		switch {
+		case strings.HasPrefix(attr, symrefTarget):
+			r.Symrefs[name] = attr[len(symrefTarget):]
+		case strings.HasPrefix(attr, peeledAttr):
+			r.Peeled[name] = plumbing.NewHash(attr[len(peeledAttr):])
+		}
+	}
+
+	return nil
+}
+
+// Encode writes the response of an ls-refs command to w.
+func (r *LsRefsResponse) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	for _, ref := range r.References {
+		line := fmt.Sprintf("%s %s", ref.Hash(), ref.Name())
+		if target, ok := r.Symrefs[ref.Name().String()]; ok {
+			line += " " + symrefTarget + target
+		}
+
+		if peeled, ok := r.Peeled[ref.Name().String()]; ok {
+			line += " " + peeledAttr + peeled.String()
+		}
+
+		if err := e.Encodef("%s\n", line); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// AdvRefs returns the references of the response as an AdvRefs, the way
+// they're advertised in the protocol versions 0 and 1: the hash of HEAD is
+// the Head and the symbolic references are symref capabilities.
+func (r *LsRefsResponse) AdvRefs() *AdvRefs {
+	ar := NewAdvRefs()
+	for _, ref := range r.References {
+		if ref.Name() == plumbing.HEAD {
+			h := ref.Hash()
+			ar.Head = &h
+		} else {
+			ar.References[ref.Name().String()] = ref.Hash()
+		}
+
+		if target, ok := r.Symrefs[ref.Name().String()]; ok {
+			_ = ar.Capabilities.Add(capability.SymRef, ref.Name().String()+":"+target)
+		}
+	}
+
+	for name, h := range r.Peeled {
+		ar.Peeled[name] = h
+	}
+
+	return ar
+}
+
+// encodeCommand writes a command request of the protocol version 2: the
+// command, its capabilities and, after a delim-pkt, its arguments.
+func encodeCommand(w io.Writer, command string, caps *capability.List, args []string) error {
+	e := pktline.NewEncoder(w)
+	if err := e.Encodef("command=%s\n", command); err != nil {
+		return err
+	}
+
+	for _, c := range caps.All() {
+		values := caps.Get(c)
+		if len(values) == 0 {
+			if err := e.Encodef("%s\n", c); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, v := range values {
+			if err := e.Encodef("%s=%s\n", c, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.Delim(); err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		if err := e.Encodef("%s\n", arg); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// decodeCommand reads a command request of the protocol version 2, checking
+// it's the expected command, storing its capabilities in caps and returning
+// its arguments.
+func decodeCommand(r io.Reader, command string, caps *capability.List) ([]string, error) {
+	s := pktline.NewV2Scanner(r)
+	if !s.Scan() {
+		return nil, scannerError(s)
+	}
+
+	line := string(bytes.TrimSuffix(s.Bytes(), eol))
+	if line != "command="+command {
+		return nil, NewErrUnexpectedData("unexpected command", []byte(line))
+	}
+
+	inArgs := false
+	var args []string
+	for s.Scan() {
+		switch {
+		case s.IsDelim():
+			inArgs = true
+			continue
+		case isFlush(s.Bytes()):
+			return args, nil
+		}
+
+		line := string(bytes.TrimSuffix(s.Bytes(), eol))
+		if inArgs {
+			args = append(args, line)
+			continue
+		}
+
+		if err := addCapabilityLine(caps, line); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, scannerError(s)
+}
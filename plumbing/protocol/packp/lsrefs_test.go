@@ -0,0 +1,107 @@
+package packp
+
+import (
+	"bytes"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type LsRefsSuite struct{}
+
+var _ = Suite(&LsRefsSuite{})
+
+func (s *LsRefsSuite) TestEncodeRequest(c *C) {
+	req := NewLsRefsRequest()
+	c.Assert(req.Capabilities.Set(capability.Agent, "go-git/4.x"), IsNil)
+	req.Prefixes = []string{"HEAD", "refs/heads/"}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=ls-refs\n", "agent=go-git/4.x\n")) +
+		string(pktline.DelimPkt) +
+		string(pktlines(c,
+			"peel\n",
+			"symrefs\n",
+			"ref-prefix HEAD\n",
+			"ref-prefix refs/heads/\n",
+			pktline.FlushString,
+		))
+
+	c.Assert(buf.String(), Equals, expected)
+
+	decoded := &LsRefsRequest{Capabilities: capability.NewList()}
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded, DeepEquals, req)
+}
+
+func (s *LsRefsSuite) TestDecodeRequestUnexpectedCommand(c *C) {
+	raw := string(pktlines(c, "command=fetch\n")) + string(pktline.DelimPkt) +
+		string(pktlines(c, pktline.FlushString))
+
+	err := NewLsRefsRequest().Decode(bytes.NewBufferString(raw))
+	c.Assert(err, FitsTypeOf, &ErrUnexpectedData{})
+}
+
+func (s *LsRefsSuite) TestDecodeResponse(c *C) {
+	raw := pktlines(c,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD symref-target:refs/heads/master\n",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+		"b8e471f58bcbca63b07bda20e428190409c2db47 refs/tags/v1.0.0 peeled:6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"unborn refs/heads/foo\n",
+		pktline.FlushString,
+	)
+
+	res := NewLsRefsResponse()
+	c.Assert(res.Decode(bytes.NewReader(raw)), IsNil)
+	c.Assert(res.References, DeepEquals, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("HEAD", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b8e471f58bcbca63b07bda20e428190409c2db47"),
+	})
+	c.Assert(res.Symrefs, DeepEquals, map[string]string{"HEAD": "refs/heads/master"})
+	c.Assert(res.Peeled, DeepEquals, map[string]plumbing.Hash{
+		"refs/tags/v1.0.0": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+
+	ar := res.AdvRefs()
+	c.Assert(*ar.Head, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(ar.Capabilities.Get(capability.SymRef), DeepEquals, []string{"HEAD:refs/heads/master"})
+	c.Assert(ar.References, HasLen, 2)
+	c.Assert(ar.Peeled, DeepEquals, res.Peeled)
+
+	refs, err := ar.AllReferences()
+	c.Assert(err, IsNil)
+	head, err := refs.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+}
+
+func (s *LsRefsSuite) TestDecodeResponseError(c *C) {
+	raw := pktlines(c, "ERR access denied\n")
+
+	err := NewLsRefsResponse().Decode(bytes.NewReader(raw))
+	c.Assert(err, ErrorMatches, "remote error: access denied")
+}
+
+func (s *LsRefsSuite) TestEncodeResponse(c *C) {
+	res := NewLsRefsResponse()
+	res.References = []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("HEAD", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b8e471f58bcbca63b07bda20e428190409c2db47"),
+	}
+	res.Symrefs["HEAD"] = "refs/heads/master"
+	res.Peeled["refs/tags/v1.0.0"] = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	var buf bytes.Buffer
+	c.Assert(res.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD symref-target:refs/heads/master\n",
+		"b8e471f58bcbca63b07bda20e428190409c2db47 refs/tags/v1.0.0 peeled:6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		pktline.FlushString,
+	))
+}
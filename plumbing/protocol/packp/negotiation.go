@@ -0,0 +1,261 @@
+package packp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+)
+
+const (
+	// initialFlush is the number of haves sent in the first round of a
+	// negotiation, the rounds grow up to pipeSafeFlush haves for stateful
+	// connections and up to largeFlush for stateless ones before growing
+	// slower.
+	initialFlush  = 16
+	pipeSafeFlush = 32
+	largeFlush    = 16384
+	// maxInVain is the number of haves sent without finding a new common one
+	// after which the negotiation is given up, once a common one was found.
+	maxInVain = 256
+)
+
+// ErrNegotiationDone is returned by Negotiation.EncodeRound when the
+// negotiation is already done.
+var ErrNegotiationDone = errors.New("negotiation already done")
+
+// Negotiation negotiates the haves of an upload-pack request in the
+// multi_ack and multi_ack_detailed modes, the way git does: the haves are
+// sent in growing rounds, each one followed by a flush-pkt and answered by
+// the server acknowledging the common ones, until the server is ready to send
+// the packfile, the client runs out of haves or too many of them are sent
+// without finding a common one. Then a done is sent, unless the server is
+// ready and the no-done capability is used.
+//
+// For stateless connections, such as HTTP, every round is a new request
+// including the wants and the common haves found so far.
+type Negotiation struct {
+	req        *UploadPackRequest
+	negotiator Negotiator
+	stateless  bool
+	noDone     bool
+
+	round       int
+	sent        int
+	flush       int
+	inVain      int
+	gotContinue bool
+	ready       bool
+	done        bool
+	acked       map[plumbing.Hash]bool
+	common      []plumbing.Hash
+	isCommon    map[plumbing.Hash]bool
+
+	isShallow bool
+	// shallowRound is the round whose response included the shallow update
+	// read in shallowUpdate.
+	shallowRound  int
+	shallowUpdate ShallowUpdate
+}
+
+// NewNegotiation returns a new Negotiation of the given request, the haves
+// are provided by its Negotiator or, if not set, taken from its Haves.
+func NewNegotiation(req *UploadPackRequest, stateless bool) *Negotiation {
+	n := req.Negotiator
+	if n == nil {
+		n = &hashesNegotiator{haves: req.Haves}
+	}
+
+	return &Negotiation{
+		req:        req,
+		negotiator: n,
+		stateless:  stateless,
+		noDone:     req.Capabilities.Supports(capability.NoDone),
+		flush:      initialFlush,
+		acked:      make(map[plumbing.Hash]bool),
+		isCommon:   make(map[plumbing.Hash]bool),
+		isShallow:  req.isShallow(),
+	}
+}
+
+// Done returns true if the negotiation is over, the packfile follows the
+// response of the last round.
+func (n *Negotiation) Done() bool {
+	return n.done
+}
+
+// Common returns the haves acknowledged as common by the server, the ones
+// sent again in every round of a stateless negotiation.
+func (n *Negotiation) Common() []plumbing.Hash {
+	return n.common
+}
+
+// EncodeRound writes the next round of the negotiation to w: the wants in the
+// first round, and in every round for stateless connections along with the
+// common haves found so far, followed by the next haves and a flush-pkt, or
+// by a done if the negotiation is over.
+func (n *Negotiation) EncodeRound(w io.Writer) error {
+	if n.done {
+		return ErrNegotiationDone
+	}
+
+	if n.round == 0 || n.stateless {
+		if err := n.req.UploadRequest.Encode(w); err != nil {
+			return err
+		}
+	}
+
+	e := pktline.NewEncoder(w)
+	if n.stateless {
+		for _, h := range n.common {
+			if err := e.Encodef("have %s\n", h); err != nil {
+				return err
+			}
+		}
+	}
+
+	n.round++
+
+	haves, err := n.nextHaves()
+	if err != nil {
+		return err
+	}
+
+	if len(haves) == 0 {
+		n.done = true
+		return e.Encodef("done\n")
+	}
+
+	for _, h := range haves {
+		if err := e.Encodef("have %s\n", h); err != nil {
+			return err
+		}
+	}
+
+	n.sent += len(haves)
+	n.inVain += len(haves)
+	n.flush = nextFlush(n.stateless, n.flush)
+	return e.Flush()
+}
+
+func (n *Negotiation) nextHaves() ([]plumbing.Hash, error) {
+	if n.ready || (n.gotContinue && n.inVain >= maxInVain) {
+		return nil, nil
+	}
+
+	return n.negotiator.Next(n.flush - n.sent)
+}
+
+// nextFlush returns the number of haves sent when the next round ends.
+func nextFlush(stateless bool, count int) int {
+	if stateless {
+		if count < largeFlush {
+			return count << 1
+		}
+
+		return count * 11 / 10
+	}
+
+	if count < pipeSafeFlush {
+		return count << 1
+	}
+
+	return count + pipeSafeFlush
+}
+
+// DecodeRound reads the response of the server to a round not ending with a
+// done, passing the acknowledged haves to the negotiator.
+func (n *Negotiation) DecodeRound(r *bufio.Reader) error {
+	if n.expectsShallowUpdate() {
+		n.shallowUpdate = ShallowUpdate{}
+		if err := n.shallowUpdate.Decode(r); err != nil {
+			return err
+		}
+
+		n.shallowRound = n.round
+	}
+
+	var res ServerResponse
+	if err := res.Decode(r, true); err != nil {
+		return err
+	}
+
+	for _, h := range res.ACKs {
+		n.gotContinue = true
+		if n.acked[h] {
+			continue
+		}
+
+		if err := n.negotiator.Ack(h); err != nil {
+			return err
+		}
+
+		n.acked[h] = true
+		n.inVain = 0
+	}
+
+	for _, h := range res.common {
+		if !n.isCommon[h] {
+			n.isCommon[h] = true
+			n.common = append(n.common, h)
+		}
+	}
+
+	if res.Ready {
+		n.ready = true
+		// with no-done the packfile follows the response
+		n.done = n.noDone
+	}
+
+	return nil
+}
+
+// DecodeResponse reads the response of the server once the negotiation is
+// done and prepares it to read the packfile.
+func (n *Negotiation) DecodeResponse(r io.ReadCloser) (*UploadPackResponse, error) {
+	res := NewUploadPackResponse(n.req)
+	expectsShallow := n.expectsShallowUpdate()
+	if n.isShallow && !expectsShallow {
+		// the shallow update was read along with a previous round
+		res.ShallowUpdate = n.shallowUpdate
+	}
+
+	if err := res.decode(r, expectsShallow); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// expectsShallowUpdate returns true if the response to the current round
+// starts with a shallow update: the response to the first round and, for
+// stateless connections, every response, unless it was already read.
+func (n *Negotiation) expectsShallowUpdate() bool {
+	if !n.isShallow || n.shallowRound == n.round {
+		return false
+	}
+
+	return n.stateless || n.round == 1
+}
+
+// hashesNegotiator is a Negotiator sending the given haves.
+type hashesNegotiator struct {
+	haves []plumbing.Hash
+}
+
+func (n *hashesNegotiator) Next(max int) ([]plumbing.Hash, error) {
+	if max > len(n.haves) {
+		max = len(n.haves)
+	}
+
+	next := n.haves[:max]
+	n.haves = n.haves[max:]
+	return next, nil
+}
+
+func (*hashesNegotiator) Ack(plumbing.Hash) error {
+	return nil
+}
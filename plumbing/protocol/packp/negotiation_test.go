@@ -0,0 +1,325 @@
+package packp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type NegotiationSuite struct{}
+
+var _ = Suite(&NegotiationSuite{})
+
+var negotiationWant = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+func negotiationHaves(n int) []plumbing.Hash {
+	var haves []plumbing.Hash
+	for i := 0; i < n; i++ {
+		haves = append(haves, plumbing.NewHash(fmt.Sprintf("%040x", i+1)))
+	}
+
+	return haves
+}
+
+func (s *NegotiationSuite) newRequest(c *C, caps ...capability.Capability) *UploadPackRequest {
+	req := NewUploadPackRequest()
+	for _, cap := range caps {
+		c.Assert(req.Capabilities.Set(cap), IsNil)
+	}
+
+	req.Wants = []plumbing.Hash{negotiationWant}
+	return req
+}
+
+func (s *NegotiationSuite) wants(c *C, req *UploadPackRequest) string {
+	var buf bytes.Buffer
+	c.Assert(req.UploadRequest.Encode(&buf), IsNil)
+	return buf.String()
+}
+
+func (s *NegotiationSuite) haves(c *C, haves []plumbing.Hash, end string) string {
+	var payloads []string
+	for _, h := range haves {
+		payloads = append(payloads, fmt.Sprintf("have %s\n", h))
+	}
+
+	return string(pktlines(c, append(payloads, end)...))
+}
+
+func (s *NegotiationSuite) TestStateful(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed)
+	haves := negotiationHaves(50)
+	req.Haves = haves
+
+	n := NewNegotiation(req, false)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, s.wants(c, req)+s.haves(c, haves[:16], pktline.FlushString))
+
+	raw := pktlines(c, fmt.Sprintf("ACK %s common\n", haves[3]), "NAK\n")
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+	c.Assert(n.Common(), DeepEquals, []plumbing.Hash{haves[3]})
+
+	buf.Reset()
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, s.haves(c, haves[16:32], pktline.FlushString))
+
+	raw = pktlines(c, "NAK\n")
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+
+	buf.Reset()
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, s.haves(c, haves[32:], pktline.FlushString))
+
+	raw = pktlines(c, "NAK\n")
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+	c.Assert(n.Done(), Equals, false)
+
+	buf.Reset()
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, string(pktlines(c, "done\n")))
+	c.Assert(n.Done(), Equals, true)
+	c.Assert(n.EncodeRound(&buf), Equals, ErrNegotiationDone)
+
+	raw = append(pktlines(c, fmt.Sprintf("ACK %s\n", haves[3])), "PACK"...)
+	res, err := n.DecodeResponse(ioutil.NopCloser(bytes.NewReader(raw)))
+	c.Assert(err, IsNil)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{haves[3]})
+
+	pack, err := ioutil.ReadAll(res)
+	c.Assert(err, IsNil)
+	c.Assert(string(pack), Equals, "PACK")
+}
+
+func (s *NegotiationSuite) TestStatelessReplaysCommon(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed)
+	haves := negotiationHaves(40)
+	req.Haves = haves
+
+	n := NewNegotiation(req, true)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+
+	raw := pktlines(c,
+		fmt.Sprintf("ACK %s common\n", haves[1]),
+		fmt.Sprintf("ACK %s continue\n", haves[2]),
+		"NAK\n",
+	)
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+
+	buf.Reset()
+	c.Assert(n.EncodeRound(&buf), IsNil)
+
+	expected := s.wants(c, req) +
+		s.haves(c, append([]plumbing.Hash{haves[1]}, haves[16:32]...), pktline.FlushString)
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *NegotiationSuite) TestReadyNoDone(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed, capability.NoDone)
+	haves := negotiationHaves(20)
+	req.Haves = haves
+
+	n := NewNegotiation(req, true)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+
+	raw := append(pktlines(c,
+		fmt.Sprintf("ACK %s common\n", haves[0]),
+		fmt.Sprintf("ACK %s ready\n", haves[5]),
+		"NAK\n",
+		fmt.Sprintf("ACK %s\n", haves[5]),
+	), "PACK"...)
+
+	r := bufio.NewReader(bytes.NewReader(raw))
+	c.Assert(n.DecodeRound(r), IsNil)
+	c.Assert(n.Done(), Equals, true)
+
+	res, err := n.DecodeResponse(ioutil.NopCloser(r))
+	c.Assert(err, IsNil)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{haves[5]})
+
+	pack, err := ioutil.ReadAll(res)
+	c.Assert(err, IsNil)
+	c.Assert(string(pack), Equals, "PACK")
+}
+
+func (s *NegotiationSuite) TestReadyDone(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed)
+	haves := negotiationHaves(20)
+	req.Haves = haves
+
+	n := NewNegotiation(req, false)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+
+	raw := pktlines(c, fmt.Sprintf("ACK %s ready\n", haves[5]), "NAK\n")
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+	c.Assert(n.Done(), Equals, false)
+
+	buf.Reset()
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, string(pktlines(c, "done\n")))
+	c.Assert(n.Done(), Equals, true)
+}
+
+func (s *NegotiationSuite) TestGiveUpInVain(c *C) {
+	req := s.newRequest(c, capability.MultiACK)
+	haves := negotiationHaves(1000)
+	req.Haves = haves
+
+	n := NewNegotiation(req, false)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+
+	raw := pktlines(c, fmt.Sprintf("ACK %s continue\n", haves[0]), "NAK\n")
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+
+	rounds := 1
+	for !n.Done() {
+		c.Assert(n.EncodeRound(&buf), IsNil)
+		if n.Done() {
+			break
+		}
+
+		rounds++
+		raw := pktlines(c, "NAK\n")
+		c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+	}
+
+	// 16, 32, 64, 96, 128, 160, 192, 224, 256, 288
+	c.Assert(rounds, Equals, 10)
+}
+
+func (s *NegotiationSuite) TestNoHaves(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed)
+
+	n := NewNegotiation(req, false)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, s.wants(c, req)+string(pktlines(c, "done\n")))
+	c.Assert(n.Done(), Equals, true)
+
+	raw := append(pktlines(c, "NAK\n"), "PACK"...)
+	res, err := n.DecodeResponse(ioutil.NopCloser(bytes.NewReader(raw)))
+	c.Assert(err, IsNil)
+	c.Assert(res.ACKs, HasLen, 0)
+}
+
+func (s *NegotiationSuite) TestShallow(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed, capability.Shallow)
+	req.Depth = DepthCommits(1)
+	haves := negotiationHaves(16)
+	req.Haves = haves
+
+	shallow := pktlines(c, fmt.Sprintf("shallow %s\n", negotiationWant), pktline.FlushString)
+	for _, stateless := range []bool{false, true} {
+		n := NewNegotiation(req, stateless)
+
+		var buf bytes.Buffer
+		c.Assert(n.EncodeRound(&buf), IsNil)
+
+		raw := append(shallow, pktlines(c, "NAK\n")...)
+		c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+
+		c.Assert(n.EncodeRound(&buf), IsNil)
+		c.Assert(n.Done(), Equals, true)
+
+		raw = pktlines(c, "NAK\n")
+		if stateless {
+			// the shallow update is sent again in every response
+			raw = append(shallow, raw...)
+		}
+
+		res, err := n.DecodeResponse(ioutil.NopCloser(bytes.NewReader(raw)))
+		c.Assert(err, IsNil, Commentf("stateless %t", stateless))
+		c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{negotiationWant})
+	}
+}
+
+func (s *NegotiationSuite) TestShallowMultiRound(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed, capability.Shallow)
+	req.Depth = DepthCommits(1)
+	haves := negotiationHaves(40)
+	req.Haves = haves
+
+	shallow := pktlines(c, fmt.Sprintf("shallow %s\n", negotiationWant), pktline.FlushString)
+	for _, stateless := range []bool{false, true} {
+		comment := Commentf("stateless %t", stateless)
+		n := NewNegotiation(req, stateless)
+
+		var buf bytes.Buffer
+		for round := 1; !n.Done(); round++ {
+			c.Assert(n.EncodeRound(&buf), IsNil, comment)
+			if n.Done() {
+				break
+			}
+
+			raw := pktlines(c, "NAK\n")
+			if stateless || round == 1 {
+				raw = append(shallow, raw...)
+			}
+
+			c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil, comment)
+		}
+
+		raw := append(pktlines(c, "NAK\n"), "PACK"...)
+		if stateless {
+			raw = append(shallow, raw...)
+		}
+
+		res, err := n.DecodeResponse(ioutil.NopCloser(bytes.NewReader(raw)))
+		c.Assert(err, IsNil, comment)
+		c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{negotiationWant}, comment)
+
+		buf.Reset()
+		c.Assert(res.Encode(&buf), IsNil, comment)
+		c.Assert(buf.String(), Equals, string(append(shallow, pktlines(c, "NAK\n")...))+"PACK", comment)
+	}
+}
+
+type ackRecorder struct {
+	hashesNegotiator
+	acks []plumbing.Hash
+}
+
+func (n *ackRecorder) Ack(h plumbing.Hash) error {
+	n.acks = append(n.acks, h)
+	return nil
+}
+
+func (s *NegotiationSuite) TestNegotiator(c *C) {
+	req := s.newRequest(c, capability.MultiACKDetailed)
+	haves := negotiationHaves(4)
+	negotiator := &ackRecorder{hashesNegotiator: hashesNegotiator{haves: haves}}
+	req.Negotiator = negotiator
+	req.Haves = negotiationHaves(100)
+
+	n := NewNegotiation(req, false)
+
+	var buf bytes.Buffer
+	c.Assert(n.EncodeRound(&buf), IsNil)
+	c.Assert(buf.String(), Equals, s.wants(c, req)+s.haves(c, haves, pktline.FlushString))
+
+	raw := pktlines(c,
+		fmt.Sprintf("ACK %s common\n", haves[1]),
+		fmt.Sprintf("ACK %s common\n", haves[1]),
+		fmt.Sprintf("ACK %s common\n", haves[2]),
+		"NAK\n",
+	)
+	c.Assert(n.DecodeRound(bufio.NewReader(bytes.NewReader(raw))), IsNil)
+	c.Assert(negotiator.acks, DeepEquals, []plumbing.Hash{haves[1], haves[2]})
+}
@@ -0,0 +1,114 @@
+package packp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// ErrPushCertCommands is returned when encoding a ReferenceUpdateRequest whose
+// commands aren't the ones of its push certificate.
+var ErrPushCertCommands = errors.New("push certificate commands don't match the request commands")
+
+const pushCertVersion = "0.1"
+
+var (
+	pushCert            = []byte("push-cert")
+	pushCertEnd         = []byte("push-cert-end")
+	pushCertVersionLine = []byte("certificate version " + pushCertVersion)
+	pushCertPusher      = []byte("pusher ")
+	pushCertPushee      = []byte("pushee ")
+	pushCertNonce       = []byte("nonce ")
+	pushCertOption      = []byte("push-option ")
+	pushCertSignature   = []byte("-----BEGIN PGP SIGNATURE-----")
+)
+
+// PushCertificate is a signed push certificate, sent instead of the commands
+// of a ReferenceUpdateRequest when the server advertises push-cert, proving
+// who requested the reference updates.
+type PushCertificate struct {
+	// Pusher is the identity of the signer followed by the time of the
+	// push, as in the signatures of the commits.
+	Pusher string
+	// Pushee is the URL of the repository pushed to, without credentials.
+	Pushee string
+	// Nonce is the value of the push-cert capability advertised by the
+	// server, preventing the certificate from being replayed.
+	Nonce string
+	// Options are the push options of the request.
+	Options []*Option
+	// Commands are the reference updates requested.
+	Commands []*Command
+	// Signature is the armored detached signature of the certificate.
+	Signature string
+}
+
+// Payload returns the signed part of the certificate.
+func (c *PushCertificate) Payload() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n", pushCertVersionLine)
+	fmt.Fprintf(&b, "%s%s\n", pushCertPusher, c.Pusher)
+	if c.Pushee != "" {
+		fmt.Fprintf(&b, "%s%s\n", pushCertPushee, c.Pushee)
+	}
+
+	fmt.Fprintf(&b, "%s%s\n", pushCertNonce, c.Nonce)
+	for _, opt := range c.Options {
+		fmt.Fprintf(&b, "%s%s\n", pushCertOption, formatOption(opt))
+	}
+
+	b.WriteString("\n")
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(&b, "%s\n", formatCommand(cmd))
+	}
+
+	return b.Bytes()
+}
+
+// Sign signs the certificate with the given entity, setting its Signature.
+func (c *PushCertificate) Sign(signKey *openpgp.Entity) error {
+	var b bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&b, signKey, bytes.NewReader(c.Payload()), nil); err != nil {
+		return err
+	}
+
+	c.Signature = b.String()
+	if !strings.HasSuffix(c.Signature, "\n") {
+		c.Signature += "\n"
+	}
+
+	return nil
+}
+
+// Verify performs PGP verification of the certificate with a provided armored
+// keyring and returns openpgp.Entity associated with verifying key on success.
+func (c *PushCertificate) Verify(armoredKeyRing string) (*openpgp.Entity, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKeyRing))
+	if err != nil {
+		return nil, err
+	}
+
+	return openpgp.CheckArmoredDetachedSignature(keyring,
+		bytes.NewReader(c.Payload()), strings.NewReader(c.Signature))
+}
+
+// lines returns the lines of the certificate, as sent between the push-cert
+// line and the push-cert-end one.
+func (c *PushCertificate) lines() []string {
+	lines := splitLines(string(c.Payload()))
+	if c.Signature == "" {
+		return lines
+	}
+
+	return append(lines, splitLines(strings.TrimSuffix(c.Signature, "\n")+"\n")...)
+}
+
+// splitLines splits a text ending with a new line into its lines, keeping
+// their new line.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	return lines[:len(lines)-1]
+}
@@ -0,0 +1,64 @@
+package packp
+
+import (
+	"bytes"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type PushCertSuite struct{}
+
+var _ = Suite(&PushCertSuite{})
+
+func (s *PushCertSuite) newCertificate() *PushCertificate {
+	return &PushCertificate{
+		Pusher:  "foo <foo@foo.foo> 1546300800 +0000",
+		Pushee:  "https://example.com/foo.git",
+		Nonce:   "1546300800-abc",
+		Options: []*Option{{Key: "ci.skip"}},
+		Commands: []*Command{{
+			Name: plumbing.ReferenceName("refs/heads/master"),
+			Old:  plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+			New:  plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		}},
+	}
+}
+
+func (s *PushCertSuite) TestPayload(c *C) {
+	c.Assert(string(s.newCertificate().Payload()), Equals, ""+
+		"certificate version 0.1\n"+
+		"pusher foo <foo@foo.foo> 1546300800 +0000\n"+
+		"pushee https://example.com/foo.git\n"+
+		"nonce 1546300800-abc\n"+
+		"push-option ci.skip\n"+
+		"\n"+
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+	)
+}
+
+func (s *PushCertSuite) TestSignAndVerify(c *C) {
+	key, err := openpgp.NewEntity("foo", "", "foo@foo.foo", nil)
+	c.Assert(err, IsNil)
+
+	cert := s.newCertificate()
+	c.Assert(cert.Sign(key), IsNil)
+	c.Assert(cert.Signature, Matches, "(?s)-----BEGIN PGP SIGNATURE-----\n.*-----END PGP SIGNATURE-----\n")
+
+	var pks bytes.Buffer
+	w, err := armor.Encode(&pks, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key.Serialize(w), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	signer, err := cert.Verify(pks.String())
+	c.Assert(err, IsNil)
+	c.Assert(signer.PrimaryKey.KeyId, Equals, key.PrimaryKey.KeyId)
+
+	cert.Commands[0].New = plumbing.ZeroHash
+	_, err = cert.Verify(pks.String())
+	c.Assert(err, NotNil)
+}
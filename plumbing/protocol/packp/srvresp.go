@@ -13,17 +13,41 @@ import (
 
 const ackLineLen = 44
 
+// ACKStatus is the status of an acknowledgment of a negotiation round in the
+// multi_ack and multi_ack_detailed modes.
+type ACKStatus string
+
+const (
+	// ACKContinue acknowledges a have in the multi_ack mode, or a have
+	// unknown to the server once it's ready in that mode.
+	ACKContinue ACKStatus = "continue"
+	// ACKCommon acknowledges a have found in the server, in the
+	// multi_ack_detailed mode.
+	ACKCommon ACKStatus = "common"
+	// ACKReady acknowledges the server is ready to send the packfile, in the
+	// multi_ack_detailed mode.
+	ACKReady ACKStatus = "ready"
+)
+
 // ServerResponse object acknowledgement from upload-pack service
 type ServerResponse struct {
 	ACKs []plumbing.Hash
+	// Ready is true if the server acknowledged it's ready to send the
+	// packfile, in the multi_ack_detailed mode.
+	Ready bool
+
+	// common are the ACKs with the common status, the ones to send again in
+	// the following rounds of a stateless negotiation.
+	common []plumbing.Hash
 }
 
 // Decode decodes the response into the struct, isMultiACK should be true, if
-// the request was done with multi_ack or multi_ack_detailed capabilities.
+// the request was done with multi_ack or multi_ack_detailed capabilities. In
+// that case, the acknowledgments are read until a NAK or an ACK without
+// status, which end a negotiation round and the negotiation respectively.
 func (r *ServerResponse) Decode(reader *bufio.Reader, isMultiACK bool) error {
-	// TODO: implement support for multi_ack or multi_ack_detailed responses
 	if isMultiACK {
-		return errors.New("multi_ack and multi_ack_detailed are not supported")
+		return r.decodeMultiACK(reader)
 	}
 
 	s := pktline.NewScanner(reader)
@@ -51,6 +75,42 @@ func (r *ServerResponse) Decode(reader *bufio.Reader, isMultiACK bool) error {
 	return s.Err()
 }
 
+func (r *ServerResponse) decodeMultiACK(reader *bufio.Reader) error {
+	s := pktline.NewScanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if bytes.Equal(line, nak) {
+			return nil
+		}
+
+		if !bytes.HasPrefix(line, ack) {
+			return fmt.Errorf("unexpected content %q", string(line))
+		}
+
+		if err := r.decodeACKLine(line); err != nil {
+			return err
+		}
+
+		switch status := ACKStatus(bytes.TrimSpace(line[ackLineLen:])); status {
+		case "":
+			return nil
+		case ACKReady:
+			r.Ready = true
+		case ACKCommon:
+			r.common = append(r.common, r.ACKs[len(r.ACKs)-1])
+		case ACKContinue:
+		default:
+			return fmt.Errorf("unknown ACK status %q", status)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
 // stopReading detects when a valid command such as ACK or NAK is found to be
 // read in the buffer without moving the read pointer.
 func (r *ServerResponse) stopReading(reader *bufio.Reader) (bool, error) {
@@ -125,3 +185,33 @@ func (r *ServerResponse) Encode(w io.Writer) error {
 
 	return e.Encodef("%s %s\n", ack, r.ACKs[0].String())
 }
+
+// ACK is an acknowledgment of a have in a negotiation round.
+type ACK struct {
+	Hash   plumbing.Hash
+	Status ACKStatus
+}
+
+// RoundResponse is the response of the server to a negotiation round in the
+// multi_ack and multi_ack_detailed modes, the one decoded by
+// ServerResponse.Decode with isMultiACK.
+type RoundResponse struct {
+	ACKs []ACK
+	// Ready is true once the server acknowledged it's ready to send the
+	// packfile, in this round or a previous one. With the no-done
+	// capability the packfile follows the response.
+	Ready bool
+}
+
+// Encode encodes the acknowledgments of the round into a writer, followed by
+// the NAK ending the round.
+func (r *RoundResponse) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	for _, a := range r.ACKs {
+		if err := e.Encodef("%s %s %s\n", ack, a.Hash.String(), a.Status); err != nil {
+			return err
+		}
+	}
+
+	return e.Encodef("%s\n", nak)
+}
@@ -77,3 +77,64 @@ func (s *ServerResponseSuite) TestDecodeMultiACK(c *C) {
 	err := sr.Decode(bufio.NewReader(bytes.NewBuffer(nil)), true)
 	c.Assert(err, NotNil)
 }
+
+func (s *ServerResponseSuite) TestDecodeMultiACKDetailed(c *C) {
+	raw := "" +
+		"0038ACK 1111111111111111111111111111111111111111 common\n" +
+		"0037ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 ready\n" +
+		"0008NAK\n" +
+		"0031ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n"
+
+	r := bufio.NewReader(bytes.NewBufferString(raw))
+	sr := &ServerResponse{}
+	err := sr.Decode(r, true)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Ready, Equals, true)
+	c.Assert(sr.ACKs, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("1111111111111111111111111111111111111111"),
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+
+	sr = &ServerResponse{}
+	err = sr.Decode(r, true)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Ready, Equals, false)
+	c.Assert(sr.ACKs, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+}
+
+func (s *ServerResponseSuite) TestDecodeMultiACKUnknownStatus(c *C) {
+	raw := "0037ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 later\n"
+
+	sr := &ServerResponse{}
+	err := sr.Decode(bufio.NewReader(bytes.NewBufferString(raw)), true)
+	c.Assert(err, ErrorMatches, "unknown ACK status.*")
+}
+
+func (s *ServerResponseSuite) TestEncodeRoundResponse(c *C) {
+	rr := &RoundResponse{ACKs: []ACK{
+		{plumbing.NewHash("1111111111111111111111111111111111111111"), ACKCommon},
+		{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"), ACKReady},
+	}}
+
+	var buf bytes.Buffer
+	c.Assert(rr.Encode(&buf), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"0038ACK 1111111111111111111111111111111111111111 common\n"+
+		"0037ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 ready\n"+
+		"0008NAK\n")
+
+	sr := &ServerResponse{}
+	c.Assert(sr.Decode(bufio.NewReader(&buf), true), IsNil)
+	c.Assert(sr.Ready, Equals, true)
+	c.Assert(sr.common, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("1111111111111111111111111111111111111111"),
+	})
+}
+
+func (s *ServerResponseSuite) TestEncodeRoundResponseEmpty(c *C) {
+	var buf bytes.Buffer
+	c.Assert((&RoundResponse{}).Encode(&buf), IsNil)
+	c.Assert(buf.String(), Equals, "0008NAK\n")
+}
@@ -17,10 +17,13 @@ type UploadRequest struct {
 	Wants        []plumbing.Hash
 	Shallows     []plumbing.Hash
 	Depth        Depth
+	// Filter, if not empty, requests the server to omit the objects not
+	// matching it, see Filter.
+	Filter Filter
 }
 
 // Depth values stores the desired depth of the requested packfile: see
-// DepthCommit, DepthSince and DepthReference.
+// DepthCommit, DepthSince, DepthReference and DepthRevisions.
 type Depth interface {
 	isDepth()
 	IsZero() bool
@@ -55,6 +58,20 @@ func (d DepthReference) IsZero() bool {
 	return string(d) == ""
 }
 
+// DepthRevisions requests only the commits newer than Since, unless it's
+// zero, and not found in any of the references in Not, combining DepthSince
+// and DepthReference.
+type DepthRevisions struct {
+	Since time.Time
+	Not   []string
+}
+
+func (d DepthRevisions) isDepth() {}
+
+func (d DepthRevisions) IsZero() bool {
+	return d.Since.IsZero() && len(d.Not) == 0
+}
+
 // NewUploadRequest returns a pointer to a new UploadRequest value, ready to be
 // used. It has no capabilities, wants or shallows and an infinite depth. Please
 // note that to encode an upload-request it has to have at least one wanted hash.
@@ -107,6 +124,9 @@ func NewUploadRequestFromCapabilities(adv *capability.List) *UploadRequest {
 //   - is a non-zero DepthCommits is given capability.Shallow MUST be present
 //   - is a DepthSince is given capability.Shallow MUST be present
 //   - is a DepthReference is given capability.DeepenNot MUST be present
+//   - is a DepthRevisions is given capability.DeepenSince MUST be present if
+//     it has a Since and capability.DeepenNot if it has any Not
+//   - is a Filter is given capability.Filter MUST be present
 //   - MUST contain only maximum of one of capability.Sideband and capability.Sideband64k
 //   - MUST contain only maximum of one of capability.MultiACK and capability.MultiACKDetailed
 func (r *UploadRequest) Validate() error {
@@ -132,9 +152,9 @@ func (r *UploadRequest) validateRequiredCapabilities() error {
 		return fmt.Errorf(msg, capability.Shallow)
 	}
 
-	switch r.Depth.(type) {
+	switch depth := r.Depth.(type) {
 	case DepthCommits:
-		if r.Depth != DepthCommits(0) {
+		if depth != DepthCommits(0) {
 			if !r.Capabilities.Supports(capability.Shallow) {
 				return fmt.Errorf(msg, capability.Shallow)
 			}
@@ -147,6 +167,18 @@ func (r *UploadRequest) validateRequiredCapabilities() error {
 		if !r.Capabilities.Supports(capability.DeepenNot) {
 			return fmt.Errorf(msg, capability.DeepenNot)
 		}
+	case DepthRevisions:
+		if !depth.Since.IsZero() && !r.Capabilities.Supports(capability.DeepenSince) {
+			return fmt.Errorf(msg, capability.DeepenSince)
+		}
+
+		if len(depth.Not) != 0 && !r.Capabilities.Supports(capability.DeepenNot) {
+			return fmt.Errorf(msg, capability.DeepenNot)
+		}
+	}
+
+	if r.Filter != "" && !r.Capabilities.Supports(capability.Filter) {
+		return fmt.Errorf(msg, capability.Filter)
 	}
 
 	return nil
@@ -166,3 +198,10 @@ func (r *UploadRequest) validateConflictCapabilities() error {
 
 	return nil
 }
+
+// isShallow returns true if the response to the request starts with a
+// shallow update, which happens when a depth is requested. The shallows of
+// the client alone don't change them, so no update is sent for them.
+func (r *UploadRequest) isShallow() bool {
+	return !r.Depth.IsZero()
+}
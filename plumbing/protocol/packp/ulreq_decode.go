@@ -136,6 +136,10 @@ func (d *ulReqDecoder) decodeOtherWants() stateFn {
 		return d.decodeDeepen
 	}
 
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
 	if len(d.line) == 0 {
 		return nil
 	}
@@ -161,6 +165,10 @@ func (d *ulReqDecoder) decodeShallow() stateFn {
 		return d.decodeDeepen
 	}
 
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
 	if len(d.line) == 0 {
 		return nil
 	}
@@ -217,9 +225,13 @@ func (d *ulReqDecoder) decodeDeepenCommits() stateFn {
 		d.err = fmt.Errorf("negative depth")
 		return nil
 	}
+	if !d.data.Depth.IsZero() {
+		d.err = fmt.Errorf("deepen and deepen-since (or deepen-not) cannot be used together")
+		return nil
+	}
 	d.data.Depth = DepthCommits(n)
 
-	return d.decodeFlush
+	return d.decodeFilterOrFlush
 }
 
 func (d *ulReqDecoder) decodeDeepenSince() stateFn {
@@ -231,15 +243,85 @@ func (d *ulReqDecoder) decodeDeepenSince() stateFn {
 		return nil
 	}
 	t := time.Unix(secs, 0).UTC()
-	d.data.Depth = DepthSince(t)
+	d.addDepthRevision(t, "")
 
-	return d.decodeFlush
+	return d.decodeDeepenOrFilterOrFlush
 }
 
 func (d *ulReqDecoder) decodeDeepenReference() stateFn {
 	d.line = bytes.TrimPrefix(d.line, deepenReference)
 
-	d.data.Depth = DepthReference(string(d.line))
+	d.addDepthRevision(time.Time{}, string(d.line))
+
+	return d.decodeDeepenOrFilterOrFlush
+}
+
+// addDepthRevision sets the depth to the given DepthSince or DepthReference,
+// combining them into a DepthRevisions if any was decoded before.
+func (d *ulReqDecoder) addDepthRevision(since time.Time, reference string) {
+	var revs DepthRevisions
+	switch depth := d.data.Depth.(type) {
+	case DepthSince:
+		revs.Since = time.Time(depth)
+	case DepthReference:
+		revs.Not = []string{string(depth)}
+	case DepthRevisions:
+		revs = depth
+	default:
+		if reference == "" {
+			d.data.Depth = DepthSince(since)
+		} else {
+			d.data.Depth = DepthReference(reference)
+		}
+
+		return
+	}
+
+	if reference == "" {
+		revs.Since = since
+	} else {
+		revs.Not = append(revs.Not, reference)
+	}
+
+	d.data.Depth = revs
+}
+
+// deepen-since and deepen-not can be combined and repeated
+func (d *ulReqDecoder) decodeDeepenOrFilterOrFlush() stateFn {
+	if ok := d.nextLine(); !ok {
+		return nil
+	}
+
+	if bytes.HasPrefix(d.line, deepen) {
+		return d.decodeDeepen
+	}
+
+	return d.decodeFilterOrFlushLine
+}
+
+func (d *ulReqDecoder) decodeFilterOrFlush() stateFn {
+	if ok := d.nextLine(); !ok {
+		return nil
+	}
+
+	return d.decodeFilterOrFlushLine
+}
+
+func (d *ulReqDecoder) decodeFilterOrFlushLine() stateFn {
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
+	if len(d.line) != 0 {
+		d.err = fmt.Errorf("unexpected payload while expecting a filter or a flush-pkt: %q", d.line)
+	}
+
+	return nil
+}
+
+// Expected format: filter <filter-spec>
+func (d *ulReqDecoder) decodeFilter() stateFn {
+	d.data.Filter = Filter(bytes.TrimPrefix(d.line, filter))
 
 	return d.decodeFlush
 }
@@ -477,6 +477,55 @@ func (s *UlReqDecodeSuite) TestDeepenReference(c *C) {
 	c.Assert(string(reference), Equals, expected)
 }
 
+func (s *UlReqDecodeSuite) TestDeepenRevisions(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
+		"deepen-not refs/heads/master",
+		"deepen-since 1420167845",
+		"deepen-not refs/tags/v1.0.0",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+
+	c.Assert(ur.Depth, DeepEquals, DepthRevisions{
+		Since: time.Date(2015, time.January, 2, 3, 4, 5, 0, time.UTC),
+		Not:   []string{"refs/heads/master", "refs/tags/v1.0.0"},
+	})
+}
+
+func (s *UlReqDecodeSuite) TestDeepenCommitsAndSince(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
+		"deepen-since 1420167845",
+		"deepen 1",
+		pktline.FlushString,
+	}
+	s.testDecoderErrorMatches(c, toPktLines(c, payloads), ".*cannot be used together.*")
+}
+
+func (s *UlReqDecodeSuite) TestFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta filter",
+		"filter blob:none",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+	c.Assert(ur.Filter, Equals, FilterBlobNone())
+}
+
+func (s *UlReqDecodeSuite) TestDeepenAndFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta shallow filter",
+		"shallow aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"deepen 1",
+		"filter tree:0",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+	c.Assert(ur.Depth, Equals, DepthCommits(1))
+	c.Assert(ur.Filter, Equals, FilterTreeDepth(0))
+}
+
 func (s *UlReqDecodeSuite) TestAll(c *C) {
 	payloads := []string{
 		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
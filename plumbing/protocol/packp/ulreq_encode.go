@@ -14,7 +14,7 @@ import (
 //
 // All the payloads will end with a newline character.  Wants and
 // shallows are sorted alphabetically.  A depth of 0 means no depth
-// request is sent.
+// request is sent, as an empty filter.
 func (u *UploadRequest) Encode(w io.Writer) error {
 	e := newUlReqEncoder(w)
 	return e.Encode(u)
@@ -127,11 +127,39 @@ func (e *ulReqEncoder) encodeDepth() stateFn {
 			e.err = fmt.Errorf("encoding depth %s: %s", reference, err)
 			return nil
 		}
+	case DepthRevisions:
+		if !depth.Since.IsZero() {
+			when := depth.Since.UTC()
+			if err := e.pe.Encodef("deepen-since %d\n", when.Unix()); err != nil {
+				e.err = fmt.Errorf("encoding depth %s: %s", when, err)
+				return nil
+			}
+		}
+
+		for _, reference := range depth.Not {
+			if err := e.pe.Encodef("deepen-not %s\n", reference); err != nil {
+				e.err = fmt.Errorf("encoding depth %s: %s", reference, err)
+				return nil
+			}
+		}
 	default:
 		e.err = fmt.Errorf("unsupported depth type")
 		return nil
 	}
 
+	return e.encodeFilter
+}
+
+func (e *ulReqEncoder) encodeFilter() stateFn {
+	if e.data.Filter == "" {
+		return e.encodeFlush
+	}
+
+	if err := e.pe.Encodef("filter %s\n", e.data.Filter); err != nil {
+		e.err = fmt.Errorf("encoding filter %s: %s", e.data.Filter, err)
+		return nil
+	}
+
 	return e.encodeFlush
 }
 
@@ -268,6 +268,41 @@ func (s *UlReqEncodeSuite) TestDepthReference(c *C) {
 	testUlReqEncode(c, ur, expected)
 }
 
+func (s *UlReqEncodeSuite) TestDepthRevisions(c *C) {
+	ur := NewUploadRequest()
+	ur.Wants = append(ur.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	ur.Depth = DepthRevisions{
+		Since: time.Date(2015, time.January, 2, 3, 4, 5, 0, time.UTC),
+		Not:   []string{"refs/heads/feature-foo", "refs/tags/v1.0.0"},
+	}
+
+	expected := []string{
+		"want 1111111111111111111111111111111111111111\n",
+		"deepen-since 1420167845\n",
+		"deepen-not refs/heads/feature-foo\n",
+		"deepen-not refs/tags/v1.0.0\n",
+		pktline.FlushString,
+	}
+
+	testUlReqEncode(c, ur, expected)
+}
+
+func (s *UlReqEncodeSuite) TestFilter(c *C) {
+	ur := NewUploadRequest()
+	ur.Wants = append(ur.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	ur.Depth = DepthCommits(1)
+	ur.Filter = FilterBlobNone()
+
+	expected := []string{
+		"want 1111111111111111111111111111111111111111\n",
+		"deepen 1\n",
+		"filter blob:none\n",
+		pktline.FlushString,
+	}
+
+	testUlReqEncode(c, ur, expected)
+}
+
 func (s *UlReqEncodeSuite) TestAll(c *C) {
 	ur := NewUploadRequest()
 	ur.Wants = append(ur.Wants,
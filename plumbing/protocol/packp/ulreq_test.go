@@ -81,6 +81,33 @@ func (s *UlReqSuite) TestValidateDepthReference(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *UlReqSuite) TestValidateDepthRevisions(c *C) {
+	r := NewUploadRequest()
+	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	r.Depth = DepthRevisions{Since: time.Now(), Not: []string{"refs/heads/master"}}
+
+	c.Assert(r.Validate(), NotNil)
+
+	r.Capabilities.Set(capability.DeepenSince)
+	c.Assert(r.Validate(), NotNil)
+
+	r.Capabilities.Set(capability.DeepenNot)
+	c.Assert(r.Validate(), IsNil)
+}
+
+func (s *UlReqSuite) TestValidateFilter(c *C) {
+	r := NewUploadRequest()
+	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	r.Filter = FilterBlobNone()
+
+	err := r.Validate()
+	c.Assert(err, NotNil)
+
+	r.Capabilities.Set(capability.Filter)
+	err = r.Validate()
+	c.Assert(err, IsNil)
+}
+
 func (s *UlReqSuite) TestValidateDepthSince(c *C) {
 	r := NewUploadRequest()
 	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
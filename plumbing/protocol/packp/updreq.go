@@ -20,6 +20,12 @@ type ReferenceUpdateRequest struct {
 	Capabilities *capability.List
 	Commands     []*Command
 	Shallow      *plumbing.Hash
+	// Options are the push options sent after the commands, they are only
+	// encoded and decoded along with the capability.PushOptions.
+	Options []*Option
+	// Certificate, if not nil, is sent instead of the commands, which must
+	// be the ones of the certificate, when the server supports push-cert.
+	Certificate *PushCertificate
 	// Packfile contains an optional packfile reader.
 	Packfile io.ReadCloser
 
@@ -30,7 +36,6 @@ type ReferenceUpdateRequest struct {
 // New returns a pointer to a new ReferenceUpdateRequest value.
 func NewReferenceUpdateRequest() *ReferenceUpdateRequest {
 	return &ReferenceUpdateRequest{
-		// TODO: Add support for push-cert
 		Capabilities: capability.NewList(),
 		Commands:     nil,
 	}
@@ -79,9 +84,34 @@ func (r *ReferenceUpdateRequest) validate() error {
 		}
 	}
 
+	if r.Certificate != nil && !equalCommands(r.Commands, r.Certificate.Commands) {
+		return ErrPushCertCommands
+	}
+
 	return nil
 }
 
+func equalCommands(a, b []*Command) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Option is a push option, passed by the server to its hooks. It's sent as
+// key=value, or just as the key if the value is empty.
+type Option struct {
+	Key   string
+	Value string
+}
+
 type Action string
 
 const (
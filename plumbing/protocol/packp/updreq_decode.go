@@ -10,6 +10,7 @@ import (
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 )
 
 var (
@@ -101,6 +102,7 @@ func (d *updReqDecoder) Decode(req *ReferenceUpdateRequest) error {
 		d.decodeShallow,
 		d.decodeCommandAndCapabilities,
 		d.decodeCommands,
+		d.decodeOptions,
 		d.setPackfile,
 		req.validate,
 	}
@@ -167,6 +169,34 @@ func (d *updReqDecoder) decodeCommands() error {
 	}
 }
 
+func (d *updReqDecoder) decodeOptions() error {
+	if !d.req.Capabilities.Supports(capability.PushOptions) {
+		return nil
+	}
+
+	for {
+		if ok := d.s.Scan(); !ok {
+			return d.scanErrorOr(errMalformedRequest("missing push options flush-pkt"))
+		}
+
+		b := d.s.Bytes()
+		if bytes.Equal(b, pktline.Flush) {
+			return nil
+		}
+
+		d.req.Options = append(d.req.Options, parseOption(b))
+	}
+}
+
+func parseOption(b []byte) *Option {
+	opt := &Option{Key: string(b)}
+	if i := bytes.IndexByte(b, '='); i != -1 {
+		opt.Key, opt.Value = string(b[:i]), string(b[i+1:])
+	}
+
+	return opt
+}
+
 func (d *updReqDecoder) decodeCommandAndCapabilities() error {
 	b := d.s.Bytes()
 	i := bytes.IndexByte(b, 0)
@@ -174,6 +204,10 @@ func (d *updReqDecoder) decodeCommandAndCapabilities() error {
 		return errMissingCapabilitiesDelimiter
 	}
 
+	if bytes.Equal(b[:i], pushCert) {
+		return d.decodeCertificate(bytes.TrimSuffix(b[i+1:], eol))
+	}
+
 	if len(b) < minCommandAndCapsLenth {
 		return errInvalidCommandCapabilitiesLineLength(len(b))
 	}
@@ -196,6 +230,80 @@ func (d *updReqDecoder) decodeCommandAndCapabilities() error {
 	return nil
 }
 
+func (d *updReqDecoder) decodeCertificate(caps []byte) error {
+	if err := d.req.Capabilities.Decode(caps); err != nil {
+		return err
+	}
+
+	var lines [][]byte
+	for {
+		if ok := d.s.Scan(); !ok {
+			return d.scanErrorOr(errMalformedRequest("missing push-cert-end"))
+		}
+
+		b := bytes.TrimSuffix(d.s.Bytes(), eol)
+		if bytes.Equal(b, pushCertEnd) {
+			break
+		}
+
+		lines = append(lines, append([]byte(nil), b...))
+	}
+
+	cert, err := parseCertificate(lines)
+	if err != nil {
+		return err
+	}
+
+	d.req.Certificate = cert
+	d.req.Commands = append(d.req.Commands, cert.Commands...)
+
+	return d.scanLine()
+}
+
+func parseCertificate(lines [][]byte) (*PushCertificate, error) {
+	if len(lines) == 0 || !bytes.Equal(lines[0], pushCertVersionLine) {
+		return nil, errMalformedRequest("unsupported push certificate version")
+	}
+
+	cert := &PushCertificate{}
+	i := 1
+	for ; i < len(lines) && len(lines[i]) != 0; i++ {
+		b := lines[i]
+		switch {
+		case bytes.HasPrefix(b, pushCertPusher):
+			cert.Pusher = string(b[len(pushCertPusher):])
+		case bytes.HasPrefix(b, pushCertPushee):
+			cert.Pushee = string(b[len(pushCertPushee):])
+		case bytes.HasPrefix(b, pushCertNonce):
+			cert.Nonce = string(b[len(pushCertNonce):])
+		case bytes.HasPrefix(b, pushCertOption):
+			cert.Options = append(cert.Options, parseOption(b[len(pushCertOption):]))
+		default:
+			return nil, errMalformedRequest(fmt.Sprintf(
+				"unexpected push certificate line: %q", b))
+		}
+	}
+
+	if i == len(lines) {
+		return nil, errMalformedRequest("missing push certificate commands")
+	}
+
+	for i++; i < len(lines) && !bytes.Equal(lines[i], pushCertSignature); i++ {
+		c, err := parseCommand(lines[i])
+		if err != nil {
+			return nil, err
+		}
+
+		cert.Commands = append(cert.Commands, c)
+	}
+
+	for ; i < len(lines); i++ {
+		cert.Signature += string(lines[i]) + "\n"
+	}
+
+	return cert, nil
+}
+
 func (d *updReqDecoder) setPackfile() error {
 	d.req.Packfile = d.r
 
@@ -237,6 +237,115 @@ func (s *UpdReqDecodeSuite) TestMultipleCommandsAndCapabilitiesShallow(c *C) {
 	s.testDecodeOkExpected(c, expected, payloads)
 }
 
+func (s *UpdReqDecodeSuite) TestPushOptions(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	expected := NewReferenceUpdateRequest()
+	expected.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref1"), Old: hash1, New: hash2},
+	}
+	expected.Capabilities.Add("push-options")
+	expected.Options = []*Option{
+		{Key: "ci.skip"},
+		{Key: "ci.variable", Value: "FOO=bar"},
+	}
+	expected.Packfile = ioutil.NopCloser(bytes.NewReader([]byte{}))
+
+	payloads := []string{
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref1\x00push-options",
+		pktline.FlushString,
+		"ci.skip",
+		"ci.variable=FOO=bar",
+		pktline.FlushString,
+	}
+
+	s.testDecodeOkExpected(c, expected, payloads)
+}
+
+func (s *UpdReqDecodeSuite) TestPushOptionsMissingFlush(c *C) {
+	payloads := []string{
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref1\x00push-options",
+		pktline.FlushString,
+		"ci.skip",
+	}
+
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+	c.Assert(e.EncodeString(payloads...), IsNil)
+	s.testDecoderErrorMatches(c, &buf, ".*missing push options flush-pkt.*")
+}
+
+func (s *UpdReqDecodeSuite) TestPushCertificate(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	expected := NewReferenceUpdateRequest()
+	expected.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref1"), Old: hash1, New: hash2},
+	}
+	expected.Capabilities.Add("report-status")
+	expected.Capabilities.Add("push-options")
+	expected.Options = []*Option{{Key: "ci.skip"}}
+	expected.Certificate = &PushCertificate{
+		Pusher:    "foo <foo@foo.foo> 1546300800 +0000",
+		Pushee:    "https://example.com/foo.git",
+		Nonce:     "1546300800-abc",
+		Options:   []*Option{{Key: "ci.skip"}},
+		Commands:  expected.Commands,
+		Signature: "-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----\n",
+	}
+	expected.Packfile = ioutil.NopCloser(bytes.NewReader([]byte{}))
+
+	payloads := []string{
+		"push-cert\x00report-status push-options\n",
+		"certificate version 0.1\n",
+		"pusher foo <foo@foo.foo> 1546300800 +0000\n",
+		"pushee https://example.com/foo.git\n",
+		"nonce 1546300800-abc\n",
+		"push-option ci.skip\n",
+		"\n",
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref1\n",
+		"-----BEGIN PGP SIGNATURE-----\n",
+		"\n",
+		"abc\n",
+		"-----END PGP SIGNATURE-----\n",
+		"push-cert-end\n",
+		pktline.FlushString,
+		"ci.skip",
+		pktline.FlushString,
+	}
+
+	s.testDecodeOkExpected(c, expected, payloads)
+}
+
+func (s *UpdReqDecodeSuite) TestPushCertificateMissingEnd(c *C) {
+	payloads := []string{
+		"push-cert\x00report-status\n",
+		"certificate version 0.1\n",
+		"pusher foo <foo@foo.foo> 1546300800 +0000\n",
+	}
+
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+	c.Assert(e.EncodeString(payloads...), IsNil)
+	s.testDecoderErrorMatches(c, &buf, ".*missing push-cert-end.*")
+}
+
+func (s *UpdReqDecodeSuite) TestPushCertificateVersion(c *C) {
+	payloads := []string{
+		"push-cert\x00report-status\n",
+		"certificate version 0.2\n",
+		"push-cert-end\n",
+		pktline.FlushString,
+	}
+
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+	c.Assert(e.EncodeString(payloads...), IsNil)
+	s.testDecoderErrorMatches(c, &buf, ".*unsupported push certificate version.*")
+}
+
 func (s *UpdReqDecodeSuite) TestWithPackfile(c *C) {
 	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
 	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
@@ -25,8 +25,20 @@ func (r *ReferenceUpdateRequest) Encode(w io.Writer) error {
 		return err
 	}
 
-	if err := r.encodeCommands(e, r.Commands, r.Capabilities); err != nil {
-		return err
+	if r.Certificate != nil {
+		if err := r.encodeCertificate(e, r.Certificate, r.Capabilities); err != nil {
+			return err
+		}
+	} else {
+		if err := r.encodeCommands(e, r.Commands, r.Capabilities); err != nil {
+			return err
+		}
+	}
+
+	if r.Capabilities.Supports(capability.PushOptions) {
+		if err := r.encodeOptions(e, r.Options); err != nil {
+			return err
+		}
 	}
 
 	if r.Packfile != nil {
@@ -68,8 +80,48 @@ func (r *ReferenceUpdateRequest) encodeCommands(e *pktline.Encoder,
 	return e.Flush()
 }
 
+func (r *ReferenceUpdateRequest) encodeCertificate(e *pktline.Encoder,
+	cert *PushCertificate, cap *capability.List) error {
+
+	if err := e.Encodef("%s\x00%s\n", pushCert, cap.String()); err != nil {
+		return err
+	}
+
+	for _, line := range cert.lines() {
+		if err := e.EncodeString(line); err != nil {
+			return err
+		}
+	}
+
+	if err := e.Encodef("%s\n", pushCertEnd); err != nil {
+		return err
+	}
+
+	return e.Flush()
+}
+
 func formatCommand(cmd *Command) string {
 	o := cmd.Old.String()
 	n := cmd.New.String()
 	return fmt.Sprintf("%s %s %s", o, n, cmd.Name)
 }
+
+func (r *ReferenceUpdateRequest) encodeOptions(e *pktline.Encoder,
+	opts []*Option) error {
+
+	for _, opt := range opts {
+		if err := e.EncodeString(formatOption(opt)); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+func formatOption(opt *Option) string {
+	if opt.Value == "" {
+		return opt.Key
+	}
+
+	return fmt.Sprintf("%s=%s", opt.Key, opt.Value)
+}
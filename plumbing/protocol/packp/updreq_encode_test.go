@@ -5,6 +5,7 @@ import (
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 
 	. "gopkg.in/check.v1"
 	"io/ioutil"
@@ -119,6 +120,81 @@ func (s *UpdReqEncodeSuite) TestMultipleCommandsAndCapabilitiesShallow(c *C) {
 	s.testEncode(c, r, expected)
 }
 
+func (s *UpdReqEncodeSuite) TestPushOptions(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	r := NewReferenceUpdateRequest()
+	r.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref1"), Old: hash1, New: hash2},
+	}
+	r.Capabilities.Add(capability.Atomic)
+	r.Capabilities.Add(capability.PushOptions)
+	r.Options = []*Option{
+		{Key: "ci.skip"},
+		{Key: "ci.variable", Value: "FOO=bar"},
+	}
+
+	expected := pktlines(c,
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref1\x00atomic push-options",
+		pktline.FlushString,
+		"ci.skip",
+		"ci.variable=FOO=bar",
+		pktline.FlushString,
+	)
+
+	s.testEncode(c, r, expected)
+}
+
+func (s *UpdReqEncodeSuite) TestPushCertificate(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	r := NewReferenceUpdateRequest()
+	r.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref1"), Old: hash1, New: hash2},
+	}
+	r.Capabilities.Add(capability.ReportStatus)
+	r.Certificate = &PushCertificate{
+		Pusher:    "foo <foo@foo.foo> 1546300800 +0000",
+		Nonce:     "1546300800-abc",
+		Commands:  r.Commands,
+		Signature: "-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----",
+	}
+
+	expected := pktlines(c,
+		"push-cert\x00report-status\n",
+		"certificate version 0.1\n",
+		"pusher foo <foo@foo.foo> 1546300800 +0000\n",
+		"nonce 1546300800-abc\n",
+		"\n",
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref1\n",
+		"-----BEGIN PGP SIGNATURE-----\n",
+		"\n",
+		"abc\n",
+		"-----END PGP SIGNATURE-----\n",
+		"push-cert-end\n",
+		pktline.FlushString,
+	)
+
+	s.testEncode(c, r, expected)
+}
+
+func (s *UpdReqEncodeSuite) TestPushCertificateCommands(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	r := NewReferenceUpdateRequest()
+	r.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref1"), Old: hash1, New: hash2},
+	}
+	r.Certificate = &PushCertificate{Commands: []*Command{
+		{Name: plumbing.ReferenceName("myref2"), Old: hash1, New: hash2},
+	}}
+
+	c.Assert(r.Encode(&bytes.Buffer{}), Equals, ErrPushCertCommands)
+}
+
 func (s *UpdReqEncodeSuite) TestWithPackfile(c *C) {
 	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
 	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
@@ -2,6 +2,7 @@ package packp
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"io"
 
@@ -15,6 +16,22 @@ import (
 type UploadPackRequest struct {
 	UploadRequest
 	UploadHaves
+	// Negotiator, if set, provides the haves negotiated with the server in
+	// the multi_ack and multi_ack_detailed modes instead of the Haves, see
+	// Negotiation.
+	Negotiator Negotiator
+}
+
+// Negotiator provides the haves of an upload-pack request negotiated with
+// the server, usually walking the history of the client from its most recent
+// commits.
+type Negotiator interface {
+	// Next returns at most n haves to send to the server, none if there are
+	// no more.
+	Next(n int) ([]plumbing.Hash, error)
+	// Ack marks a have as common with the server, the ones reachable from it
+	// don't need to be sent.
+	Ack(h plumbing.Hash) error
 }
 
 // NewUploadPackRequest creates a new UploadPackRequest and returns a pointer.
@@ -38,10 +55,20 @@ func NewUploadPackRequestFromCapabilities(adv *capability.List) *UploadPackReque
 	}
 }
 
+// Decode decodes the UploadRequest followed by the UploadHaves of the request.
+func (r *UploadPackRequest) Decode(rd io.Reader) error {
+	if err := r.UploadRequest.Decode(rd); err != nil {
+		return err
+	}
+
+	return r.UploadHaves.Decode(rd)
+}
+
 // IsEmpty a request if empty if Haves are contained in the Wants, or if Wants
-// length is zero
+// length is zero, unless it requests a depth, which may deepen the history of
+// the Haves.
 func (r *UploadPackRequest) IsEmpty() bool {
-	return isSubset(r.Wants, r.Haves)
+	return isSubset(r.Wants, r.Haves) && r.Depth.IsZero()
 }
 
 func isSubset(needle []plumbing.Hash, haystack []plumbing.Hash) bool {
@@ -66,6 +93,9 @@ func isSubset(needle []plumbing.Hash, haystack []plumbing.Hash) bool {
 // upload-pack. Do not use this directly. Use UploadPackRequest request instead.
 type UploadHaves struct {
 	Haves []plumbing.Hash
+	// Done is set by Decode if the haves end with a done line instead of a
+	// flush-pkt: the client doesn't want to negotiate anymore.
+	Done bool
 }
 
 // Encode encodes the UploadHaves into the Writer. If flush is true, a flush
@@ -96,3 +126,37 @@ func (u *UploadHaves) Encode(w io.Writer, flush bool) error {
 
 	return nil
 }
+
+// Decode decodes the haves sent by the client after the UploadRequest, up to
+// a flush-pkt or a done line.
+func (u *UploadHaves) Decode(r io.Reader) error {
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) {
+			return nil
+		}
+
+		if bytes.Equal(line, done) {
+			u.Done = true
+			return nil
+		}
+
+		if !bytes.HasPrefix(line, have) || len(line) != len(have)+hashSize {
+			return NewErrUnexpectedData("malformed have line", line)
+		}
+
+		var h plumbing.Hash
+		if _, err := hex.Decode(h[:], line[len(have):]); err != nil {
+			return NewErrUnexpectedData("malformed have line", line)
+		}
+
+		u.Haves = append(u.Haves, h)
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
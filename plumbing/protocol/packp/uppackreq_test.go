@@ -2,6 +2,7 @@ package packp
 
 import (
 	"bytes"
+	"io"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
@@ -41,6 +42,9 @@ func (s *UploadPackRequestSuite) TestIsEmpty(c *C) {
 	r.Haves = append(r.Haves, plumbing.NewHash("d82f291cde9987322c8a0c81a325e1ba6159684c"))
 
 	c.Assert(r.IsEmpty(), Equals, true)
+
+	r.Depth = DepthCommits(1)
+	c.Assert(r.IsEmpty(), Equals, false)
 }
 
 type UploadHavesSuite struct{}
@@ -67,3 +71,52 @@ func (s *UploadHavesSuite) TestEncode(c *C) {
 		"0000",
 	)
 }
+
+func (s *UploadHavesSuite) TestDecode(c *C) {
+	uh := &UploadHaves{}
+	err := uh.Decode(bytes.NewBufferString("" +
+		"0032have 1111111111111111111111111111111111111111\n" +
+		"0032have 2222222222222222222222222222222222222222\n" +
+		"0009done\n",
+	))
+	c.Assert(err, IsNil)
+	c.Assert(uh.Done, Equals, true)
+	c.Assert(uh.Haves, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("1111111111111111111111111111111111111111"),
+		plumbing.NewHash("2222222222222222222222222222222222222222"),
+	})
+}
+
+func (s *UploadHavesSuite) TestDecodeFlush(c *C) {
+	uh := &UploadHaves{}
+	err := uh.Decode(bytes.NewBufferString("" +
+		"0032have 1111111111111111111111111111111111111111\n" +
+		"0000",
+	))
+	c.Assert(err, IsNil)
+	c.Assert(uh.Done, Equals, false)
+	c.Assert(uh.Haves, HasLen, 1)
+}
+
+func (s *UploadHavesSuite) TestDecodeMalformed(c *C) {
+	uh := &UploadHaves{}
+	err := uh.Decode(bytes.NewBufferString("0032have 111111111111111111111111111111111111111x\n"))
+	c.Assert(err, NotNil)
+
+	err = uh.Decode(bytes.NewBufferString(""))
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+}
+
+func (s *UploadPackRequestSuite) TestDecode(c *C) {
+	r := NewUploadPackRequest()
+	err := r.Decode(bytes.NewBufferString("" +
+		"0032want d82f291cde9987322c8a0c81a325e1ba6159684c\n" +
+		"0000" +
+		"0032have 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n" +
+		"0009done\n",
+	))
+	c.Assert(err, IsNil)
+	c.Assert(r.Wants, DeepEquals, []plumbing.Hash{plumbing.NewHash("d82f291cde9987322c8a0c81a325e1ba6159684c")})
+	c.Assert(r.Haves, DeepEquals, []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")})
+	c.Assert(r.Done, Equals, true)
+}
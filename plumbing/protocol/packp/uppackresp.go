@@ -21,22 +21,24 @@ type UploadPackResponse struct {
 	ShallowUpdate
 	ServerResponse
 
-	r          io.ReadCloser
-	isShallow  bool
-	isMultiACK bool
-	isOk       bool
+	r             io.ReadCloser
+	isShallow     bool
+	isMultiACK    bool
+	isSideband64k bool
+	isOk          bool
 }
 
 // NewUploadPackResponse create a new UploadPackResponse instance, the request
 // being responded by the response is required.
 func NewUploadPackResponse(req *UploadPackRequest) *UploadPackResponse {
-	isShallow := !req.Depth.IsZero()
+	isShallow := req.isShallow()
 	isMultiACK := req.Capabilities.Supports(capability.MultiACK) ||
 		req.Capabilities.Supports(capability.MultiACKDetailed)
 
 	return &UploadPackResponse{
-		isShallow:  isShallow,
-		isMultiACK: isMultiACK,
+		isShallow:     isShallow,
+		isMultiACK:    isMultiACK,
+		isSideband64k: req.Capabilities.Supports(capability.Sideband64k),
 	}
 }
 
@@ -53,9 +55,15 @@ func NewUploadPackResponseWithPackfile(req *UploadPackRequest,
 // Decode decodes all the responses sent by upload-pack service into the struct
 // and prepares it to read the packfile using the Read method
 func (r *UploadPackResponse) Decode(reader io.ReadCloser) error {
+	return r.decode(reader, r.isShallow)
+}
+
+// decode decodes the response, starting with a shallow update if
+// expectsShallow is true.
+func (r *UploadPackResponse) decode(reader io.ReadCloser, expectsShallow bool) error {
 	buf := bufio.NewReader(reader)
 
-	if r.isShallow {
+	if expectsShallow {
 		if err := r.ShallowUpdate.Decode(buf); err != nil {
 			return err
 		}
@@ -28,6 +28,9 @@ var RefRevParseRules = []string{
 
 var (
 	ErrReferenceNotFound = errors.New("reference not found")
+	// ErrInvalidReferenceName is returned when a reference name doesn't
+	// follow the rules of git check-ref-format.
+	ErrInvalidReferenceName = errors.New("invalid reference name")
 )
 
 // ReferenceType reference type's
@@ -93,6 +96,32 @@ func (r ReferenceName) Short() string {
 	return res
 }
 
+// Validate returns ErrInvalidReferenceName if the name doesn't follow the
+// rules of git check-ref-format: the components can't be empty, begin with a
+// dot or end with ".lock", and the name can't contain "..", "@{", control
+// characters, spaces or any of ~^:?*[\, nor end with a dot or be "@".
+func (r ReferenceName) Validate() error {
+	s := string(r)
+	if s == "" || s == "@" || strings.HasSuffix(s, ".") ||
+		strings.Contains(s, "..") || strings.Contains(s, "@{") {
+		return ErrInvalidReferenceName
+	}
+
+	for _, c := range s {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return ErrInvalidReferenceName
+		}
+	}
+
+	for _, part := range strings.Split(s, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return ErrInvalidReferenceName
+		}
+	}
+
+	return nil
+}
+
 const (
 	HEAD   ReferenceName = "HEAD"
 	Master ReferenceName = "refs/heads/master"
@@ -73,3 +73,39 @@ func (s *ReferenceSuite) TestIsTag(c *C) {
 	r := ReferenceName("refs/tags/v3.1.")
 	c.Assert(r.IsTag(), Equals, true)
 }
+
+func (s *ReferenceSuite) TestValidate(c *C) {
+	for _, name := range []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/tags/v1.0.0",
+		"refs/heads/feature/foo-bar_baz",
+		"refs/tags/@",
+	} {
+		c.Assert(ReferenceName(name).Validate(), IsNil, Commentf(name))
+	}
+
+	for _, name := range []string{
+		"",
+		"@",
+		"refs/tags/v1.",
+		"refs/tags/v1..0",
+		"refs/tags/.hidden",
+		"refs/tags/foo.lock",
+		"refs/tags//foo",
+		"refs/tags/foo/",
+		"/refs/tags/foo",
+		"refs/tags/foo bar",
+		"refs/tags/foo~1",
+		"refs/tags/foo^",
+		"refs/tags/foo:bar",
+		"refs/tags/foo?",
+		"refs/tags/foo*",
+		"refs/tags/foo[",
+		"refs/tags/foo\\bar",
+		"refs/tags/foo@{1}",
+		"refs/tags/foo\x01",
+	} {
+		c.Assert(ReferenceName(name).Validate(), Equals, ErrInvalidReferenceName, Commentf(name))
+	}
+}
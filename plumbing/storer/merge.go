@@ -0,0 +1,16 @@
+package storer
+
+// MergeStorer is a storage of the message of an in-progress merge, stopped to
+// resolve conflicts. It is an optional interface, the merge commit concluding
+// the merge is created with the given message on the storages not
+// implementing it.
+type MergeStorer interface {
+	// MergeMessage returns the message of the in-progress merge, or an empty
+	// string if there is no merge in progress.
+	MergeMessage() (string, error)
+	// SetMergeMessage stores the message of the in-progress merge.
+	SetMergeMessage(string) error
+	// RemoveMergeMessage deletes the message of the in-progress merge, if
+	// any.
+	RemoveMergeMessage() error
+}
@@ -97,6 +97,34 @@ type PackfileWriter interface {
 	PackfileWriter(plumbing.StatusChan) (io.WriteCloser, error)
 }
 
+// PromisorPackfileWriter is a optional method for ObjectStorer, it enables
+// direct write of the packfiles fetched from a promisor remote, the ones of a
+// partial clone, keeping track of them as promisor packfiles.
+type PromisorPackfileWriter interface {
+	// PromisorPackfileWriter returns a writer for writing a packfile
+	// fetched from a promisor remote to the storage.
+	PromisorPackfileWriter(plumbing.StatusChan) (io.WriteCloser, error)
+}
+
+// Quarantiner is a optional method for ObjectStorer, it enables staging new
+// objects in a quarantine, apart from the ones of the storage until they are
+// migrated to it.
+type Quarantiner interface {
+	// Quarantine returns a new empty quarantine of the storage.
+	Quarantine() (Quarantine, error)
+}
+
+// Quarantine is an in-progress quarantine of new objects, the objects of its
+// storage are read through it as the ones of an alternate, but only the new
+// ones are iterated. A quarantine must end with a call to Migrate or Discard.
+type Quarantine interface {
+	EncodedObjectStorer
+	// Migrate moves the objects of the quarantine to the storage.
+	Migrate() error
+	// Discard removes the quarantine along with its objects.
+	Discard() error
+}
+
 // EncodedObjectIter is a generic closable interface for iterating over objects.
 type EncodedObjectIter interface {
 	Next() (plumbing.EncodedObject, error)
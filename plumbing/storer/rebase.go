@@ -0,0 +1,17 @@
+package storer
+
+import "gopkg.in/src-d/go-git.v4/plumbing/format/rebase"
+
+// RebaseStorer is a storage of the state of an in-progress rebase. It is an
+// optional interface, a rebase can't be stopped to resolve conflicts on the
+// storages not implementing it.
+type RebaseStorer interface {
+	// RebaseState returns the state of the in-progress rebase, or nil if
+	// there is no rebase in progress.
+	RebaseState() (*rebase.State, error)
+	// SetRebaseState stores the state of the in-progress rebase, replacing
+	// the previous one.
+	SetRebaseState(*rebase.State) error
+	// RemoveRebaseState deletes the state of the in-progress rebase, if any.
+	RemoveRebaseState() error
+}
@@ -0,0 +1,24 @@
+package storer
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+)
+
+// ReflogStorer is a storage of reflogs, the logs of the updates of the
+// references. It is an optional interface, the storages not implementing it
+// don't keep the history of the references.
+type ReflogStorer interface {
+	// Reflog returns the entries of the reflog of the given reference, the
+	// oldest first. If the reference has no reflog an empty slice is
+	// returned.
+	Reflog(plumbing.ReferenceName) ([]*reflog.Entry, error)
+	// AppendReflog adds an entry at the end of the reflog of the given
+	// reference, creating the reflog if needed.
+	AppendReflog(plumbing.ReferenceName, *reflog.Entry) error
+	// SetReflog replaces all the entries of the reflog of the given
+	// reference.
+	SetReflog(plumbing.ReferenceName, []*reflog.Entry) error
+	// RemoveReflog deletes the reflog of the given reference.
+	RemoveReflog(plumbing.ReferenceName) error
+}
@@ -26,6 +26,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 )
 
 var (
@@ -92,6 +93,54 @@ type ReceivePackSession interface {
 	ReceivePack(context.Context, *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error)
 }
 
+// RefPrefixSession is implemented by the sessions able to request only the
+// references starting with some prefixes, such as the git-upload-pack
+// sessions using the protocol version 2.
+type RefPrefixSession interface {
+	// AdvertisedReferencesWithPrefixes retrieves the advertised references
+	// starting with any of the given prefixes, all of them if empty. The
+	// server may return other references too.
+	AdvertisedReferencesWithPrefixes(prefixes []string) (*packp.AdvRefs, error)
+}
+
+// DumbSession is implemented by the git-upload-pack sessions able to talk to
+// the servers speaking the dumb protocol, such as the static HTTP servers,
+// which serve the files of the repositories instead of generating packfiles.
+type DumbSession interface {
+	// IsDumb returns true if the server speaks the dumb protocol, known once
+	// the references are advertised. UploadPack isn't supported then, the
+	// objects are fetched with FetchObjects.
+	IsDumb() bool
+	// FetchObjects stores the given objects missing in the storer, along
+	// with the missing objects they reference, downloading them from the
+	// server speaking the dumb protocol.
+	FetchObjects(ctx context.Context, wants []plumbing.Hash, s storer.Storer) error
+}
+
+// AdvertisedReferencesWithPrefixes retrieves the advertised references of the
+// session starting with any of the given prefixes, if the session supports
+// it, or all the advertised references otherwise.
+func AdvertisedReferencesWithPrefixes(s Session, prefixes []string) (*packp.AdvRefs, error) {
+	if ps, ok := s.(RefPrefixSession); ok {
+		return ps.AdvertisedReferencesWithPrefixes(prefixes)
+	}
+
+	return s.AdvertisedReferences()
+}
+
+// ProtocolVersion is a version of the git wire protocol.
+type ProtocolVersion int
+
+const (
+	// ProtocolV0 is the original version of the wire protocol.
+	ProtocolV0 ProtocolVersion = iota
+	// ProtocolV1 is the version 0 with the version announced by the server.
+	ProtocolV1
+	// ProtocolV2 is the command based version of the wire protocol, only
+	// defined for git-upload-pack.
+	ProtocolV2
+)
+
 // Endpoint represents a Git URL in any supported protocol.
 type Endpoint struct {
 	// Protocol is the protocol of the endpoint (e.g. git, https, file).
@@ -107,6 +156,9 @@ type Endpoint struct {
 	Port int
 	// Path is the repository path.
 	Path string
+	// ProtocolVersion is the version of the wire protocol requested to the
+	// server, which falls back to the version 0 if it doesn't support it.
+	ProtocolVersion ProtocolVersion
 }
 
 var defaultPorts = map[string]int{
@@ -157,6 +209,21 @@ func (u *Endpoint) String() string {
 	return buf.String()
 }
 
+// GitProtocol returns the value of the GIT_PROTOCOL environment variable, or
+// the Git-Protocol header in HTTP, requesting the protocol version of the
+// endpoint for the given service. It is empty for the version 0 and the
+// version 2 of git-receive-pack falls back to the version 0.
+func (u *Endpoint) GitProtocol(service string) string {
+	switch {
+	case u.ProtocolVersion == ProtocolV1:
+		return "version=1"
+	case u.ProtocolVersion == ProtocolV2 && service == UploadPackServiceName:
+		return "version=2"
+	}
+
+	return ""
+}
+
 func NewEndpoint(endpoint string) (*Endpoint, error) {
 	if e, ok := parseSCPLike(endpoint); ok {
 		return e, nil
@@ -258,11 +325,7 @@ func parseFile(endpoint string) (*Endpoint, bool) {
 
 // UnsupportedCapabilities are the capabilities not supported by any client
 // implementation
-var UnsupportedCapabilities = []capability.Capability{
-	capability.MultiACK,
-	capability.MultiACKDetailed,
-	capability.ThinPack,
-}
+var UnsupportedCapabilities = []capability.Capability{}
 
 // FilterUnsupportedCapabilities it filter out all the UnsupportedCapabilities
 // from a capability.List, the intended usage is on the client implementation
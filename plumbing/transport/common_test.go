@@ -172,7 +172,9 @@ func (s *SuiteCommon) TestNewEndpointInvalidURL(c *C) {
 func (s *SuiteCommon) TestFilterUnsupportedCapabilities(c *C) {
 	l := capability.NewList()
 	l.Set(capability.MultiACK)
+	l.Set(capability.ThinPack)
 
 	FilterUnsupportedCapabilities(l)
-	c.Assert(l.Supports(capability.MultiACK), Equals, false)
+	c.Assert(l.Supports(capability.MultiACK), Equals, true)
+	c.Assert(l.Supports(capability.ThinPack), Equals, true)
 }
@@ -76,6 +76,7 @@ func prefixExecPath(cmd string) (string, error) {
 func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.AuthMethod,
 ) (common.Command, error) {
 
+	service := cmd
 	switch cmd {
 	case transport.UploadPackServiceName:
 		cmd = r.UploadPackBin
@@ -95,7 +96,12 @@ func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.Auth
 		}
 	}
 
-	return &command{cmd: exec.Command(cmd, ep.Path)}, nil
+	c := exec.Command(cmd, ep.Path)
+	if v := ep.GitProtocol(service); v != "" {
+		c.Env = append(os.Environ(), "GIT_PROTOCOL="+v)
+	}
+
+	return &command{cmd: c}, nil
 }
 
 type command struct {
@@ -84,3 +84,17 @@ func (s *UploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
 	// canceled context when the packfile is being read.
 	c.Skip("UploadPack has a race condition when we Close the session")
 }
+
+type UploadPackV2Suite struct {
+	UploadPackSuite
+}
+
+var _ = Suite(&UploadPackV2Suite{})
+
+func (s *UploadPackV2Suite) SetUpSuite(c *C) {
+	s.UploadPackSuite.SetUpSuite(c)
+
+	s.Endpoint.ProtocolVersion = transport.ProtocolV2
+	s.EmptyEndpoint.ProtocolVersion = transport.ProtocolV2
+	s.NonExistentEndpoint.ProtocolVersion = transport.ProtocolV2
+}
@@ -95,6 +95,11 @@ func endpointToCommand(cmd string, ep *transport.Endpoint) string {
 		host = fmt.Sprintf("%s:%d", ep.Host, ep.Port)
 	}
 
+	if v := ep.GitProtocol(cmd); v != "" {
+		// the extra parameters, ignored by the servers not supporting them
+		return fmt.Sprintf("%s %s%chost=%s%c%c%s%c", cmd, ep.Path, 0, host, 0, 0, v, 0)
+	}
+
 	return fmt.Sprintf("%s %s%chost=%s%c", cmd, ep.Path, 0, host, 0)
 }
 
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/test"
 
 	. "gopkg.in/check.v1"
@@ -24,3 +25,17 @@ func (s *UploadPackSuite) SetUpSuite(c *C) {
 
 	s.StartDaemon(c)
 }
+
+type UploadPackV2Suite struct {
+	UploadPackSuite
+}
+
+var _ = Suite(&UploadPackV2Suite{})
+
+func (s *UploadPackV2Suite) SetUpSuite(c *C) {
+	s.UploadPackSuite.SetUpSuite(c)
+
+	s.Endpoint.ProtocolVersion = transport.ProtocolV2
+	s.EmptyEndpoint.ProtocolVersion = transport.ProtocolV2
+	s.NonExistentEndpoint.ProtocolVersion = transport.ProtocolV2
+}
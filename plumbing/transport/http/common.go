@@ -31,6 +31,10 @@ func applyHeadersToRequest(req *http.Request, content *bytes.Buffer, host string
 
 const infoRefsPath = "/info/refs"
 
+// advertisedReferences requests the references advertisement of the service.
+// If the server speaks the protocol version 2 it sends a capability
+// advertisement instead, stored in the session, and no AdvRefs is returned:
+// the references are requested with the ls-refs command.
 func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, err error) {
 	url := fmt.Sprintf(
 		"%s%s?service=%s",
@@ -44,6 +48,7 @@ func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, e
 
 	s.ApplyAuthToRequest(req)
 	applyHeadersToRequest(req, nil, s.endpoint.Host, serviceName)
+	s.applyGitProtocolToRequest(req, serviceName)
 	res, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -56,8 +61,17 @@ func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, e
 		return nil, err
 	}
 
-	ar := packp.NewAdvRefs()
-	if err = ar.Decode(res.Body); err != nil {
+	if !isSmartResponse(res, serviceName) {
+		if serviceName == transport.ReceivePackServiceName {
+			return nil, ErrDumbPushNotSupported
+		}
+
+		s.dumb = true
+		return s.dumbAdvertisedReferences(res.Body)
+	}
+
+	ar, capAdv, err := packp.DecodeAdvertisement(res.Body)
+	if err != nil {
 		if err == packp.ErrEmptyAdvRefs {
 			err = transport.ErrEmptyRemoteRepository
 		}
@@ -65,12 +79,26 @@ func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, e
 		return nil, err
 	}
 
+	if capAdv != nil {
+		s.capAdv = capAdv
+		return nil, nil
+	}
+
 	transport.FilterUnsupportedCapabilities(ar.Capabilities)
 	s.advRefs = ar
 
 	return ar, nil
 }
 
+// isSmartResponse returns true if the response to the references request of
+// the service has the content type of the smart protocol, the servers
+// speaking the dumb protocol serve the info/refs file instead.
+func isSmartResponse(res *http.Response, serviceName string) bool {
+	contentType := strings.Split(res.Header.Get("Content-Type"), ";")[0]
+	return strings.TrimSpace(contentType) ==
+		fmt.Sprintf("application/x-%s-advertisement", serviceName)
+}
+
 type client struct {
 	c *http.Client
 }
@@ -113,6 +141,9 @@ type session struct {
 	client   *http.Client
 	endpoint *transport.Endpoint
 	advRefs  *packp.AdvRefs
+	capAdv   *packp.CapabilityAdvertisement
+	// dumb is true if the server speaks the dumb protocol.
+	dumb bool
 }
 
 func newSession(c *http.Client, ep *transport.Endpoint, auth transport.AuthMethod) (*session, error) {
@@ -141,6 +172,14 @@ func (s *session) ApplyAuthToRequest(req *http.Request) {
 	s.auth.setAuth(req)
 }
 
+// applyGitProtocolToRequest requests the protocol version of the endpoint
+// with the Git-Protocol header.
+func (s *session) applyGitProtocolToRequest(req *http.Request, serviceName string) {
+	if v := s.endpoint.GitProtocol(serviceName); v != "" {
+		req.Header.Set("Git-Protocol", v)
+	}
+}
+
 func (s *session) ModifyEndpointIfRedirect(res *http.Response) {
 	if res.Request == nil {
 		return
@@ -0,0 +1,446 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/objfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+var (
+	// ErrDumbPushNotSupported is returned when pushing to a server speaking
+	// the dumb protocol.
+	ErrDumbPushNotSupported = errors.New("dumb http protocol does not support push")
+	// ErrDumbUploadPack is returned by UploadPack when the server speaks the
+	// dumb protocol, see transport.DumbSession.
+	ErrDumbUploadPack = errors.New("dumb http protocol does not support upload-pack")
+
+	errFileNotFound = errors.New("file not found")
+)
+
+const (
+	headPath      = "HEAD"
+	infoPacksPath = "objects/info/packs"
+	peeledSuffix  = "^{}"
+)
+
+// dumbAdvertisedReferences decodes the info/refs file served by the servers
+// speaking the dumb protocol, along with their HEAD file. No capabilities are
+// advertised.
+func (s *session) dumbAdvertisedReferences(r io.Reader) (*packp.AdvRefs, error) {
+	ar := packp.NewAdvRefs()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 || !isHash(fields[0]) {
+			return nil, fmt.Errorf("malformed info/refs line: %q", line)
+		}
+
+		h := plumbing.NewHash(fields[0])
+		if strings.HasSuffix(fields[1], peeledSuffix) {
+			ar.Peeled[strings.TrimSuffix(fields[1], peeledSuffix)] = h
+		} else {
+			ar.References[fields[1]] = h
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ar.References) == 0 {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	if err := s.setDumbHEAD(ar); err != nil {
+		return nil, err
+	}
+
+	s.advRefs = ar
+	return ar, nil
+}
+
+// setDumbHEAD adds the HEAD of the repository to the advertised references,
+// as a symbolic reference if it isn't detached.
+func (s *session) setDumbHEAD(ar *packp.AdvRefs) (err error) {
+	res, err := s.get(context.Background(), headPath)
+	if err == errFileNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	content, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	head := plumbing.NewReferenceFromStrings(plumbing.HEAD.String(), strings.TrimSpace(content))
+	if head.Type() == plumbing.SymbolicReference {
+		if err := ar.AddReference(head); err != nil {
+			return err
+		}
+
+		h, ok := ar.References[head.Target().String()]
+		if !ok {
+			return nil
+		}
+
+		ar.Head = &h
+		return nil
+	}
+
+	h := head.Hash()
+	if !h.IsZero() {
+		ar.Head = &h
+	}
+
+	return nil
+}
+
+// get requests the given file of the repository, returning errFileNotFound if
+// it doesn't exist.
+func (s *session) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", s.endpoint.String(), path), nil)
+	if err != nil {
+		return nil, plumbing.NewPermanentError(err)
+	}
+
+	applyHeadersToRequest(req, nil, s.endpoint.Host, "")
+	s.ApplyAuthToRequest(req)
+
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, plumbing.NewUnexpectedError(err)
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		return nil, errFileNotFound
+	}
+
+	if err := NewErr(res); err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// IsDumb returns true if the server speaks the dumb protocol, once the
+// references are advertised.
+func (s *upSession) IsDumb() bool {
+	return s.dumb
+}
+
+// FetchObjects stores the given objects missing in the storer, along with the
+// missing objects they reference, downloading them from the server speaking
+// the dumb protocol, as loose objects or within the packs containing them.
+func (s *upSession) FetchObjects(ctx context.Context, wants []plumbing.Hash, sto storer.Storer) error {
+	f := &dumbFetcher{ctx: ctx, s: s.session, storer: sto}
+	return f.Fetch(wants)
+}
+
+// dumbFetcher walks the objects from the wanted ones through the ones they
+// reference, the way git does, stopping at the ones already in the storer,
+// which are assumed to be complete.
+type dumbFetcher struct {
+	ctx    context.Context
+	s      *session
+	storer storer.Storer
+	// packs are the packs of the server not downloaded yet, nil until the
+	// list is requested.
+	packs []plumbing.Hash
+	// indexes are the downloaded idx files of the packs.
+	indexes map[plumbing.Hash]*idxfile.MemoryIndex
+	// fetched are the indexes of the packs downloaded, their objects are
+	// walked even if they are in the storer.
+	fetched []*idxfile.MemoryIndex
+}
+
+// Fetch stores the given objects missing in the storer, along with the
+// missing objects they reference.
+func (f *dumbFetcher) Fetch(wants []plumbing.Hash) error {
+	pending := append([]plumbing.Hash(nil), wants...)
+	seen := make(map[plumbing.Hash]bool)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		walk, err := f.fetch(h)
+		if err != nil {
+			return err
+		}
+
+		if !walk {
+			continue
+		}
+
+		refs, err := f.references(h)
+		if err != nil {
+			return err
+		}
+
+		pending = append(pending, refs...)
+	}
+
+	return nil
+}
+
+// fetch downloads the given object if it's missing in the storer, it returns
+// true if its references must be walked: the object wasn't in the storer
+// before the fetch.
+func (f *dumbFetcher) fetch(h plumbing.Hash) (bool, error) {
+	for _, idx := range f.fetched {
+		ok, err := idx.Contains(h)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	err := f.storer.HasEncodedObject(h)
+	if err == nil {
+		return false, nil
+	}
+
+	if err != plumbing.ErrObjectNotFound {
+		return false, err
+	}
+
+	ok, err := f.fetchLoose(h)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	ok, err = f.fetchPacked(h)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, plumbing.ErrObjectNotFound
+	}
+
+	return true, nil
+}
+
+// fetchLoose downloads the given loose object, returning false if the server
+// doesn't have it as a loose object.
+func (f *dumbFetcher) fetchLoose(h plumbing.Hash) (ok bool, err error) {
+	name := h.String()
+	res, err := f.s.get(f.ctx, fmt.Sprintf("objects/%s/%s", name[:2], name[2:]))
+	if err == errFileNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	r, err := objfile.NewReader(res.Body)
+	if err != nil {
+		return false, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	t, size, err := r.Header()
+	if err != nil {
+		return false, err
+	}
+
+	o := f.storer.NewEncodedObject()
+	o.SetType(t)
+	o.SetSize(size)
+	w, err := o.Writer()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return false, err
+	}
+
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+
+	if o.Hash() != h {
+		return false, fmt.Errorf("object %s has a wrong hash: %s", h, o.Hash())
+	}
+
+	_, err = f.storer.SetEncodedObject(o)
+	return err == nil, err
+}
+
+// fetchPacked downloads the pack of the server containing the given object,
+// returning false if there is none.
+func (f *dumbFetcher) fetchPacked(h plumbing.Hash) (ok bool, err error) {
+	if f.packs == nil {
+		if err := f.listPacks(); err != nil {
+			return false, err
+		}
+	}
+
+	for i, pack := range f.packs {
+		idx, err := f.index(pack)
+		if err != nil {
+			return false, err
+		}
+
+		ok, err := idx.Contains(h)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := f.fetchPack(pack); err != nil {
+			return false, err
+		}
+
+		f.packs = append(f.packs[:i], f.packs[i+1:]...)
+		f.fetched = append(f.fetched, idx)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// listPacks reads the objects/info/packs file of the server.
+func (f *dumbFetcher) listPacks() (err error) {
+	f.packs = []plumbing.Hash{}
+	f.indexes = make(map[plumbing.Hash]*idxfile.MemoryIndex)
+
+	res, err := f.s.get(f.ctx, infoPacksPath)
+	if err == errFileNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "P pack-") || !strings.HasSuffix(line, ".pack") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(line, "P pack-"), ".pack")
+		if !isHash(name) {
+			return fmt.Errorf("malformed %s line: %q", infoPacksPath, line)
+		}
+
+		f.packs = append(f.packs, plumbing.NewHash(name))
+	}
+
+	return scanner.Err()
+}
+
+// index returns the idx file of the given pack, downloading it the first time.
+func (f *dumbFetcher) index(pack plumbing.Hash) (idx *idxfile.MemoryIndex, err error) {
+	if idx, ok := f.indexes[pack]; ok {
+		return idx, nil
+	}
+
+	res, err := f.s.get(f.ctx, fmt.Sprintf("objects/pack/pack-%s.idx", pack))
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	idx = idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(res.Body).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	f.indexes[pack] = idx
+	return idx, nil
+}
+
+// fetchPack downloads the given pack into the storer.
+func (f *dumbFetcher) fetchPack(pack plumbing.Hash) (err error) {
+	res, err := f.s.get(f.ctx, fmt.Sprintf("objects/pack/pack-%s.pack", pack))
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	return packfile.UpdateObjectStorage(f.storer, res.Body, nil)
+}
+
+// references returns the objects referenced by the given one: the tree and
+// the parents of the commits, the entries of the trees, but the submodules,
+// and the targets of the tags.
+func (f *dumbFetcher) references(h plumbing.Hash) ([]plumbing.Hash, error) {
+	o, err := object.GetObject(f.storer, h)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := o.(type) {
+	case *object.Commit:
+		return append([]plumbing.Hash{o.TreeHash}, o.ParentHashes...), nil
+	case *object.Tree:
+		var refs []plumbing.Hash
+		for _, e := range o.Entries {
+			if e.Mode != filemode.Submodule {
+				refs = append(refs, e.Hash)
+			}
+		}
+
+		return refs, nil
+	case *object.Tag:
+		return []plumbing.Hash{o.Target}, nil
+	}
+
+	return nil, nil
+}
+
+func isHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
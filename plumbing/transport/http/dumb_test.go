@@ -0,0 +1,310 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type DumbSuite struct {
+	dir      string
+	storage  *filesystem.Storage
+	entries  []object.TreeEntry
+	commits  []plumbing.Hash
+	tag      plumbing.Hash
+	server   *httptest.Server
+	endpoint *transport.Endpoint
+}
+
+var _ = Suite(&DumbSuite{})
+
+// SetUpTest serves a repository as static files, with a pack of two commits
+// and the objects of a third one, tagged, loose.
+func (s *DumbSuite) SetUpTest(c *C) {
+	s.init(c)
+
+	packed := memory.NewStorage()
+	s.commit(c, packed, "foo")
+	s.commit(c, packed, "bar")
+	s.pack(c, packed)
+	s.commit(c, s.storage, "qux")
+
+	master := s.commits[len(s.commits)-1]
+	s.tag = s.encode(c, s.storage, &object.Tag{
+		Name:       "v1",
+		Tagger:     signature,
+		Message:    "v1\n",
+		TargetType: plumbing.CommitObject,
+		Target:     master,
+	})
+
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference(plumbing.Master, master)), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference("refs/tags/v1", s.tag)), IsNil)
+	s.updateServerInfo(c)
+
+	s.server = httptest.NewServer(http.StripPrefix("/repo.git",
+		http.FileServer(http.Dir(s.dir))))
+
+	var err error
+	s.endpoint, err = transport.NewEndpoint(s.server.URL + "/repo.git")
+	c.Assert(err, IsNil)
+}
+
+func (s *DumbSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+var signature = object.Signature{
+	Name:  "foo",
+	Email: "foo@foo.foo",
+	When:  time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// init creates an empty bare repository, with its HEAD pointing to master.
+func (s *DumbSuite) init(c *C) {
+	s.dir = c.MkDir()
+	s.entries = nil
+	s.commits = nil
+
+	var err error
+	s.storage, err = filesystem.NewStorage(osfs.New(s.dir))
+	c.Assert(err, IsNil)
+	c.Assert(s.storage.Init(), IsNil)
+	c.Assert(s.storage.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master)), IsNil)
+}
+
+// encode writes the given object to the storage, returning its hash.
+func (s *DumbSuite) encode(c *C, sto storer.EncodedObjectStorer, o object.Object) plumbing.Hash {
+	obj := sto.NewEncodedObject()
+	c.Assert(o.Encode(obj), IsNil)
+	h, err := sto.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	return h
+}
+
+// commit writes a commit on top of the last one adding the file name, with
+// its name as content, to the given storage.
+func (s *DumbSuite) commit(c *C, sto storer.EncodedObjectStorer, name string) {
+	blob := sto.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(name))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	h, err := sto.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	s.entries = append(s.entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: h})
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].Name < s.entries[j].Name })
+	tree := s.encode(c, sto, &object.Tree{Entries: append([]object.TreeEntry(nil), s.entries...)})
+
+	var parents []plumbing.Hash
+	if len(s.commits) != 0 {
+		parents = []plumbing.Hash{s.commits[len(s.commits)-1]}
+	}
+
+	s.commits = append(s.commits, s.encode(c, sto, &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      name + "\n",
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}))
+}
+
+// pack writes all the objects of the given storage to a pack of the
+// repository.
+func (s *DumbSuite) pack(c *C, sto *memory.Storage) {
+	var hashes []plumbing.Hash
+	for h := range sto.Objects {
+		hashes = append(hashes, h)
+	}
+
+	w, err := s.storage.PackfileWriter(nil)
+	c.Assert(err, IsNil)
+	_, err = packfile.NewEncoder(w, sto, false).Encode(hashes, 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+}
+
+// updateServerInfo writes the info/refs and objects/info/packs files of the
+// repository, as git update-server-info does.
+func (s *DumbSuite) updateServerInfo(c *C) {
+	refs := bytes.NewBuffer(nil)
+	iter, err := s.storage.IterReferences()
+	c.Assert(err, IsNil)
+	c.Assert(iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		fmt.Fprintf(refs, "%s\t%s\n", ref.Hash(), ref.Name())
+		tag, err := object.GetTag(s.storage, ref.Hash())
+		if err == nil {
+			fmt.Fprintf(refs, "%s\t%s^{}\n", tag.Target, ref.Name())
+		}
+
+		return nil
+	}), IsNil)
+
+	s.writeFile(c, filepath.Join("info", "refs"), refs.Bytes())
+
+	packs := bytes.NewBuffer(nil)
+	hashes, err := s.storage.ObjectPacks()
+	c.Assert(err, IsNil)
+	for _, h := range hashes {
+		fmt.Fprintf(packs, "P pack-%s.pack\n", h)
+	}
+
+	packs.WriteString("\n")
+	s.writeFile(c, filepath.Join("objects", "info", "packs"), packs.Bytes())
+}
+
+// writeFile writes the file at the given path of the repository, creating
+// its directory if needed.
+func (s *DumbSuite) writeFile(c *C, name string, content []byte) {
+	path := filepath.Join(s.dir, name)
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, content, 0644), IsNil)
+}
+
+func (s *DumbSuite) newSession(c *C) *upSession {
+	r, err := DefaultClient.NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	return r.(*upSession)
+}
+
+func (s *DumbSuite) TestAdvertisedReferences(c *C) {
+	r := s.newSession(c)
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(r.IsDumb(), Equals, true)
+
+	master := s.commits[2]
+	c.Assert(ar.References, DeepEquals, map[string]plumbing.Hash{
+		"refs/heads/master": master,
+		"refs/tags/v1":      s.tag,
+	})
+	c.Assert(ar.Peeled, DeepEquals, map[string]plumbing.Hash{"refs/tags/v1": master})
+	c.Assert(*ar.Head, Equals, master)
+	c.Assert(ar.Capabilities.Get(capability.SymRef), DeepEquals, []string{"HEAD:refs/heads/master"})
+}
+
+func (s *DumbSuite) TestAdvertisedReferencesEmpty(c *C) {
+	s.init(c)
+	s.updateServerInfo(c)
+	s.server.Config.Handler = http.StripPrefix("/repo.git",
+		http.FileServer(http.Dir(s.dir)))
+
+	_, err := s.newSession(c).AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrEmptyRemoteRepository)
+}
+
+func (s *DumbSuite) TestFetchObjects(c *C) {
+	r := s.newSession(c)
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	sto := memory.NewStorage()
+	c.Assert(r.FetchObjects(context.Background(), []plumbing.Hash{s.tag}, sto), IsNil)
+
+	s.assertObjects(c, sto, s.tag)
+}
+
+func (s *DumbSuite) TestFetchObjectsIncremental(c *C) {
+	r := s.newSession(c)
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	sto := memory.NewStorage()
+	c.Assert(r.FetchObjects(context.Background(), []plumbing.Hash{s.commits[1]}, sto), IsNil)
+	s.assertObjects(c, sto, s.commits[1])
+	c.Assert(sto.HasEncodedObject(s.commits[2]), Equals, plumbing.ErrObjectNotFound)
+
+	c.Assert(r.FetchObjects(context.Background(), []plumbing.Hash{s.commits[2]}, sto), IsNil)
+	s.assertObjects(c, sto, s.commits[2])
+}
+
+func (s *DumbSuite) TestFetchObjectsNotFound(c *C) {
+	r := s.newSession(c)
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	err = r.FetchObjects(context.Background(),
+		[]plumbing.Hash{plumbing.NewHash("1111111111111111111111111111111111111111")},
+		memory.NewStorage())
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+// assertObjects asserts the storage has all the objects reachable from the
+// given object.
+func (s *DumbSuite) assertObjects(c *C, sto *memory.Storage, h plumbing.Hash) {
+	objects, err := revlist.Objects(s.storage, []plumbing.Hash{h}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(len(objects) > 0, Equals, true)
+	for _, h := range objects {
+		c.Assert(sto.HasEncodedObject(h), IsNil, Commentf("%s", h))
+	}
+}
+
+func (s *DumbSuite) TestUploadPack(c *C) {
+	r := s.newSession(c)
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{s.commits[2]}
+	_, err = r.UploadPack(context.Background(), req)
+	c.Assert(err, Equals, ErrDumbUploadPack)
+}
+
+func (s *DumbSuite) TestReceivePack(c *C) {
+	r, err := DefaultClient.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, ErrDumbPushNotSupported)
+}
+
+func (s *DumbSuite) TestAdvertisedReferencesSmart(c *C) {
+	s.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement; charset=utf-8")
+		e := pktline.NewEncoder(w)
+		e.EncodeString("# service=git-upload-pack\n")
+		e.Flush()
+		e.EncodeString("6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\x00agent=foo\n")
+		e.Flush()
+	})
+
+	r := s.newSession(c)
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(r.IsDumb(), Equals, false)
+	c.Assert(ar.References["refs/heads/master"], Equals,
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+}
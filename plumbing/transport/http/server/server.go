@@ -0,0 +1,439 @@
+// Package server implements a git server speaking the smart HTTP protocol, on
+// top of the transport-independent implementation of the server package of
+// the transport.
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// DefaultRealm is the default Options.Realm.
+const DefaultRealm = "git"
+
+const (
+	infoRefsPath = "/info/refs"
+	serviceParam = "service"
+)
+
+var (
+	// ErrDumbNotSupported is responded to the requests of the dumb protocol,
+	// only the smart one is served.
+	ErrDumbNotSupported = errors.New("dumb http protocol not supported")
+	// ErrUnsupportedContentType is responded to the requests of a service
+	// without its content type.
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	// ErrUnsupportedContentEncoding is responded to the requests compressed
+	// with anything but gzip.
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+)
+
+// AuthFunc authenticates a request to the given service of the repository of
+// the endpoint, transport.UploadPackServiceName or
+// transport.ReceivePackServiceName. It returns
+// transport.ErrAuthenticationRequired to request the credentials of the user,
+// and transport.ErrAuthorizationFailed to deny the request.
+type AuthFunc func(r *http.Request, service string, ep *transport.Endpoint) error
+
+// Options configures the handlers returned by NewHandler.
+type Options struct {
+	// Auth authenticates the requests. If nil, the upload-pack requests are
+	// allowed and the receive-pack ones are denied, as git-http-backend does
+	// for the anonymous users.
+	Auth AuthFunc
+	// Realm is the realm of the basic authentication requested when Auth
+	// returns transport.ErrAuthenticationRequired, DefaultRealm if empty.
+	Realm string
+	// Server configures the upload-pack and receive-pack sessions.
+	Server server.Options
+}
+
+// Validate validates the fields and sets the default values.
+func (o *Options) Validate() error {
+	if o.Realm == "" {
+		o.Realm = DefaultRealm
+	}
+
+	return o.Server.Validate()
+}
+
+type handler struct {
+	server transport.Transport
+	opts   Options
+}
+
+// NewHandler returns an http.Handler serving the repositories of the loader
+// with the smart HTTP protocol. The repositories are loaded with the endpoint
+// of the requests, without the path of the service, e.g. a request to
+// http://example.com/foo.git/info/refs loads http://example.com/foo.git.
+func NewHandler(loader server.Loader, o *Options) (http.Handler, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	srv, err := server.NewServerWithOptions(loader, &o.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler{server: srv, opts: *o}, nil
+}
+
+// ServeHTTP serves the references advertisements of the services, along with
+// their requests.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w}
+	if err := h.serve(rw, r); err != nil && !rw.written {
+		h.error(rw, err)
+	}
+}
+
+func (h *handler) serve(w http.ResponseWriter, r *http.Request) error {
+	p := path.Clean("/" + r.URL.Path)
+	switch {
+	case strings.HasSuffix(p, infoRefsPath):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return errMethodNotAllowed
+		}
+
+		return h.infoRefs(w, r, strings.TrimSuffix(p, infoRefsPath))
+	case strings.HasSuffix(p, "/"+transport.UploadPackServiceName):
+		if r.Method != http.MethodPost {
+			return errMethodNotAllowed
+		}
+
+		return h.uploadPack(w, r, strings.TrimSuffix(p, "/"+transport.UploadPackServiceName))
+	case strings.HasSuffix(p, "/"+transport.ReceivePackServiceName):
+		if r.Method != http.MethodPost {
+			return errMethodNotAllowed
+		}
+
+		return h.receivePack(w, r, strings.TrimSuffix(p, "/"+transport.ReceivePackServiceName))
+	}
+
+	return transport.ErrRepositoryNotFound
+}
+
+// infoRefs serves the references advertisement of the requested service.
+func (h *handler) infoRefs(w http.ResponseWriter, r *http.Request, repo string) (err error) {
+	service := r.URL.Query().Get(serviceParam)
+	if service != transport.UploadPackServiceName && service != transport.ReceivePackServiceName {
+		return ErrDumbNotSupported
+	}
+
+	ep, err := h.authenticate(r, service, repo)
+	if err != nil {
+		return err
+	}
+
+	var s transport.Session
+	if service == transport.UploadPackServiceName {
+		s, err = h.server.NewUploadPackSession(ep, nil)
+	} else {
+		s, err = h.server.NewReceivePackSession(ep, nil)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	ar, err := s.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+
+	ar.Prefix = [][]byte{[]byte(fmt.Sprintf("# service=%s", service)), pktline.Flush}
+
+	setHeaders(w, fmt.Sprintf("application/x-%s-advertisement", service))
+	return ar.Encode(w)
+}
+
+// uploadPack serves an upload-pack request. The requests without a done line
+// are rounds of the negotiation of the haves, only the response to the round
+// is sent unless the server is ready with the no-done capability. The shallow
+// update of the depth requested starts every response, as the clients expect
+// it in every request of the stateless negotiation.
+func (h *handler) uploadPack(w http.ResponseWriter, r *http.Request, repo string) (err error) {
+	ep, err := h.authenticate(r, transport.UploadPackServiceName, repo)
+	if err != nil {
+		return err
+	}
+
+	body, err := requestBody(r, transport.UploadPackServiceName)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(body, &err)
+
+	br := bufio.NewReader(body)
+	req := packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(br); err != nil {
+		return badRequest(err)
+	}
+
+	// the clients requesting a depth send the wants alone first, to receive
+	// the shallow update before negotiating
+	_, err = br.Peek(1)
+	wantsOnly := err == io.EOF
+	if !wantsOnly {
+		if err := req.UploadHaves.Decode(br); err != nil {
+			return badRequest(err)
+		}
+	}
+
+	sess, err := h.server.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(sess, &err)
+
+	// the sessions of server.NewServerWithOptions negotiate in rounds
+	s := sess.(server.UploadPackSession)
+	su, err := s.ReceiveWants(&req.UploadRequest)
+	if err != nil {
+		return err
+	}
+
+	if wantsOnly {
+		setHeaders(w, fmt.Sprintf("application/x-%s-result", transport.UploadPackServiceName))
+		return writeUploadPackResult(w, su, nil, nil)
+	}
+
+	var rr *packp.RoundResponse
+	if !req.Done {
+		rr, err = s.Negotiate(req.Haves)
+		if err != nil {
+			return err
+		}
+
+		if !rr.Ready || !req.Capabilities.Supports(capability.NoDone) {
+			setHeaders(w, fmt.Sprintf("application/x-%s-result", transport.UploadPackServiceName))
+			return writeUploadPackResult(w, su, rr, nil)
+		}
+	}
+
+	resp, err := s.UploadPack(r.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(resp, &err)
+
+	setHeaders(w, fmt.Sprintf("application/x-%s-result", transport.UploadPackServiceName))
+	return writeUploadPackResult(&flushWriter{w}, su, rr, resp)
+}
+
+// writeUploadPackResult writes the shallow update and the response to the
+// negotiation round, if any, followed by the response with the packfile, if
+// any.
+func writeUploadPackResult(w io.Writer, su *packp.ShallowUpdate, rr *packp.RoundResponse, resp *packp.UploadPackResponse) error {
+	if su != nil {
+		if err := su.Encode(w); err != nil {
+			return err
+		}
+	}
+
+	if rr != nil {
+		if err := rr.Encode(w); err != nil {
+			return err
+		}
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	if err := resp.ServerResponse.Encode(w); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(w, resp)
+	return err
+}
+
+// receivePack serves a receive-pack request, the status of the reference
+// updates is reported along with the response if requested.
+func (h *handler) receivePack(w http.ResponseWriter, r *http.Request, repo string) (err error) {
+	ep, err := h.authenticate(r, transport.ReceivePackServiceName, repo)
+	if err != nil {
+		return err
+	}
+
+	body, err := requestBody(r, transport.ReceivePackServiceName)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(body, &err)
+
+	s, err := h.server.NewReceivePackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(body); err != nil {
+		return badRequest(err)
+	}
+
+	rs, err := s.ReceivePack(r.Context(), req)
+	if rs == nil {
+		return err
+	}
+
+	setHeaders(w, fmt.Sprintf("application/x-%s-result", transport.ReceivePackServiceName))
+	return rs.Encode(&flushWriter{w})
+}
+
+// authenticate returns the endpoint of the given repository of the request,
+// once the request to the service is authenticated.
+func (h *handler) authenticate(r *http.Request, service, repo string) (*transport.Endpoint, error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	ep, err := transport.NewEndpoint(fmt.Sprintf("%s://%s%s", scheme, r.Host, repo))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	if h.opts.Auth != nil {
+		return ep, h.opts.Auth(r, service, ep)
+	}
+
+	if service == transport.ReceivePackServiceName {
+		return nil, transport.ErrAuthorizationFailed
+	}
+
+	return ep, nil
+}
+
+// error responds the given error with its status code.
+func (h *handler) error(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch e := err.(type) {
+	case *statusError:
+		code = e.code
+	default:
+		switch err {
+		case transport.ErrRepositoryNotFound:
+			code = http.StatusNotFound
+		case transport.ErrAuthenticationRequired:
+			code = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", h.opts.Realm))
+		case transport.ErrAuthorizationFailed, ErrDumbNotSupported:
+			code = http.StatusForbidden
+		case ErrUnsupportedContentType, ErrUnsupportedContentEncoding:
+			code = http.StatusUnsupportedMediaType
+		}
+	}
+
+	http.Error(w, err.Error(), code)
+}
+
+// requestBody returns the body of the request to the given service,
+// decompressed if needed.
+func requestBody(r *http.Request, service string) (io.ReadCloser, error) {
+	contentType := strings.Split(r.Header.Get("Content-Type"), ";")[0]
+	if strings.TrimSpace(contentType) != fmt.Sprintf("application/x-%s-request", service) {
+		return nil, ErrUnsupportedContentType
+	}
+
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+
+		return ioutil.NewReadCloser(gz, r.Body), nil
+	}
+
+	return nil, ErrUnsupportedContentEncoding
+}
+
+func setHeaders(w http.ResponseWriter, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+}
+
+// statusError is an error responded with the given status code.
+type statusError struct {
+	code int
+	err  error
+}
+
+var errMethodNotAllowed = &statusError{
+	code: http.StatusMethodNotAllowed,
+	err:  errors.New("method not allowed"),
+}
+
+func badRequest(err error) error {
+	return &statusError{code: http.StatusBadRequest, err: err}
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// responseWriter records whether the response was started, the errors can't
+// be responded after that.
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// flushWriter flushes every write, so the responses are sent in chunks as
+// they are generated, instead of buffered.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return n, err
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ServerSuite struct {
+	base   string
+	opts   Options
+	server *httptest.Server
+	url    string
+}
+
+var _ = Suite(&ServerSuite{})
+
+// SetUpTest serves a bare repository of two commits, at /repo.git.
+func (s *ServerSuite) SetUpTest(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	s.base = c.MkDir()
+	work := filepath.Join(s.base, "work")
+	_, err := git.PlainInit(work, false)
+	c.Assert(err, IsNil)
+	_, err = git.PlainInit(filepath.Join(s.base, "repo.git"), true)
+	c.Assert(err, IsNil)
+
+	s.commit(c, work, "foo")
+	s.commit(c, work, "bar")
+	s.publish(c)
+
+	s.opts = Options{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, err := NewHandler(server.NewFilesystemLoader(osfs.New(s.base)), &s.opts)
+		c.Assert(err, IsNil)
+		h.ServeHTTP(w, r)
+	}))
+
+	s.url = s.server.URL + "/repo.git"
+}
+
+func (s *ServerSuite) TearDownTest(c *C) {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+func (s *ServerSuite) git(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=foo", "-c", "user.email=foo@foo.foo",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return strings.TrimSpace(string(out))
+}
+
+// commit commits the file name, with its name as content, to the repository
+// at dir, returning the hash of the commit.
+func (s *ServerSuite) commit(c *C, dir, name string) plumbing.Hash {
+	r, err := git.PlainOpen(dir)
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644), IsNil)
+	_, err = w.Add(name)
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit(name, &git.CommitOptions{Author: &object.Signature{
+		Name:  "foo",
+		Email: "foo@foo.foo",
+		When:  time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}})
+	c.Assert(err, IsNil)
+	return h
+}
+
+// publish copies the objects and the master branch of the work repository
+// to the served one.
+func (s *ServerSuite) publish(c *C) {
+	src, err := git.PlainOpen(filepath.Join(s.base, "work"))
+	c.Assert(err, IsNil)
+	dst, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+
+	iter, err := src.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	c.Assert(iter.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := dst.Storer.SetEncodedObject(obj)
+		return err
+	}), IsNil)
+
+	ref, err := src.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+	c.Assert(dst.Storer.SetReference(ref), IsNil)
+}
+
+// reference returns the hash of the given reference of the served
+// repository.
+func (s *ServerSuite) reference(c *C, name plumbing.ReferenceName) (plumbing.Hash, error) {
+	r, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference(name, false)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ref.Hash(), nil
+}
+
+// master returns the hash of the master branch of the served repository.
+func (s *ServerSuite) master(c *C) plumbing.Hash {
+	h, err := s.reference(c, plumbing.Master)
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *ServerSuite) allowPush() {
+	s.opts.Auth = func(r *http.Request, service string, ep *transport.Endpoint) error {
+		return nil
+	}
+}
+
+func (s *ServerSuite) TestClone(c *C) {
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", s.url, "clone")
+
+	c.Assert(s.git(c, filepath.Join(dir, "clone"), "rev-parse", "HEAD"),
+		Equals, s.master(c).String())
+}
+
+func (s *ServerSuite) TestFetch(c *C) {
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", s.url, "clone")
+	clone := filepath.Join(dir, "clone")
+
+	h := s.commit(c, filepath.Join(s.base, "work"), "qux")
+	s.publish(c)
+
+	s.git(c, clone, "fetch", "-q", "origin")
+	c.Assert(s.git(c, clone, "rev-parse", "origin/master"), Equals, h.String())
+}
+
+func (s *ServerSuite) TestShallowClone(c *C) {
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", "--depth", "1", s.url, "clone")
+	clone := filepath.Join(dir, "clone")
+	c.Assert(s.git(c, clone, "rev-parse", "--is-shallow-repository"), Equals, "true")
+	c.Assert(s.git(c, clone, "rev-list", "--count", "HEAD"), Equals, "1")
+
+	s.commit(c, filepath.Join(s.base, "work"), "qux")
+	s.publish(c)
+
+	s.git(c, clone, "fetch", "-q", "--deepen", "1", "origin")
+	c.Assert(s.git(c, clone, "rev-list", "--count", "origin/master"), Equals, "3")
+	c.Assert(s.git(c, clone, "rev-parse", "--is-shallow-repository"), Equals, "true")
+
+	s.git(c, clone, "fetch", "-q", "--unshallow", "origin")
+	c.Assert(s.git(c, clone, "rev-parse", "--is-shallow-repository"), Equals, "false")
+	c.Assert(s.git(c, clone, "rev-list", "--count", "origin/master"), Equals, "3")
+}
+
+func (s *ServerSuite) TestCloneProgress(c *C) {
+	out := s.git(c, c.MkDir(), "clone", "--progress", s.url, "clone")
+	c.Assert(out, Matches, "(?s).*remote: Enumerating objects: 6, done.*")
+	c.Assert(out, Matches, "(?s).*remote: Counting objects: 100% \\(6/6\\), done.*")
+}
+
+func (s *ServerSuite) TestAdvertisedReferences(c *C) {
+	ep, err := transport.NewEndpoint(s.url)
+	c.Assert(err, IsNil)
+
+	r, err := githttp.DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	head := s.master(c)
+	c.Assert(ar.References["refs/heads/master"], Equals, head)
+	c.Assert(*ar.Head, Equals, head)
+}
+
+func (s *ServerSuite) TestPush(c *C) {
+	s.allowPush()
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", s.url, "clone")
+	clone := filepath.Join(dir, "clone")
+
+	head := s.commit(c, clone, "qux")
+	s.git(c, clone, "push", "-q", "origin", "master", "master:refs/heads/branch")
+
+	c.Assert(s.master(c), Equals, head)
+	branch, err := s.reference(c, "refs/heads/branch")
+	c.Assert(err, IsNil)
+	c.Assert(branch, Equals, head)
+
+	s.git(c, clone, "push", "-q", "origin", ":branch")
+	_, err = s.reference(c, "refs/heads/branch")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ServerSuite) TestPushDenyNonFastForwards(c *C) {
+	s.allowPush()
+	s.opts.Server.DenyNonFastForwards = true
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", s.url, "clone")
+	clone := filepath.Join(dir, "clone")
+
+	s.git(c, clone, "reset", "-q", "--hard", "HEAD~1")
+	s.commit(c, clone, "qux")
+
+	cmd := exec.Command("git", "push", "-f", "origin", "master")
+	cmd.Dir = clone
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, NotNil)
+	c.Assert(string(out), Matches, `(?s).*\[remote rejected\] master -> master \(non-fast-forward\).*`)
+
+	c.Assert(s.master(c).String(), Equals, s.git(c, clone, "rev-parse", "origin/master"))
+}
+
+func (s *ServerSuite) TestPushAnonymous(c *C) {
+	ep, err := transport.NewEndpoint(s.url)
+	c.Assert(err, IsNil)
+
+	r, err := githttp.DefaultClient.NewReceivePackSession(ep, nil)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrAuthorizationFailed)
+}
+
+func (s *ServerSuite) TestAuth(c *C) {
+	s.opts.Auth = func(r *http.Request, service string, ep *transport.Endpoint) error {
+		c.Assert(service, Equals, transport.ReceivePackServiceName)
+		c.Assert(ep.Path, Equals, "/repo.git")
+
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return transport.ErrAuthenticationRequired
+		}
+
+		if user != "foo" || password != "bar" {
+			return transport.ErrAuthorizationFailed
+		}
+
+		return nil
+	}
+
+	ep, err := transport.NewEndpoint(s.url)
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		auth transport.AuthMethod
+		err  error
+	}{
+		{nil, transport.ErrAuthenticationRequired},
+		{&githttp.BasicAuth{Username: "foo", Password: "qux"}, transport.ErrAuthorizationFailed},
+		{&githttp.BasicAuth{Username: "foo", Password: "bar"}, nil},
+	} {
+		r, err := githttp.DefaultClient.NewReceivePackSession(ep, t.auth)
+		c.Assert(err, IsNil)
+		_, err = r.AdvertisedReferences()
+		c.Assert(err, Equals, t.err)
+	}
+}
+
+func (s *ServerSuite) TestAuthRealm(c *C) {
+	s.opts.Realm = "foo"
+	res, err := http.Get(s.url + "/info/refs?service=git-receive-pack")
+	c.Assert(err, IsNil)
+	c.Assert(res.Body.Close(), IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+
+	s.opts.Auth = func(*http.Request, string, *transport.Endpoint) error {
+		return transport.ErrAuthenticationRequired
+	}
+
+	res, err = http.Get(s.url + "/info/refs?service=git-upload-pack")
+	c.Assert(err, IsNil)
+	c.Assert(res.Body.Close(), IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(res.Header.Get("WWW-Authenticate"), Equals, `Basic realm="foo"`)
+}
+
+func (s *ServerSuite) TestInfoRefs(c *C) {
+	res, err := http.Get(s.url + "/info/refs?service=git-upload-pack")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-advertisement")
+	c.Assert(res.Header.Get("Cache-Control"), Equals, "no-cache, max-age=0, must-revalidate")
+
+	ar := packp.NewAdvRefs()
+	c.Assert(ar.Decode(res.Body), IsNil)
+	c.Assert(ar.Prefix, DeepEquals, [][]byte{[]byte("# service=git-upload-pack"), {}})
+}
+
+func (s *ServerSuite) TestUploadPackGzip(c *C) {
+	head := s.master(c)
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{head}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	c.Assert(req.UploadRequest.Encode(gz), IsNil)
+	_, err := fmt.Fprint(gz, "0009done\n")
+	c.Assert(err, IsNil)
+	c.Assert(gz.Close(), IsNil)
+
+	r, err := http.NewRequest(http.MethodPost, s.url+"/git-upload-pack", &buf)
+	c.Assert(err, IsNil)
+	r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	r.Header.Set("Content-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(r)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-result")
+	c.Assert(res.TransferEncoding, DeepEquals, []string{"chunked"})
+
+	body, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(body), "0008NAK\nPACK"), Equals, true)
+}
+
+func (s *ServerSuite) TestUploadPackNegotiation(c *C) {
+	head := s.master(c)
+	req := packp.NewUploadPackRequest()
+	c.Assert(req.Capabilities.Set(capability.MultiACKDetailed), IsNil)
+	req.Wants = []plumbing.Hash{head}
+	req.Haves = []plumbing.Hash{head}
+
+	var buf bytes.Buffer
+	c.Assert(req.UploadRequest.Encode(&buf), IsNil)
+	c.Assert(req.UploadHaves.Encode(&buf, true), IsNil)
+
+	res, err := http.Post(s.url+"/git-upload-pack", "application/x-git-upload-pack-request", &buf)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, fmt.Sprintf("0038ACK %s common\n0037ACK %s ready\n0008NAK\n", head, head))
+}
+
+func (s *ServerSuite) TestUnsupportedContentType(c *C) {
+	res, err := http.Post(s.url+"/git-upload-pack", "text/plain", strings.NewReader("0000"))
+	c.Assert(err, IsNil)
+	c.Assert(res.Body.Close(), IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusUnsupportedMediaType)
+}
+
+func (s *ServerSuite) TestMethodNotAllowed(c *C) {
+	res, err := http.Get(s.url + "/git-upload-pack")
+	c.Assert(err, IsNil)
+	c.Assert(res.Body.Close(), IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *ServerSuite) TestDumbNotSupported(c *C) {
+	res, err := http.Get(s.url + "/info/refs")
+	c.Assert(err, IsNil)
+	c.Assert(res.Body.Close(), IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+}
+
+func (s *ServerSuite) TestRepositoryNotFound(c *C) {
+	ep, err := transport.NewEndpoint(s.server.URL + "/foo.git")
+	c.Assert(err, IsNil)
+
+	r, err := githttp.DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+
+	res, err := http.Get(s.server.URL + "/foo")
+	c.Assert(err, IsNil)
+	c.Assert(res.Body.Close(), IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/internal/common"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
@@ -25,13 +27,65 @@ func newUploadPackSession(c *http.Client, ep *transport.Endpoint, auth transport
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
-	return advertisedReferences(s.session, transport.UploadPackServiceName)
+	return s.AdvertisedReferencesWithPrefixes(nil)
+}
+
+// AdvertisedReferencesWithPrefixes retrieves the advertised references
+// starting with any of the given prefixes. Only the servers speaking the
+// protocol version 2 filter them, the others advertise all the references.
+func (s *upSession) AdvertisedReferencesWithPrefixes(prefixes []string) (*packp.AdvRefs, error) {
+	ar, err := advertisedReferences(s.session, transport.UploadPackServiceName)
+	if err != nil || s.capAdv == nil {
+		return ar, err
+	}
+
+	return s.lsRefs(prefixes)
+}
+
+func (s *upSession) lsRefs(prefixes []string) (ar *packp.AdvRefs, err error) {
+	req := packp.NewLsRefsRequest()
+	req.Prefixes = prefixes
+	if s.capAdv.Capabilities.Supports(capability.Agent) {
+		if err := req.Capabilities.Set(capability.Agent, capability.DefaultAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	content := bytes.NewBuffer(nil)
+	if err := req.Encode(content); err != nil {
+		return nil, err
+	}
+
+	res, err := s.doRequest(context.Background(), http.MethodPost, s.uploadPackURL(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	lr := packp.NewLsRefsResponse()
+	if err := lr.Decode(res.Body); err != nil {
+		return nil, err
+	}
+
+	if len(lr.References) == 0 && len(prefixes) == 0 {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	ar = s.capAdv.AdvRefs(lr)
+	transport.FilterUnsupportedCapabilities(ar.Capabilities)
+	s.advRefs = ar
+	return ar, nil
 }
 
 func (s *upSession) UploadPack(
 	ctx context.Context, req *packp.UploadPackRequest,
 ) (*packp.UploadPackResponse, error) {
 
+	if s.dumb {
+		return nil, ErrDumbUploadPack
+	}
+
 	if req.IsEmpty() {
 		return nil, transport.ErrEmptyUploadPackRequest
 	}
@@ -40,17 +94,23 @@ func (s *upSession) UploadPack(
 		return nil, err
 	}
 
-	url := fmt.Sprintf(
-		"%s/%s",
-		s.endpoint.String(), transport.UploadPackServiceName,
-	)
+	if s.capAdv == nil && common.IsMultiACK(req) {
+		return s.negotiateUploadPack(ctx, req)
+	}
+
+	var content *bytes.Buffer
+	var err error
+	if s.capAdv != nil {
+		content, err = fetchCommandToReader(req)
+	} else {
+		content, err = uploadPackRequestToReader(req)
+	}
 
-	content, err := uploadPackRequestToReader(req)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := s.doRequest(ctx, http.MethodPost, url, content)
+	res, err := s.doRequest(ctx, http.MethodPost, s.uploadPackURL(), content)
 	if err != nil {
 		return nil, err
 	}
@@ -65,9 +125,79 @@ func (s *upSession) UploadPack(
 	}
 
 	rc := ioutil.NewReadCloser(r, res.Body)
+	if s.capAdv != nil {
+		resp := packp.NewUploadPackResponse(req)
+		if err := resp.DecodeV2(rc); err != nil {
+			return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+		}
+
+		return resp, nil
+	}
+
 	return common.DecodeUploadPackResponse(rc, req)
 }
 
+// negotiateUploadPack negotiates the haves of the request with the server,
+// sending a request for every round until it's done, and decodes the response
+// of the last one. The no-done capability is requested if the server supports
+// it, so the packfile is sent as soon as the server is ready without an extra
+// round.
+func (s *upSession) negotiateUploadPack(
+	ctx context.Context, req *packp.UploadPackRequest,
+) (*packp.UploadPackResponse, error) {
+
+	if s.advRefs != nil && s.advRefs.Capabilities.Supports(capability.NoDone) &&
+		req.Capabilities.Supports(capability.MultiACKDetailed) {
+		if err := req.Capabilities.Set(capability.NoDone); err != nil {
+			return nil, err
+		}
+	}
+
+	n := packp.NewNegotiation(req, true)
+	for {
+		content := bytes.NewBuffer(nil)
+		if err := n.EncodeRound(content); err != nil {
+			return nil, fmt.Errorf("sending haves message: %s", err)
+		}
+
+		res, err := s.doRequest(ctx, http.MethodPost, s.uploadPackURL(), content)
+		if err != nil {
+			return nil, err
+		}
+
+		r := bufio.NewReader(res.Body)
+		if !n.Done() {
+			if err := n.DecodeRound(r); err != nil {
+				_ = res.Body.Close()
+				return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+			}
+
+			if !n.Done() {
+				if err := res.Body.Close(); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+		}
+
+		resp, err := n.DecodeResponse(ioutil.NewReadCloser(r, res.Body))
+		if err != nil {
+			_ = res.Body.Close()
+			return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+		}
+
+		return resp, nil
+	}
+}
+
+func (s *upSession) uploadPackURL() string {
+	return fmt.Sprintf(
+		"%s/%s",
+		s.endpoint.String(), transport.UploadPackServiceName,
+	)
+}
+
 // Close does nothing.
 func (s *upSession) Close() error {
 	return nil
@@ -88,6 +218,10 @@ func (s *upSession) doRequest(
 	}
 
 	applyHeadersToRequest(req, content, s.endpoint.Host, transport.UploadPackServiceName)
+	if s.capAdv != nil {
+		s.applyGitProtocolToRequest(req, transport.UploadPackServiceName)
+	}
+
 	s.ApplyAuthToRequest(req)
 
 	res, err := s.client.Do(req.WithContext(ctx))
@@ -121,3 +255,12 @@ func uploadPackRequestToReader(req *packp.UploadPackRequest) (*bytes.Buffer, err
 
 	return buf, nil
 }
+
+func fetchCommandToReader(req *packp.UploadPackRequest) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := req.EncodeV2(buf); err != nil {
+		return nil, fmt.Errorf("sending fetch command: %s", err)
+	}
+
+	return buf, nil
+}
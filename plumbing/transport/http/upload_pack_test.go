@@ -30,6 +30,20 @@ func (s *UploadPackSuite) SetUpSuite(c *C) {
 	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
 }
 
+type UploadPackV2Suite struct {
+	UploadPackSuite
+}
+
+var _ = Suite(&UploadPackV2Suite{})
+
+func (s *UploadPackV2Suite) SetUpSuite(c *C) {
+	s.UploadPackSuite.SetUpSuite(c)
+
+	s.Endpoint.ProtocolVersion = transport.ProtocolV2
+	s.EmptyEndpoint.ProtocolVersion = transport.ProtocolV2
+	s.NonExistentEndpoint.ProtocolVersion = transport.ProtocolV2
+}
+
 // Overwritten, different behaviour for HTTP.
 func (s *UploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
 	r, err := s.Client.NewUploadPackSession(s.NonExistentEndpoint, s.EmptyAuth)
@@ -103,6 +103,8 @@ type session struct {
 
 	isReceivePack bool
 	advRefs       *packp.AdvRefs
+	capAdv        *packp.CapabilityAdvertisement
+	advertised    bool
 	packRun       bool
 	finished      bool
 	firstErrLine  chan string
@@ -168,15 +170,83 @@ func (s *session) AdvertisedReferences() (*packp.AdvRefs, error) {
 		return s.advRefs, nil
 	}
 
-	ar := packp.NewAdvRefs()
-	if err := ar.Decode(s.Stdout); err != nil {
-		if err := s.handleAdvRefDecodeError(err); err != nil {
+	return s.AdvertisedReferencesWithPrefixes(nil)
+}
+
+// AdvertisedReferencesWithPrefixes retrieves the advertised references from
+// the server starting with any of the given prefixes. Only the servers
+// speaking the protocol version 2 filter them, the others advertise all the
+// references.
+func (s *session) AdvertisedReferencesWithPrefixes(prefixes []string) (*packp.AdvRefs, error) {
+	if !s.advertised {
+		if err := s.decodeAdvertisement(); err != nil {
 			return nil, err
 		}
 	}
 
+	if s.capAdv == nil || s.packRun {
+		return s.advRefs, nil
+	}
+
+	ar, err := s.lsRefs(prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.advRefs = ar
+	return ar, nil
+}
+
+// decodeAdvertisement reads the first message of the server, the references
+// advertisement or, in the protocol version 2, the capability advertisement.
+func (s *session) decodeAdvertisement() error {
+	ar, capAdv, err := packp.DecodeAdvertisement(s.Stdout)
+	if err != nil {
+		if err := s.handleAdvRefDecodeError(err); err != nil {
+			return err
+		}
+	}
+
+	s.advertised = true
+	if capAdv != nil {
+		s.capAdv = capAdv
+		return nil
+	}
+
 	transport.FilterUnsupportedCapabilities(ar.Capabilities)
 	s.advRefs = ar
+	return nil
+}
+
+// lsRefs requests the references to a server speaking the protocol version 2.
+func (s *session) lsRefs(prefixes []string) (*packp.AdvRefs, error) {
+	req := packp.NewLsRefsRequest()
+	req.Prefixes = prefixes
+	if s.capAdv.Capabilities.Supports(capability.Agent) {
+		if err := req.Capabilities.Set(capability.Agent, capability.DefaultAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := req.Encode(s.Stdin); err != nil {
+		return nil, fmt.Errorf("sending ls-refs command: %s", err)
+	}
+
+	res := packp.NewLsRefsResponse()
+	if err := res.Decode(s.Stdout); err != nil {
+		return nil, err
+	}
+
+	if len(res.References) == 0 && len(prefixes) == 0 {
+		if err := s.finish(); err != nil {
+			return nil, err
+		}
+
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	ar := s.capAdv.AdvRefs(res)
+	transport.FilterUnsupportedCapabilities(ar.Capabilities)
 	return ar, nil
 }
 
@@ -239,6 +309,14 @@ func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest)
 	in := s.StdinContext(ctx)
 	out := s.StdoutContext(ctx)
 
+	if s.capAdv != nil {
+		return s.uploadPackV2(in, out, req)
+	}
+
+	if IsMultiACK(req) {
+		return s.negotiateUploadPack(in, out, req)
+	}
+
 	if err := uploadPack(in, out, req); err != nil {
 		return nil, err
 	}
@@ -260,6 +338,61 @@ func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest)
 	return DecodeUploadPackResponse(rc, req)
 }
 
+// uploadPackV2 sends the fetch command of the protocol version 2, ending the
+// session after it, and decodes its response.
+func (s *session) uploadPackV2(w io.WriteCloser, r io.Reader, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	if err := req.EncodeV2(w); err != nil {
+		return nil, fmt.Errorf("sending fetch command: %s", err)
+	}
+
+	if _, err := w.Write(pktline.FlushPkt); err != nil {
+		return nil, fmt.Errorf("sending flush message: %s", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("closing input: %s", err)
+	}
+
+	res := packp.NewUploadPackResponse(req)
+	if err := res.DecodeV2(ioutil.NewReadCloser(r, s)); err != nil {
+		return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+	}
+
+	return res, nil
+}
+
+// negotiateUploadPack negotiates the haves of the request with the server,
+// sending them in rounds until it's done, ending the session input after it,
+// and decodes its response.
+func (s *session) negotiateUploadPack(w io.WriteCloser, r io.Reader, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	n := packp.NewNegotiation(req, false)
+	buf := bufio.NewReader(r)
+	for !n.Done() {
+		if err := n.EncodeRound(w); err != nil {
+			return nil, fmt.Errorf("sending haves message: %s", err)
+		}
+
+		if n.Done() {
+			break
+		}
+
+		if err := n.DecodeRound(buf); err != nil {
+			return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("closing input: %s", err)
+	}
+
+	res, err := n.DecodeResponse(ioutil.NewReadCloser(buf, s))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+	}
+
+	return res, nil
+}
+
 func (s *session) StdinContext(ctx context.Context) io.WriteCloser {
 	return ioutil.NewWriteCloserOnError(
 		ioutil.NewContextWriteCloser(ctx, s.Stdin),
@@ -424,11 +557,6 @@ var (
 
 // uploadPack implements the git-upload-pack protocol.
 func uploadPack(w io.WriteCloser, r io.Reader, req *packp.UploadPackRequest) error {
-	// TODO support multi_ack mode
-	// TODO support multi_ack_detailed mode
-	// TODO support acks for common objects
-	// TODO build a proper state machine for all these processing options
-
 	if err := req.UploadRequest.Encode(w); err != nil {
 		return fmt.Errorf("sending upload-req message: %s", err)
 	}
@@ -454,6 +582,14 @@ func sendDone(w io.Writer) error {
 	return e.Encodef("done\n")
 }
 
+// IsMultiACK returns true if the request uses the multi_ack or
+// multi_ack_detailed capabilities, its haves are negotiated with the server
+// in rounds, see packp.Negotiation.
+func IsMultiACK(req *packp.UploadPackRequest) bool {
+	return req.Capabilities.Supports(capability.MultiACK) ||
+		req.Capabilities.Supports(capability.MultiACKDetailed)
+}
+
 // DecodeUploadPackResponse decodes r into a new packp.UploadPackResponse
 func DecodeUploadPackResponse(r io.ReadCloser, req *packp.UploadPackRequest) (
 	*packp.UploadPackResponse, error,
@@ -1,12 +1,17 @@
 package common
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 )
 
@@ -18,7 +23,7 @@ type ServerCommand struct {
 }
 
 func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err error) {
-	ioutil.CheckClose(cmd.Stdout, &err)
+	defer ioutil.CheckClose(cmd.Stdout, &err)
 
 	ar, err := s.AdvertisedReferences()
 	if err != nil {
@@ -29,18 +34,87 @@ func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err erro
 		return err
 	}
 
+	// the clients not fetching anything send a flush-pkt instead of a request
+	stdin := bufio.NewReader(cmd.Stdin)
+	if isFlush(stdin) {
+		return nil
+	}
+
 	req := packp.NewUploadPackRequest()
-	if err := req.Decode(cmd.Stdin); err != nil {
+	ns, ok := s.(server.UploadPackSession)
+	if !ok {
+		if err := req.Decode(stdin); err != nil {
+			return err
+		}
+
+		var resp *packp.UploadPackResponse
+		resp, err = s.UploadPack(context.TODO(), req)
+		if err != nil {
+			return err
+		}
+
+		return resp.Encode(cmd.Stdout)
+	}
+
+	return negotiateUploadPack(cmd.Stdout, stdin, ns, req)
+}
+
+// negotiateUploadPack serves the request of a session negotiating the haves in
+// rounds: the shallow update is sent once the wants are received, then every
+// round of haves is answered until the client is done, or the server is ready
+// with the no-done capability, and finally the packfile is sent.
+func negotiateUploadPack(w io.Writer, r io.Reader, s server.UploadPackSession, req *packp.UploadPackRequest) (err error) {
+	if err := req.UploadRequest.Decode(r); err != nil {
 		return err
 	}
 
-	var resp *packp.UploadPackResponse
-	resp, err = s.UploadPack(context.TODO(), req)
+	su, err := s.ReceiveWants(&req.UploadRequest)
 	if err != nil {
 		return err
 	}
 
-	return resp.Encode(cmd.Stdout)
+	if su != nil {
+		if err := su.Encode(w); err != nil {
+			return err
+		}
+	}
+
+	for {
+		req.UploadHaves = packp.UploadHaves{}
+		if err := req.UploadHaves.Decode(r); err != nil {
+			return err
+		}
+
+		if req.Done {
+			break
+		}
+
+		rr, err := s.Negotiate(req.Haves)
+		if err != nil {
+			return err
+		}
+
+		if err := rr.Encode(w); err != nil {
+			return err
+		}
+
+		if rr.Ready && req.Capabilities.Supports(capability.NoDone) {
+			break
+		}
+	}
+
+	resp, err := s.UploadPack(context.TODO(), req)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(resp, &err)
+	if err := resp.ServerResponse.Encode(w); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp)
+	return err
 }
 
 func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
@@ -53,8 +127,14 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 		return fmt.Errorf("error in advertised references encoding: %s", err)
 	}
 
+	// the clients not pushing anything send a flush-pkt instead of a request
+	stdin := bufio.NewReader(cmd.Stdin)
+	if isFlush(stdin) {
+		return nil
+	}
+
 	req := packp.NewReferenceUpdateRequest()
-	if err := req.Decode(cmd.Stdin); err != nil {
+	if err := req.Decode(stdin); err != nil {
 		return fmt.Errorf("error decoding: %s", err)
 	}
 
@@ -71,3 +151,9 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 
 	return nil
 }
+
+// isFlush returns true if the next pkt-line of the reader is a flush-pkt.
+func isFlush(r *bufio.Reader) bool {
+	b, err := r.Peek(len(pktline.FlushPkt))
+	return err == nil && bytes.Equal(b, pktline.FlushPkt)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrNonFastForward is the status of the commands rejected by
+	// Options.DenyNonFastForwards and Options.ProtectedReferences, as git
+	// reports them.
+	ErrNonFastForward = errors.New("non-fast-forward")
+	// ErrDeletionProhibited is the status of the commands rejected by
+	// Options.DenyDeletes and Options.ProtectedReferences, as git reports
+	// them.
+	ErrDeletionProhibited = errors.New("deletion prohibited")
+)
+
+// HookEnv is the environment of the receive hooks of a push, as the one git
+// runs its hooks with.
+type HookEnv struct {
+	// Storer is the storer of the repository, the objects received are
+	// available along with the ones of the repository. Until they are
+	// migrated to it, the objects received are read from the quarantine
+	// where they are staged, if the storer supports them, see
+	// storer.Quarantiner.
+	Storer storer.Storer
+	// PushCertificate is the push certificate verified, nil if the push
+	// isn't signed.
+	PushCertificate *PushCertificate
+}
+
+// ReceiveHook is run by the receive-pack sessions, as the pre-receive, update
+// and post-receive hooks of git-receive-pack, to veto the commands of the
+// pushes and to be notified of the references updated. The errors returned
+// are reported as the status of the commands rejected.
+type ReceiveHook interface {
+	// PreReceive is called with all the commands of a push, once its
+	// objects are received, before any reference is updated. None of them
+	// is if it returns an error.
+	PreReceive(ctx context.Context, env *HookEnv, cmds []*packp.Command) error
+	// Update is called with every command of a push allowed by the policies
+	// of the options, before any reference is updated. Its reference isn't
+	// if it returns an error, and the objects received are discarded if
+	// no command is left.
+	Update(ctx context.Context, env *HookEnv, cmd *packp.Command) error
+	// PostReceive is called with the commands of a push whose references
+	// were updated, if any.
+	PostReceive(ctx context.Context, env *HookEnv, cmds []*packp.Command)
+}
+
+// checkPolicies returns the error rejecting the given command by the policies
+// of the options, if any. The updates of the references are fast-forwards if
+// both the old and the new objects are commits, the new one descending from
+// the old one.
+func (o *Options) checkPolicies(s storer.EncodedObjectStorer, cmd *packp.Command) error {
+	protected := o.isProtected(cmd.Name)
+	switch cmd.Action() {
+	case packp.Delete:
+		if o.DenyDeletes || protected {
+			return ErrDeletionProhibited
+		}
+	case packp.Update:
+		if !protected && !(o.DenyNonFastForwards && cmd.Name.IsBranch()) {
+			return nil
+		}
+
+		ok, err := isFastForward(s, cmd.Old, cmd.New)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return ErrNonFastForward
+		}
+	}
+
+	return nil
+}
+
+// isProtected returns true if the given reference matches any of the
+// ProtectedReferences patterns.
+func (o *Options) isProtected(name plumbing.ReferenceName) bool {
+	for _, pattern := range o.ProtectedReferences {
+		if ok, _ := path.Match(pattern, name.String()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isFastForward(s storer.EncodedObjectStorer, old, new plumbing.Hash) (bool, error) {
+	var commits []*object.Commit
+	for _, h := range []plumbing.Hash{old, new} {
+		c, err := object.GetCommit(s, h)
+		if err == plumbing.ErrObjectNotFound || err == object.ErrUnsupportedObject {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		commits = append(commits, c)
+	}
+
+	return commits[0].IsAncestor(commits[1])
+}
@@ -0,0 +1,201 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// ReceiveHookSuite tests the hooks and the policies of the receive-pack
+// sessions, pushing the commits of the repository: refs/heads/old is added at
+// the second one.
+type ReceiveHookSuite struct {
+	GitBaseSuite
+}
+
+var _ = Suite(&ReceiveHookSuite{})
+
+func (s *ReceiveHookSuite) SetUpTest(c *C) {
+	s.GitBaseSuite.SetUpTest(c)
+
+	ref := plumbing.NewHashReference("refs/heads/old", s.commits[1])
+	c.Assert(s.work.Storer.SetReference(ref), IsNil)
+	s.publish(c)
+}
+
+// receivePack pushes the given commands, returning the statuses of their
+// references.
+func (s *ReceiveHookSuite) receivePack(c *C, o *server.Options, cmds ...*packp.Command) (map[plumbing.ReferenceName]string, error) {
+	srv, err := server.NewServerWithOptions(server.NewFilesystemLoader(osfs.New(s.base)), o)
+	c.Assert(err, IsNil)
+
+	r, err := srv.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = cmds
+
+	rs, err := r.ReceivePack(context.Background(), req)
+	c.Assert(rs, NotNil)
+	c.Assert(r.Close(), IsNil)
+
+	statuses := make(map[plumbing.ReferenceName]string)
+	for _, cs := range rs.CommandStatuses {
+		statuses[cs.ReferenceName] = cs.Status
+	}
+
+	return statuses, err
+}
+
+// reference returns the hash of the given reference of the repository, an
+// empty string if it doesn't exist.
+func (s *ReceiveHookSuite) reference(c *C, name plumbing.ReferenceName) string {
+	r, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference(name, false)
+	if err == plumbing.ErrReferenceNotFound {
+		return ""
+	}
+
+	c.Assert(err, IsNil)
+	return ref.Hash().String()
+}
+
+func (s *ReceiveHookSuite) TestDenyNonFastForwards(c *C) {
+	statuses, err := s.receivePack(c, &server.Options{DenyNonFastForwards: true},
+		&packp.Command{Name: plumbing.Master, Old: s.commits[3], New: s.commits[1]},
+		&packp.Command{Name: "refs/heads/old", Old: s.commits[1], New: s.commits[3]},
+		&packp.Command{Name: "refs/tags/v1", Old: s.tag, New: s.commits[0]},
+	)
+	c.Assert(err, Equals, server.ErrNonFastForward)
+	c.Assert(statuses, DeepEquals, map[plumbing.ReferenceName]string{
+		plumbing.Master:  "non-fast-forward",
+		"refs/heads/old": "ok",
+		"refs/tags/v1":   "ok",
+	})
+
+	c.Assert(s.reference(c, plumbing.Master), Equals, s.commits[3].String())
+	c.Assert(s.reference(c, "refs/heads/old"), Equals, s.commits[3].String())
+}
+
+func (s *ReceiveHookSuite) TestDenyDeletes(c *C) {
+	statuses, err := s.receivePack(c, &server.Options{DenyDeletes: true},
+		&packp.Command{Name: "refs/heads/old", Old: s.commits[1], New: plumbing.ZeroHash},
+	)
+	c.Assert(err, Equals, server.ErrDeletionProhibited)
+	c.Assert(statuses, DeepEquals, map[plumbing.ReferenceName]string{
+		"refs/heads/old": "deletion prohibited",
+	})
+
+	c.Assert(s.reference(c, "refs/heads/old"), Equals, s.commits[1].String())
+}
+
+func (s *ReceiveHookSuite) TestProtectedReferences(c *C) {
+	o := &server.Options{ProtectedReferences: []string{"refs/heads/o*"}}
+	statuses, err := s.receivePack(c, o,
+		&packp.Command{Name: "refs/heads/old", Old: s.commits[1], New: s.commits[0]},
+		&packp.Command{Name: plumbing.Master, Old: s.commits[3], New: s.commits[1]},
+	)
+	c.Assert(err, Equals, server.ErrNonFastForward)
+	c.Assert(statuses, DeepEquals, map[plumbing.ReferenceName]string{
+		"refs/heads/old": "non-fast-forward",
+		plumbing.Master:  "ok",
+	})
+
+	statuses, err = s.receivePack(c, o,
+		&packp.Command{Name: "refs/heads/old", Old: s.commits[1], New: plumbing.ZeroHash},
+	)
+	c.Assert(err, Equals, server.ErrDeletionProhibited)
+	c.Assert(statuses["refs/heads/old"], Equals, "deletion prohibited")
+
+	_, err = s.receivePack(c, o,
+		&packp.Command{Name: "refs/heads/old", Old: s.commits[1], New: s.commits[2]},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(s.reference(c, "refs/heads/old"), Equals, s.commits[2].String())
+}
+
+func (s *ReceiveHookSuite) TestProtectedReferencesInvalidPattern(c *C) {
+	_, err := server.NewServerWithOptions(nil, &server.Options{ProtectedReferences: []string{"["}})
+	c.Assert(err, ErrorMatches, `invalid protected reference pattern "\[": .*`)
+}
+
+func (s *ReceiveHookSuite) TestReceiveHook(c *C) {
+	hook := &receiveHook{update: map[plumbing.ReferenceName]error{
+		plumbing.Master: errors.New("foo"),
+	}}
+
+	cmds := []*packp.Command{
+		{Name: plumbing.Master, Old: s.commits[3], New: s.commits[2]},
+		{Name: "refs/heads/old", Old: s.commits[1], New: s.commits[3]},
+	}
+
+	statuses, err := s.receivePack(c, &server.Options{ReceiveHook: hook}, cmds...)
+	c.Assert(err, ErrorMatches, "foo")
+	c.Assert(statuses, DeepEquals, map[plumbing.ReferenceName]string{
+		plumbing.Master:  "foo",
+		"refs/heads/old": "ok",
+	})
+
+	c.Assert(hook.received, DeepEquals, cmds)
+	c.Assert(hook.updated, DeepEquals, cmds[1:])
+	_, err = object.GetCommit(hook.env.Storer, s.commits[3])
+	c.Assert(err, IsNil)
+
+	c.Assert(s.reference(c, plumbing.Master), Equals, s.commits[3].String())
+	c.Assert(s.reference(c, "refs/heads/old"), Equals, s.commits[3].String())
+}
+
+func (s *ReceiveHookSuite) TestPreReceiveHook(c *C) {
+	hook := &receiveHook{preReceive: errors.New("foo")}
+	statuses, err := s.receivePack(c, &server.Options{ReceiveHook: hook},
+		&packp.Command{Name: plumbing.Master, Old: s.commits[3], New: s.commits[2]},
+		&packp.Command{Name: "refs/heads/new", Old: plumbing.ZeroHash, New: s.commits[3]},
+	)
+	c.Assert(err, ErrorMatches, "foo")
+	c.Assert(statuses, DeepEquals, map[plumbing.ReferenceName]string{
+		plumbing.Master:  "foo",
+		"refs/heads/new": "foo",
+	})
+
+	c.Assert(hook.updated, IsNil)
+	c.Assert(s.reference(c, plumbing.Master), Equals, s.commits[3].String())
+	c.Assert(s.reference(c, "refs/heads/new"), Equals, "")
+}
+
+// receiveHook records the commands of its calls, rejecting the pushes with
+// preReceive and the commands of the references of update.
+type receiveHook struct {
+	preReceive error
+	update     map[plumbing.ReferenceName]error
+
+	env      *server.HookEnv
+	received []*packp.Command
+	updated  []*packp.Command
+}
+
+func (h *receiveHook) PreReceive(ctx context.Context, env *server.HookEnv, cmds []*packp.Command) error {
+	h.env = env
+	h.received = cmds
+	return h.preReceive
+}
+
+func (h *receiveHook) Update(ctx context.Context, env *server.HookEnv, cmd *packp.Command) error {
+	return h.update[cmd.Name]
+}
+
+func (h *receiveHook) PostReceive(ctx context.Context, env *server.HookEnv, cmds []*packp.Command) {
+	h.updated = cmds
+}
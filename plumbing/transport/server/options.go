@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+)
+
+// DefaultPushCertNonceSlop is the default Options.PushCertNonceSlop.
+const DefaultPushCertNonceSlop = 5 * time.Minute
+
+var (
+	// ErrPushCertRequired is returned by the receive-pack sessions of the
+	// servers with Options.RequirePushCert when the push isn't signed.
+	ErrPushCertRequired = errors.New("push certificate required")
+	// ErrPushCertNotSupported is returned by the receive-pack sessions of the
+	// servers without Options.PushCertKeyRing when the push is signed.
+	ErrPushCertNotSupported = errors.New("push certificates not supported")
+	// ErrInvalidPushCertNonce is returned by the receive-pack sessions when
+	// the nonce of the push certificate wasn't generated by the server, or
+	// it's older than Options.PushCertNonceSlop.
+	ErrInvalidPushCertNonce = errors.New("invalid push certificate nonce")
+	// ErrMissingPushCertKeyRing is returned by Options.Validate when
+	// RequirePushCert is set without a PushCertKeyRing.
+	ErrMissingPushCertKeyRing = errors.New("push certificates require a key ring")
+)
+
+// Options configures the servers returned by NewServerWithOptions.
+type Options struct {
+	// PushCertKeyRing is the armored key ring verifying the push
+	// certificates. If not empty, the receive-pack sessions advertise
+	// push-cert with a nonce, and reject the pushes whose certificate isn't
+	// signed by any of its keys or has an invalid nonce.
+	PushCertKeyRing string
+	// RequirePushCert rejects the pushes without a push certificate.
+	RequirePushCert bool
+	// PushCertNonceSeed is the secret the nonces are generated with. The
+	// servers sharing it accept the nonces generated by each other, as
+	// required by the stateless transports. A random one is used if empty.
+	PushCertNonceSeed []byte
+	// PushCertNonceSlop is how long the nonces are valid,
+	// DefaultPushCertNonceSlop if zero.
+	PushCertNonceSlop time.Duration
+	// ReceiveHook is run by the receive-pack sessions, if not nil.
+	ReceiveHook ReceiveHook
+	// DenyNonFastForwards rejects the updates of branches that aren't
+	// fast-forwards, as receive.denyNonFastForwards does.
+	DenyNonFastForwards bool
+	// DenyDeletes rejects the deletions of references, as
+	// receive.denyDeletes does.
+	DenyDeletes bool
+	// ProtectedReferences are the patterns of the references whose deletions
+	// and non-fast-forward updates are rejected, as the branch protections
+	// of the git hosts do. They are matched as path.Match does, e.g.
+	// refs/heads/release-*.
+	ProtectedReferences []string
+}
+
+// Validate validates the fields and sets the default values.
+func (o *Options) Validate() error {
+	for _, pattern := range o.ProtectedReferences {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid protected reference pattern %q: %s", pattern, err)
+		}
+	}
+
+	if o.PushCertKeyRing == "" {
+		if o.RequirePushCert {
+			return ErrMissingPushCertKeyRing
+		}
+
+		return nil
+	}
+
+	if _, err := openpgp.ReadArmoredKeyRing(strings.NewReader(o.PushCertKeyRing)); err != nil {
+		return err
+	}
+
+	if len(o.PushCertNonceSeed) == 0 {
+		o.PushCertNonceSeed = make([]byte, 32)
+		if _, err := rand.Read(o.PushCertNonceSeed); err != nil {
+			return err
+		}
+	}
+
+	if o.PushCertNonceSlop == 0 {
+		o.PushCertNonceSlop = DefaultPushCertNonceSlop
+	}
+
+	return nil
+}
+
+// nonce returns the push certificate nonce of the given time, as git does: the
+// timestamp followed by its HMAC with the seed.
+func (o *Options) nonce(t time.Time) string {
+	stamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("%s-%s", stamp, o.nonceMAC(stamp))
+}
+
+// validNonce returns true if the given nonce was generated with the seed of
+// the options no longer than PushCertNonceSlop from the given time.
+func (o *Options) validNonce(nonce string, t time.Time) bool {
+	i := strings.IndexByte(nonce, '-')
+	if i == -1 {
+		return false
+	}
+
+	stamp := nonce[:i]
+	if !hmac.Equal([]byte(nonce[i+1:]), []byte(o.nonceMAC(stamp))) {
+		return false
+	}
+
+	sec, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := t.Sub(time.Unix(sec, 0))
+	return age <= o.PushCertNonceSlop && age >= -o.PushCertNonceSlop
+}
+
+func (o *Options) nonceMAC(stamp string) string {
+	mac := hmac.New(sha1.New, o.PushCertNonceSeed)
+	mac.Write([]byte(stamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PushCertificate is a push certificate verified by a receive-pack session.
+type PushCertificate struct {
+	*packp.PushCertificate
+	// Signer is the entity of Options.PushCertKeyRing that signed it.
+	Signer *openpgp.Entity
+}
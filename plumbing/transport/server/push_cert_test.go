@@ -0,0 +1,174 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type PushCertSuite struct {
+	key      *openpgp.Entity
+	keyRing  string
+	storer   *memory.Storage
+	endpoint *transport.Endpoint
+	loader   server.MapLoader
+}
+
+var _ = Suite(&PushCertSuite{})
+
+func (s *PushCertSuite) SetUpSuite(c *C) {
+	var err error
+	s.key, err = openpgp.NewEntity("foo", "", "foo@foo.foo", nil)
+	c.Assert(err, IsNil)
+	s.keyRing = armoredPublicKey(c, s.key)
+}
+
+func (s *PushCertSuite) SetUpTest(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/foo.git")
+	c.Assert(err, IsNil)
+
+	s.storer = memory.NewStorage()
+	s.loader = server.MapLoader{s.endpoint.String(): s.storer}
+}
+
+func armoredPublicKey(c *C, key *openpgp.Entity) string {
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key.Serialize(w), IsNil)
+	c.Assert(w.Close(), IsNil)
+	return b.String()
+}
+
+func (s *PushCertSuite) newSession(c *C, o *server.Options) server.ReceivePackSession {
+	srv, err := server.NewServerWithOptions(s.loader, o)
+	c.Assert(err, IsNil)
+
+	r, err := srv.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	return r.(server.ReceivePackSession)
+}
+
+// newRequest returns a request creating refs/heads/master, signed with the
+// given key and nonce if the key isn't nil.
+func (s *PushCertSuite) newRequest(c *C, key *openpgp.Entity, nonce string) *packp.ReferenceUpdateRequest {
+	req := packp.NewReferenceUpdateRequest()
+	req.Capabilities.Set(capability.ReportStatus)
+	req.Commands = []*packp.Command{{
+		Name: plumbing.Master,
+		New:  plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	}}
+
+	if key != nil {
+		req.Certificate = &packp.PushCertificate{
+			Pusher:   "foo <foo@foo.foo> 1546300800 +0000",
+			Nonce:    nonce,
+			Commands: req.Commands,
+		}
+		c.Assert(req.Certificate.Sign(key), IsNil)
+	}
+
+	return req
+}
+
+func (s *PushCertSuite) nonce(c *C, r server.ReceivePackSession) string {
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Capabilities.Supports(capability.PushCert), Equals, true)
+
+	nonce := ar.Capabilities.Get(capability.PushCert)
+	c.Assert(nonce, HasLen, 1)
+	return nonce[0]
+}
+
+func (s *PushCertSuite) TestReceivePack(c *C) {
+	r := s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing})
+	req := s.newRequest(c, s.key, s.nonce(c, r))
+
+	rs, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(rs.Error(), IsNil)
+
+	cert := r.PushCertificate()
+	c.Assert(cert, NotNil)
+	c.Assert(cert.Signer.PrimaryKey.KeyId, Equals, s.key.PrimaryKey.KeyId)
+	c.Assert(cert.Pusher, Equals, "foo <foo@foo.foo> 1546300800 +0000")
+	c.Assert(cert.Commands, DeepEquals, req.Commands)
+
+	ref, err := s.storer.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, req.Commands[0].New)
+}
+
+func (s *PushCertSuite) TestReceivePackStateless(c *C) {
+	o := &server.Options{PushCertKeyRing: s.keyRing, PushCertNonceSeed: []byte("foo")}
+	nonce := s.nonce(c, s.newSession(c, o))
+
+	r := s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing, PushCertNonceSeed: []byte("foo")})
+	_, err := r.ReceivePack(context.Background(), s.newRequest(c, s.key, nonce))
+	c.Assert(err, IsNil)
+	c.Assert(r.PushCertificate(), NotNil)
+}
+
+func (s *PushCertSuite) TestReceivePackInvalidNonce(c *C) {
+	r := s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing})
+	nonce := s.nonce(c, r)
+
+	other := s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing})
+	_, err := other.ReceivePack(context.Background(), s.newRequest(c, s.key, nonce))
+	c.Assert(err, Equals, server.ErrInvalidPushCertNonce)
+
+	_, err = r.ReceivePack(context.Background(), s.newRequest(c, s.key, "1546300800-foo"))
+	c.Assert(err, Equals, server.ErrInvalidPushCertNonce)
+}
+
+func (s *PushCertSuite) TestReceivePackUnknownKey(c *C) {
+	key, err := openpgp.NewEntity("bar", "", "bar@bar.bar", nil)
+	c.Assert(err, IsNil)
+
+	r := s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing})
+	rs, err := r.ReceivePack(context.Background(), s.newRequest(c, key, s.nonce(c, r)))
+	c.Assert(err, NotNil)
+	c.Assert(rs.Error(), NotNil)
+	c.Assert(r.PushCertificate(), IsNil)
+
+	_, err = s.storer.Reference(plumbing.Master)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *PushCertSuite) TestReceivePackRequired(c *C) {
+	r := s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing, RequirePushCert: true})
+	_, err := r.ReceivePack(context.Background(), s.newRequest(c, nil, ""))
+	c.Assert(err, Equals, server.ErrPushCertRequired)
+
+	r = s.newSession(c, &server.Options{PushCertKeyRing: s.keyRing})
+	_, err = r.ReceivePack(context.Background(), s.newRequest(c, nil, ""))
+	c.Assert(err, IsNil)
+	c.Assert(r.PushCertificate(), IsNil)
+}
+
+func (s *PushCertSuite) TestReceivePackNotSupported(c *C) {
+	r := s.newSession(c, &server.Options{})
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Capabilities.Supports(capability.PushCert), Equals, false)
+
+	_, err = r.ReceivePack(context.Background(), s.newRequest(c, s.key, "1546300800-foo"))
+	c.Assert(err, Equals, server.ErrPushCertNotSupported)
+}
+
+func (s *PushCertSuite) TestNewServerWithOptionsMissingKeyRing(c *C) {
+	_, err := server.NewServerWithOptions(s.loader, &server.Options{RequirePushCert: true})
+	c.Assert(err, Equals, server.ErrMissingPushCertKeyRing)
+}
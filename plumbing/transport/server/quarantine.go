@@ -0,0 +1,41 @@
+package server
+
+import (
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// quarantineStorer is the storer of a repository whose new objects are stored
+// in a quarantine, the objects of the repository being read through it as the
+// ones of an alternate.
+type quarantineStorer struct {
+	storer.EncodedObjectStorer
+	storer.ReferenceStorer
+}
+
+// newQuarantine returns a new quarantine of the given storer, nil if it
+// doesn't support them.
+func newQuarantine(s storer.Storer) (storer.Quarantine, error) {
+	qs, ok := s.(storer.Quarantiner)
+	if !ok {
+		return nil, nil
+	}
+
+	return qs.Quarantine()
+}
+
+// updateObjectStorage writes the objects of the given packfile to the
+// quarantine, if any, or to the storer otherwise.
+func updateObjectStorage(s storer.Storer, q storer.Quarantine, r io.Reader) error {
+	if q == nil {
+		return packfile.UpdateObjectStorage(s, r, nil)
+	}
+
+	if pw, ok := q.(storer.PackfileWriter); ok {
+		return packfile.WritePackfileToObjectStorage(pw, r, nil)
+	}
+
+	return packfile.UpdateObjectStorage(&quarantineStorer{q, s}, r, nil)
+}
@@ -0,0 +1,164 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// QuarantineSuite tests the quarantine of the objects received by the
+// receive-pack sessions, pushing a new commit on top of the last one of the
+// repository.
+type QuarantineSuite struct {
+	GitBaseSuite
+	commit plumbing.Hash
+	pack   []byte
+}
+
+var _ = Suite(&QuarantineSuite{})
+
+func (s *QuarantineSuite) SetUpTest(c *C) {
+	s.GitBaseSuite.SetUpTest(c)
+	s.commit = s.GitBaseSuite.commit(c, "foo", "foo\nbar\n", signature(4))
+
+	hashes, err := revlist.Objects(s.work.Storer, []plumbing.Hash{s.commit}, s.commits[3:], nil)
+	c.Assert(err, IsNil)
+
+	var pack bytes.Buffer
+	_, err = packfile.NewEncoder(&pack, s.work.Storer, false).Encode(hashes, 10, nil)
+	c.Assert(err, IsNil)
+	s.pack = pack.Bytes()
+}
+
+// push pushes the new commit to master, returning the status of the command.
+func (s *QuarantineSuite) push(c *C, o *server.Options) (string, error) {
+	srv, err := server.NewServerWithOptions(server.NewFilesystemLoader(osfs.New(s.base)), o)
+	c.Assert(err, IsNil)
+
+	r, err := srv.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = []*packp.Command{{Name: plumbing.Master, Old: s.commits[3], New: s.commit}}
+	req.Packfile = ioutil.NopCloser(bytes.NewReader(s.pack))
+
+	rs, err := r.ReceivePack(context.Background(), req)
+	c.Assert(rs, NotNil)
+	c.Assert(rs.UnpackStatus, Equals, "ok")
+	c.Assert(rs.CommandStatuses, HasLen, 1)
+	c.Assert(r.Close(), IsNil)
+
+	return rs.CommandStatuses[0].Status, err
+}
+
+// repository opens the repository.
+func (s *QuarantineSuite) repository(c *C) *git.Repository {
+	r, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+	return r
+}
+
+// master returns the hash of master in the repository.
+func (s *QuarantineSuite) master(c *C) string {
+	ref, err := s.repository(c).Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+	return ref.Hash().String()
+}
+
+// hasCommit returns true if the repository has the new commit.
+func (s *QuarantineSuite) hasCommit(c *C) bool {
+	return s.repository(c).Storer.HasEncodedObject(s.commit) == nil
+}
+
+// assertNoIncoming asserts that no quarantine is left in the repository.
+func (s *QuarantineSuite) assertNoIncoming(c *C) {
+	dirs, err := filepath.Glob(filepath.Join(s.base, "repo.git", "objects", "incoming-*"))
+	c.Assert(err, IsNil)
+	c.Assert(dirs, HasLen, 0)
+}
+
+func (s *QuarantineSuite) TestAccepted(c *C) {
+	status, err := s.push(c, &server.Options{DenyNonFastForwards: true})
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, "ok")
+
+	c.Assert(s.master(c), Equals, s.commit.String())
+	c.Assert(s.hasCommit(c), Equals, true)
+	s.assertNoIncoming(c)
+	s.git(c, filepath.Join(s.base, "repo.git"), nil, "fsck", "--strict")
+}
+
+func (s *QuarantineSuite) TestRejectedByPreReceive(c *C) {
+	var found error
+	hook := &quarantineHook{preReceive: func(env *server.HookEnv) error {
+		_, found = object.GetCommit(env.Storer, s.commit)
+		return errors.New("foo")
+	}}
+
+	status, err := s.push(c, &server.Options{ReceiveHook: hook})
+	c.Assert(err, ErrorMatches, "foo")
+	c.Assert(status, Equals, "foo")
+
+	c.Assert(found, IsNil)
+	c.Assert(s.master(c), Equals, s.commits[3].String())
+	c.Assert(s.hasCommit(c), Equals, false)
+	s.assertNoIncoming(c)
+}
+
+func (s *QuarantineSuite) TestRejectedByUpdate(c *C) {
+	var found error
+	hook := &quarantineHook{update: func(env *server.HookEnv) error {
+		_, found = object.GetCommit(env.Storer, s.commit)
+		return errors.New("foo")
+	}}
+
+	status, err := s.push(c, &server.Options{ReceiveHook: hook})
+	c.Assert(err, ErrorMatches, "foo")
+	c.Assert(status, Equals, "foo")
+
+	c.Assert(found, IsNil)
+	c.Assert(s.master(c), Equals, s.commits[3].String())
+	c.Assert(s.hasCommit(c), Equals, false)
+	s.assertNoIncoming(c)
+}
+
+// quarantineHook is a server.ReceiveHook running preReceive and update, if
+// any, as its pre-receive and update hooks.
+type quarantineHook struct {
+	preReceive func(*server.HookEnv) error
+	update     func(*server.HookEnv) error
+}
+
+func (h *quarantineHook) PreReceive(ctx context.Context, env *server.HookEnv, cmds []*packp.Command) error {
+	if h.preReceive == nil {
+		return nil
+	}
+
+	return h.preReceive(env)
+}
+
+func (h *quarantineHook) Update(ctx context.Context, env *server.HookEnv, cmd *packp.Command) error {
+	if h.update == nil {
+		return nil
+	}
+
+	return h.update(env)
+}
+
+func (h *quarantineHook) PostReceive(ctx context.Context, env *server.HookEnv, cmds []*packp.Command) {
+}
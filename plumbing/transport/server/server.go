@@ -7,12 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
-	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
-	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
@@ -34,6 +33,19 @@ func NewServer(loader Loader) transport.Transport {
 	}
 }
 
+// NewServerWithOptions returns a transport.Transport implementing a git
+// server, as NewServer does, configured with the given options.
+func NewServerWithOptions(loader Loader, o *Options) (transport.Transport, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &server{
+		loader,
+		&handler{asClient: false, opts: *o},
+	}, nil
+}
+
 // NewClient returns a transport.Transport implementing a client with an
 // embedded server.
 func NewClient(loader Loader) transport.Transport {
@@ -63,6 +75,7 @@ func (s *server) NewReceivePackSession(ep *transport.Endpoint, auth transport.Au
 
 type handler struct {
 	asClient bool
+	opts     Options
 }
 
 func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSession, error) {
@@ -74,6 +87,7 @@ func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSes
 func (h *handler) NewReceivePackSession(s storer.Storer) (transport.ReceivePackSession, error) {
 	return &rpSession{
 		session:   session{storer: s, asClient: h.asClient},
+		opts:      &h.opts,
 		cmdStatus: map[plumbing.ReferenceName]error{},
 	}, nil
 }
@@ -103,8 +117,28 @@ func (s *session) checkSupportedCapabilities(cl *capability.List) error {
 	return nil
 }
 
+// UploadPackSession is the transport.UploadPackSession of the servers, whose
+// requests can be served in steps, as the transports do to negotiate the
+// haves in rounds with the multi_ack and multi_ack_detailed capabilities: the
+// wants of the request are received first, then the haves of every round are
+// acknowledged until the client is done, or the server is ready with the
+// no-done capability, and finally UploadPack sends the packfile.
+type UploadPackSession interface {
+	transport.UploadPackSession
+	// ReceiveWants validates the wants of the request and returns the
+	// shallow update of the depth requested, which is sent before the
+	// negotiation, nil if no depth is requested.
+	ReceiveWants(req *packp.UploadRequest) (*packp.ShallowUpdate, error)
+	// Negotiate acknowledges the haves of a negotiation round of the request
+	// whose wants were received, returning the response to the round.
+	Negotiate(haves []plumbing.Hash) (*packp.RoundResponse, error)
+}
+
 type upSession struct {
 	session
+	// n is the negotiation of the request whose wants were received, nil
+	// until then and after UploadPack.
+	n *negotiation
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -131,13 +165,14 @@ func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 	return ar, nil
 }
 
-func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
-	if req.IsEmpty() {
-		return nil, transport.ErrEmptyUploadPackRequest
-	}
-
-	if err := req.Validate(); err != nil {
-		return nil, err
+// ReceiveWants validates the wants of the request and returns the shallow
+// update of the depth requested, nil if no depth is requested. The wants must
+// be reachable from the references, as allow-reachable-sha1-in-want allows.
+func (s *upSession) ReceiveWants(req *packp.UploadRequest) (*packp.ShallowUpdate, error) {
+	// the git clients request depths without the shallow capability, so only
+	// the wants are validated
+	if len(req.Wants) == 0 {
+		return nil, ErrNoWants
 	}
 
 	if s.caps == nil {
@@ -153,54 +188,176 @@ func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest
 
 	s.caps = req.Capabilities
 
-	if len(req.Shallows) > 0 {
-		return nil, fmt.Errorf("shallow not supported")
+	if err := s.checkWants(req.Wants); err != nil {
+		return nil, err
+	}
+
+	n, err := newNegotiation(s.storer, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.n = n
+	return n.update, nil
+}
+
+// Negotiate acknowledges the haves of a negotiation round, the way git does:
+// in the multi_ack_detailed mode the haves found are acknowledged as common
+// and, once every want reaches a common commit, the server is ready. In the
+// multi_ack mode all of them are acknowledged to continue. In any other mode
+// the round is only answered with a NAK, the first common have is
+// acknowledged once the client is done.
+func (s *upSession) Negotiate(haves []plumbing.Hash) (*packp.RoundResponse, error) {
+	if s.n == nil {
+		return nil, ErrWantsNotReceived
+	}
+
+	multiACK := s.caps.Supports(capability.MultiACK)
+	detailed := s.caps.Supports(capability.MultiACKDetailed)
+
+	res := &packp.RoundResponse{}
+	var gotCommon, gotOther bool
+	for _, h := range haves {
+		common, err := s.n.acknowledge(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if common {
+			gotCommon = true
+			switch {
+			case detailed:
+				res.ACKs = append(res.ACKs, packp.ACK{Hash: h, Status: packp.ACKCommon})
+			case multiACK:
+				res.ACKs = append(res.ACKs, packp.ACK{Hash: h, Status: packp.ACKContinue})
+			}
+
+			continue
+		}
+
+		gotOther = true
+		if !detailed && !multiACK {
+			continue
+		}
+
+		ok, err := s.n.okToGiveUp()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if detailed {
+			s.n.ready = true
+			res.ACKs = append(res.ACKs, packp.ACK{Hash: h, Status: packp.ACKReady})
+		} else {
+			res.ACKs = append(res.ACKs, packp.ACK{Hash: h, Status: packp.ACKContinue})
+		}
+	}
+
+	if detailed && gotCommon && !gotOther {
+		ok, err := s.n.okToGiveUp()
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			s.n.ready = true
+			res.ACKs = append(res.ACKs, packp.ACK{Hash: s.n.last, Status: packp.ACKReady})
+		}
+	}
+
+	res.Ready = s.n.ready
+	return res, nil
+}
+
+func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	if req.IsEmpty() {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+
+	if s.n == nil {
+		if _, err := s.ReceiveWants(&req.UploadRequest); err != nil {
+			return nil, err
+		}
+	}
+
+	n := s.n
+	s.n = nil
+
+	for _, h := range req.Haves {
+		if _, err := n.acknowledge(h); err != nil {
+			return nil, err
+		}
 	}
 
-	objs, err := s.objectsToUpload(req)
+	objs, err := s.objectsToUpload(n)
 	if err != nil {
 		return nil, err
 	}
 
 	pr, pw := io.Pipe()
-	e := packfile.NewEncoder(pw, s.storer, false)
 	go func() {
-		// TODO: plumb through a pack window.
-		_, err := e.Encode(objs, 10, nil)
-		pw.CloseWithError(err)
+		pw.CloseWithError(s.encodePackfile(pw, objs))
 	}()
 
-	return packp.NewUploadPackResponseWithPackfile(req,
+	resp := packp.NewUploadPackResponseWithPackfile(req,
 		ioutil.NewContextReadCloser(ctx, pr),
-	), nil
-}
+	)
 
-func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Hash, error) {
-	haves, err := revlist.Objects(s.storer, req.Haves, nil, nil)
-	if err != nil {
-		return nil, err
+	if n.update != nil {
+		resp.ShallowUpdate = *n.update
 	}
 
-	return revlist.Objects(s.storer, req.Wants, haves, nil)
+	if h := n.acknowledged(s.caps); !h.IsZero() {
+		resp.ACKs = []plumbing.Hash{h}
+	}
+
+	return resp, nil
 }
 
 func (*upSession) setSupportedCapabilities(c *capability.List) error {
-	if err := c.Set(capability.Agent, capability.DefaultAgent); err != nil {
-		return err
+	for _, name := range []capability.Capability{
+		capability.MultiACK,
+		capability.MultiACKDetailed,
+		capability.NoDone,
+		capability.Sideband,
+		capability.Sideband64k,
+		capability.OFSDelta,
+		capability.Shallow,
+		capability.DeepenSince,
+		capability.DeepenNot,
+		capability.DeepenRelative,
+		capability.NoProgress,
+		capability.IncludeTag,
+		capability.AllowReachableSHA1InWant,
+	} {
+		if err := c.Set(name); err != nil {
+			return err
+		}
 	}
 
-	if err := c.Set(capability.OFSDelta); err != nil {
-		return err
-	}
+	return c.Set(capability.Agent, capability.DefaultAgent)
+}
 
-	return nil
+// ReceivePackSession is the transport.ReceivePackSession of the servers,
+// giving access to the push certificate verified by ReceivePack.
+type ReceivePackSession interface {
+	transport.ReceivePackSession
+	// PushCertificate returns the push certificate verified by the last
+	// ReceivePack, nil if the push wasn't signed.
+	PushCertificate() *PushCertificate
 }
 
 type rpSession struct {
 	session
+	opts      *Options
 	cmdStatus map[plumbing.ReferenceName]error
 	firstErr  error
 	unpackErr error
+	cert      *PushCertificate
 }
 
 func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -210,6 +367,13 @@ func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 		return nil, err
 	}
 
+	if s.opts.PushCertKeyRing != "" {
+		nonce := s.opts.nonce(time.Now())
+		if err := ar.Capabilities.Set(capability.PushCert, nonce); err != nil {
+			return nil, err
+		}
+	}
+
 	s.caps = ar.Capabilities
 
 	if err := setReferences(s.storer, ar); err != nil {
@@ -241,74 +405,226 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 
 	s.caps = req.Capabilities
 
+	cert, err := s.verifyCertificate(req)
+	if err != nil {
+		if req.Packfile != nil {
+			_ = req.Packfile.Close()
+		}
+
+		for _, cmd := range req.Commands {
+			s.setStatus(cmd.Name, err)
+		}
+
+		return s.reportStatus(), err
+	}
+
+	s.cert = cert
+
 	//TODO: Implement 'atomic' update of references.
 
-	r := ioutil.NewContextReadCloser(ctx, req.Packfile)
-	if err := s.writePackfile(r); err != nil {
+	var r io.ReadCloser
+	if req.Packfile != nil && sendsPackfile(req) {
+		r = ioutil.NewContextReadCloser(ctx, req.Packfile)
+	}
+
+	q, err := s.writePackfile(r)
+	if err != nil {
 		s.unpackErr = err
 		s.firstErr = err
 		return s.reportStatus(), err
 	}
 
-	s.updateReferences(req)
+	env := &HookEnv{Storer: s.storer, PushCertificate: s.cert}
+	if q != nil {
+		env.Storer = &quarantineStorer{q, s.storer}
+	}
+
+	accepted, err := s.checkCommands(ctx, env, req)
+	if q != nil && err == nil && len(accepted) != 0 {
+		if err = q.Migrate(); err != nil {
+			_ = q.Discard()
+			for _, cmd := range accepted {
+				s.setStatus(cmd.Name, err)
+			}
+		}
+	} else if q != nil {
+		_ = q.Discard()
+	}
+
+	if err != nil {
+		return s.reportStatus(), err
+	}
+
+	updated := s.updateReferences(accepted)
+	if hook := s.opts.ReceiveHook; hook != nil && len(updated) != 0 {
+		env = &HookEnv{Storer: s.storer, PushCertificate: s.cert}
+		hook.PostReceive(ctx, env, updated)
+	}
+
 	return s.reportStatus(), s.firstErr
 }
 
-func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
+// PushCertificate returns the push certificate verified by the last
+// ReceivePack, nil if the push wasn't signed.
+func (s *rpSession) PushCertificate() *PushCertificate {
+	return s.cert
+}
+
+// verifyCertificate verifies the push certificate of the request, if any,
+// with the key ring of the options, returning ErrPushCertRequired if the
+// options require one and the request has none.
+func (s *rpSession) verifyCertificate(req *packp.ReferenceUpdateRequest) (*PushCertificate, error) {
+	if req.Certificate == nil {
+		if s.opts.RequirePushCert {
+			return nil, ErrPushCertRequired
+		}
+
+		return nil, nil
+	}
+
+	if s.opts.PushCertKeyRing == "" {
+		return nil, ErrPushCertNotSupported
+	}
+
+	if !s.opts.validNonce(req.Certificate.Nonce, time.Now()) {
+		return nil, ErrInvalidPushCertNonce
+	}
+
+	signer, err := req.Certificate.Verify(s.opts.PushCertKeyRing)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PushCertificate{PushCertificate: req.Certificate, Signer: signer}, nil
+}
+
+// checkCommands returns the commands of the request allowed by the pre-receive
+// hook, the policies of the options and the update hook, setting the status of
+// the ones rejected. None of them is if the pre-receive hook returns an error.
+func (s *rpSession) checkCommands(ctx context.Context, env *HookEnv, req *packp.ReferenceUpdateRequest) ([]*packp.Command, error) {
+	if hook := s.opts.ReceiveHook; hook != nil {
+		if err := hook.PreReceive(ctx, env, req.Commands); err != nil {
+			for _, cmd := range req.Commands {
+				s.setStatus(cmd.Name, err)
+			}
+
+			return nil, err
+		}
+	}
+
+	var accepted []*packp.Command
 	for _, cmd := range req.Commands {
+		if cmd.Action() == packp.Invalid {
+			continue
+		}
+
 		exists, err := referenceExists(s.storer, cmd.Name)
 		if err != nil {
 			s.setStatus(cmd.Name, err)
 			continue
 		}
 
-		switch cmd.Action() {
-		case packp.Create:
-			if exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
-			}
+		if !isValidAction(cmd.Action(), exists) {
+			s.setStatus(cmd.Name, ErrUpdateReference)
+			continue
+		}
 
-			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
+		if err := s.checkCommand(ctx, env, cmd); err != nil {
 			s.setStatus(cmd.Name, err)
-		case packp.Delete:
-			if !exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
-			}
+			continue
+		}
 
-			err := s.storer.RemoveReference(cmd.Name)
-			s.setStatus(cmd.Name, err)
-		case packp.Update:
-			if !exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
-			}
+		accepted = append(accepted, cmd)
+	}
 
-			if err != nil {
-				s.setStatus(cmd.Name, err)
-				continue
-			}
+	return accepted, nil
+}
 
-			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
-			s.setStatus(cmd.Name, err)
+// updateReferences updates the references of the given commands, returning
+// the ones updated.
+func (s *rpSession) updateReferences(cmds []*packp.Command) []*packp.Command {
+	var updated []*packp.Command
+	for _, cmd := range cmds {
+		var err error
+		if cmd.Action() == packp.Delete {
+			err = s.storer.RemoveReference(cmd.Name)
+		} else {
+			err = s.storer.SetReference(plumbing.NewHashReference(cmd.Name, cmd.New))
+		}
+
+		s.setStatus(cmd.Name, err)
+		if err == nil {
+			updated = append(updated, cmd)
 		}
 	}
+
+	return updated
 }
 
-func (s *rpSession) writePackfile(r io.ReadCloser) error {
-	if r == nil {
+// isValidAction returns true if the given action applies to a reference,
+// depending on whether it exists.
+func isValidAction(action packp.Action, exists bool) bool {
+	if action == packp.Create {
+		return !exists
+	}
+
+	return exists
+}
+
+// checkCommand returns the error rejecting the given command by the policies
+// of the options or the update hook, if any.
+func (s *rpSession) checkCommand(ctx context.Context, env *HookEnv, cmd *packp.Command) error {
+	if err := s.opts.checkPolicies(env.Storer, cmd); err != nil {
+		return err
+	}
+
+	if s.opts.ReceiveHook == nil {
 		return nil
 	}
 
-	if err := packfile.UpdateObjectStorage(s.storer, r, nil); err != nil {
+	return s.opts.ReceiveHook.Update(ctx, env, cmd)
+}
+
+// sendsPackfile returns true if the request is followed by a packfile, which
+// the clients don't send when all the commands delete references.
+func sendsPackfile(req *packp.ReferenceUpdateRequest) bool {
+	for _, cmd := range req.Commands {
+		if cmd.Action() != packp.Delete {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writePackfile writes the given packfile, if any, to a new quarantine of the
+// storer, which is returned, or straight to the storer if it doesn't support
+// quarantines.
+func (s *rpSession) writePackfile(r io.ReadCloser) (storer.Quarantine, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	q, err := newQuarantine(s.storer)
+	if err != nil {
 		_ = r.Close()
-		return err
+		return nil, err
+	}
+
+	err = updateObjectStorage(s.storer, q, r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		if q != nil {
+			_ = q.Discard()
+		}
+
+		return nil, err
 	}
 
-	return r.Close()
+	return q, nil
 }
 
 func (s *rpSession) setStatus(ref plumbing.ReferenceName, err error) {
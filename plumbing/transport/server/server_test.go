@@ -1,8 +1,16 @@
 package server_test
 
 import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
@@ -11,6 +19,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/storage/memory"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/util"
 	"gopkg.in/src-d/go-git-fixtures.v3"
 )
 
@@ -63,3 +72,104 @@ func (s *BaseSuite) prepareRepositories(c *C) {
 	s.NonExistentEndpoint, err = transport.NewEndpoint("/non-existent.git")
 	c.Assert(err, IsNil)
 }
+
+// GitBaseSuite serves a bare repository built with go-git, at /repo.git: a
+// history of four commits, one a day since 2019-01-01, the second of them
+// tagged by the annotated tag v1. The history is committed in the work
+// repository and then copied to the served one.
+type GitBaseSuite struct {
+	base     string
+	work     *git.Repository
+	endpoint *transport.Endpoint
+	commits  []plumbing.Hash
+	tag      plumbing.Hash
+}
+
+func (s *GitBaseSuite) SetUpTest(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	s.base = c.MkDir()
+	var err error
+	s.work, err = git.PlainInit(filepath.Join(s.base, "work"), false)
+	c.Assert(err, IsNil)
+
+	s.commits = nil
+	for i, name := range []string{"foo", "bar", "baz", "qux"} {
+		s.commits = append(s.commits, s.commit(c, name, name, signature(i)))
+	}
+
+	ref, err := s.work.CreateTag("v1", s.commits[1], &git.CreateTagOptions{
+		Tagger:  signature(1),
+		Message: "v1",
+	})
+	c.Assert(err, IsNil)
+	s.tag = ref.Hash()
+
+	_, err = git.PlainInit(filepath.Join(s.base, "repo.git"), true)
+	c.Assert(err, IsNil)
+	s.publish(c)
+
+	s.endpoint, err = transport.NewEndpoint("/repo.git")
+	c.Assert(err, IsNil)
+}
+
+// signature returns the signature of the i-th day since 2019-01-01.
+func signature(i int) *object.Signature {
+	return &object.Signature{
+		Name:  "foo",
+		Email: "foo@foo.foo",
+		When:  time.Date(2019, 1, i+1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// commit writes the file name with the given content to the work repository
+// and commits it, returning the hash of the commit.
+func (s *GitBaseSuite) commit(c *C, name, content string, sig *object.Signature) plumbing.Hash {
+	w, err := s.work.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(w.Filesystem, name, []byte(content), 0644), IsNil)
+	_, err = w.Add(name)
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit(name, &git.CommitOptions{Author: sig})
+	c.Assert(err, IsNil)
+	return h
+}
+
+// publish copies the objects and the references of the work repository to
+// the served one.
+func (s *GitBaseSuite) publish(c *C) {
+	r, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+
+	objects, err := s.work.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	c.Assert(objects.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := r.Storer.SetEncodedObject(obj)
+		return err
+	}), IsNil)
+
+	refs, err := s.work.References()
+	c.Assert(err, IsNil)
+	c.Assert(refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		return r.Storer.SetReference(ref)
+	}), IsNil)
+}
+
+func (s *GitBaseSuite) git(c *C, dir string, env []string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=foo", "-c", "user.email=foo@foo.foo",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrNoShallowCommits is returned when the deepen-since and deepen-not
+	// of a request select none of the commits wanted.
+	ErrNoShallowCommits = errors.New("no commits selected for shallow requests")
+	// ErrDeepenRelativeRevisions is returned when deepen-relative is
+	// requested along with deepen-since or deepen-not.
+	ErrDeepenRelativeRevisions = errors.New("deepen-relative not supported with deepen-since and deepen-not")
+)
+
+// shallowState are the boundaries of the histories of the client and of the
+// packfile sent to it, their commits are sent without their parents.
+type shallowState struct {
+	// update is the shallow update of the depth requested, nil if none.
+	update *packp.ShallowUpdate
+	// clientShallows are the shallow commits of the client found in the
+	// repository.
+	clientShallows map[plumbing.Hash]bool
+	// clientBoundary are the commits whose parents the client doesn't have:
+	// its shallow commits, along with the ones of the repository.
+	clientBoundary map[plumbing.Hash]bool
+	// boundary are the commits whose parents aren't sent: the shallow
+	// commits of the client once it receives the packfile, along with the
+	// ones of the repository.
+	boundary map[plumbing.Hash]bool
+	// deepened are the parents of the commits unshallowed, which are sent
+	// even if the client has their children.
+	deepened []plumbing.Hash
+}
+
+// deepen computes the boundaries of the request: the shallow commits of the
+// client, unless a depth is requested. Then, as git does, the commits within
+// the depth are the new boundary, the ones beyond it are the shallow commits
+// sent and the shallow commits of the client within it are unshallowed.
+func (n *negotiation) deepen() error {
+	shallows, err := repositoryShallows(n.storer)
+	if err != nil {
+		return err
+	}
+
+	n.clientShallows = make(map[plumbing.Hash]bool)
+	for _, h := range n.req.Shallows {
+		o, err := n.storer.EncodedObject(plumbing.AnyObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if o.Type() != plumbing.CommitObject {
+			return fmt.Errorf("invalid shallow object %s", h)
+		}
+
+		n.clientShallows[h] = true
+	}
+
+	n.clientBoundary = union(n.clientShallows, shallows)
+	n.boundary = n.clientBoundary
+	if n.req.Depth.IsZero() {
+		return nil
+	}
+
+	relative := n.req.Capabilities.Supports(capability.DeepenRelative)
+	var interior, boundary map[plumbing.Hash]bool
+	switch depth := n.req.Depth.(type) {
+	case packp.DepthCommits:
+		from, d := n.wants, int(depth)
+		if relative {
+			from, d = hashes(n.clientShallows), d+1
+		}
+
+		interior, boundary, err = depthCommits(n.storer, from, d, shallows)
+	case packp.DepthSince:
+		interior, boundary, err = n.revisionCommits(time.Time(depth), nil, shallows)
+	case packp.DepthReference:
+		interior, boundary, err = n.revisionCommits(time.Time{}, []string{string(depth)}, shallows)
+	case packp.DepthRevisions:
+		interior, boundary, err = n.revisionCommits(depth.Since, depth.Not, shallows)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	n.update = &packp.ShallowUpdate{}
+	for h := range boundary {
+		if !n.clientShallows[h] {
+			n.update.Shallows = append(n.update.Shallows, h)
+		}
+	}
+
+	n.boundary = union(boundary, shallows)
+	for h := range n.clientShallows {
+		if !interior[h] {
+			n.boundary[h] = true
+			continue
+		}
+
+		n.update.Unshallows = append(n.update.Unshallows, h)
+		c, err := object.GetCommit(n.storer, h)
+		if err != nil {
+			return err
+		}
+
+		n.deepened = append(n.deepened, c.ParentHashes...)
+	}
+
+	plumbing.HashesSort(n.update.Shallows)
+	plumbing.HashesSort(n.update.Unshallows)
+	return nil
+}
+
+// revisionCommits returns the commits reachable from the wants newer than
+// since, unless it's zero, and not reachable from the given references: the
+// interior ones, whose parents are among them, and the boundary ones.
+func (n *negotiation) revisionCommits(since time.Time, not []string, shallows map[plumbing.Hash]bool) (interior, boundary map[plumbing.Hash]bool, err error) {
+	if n.req.Capabilities.Supports(capability.DeepenRelative) {
+		return nil, nil, ErrDeepenRelativeRevisions
+	}
+
+	var refs []plumbing.Hash
+	for _, name := range not {
+		ref, err := resolveReference(n.storer, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		refs = append(refs, ref.Hash())
+	}
+
+	commits, _, _, err := splitCommits(n.storer, refs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	excluded, err := walkCommits(n.storer, commits, shallows, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	included := make(map[plumbing.Hash]*object.Commit)
+	pending := append([]plumbing.Hash(nil), n.wants...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := included[h]; ok {
+			continue
+		}
+
+		if _, ok := excluded[h]; ok {
+			continue
+		}
+
+		c, err := object.GetCommit(n.storer, h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !since.IsZero() && c.Committer.When.Before(since) {
+			continue
+		}
+
+		included[h] = c
+		if !shallows[h] {
+			pending = append(pending, c.ParentHashes...)
+		}
+	}
+
+	if len(included) == 0 {
+		return nil, nil, ErrNoShallowCommits
+	}
+
+	interior = make(map[plumbing.Hash]bool)
+	boundary = make(map[plumbing.Hash]bool)
+	for h, c := range included {
+		isBoundary := shallows[h]
+		for _, p := range c.ParentHashes {
+			if _, ok := included[p]; !ok {
+				isBoundary = true
+			}
+		}
+
+		if isBoundary {
+			boundary[h] = true
+		} else {
+			interior[h] = true
+		}
+	}
+
+	return interior, boundary, nil
+}
+
+// depthCommits returns the commits of the history of the given ones up to the
+// given depth, which starts at 1 for them: the interior ones, whose parents
+// are within the depth, and the boundary ones, at the depth or shallow in the
+// repository.
+func depthCommits(s storer.EncodedObjectStorer, from []plumbing.Hash, depth int, shallows map[plumbing.Hash]bool) (interior, boundary map[plumbing.Hash]bool, err error) {
+	interior = make(map[plumbing.Hash]bool)
+	boundary = make(map[plumbing.Hash]bool)
+	level := from
+	for d := 1; len(level) > 0; d++ {
+		var next []plumbing.Hash
+		for _, h := range level {
+			if interior[h] || boundary[h] {
+				continue
+			}
+
+			if d >= depth || shallows[h] {
+				boundary[h] = true
+				continue
+			}
+
+			c, err := object.GetCommit(s, h)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			interior[h] = true
+			next = append(next, c.ParentHashes...)
+		}
+
+		level = next
+	}
+
+	return interior, boundary, nil
+}
+
+// repositoryShallows returns the shallow commits of the repository of the
+// storer, if it's a shallow one.
+func repositoryShallows(s storer.Storer) (map[plumbing.Hash]bool, error) {
+	ss, ok := s.(storer.ShallowStorer)
+	if !ok {
+		return nil, nil
+	}
+
+	shallows, err := ss.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	return hashSet(shallows), nil
+}
+
+// resolveReference resolves the given reference, which can be shortened as
+// git allows.
+func resolveReference(s storer.ReferenceStorer, name string) (*plumbing.Reference, error) {
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		ref, err := storer.ResolveReference(s, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		}
+
+		return ref, err
+	}
+
+	return nil, fmt.Errorf("deepen-not reference not found: %s", name)
+}
+
+func union(a, b map[plumbing.Hash]bool) map[plumbing.Hash]bool {
+	u := make(map[plumbing.Hash]bool, len(a)+len(b))
+	for h := range a {
+		u[h] = true
+	}
+
+	for h := range b {
+		u[h] = true
+	}
+
+	return u
+}
+
+func hashes(set map[plumbing.Hash]bool) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	for h := range set {
+		hashes = append(hashes, h)
+	}
+
+	return hashes
+}
@@ -0,0 +1,91 @@
+package server_test
+
+import (
+	"math"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *GitUploadPackSuite) TestReceiveWantsDepth(c *C) {
+	r := s.newSession(c)
+	req := s.newRequest(c, capability.Shallow)
+	req.Depth = packp.DepthCommits(2)
+
+	su, err := r.ReceiveWants(&req.UploadRequest)
+	c.Assert(err, IsNil)
+	c.Assert(su.Shallows, DeepEquals, []plumbing.Hash{s.commits[2]})
+	c.Assert(su.Unshallows, HasLen, 0)
+
+	sto := s.uploadPack(c, r, req)
+	c.Assert(sto.HasEncodedObject(s.commits[2]), IsNil)
+	c.Assert(sto.HasEncodedObject(s.commits[1]), Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *GitUploadPackSuite) TestReceiveWantsDeepenRelative(c *C) {
+	r := s.newSession(c)
+	req := s.newRequest(c, capability.Shallow, capability.DeepenRelative)
+	req.Shallows = []plumbing.Hash{s.commits[2]}
+	req.Haves = []plumbing.Hash{s.commits[3]}
+	req.Depth = packp.DepthCommits(1)
+
+	su, err := r.ReceiveWants(&req.UploadRequest)
+	c.Assert(err, IsNil)
+	c.Assert(su.Shallows, DeepEquals, []plumbing.Hash{s.commits[1]})
+	c.Assert(su.Unshallows, DeepEquals, []plumbing.Hash{s.commits[2]})
+
+	sto := s.uploadPack(c, r, req)
+	c.Assert(sto.HasEncodedObject(s.commits[1]), IsNil)
+	c.Assert(sto.HasEncodedObject(s.commits[0]), Equals, plumbing.ErrObjectNotFound)
+	c.Assert(sto.HasEncodedObject(s.commits[3]), Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *GitUploadPackSuite) TestReceiveWantsUnshallow(c *C) {
+	req := s.newRequest(c, capability.Shallow)
+	req.Shallows = []plumbing.Hash{s.commits[2]}
+	req.Depth = packp.DepthCommits(math.MaxInt32)
+
+	su, err := s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, IsNil)
+	c.Assert(su.Shallows, HasLen, 0)
+	c.Assert(su.Unshallows, DeepEquals, []plumbing.Hash{s.commits[2]})
+}
+
+func (s *GitUploadPackSuite) TestReceiveWantsDeepenSince(c *C) {
+	req := s.newRequest(c, capability.DeepenSince)
+	req.Depth = packp.DepthSince(time.Date(2019, 1, 3, 0, 0, 0, 0, time.UTC))
+
+	su, err := s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, IsNil)
+	c.Assert(su.Shallows, DeepEquals, []plumbing.Hash{s.commits[2]})
+
+	req.Depth = packp.DepthSince(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, err = s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, Equals, server.ErrNoShallowCommits)
+}
+
+func (s *GitUploadPackSuite) TestReceiveWantsDeepenNot(c *C) {
+	req := s.newRequest(c, capability.DeepenNot)
+	req.Depth = packp.DepthReference("v1")
+
+	su, err := s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, IsNil)
+	c.Assert(su.Shallows, DeepEquals, []plumbing.Hash{s.commits[2]})
+
+	req.Depth = packp.DepthReference("foo")
+	_, err = s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, ErrorMatches, "deepen-not reference not found: foo")
+}
+
+func (s *GitUploadPackSuite) TestReceiveWantsDeepenRelativeRevisions(c *C) {
+	req := s.newRequest(c, capability.DeepenNot, capability.DeepenRelative)
+	req.Depth = packp.DepthReference("v1")
+
+	_, err := s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, Equals, server.ErrDeepenRelativeRevisions)
+}
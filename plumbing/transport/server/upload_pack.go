@@ -0,0 +1,553 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrNoWants is returned by UploadPackSession.ReceiveWants when the
+	// request has no wants.
+	ErrNoWants = errors.New("want can't be empty")
+	// ErrWantsNotReceived is returned by UploadPackSession.Negotiate when
+	// the wants of the request weren't received.
+	ErrWantsNotReceived = errors.New("wants not received")
+)
+
+// packWindow is the window of the delta selection of the packfiles sent.
+const packWindow = 10
+
+// negotiation is the state of an upload-pack request: its wants, the
+// boundaries of the histories of the client and of the packfile, and the
+// haves acknowledged so far.
+type negotiation struct {
+	storer storer.Storer
+	req    *packp.UploadRequest
+	// wants are the commits wanted, once the tags are peeled.
+	wants []plumbing.Hash
+
+	shallowState
+
+	// common are the haves found in the repository.
+	common map[plumbing.Hash]bool
+	// theyHave are the common commits, along with their parents.
+	theyHave    map[plumbing.Hash]bool
+	first, last plumbing.Hash
+	// oldest is the date of the oldest common commit, older commits aren't
+	// walked looking for the common ones.
+	oldest time.Time
+	// ready is true once the server acknowledged it's ready to send the
+	// packfile.
+	ready bool
+	// canGiveUp caches okToGiveUp, which can't be false again once true,
+	// checked is the number of haves found when it was false.
+	canGiveUp bool
+	checked   int
+}
+
+func newNegotiation(s storer.Storer, req *packp.UploadRequest) (*negotiation, error) {
+	wants, _, _, err := splitCommits(s, req.Wants)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &negotiation{
+		storer:   s,
+		req:      req,
+		wants:    wants,
+		common:   make(map[plumbing.Hash]bool),
+		theyHave: make(map[plumbing.Hash]bool),
+	}
+
+	if err := n.deepen(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// acknowledge adds the given have to the common ones, returning false if it
+// isn't found in the repository.
+func (n *negotiation) acknowledge(h plumbing.Hash) (bool, error) {
+	if n.common[h] {
+		n.last = h
+		return true, nil
+	}
+
+	o, err := n.storer.EncodedObject(plumbing.AnyObject, h)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if o.Type() == plumbing.CommitObject {
+		c, err := object.DecodeCommit(n.storer, o)
+		if err != nil {
+			return false, err
+		}
+
+		n.theyHave[h] = true
+		for _, p := range c.ParentHashes {
+			n.theyHave[p] = true
+		}
+
+		if n.oldest.IsZero() || c.Committer.When.Before(n.oldest) {
+			n.oldest = c.Committer.When
+		}
+	}
+
+	n.common[h] = true
+	if n.first.IsZero() {
+		n.first = h
+	}
+
+	n.last = h
+	return true, nil
+}
+
+// acknowledged returns the have acknowledged once the client is done: the
+// last common one in the multi_ack modes, the first one otherwise, or the
+// zero hash if none was found.
+func (n *negotiation) acknowledged(caps *capability.List) plumbing.Hash {
+	if caps.Supports(capability.MultiACK) || caps.Supports(capability.MultiACKDetailed) {
+		return n.last
+	}
+
+	return n.first
+}
+
+// okToGiveUp returns true if every want reaches a common commit, walking the
+// commits no older than the oldest common one, so the client can stop sending
+// haves.
+func (n *negotiation) okToGiveUp() (bool, error) {
+	if n.canGiveUp {
+		return true, nil
+	}
+
+	if len(n.common) == 0 || n.checked == len(n.common) {
+		return false, nil
+	}
+
+	n.checked = len(n.common)
+	for _, w := range n.wants {
+		ok, err := n.reachesCommon(w)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	n.canGiveUp = true
+	return true, nil
+}
+
+func (n *negotiation) reachesCommon(h plumbing.Hash) (bool, error) {
+	pending := []plumbing.Hash{h}
+	seen := make(map[plumbing.Hash]bool)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		if n.theyHave[h] {
+			return true, nil
+		}
+
+		c, err := object.GetCommit(n.storer, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if c.Committer.When.Before(n.oldest) {
+			continue
+		}
+
+		pending = append(pending, c.ParentHashes...)
+	}
+
+	return false, nil
+}
+
+// checkWants returns an error if any of the wants isn't reachable from the
+// references of the repository.
+func (s *upSession) checkWants(wants []plumbing.Hash) error {
+	var tips []plumbing.Hash
+	isTip := make(map[plumbing.Hash]bool)
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && !isTip[ref.Hash()] {
+			isTip[ref.Hash()] = true
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var pending []plumbing.Hash
+	for _, h := range wants {
+		if !isTip[h] {
+			pending = append(pending, h)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	commits, tags, others, err := splitCommits(s.storer, tips)
+	if err != nil {
+		return err
+	}
+
+	shallows, err := repositoryShallows(s.storer)
+	if err != nil {
+		return err
+	}
+
+	reachable, err := walkCommits(s.storer, commits, shallows, nil)
+	if err != nil {
+		return err
+	}
+
+	var objs map[plumbing.Hash]bool
+	for _, h := range pending {
+		if _, ok := reachable[h]; ok {
+			continue
+		}
+
+		if objs == nil {
+			hashes, err := revlist.Objects(s.storer, append(trees(reachable), others...), nil, nil)
+			if err != nil {
+				return err
+			}
+
+			objs = hashSet(append(hashes, tags...))
+		}
+
+		if !objs[h] {
+			return fmt.Errorf("not our ref %s", h)
+		}
+	}
+
+	return nil
+}
+
+// objectsToUpload returns the objects reachable from the wants of the
+// request, within the boundary of its depth, the client doesn't have: the
+// ones not reachable from the common haves. The annotated tags pointing to
+// them are included with the include-tag capability.
+func (s *upSession) objectsToUpload(n *negotiation) ([]plumbing.Hash, error) {
+	commits, haveTags, haveOthers, err := splitCommits(s.storer, hashes(n.common))
+	if err != nil {
+		return nil, err
+	}
+
+	has, err := walkCommits(s.storer, commits, n.clientBoundary, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	wants := append(append([]plumbing.Hash(nil), n.req.Wants...), n.deepened...)
+	commits, tags, others, err := splitCommits(s.storer, wants)
+	if err != nil {
+		return nil, err
+	}
+
+	sends, err := walkCommits(s.storer, commits, n.boundary, has)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := revlist.Objects(s.storer,
+		append(trees(sends), others...),
+		append(trees(has), haveOthers...),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range sends {
+		objs = append(objs, h)
+	}
+
+	excluded := hashSet(haveTags)
+	for _, h := range tags {
+		if !excluded[h] {
+			excluded[h] = true
+			objs = append(objs, h)
+		}
+	}
+
+	if !s.caps.Supports(capability.IncludeTag) {
+		return objs, nil
+	}
+
+	return s.includeTags(objs, excluded)
+}
+
+// includeTags adds to the objects sent the annotated tags of the repository
+// pointing to any of them, but the excluded ones.
+func (s *upSession) includeTags(objs []plumbing.Hash, excluded map[plumbing.Hash]bool) ([]plumbing.Hash, error) {
+	sent := hashSet(objs)
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !ref.Name().IsTag() || sent[ref.Hash()] {
+			return nil
+		}
+
+		chain, target, err := peelTag(s.storer, ref.Hash())
+		if err != nil || !sent[target] {
+			return err
+		}
+
+		for _, h := range chain {
+			if !sent[h] && !excluded[h] {
+				sent[h] = true
+				objs = append(objs, h)
+			}
+		}
+
+		return nil
+	})
+
+	return objs, err
+}
+
+// encodePackfile writes the packfile of the given objects, multiplexed along
+// with the progress messages with the side-band capabilities.
+func (s *upSession) encodePackfile(w io.Writer, objs []plumbing.Hash) error {
+	var t sideband.Type
+	switch {
+	case s.caps.Supports(capability.Sideband64k):
+		t = sideband.Sideband64k
+	case s.caps.Supports(capability.Sideband):
+		t = sideband.Sideband
+	default:
+		_, err := packfile.NewEncoder(w, s.storer, false).Encode(objs, packWindow, nil)
+		return err
+	}
+
+	m := &muxer{m: sideband.NewMuxer(t, w)}
+	var status chan plumbing.StatusUpdate
+	done := make(chan struct{})
+	if s.caps.Supports(capability.NoProgress) {
+		close(done)
+	} else {
+		progress := m.Channel(sideband.ProgressMessage)
+		fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objs))
+
+		status = make(chan plumbing.StatusUpdate)
+		go func() {
+			writeProgress(progress, status)
+			close(done)
+		}()
+	}
+
+	_, err := packfile.NewEncoder(m, s.storer, false).Encode(objs, packWindow, status)
+	if status != nil {
+		close(status)
+	}
+
+	<-done
+	if err != nil {
+		_, _ = m.Channel(sideband.ErrorMessage).Write([]byte(err.Error()))
+		return err
+	}
+
+	_, err = w.Write(pktline.FlushPkt)
+	return err
+}
+
+// progressTitles are the titles of the progress messages of the stages of
+// the packfile encoding.
+var progressTitles = map[plumbing.StatusStage]string{
+	plumbing.StatusRead:  "Counting objects",
+	plumbing.StatusDelta: "Compressing objects",
+}
+
+// writeProgress writes the progress messages of the status updates, the way
+// git does, until the channel is closed.
+func writeProgress(w io.Writer, status <-chan plumbing.StatusUpdate) {
+	stage := plumbing.StatusUnknown
+	percent := -1
+	for u := range status {
+		title, ok := progressTitles[u.Stage]
+		if !ok || u.ObjectsTotal == 0 {
+			continue
+		}
+
+		p := u.ObjectsDone * 100 / u.ObjectsTotal
+		if u.Stage == stage && p == percent {
+			continue
+		}
+
+		stage, percent = u.Stage, p
+		eol := "\r"
+		if u.ObjectsDone == u.ObjectsTotal {
+			eol = ", done.\n"
+		}
+
+		fmt.Fprintf(w, "%s: %3d%% (%d/%d)%s", title, p, u.ObjectsDone, u.ObjectsTotal, eol)
+	}
+}
+
+// muxer is a sideband.Muxer whose channels can be written concurrently.
+type muxer struct {
+	mu sync.Mutex
+	m  *sideband.Muxer
+}
+
+// Write writes p in the PackData channel.
+func (m *muxer) Write(p []byte) (int, error) {
+	return m.write(sideband.PackData, p)
+}
+
+// Channel returns a writer of the given channel.
+func (m *muxer) Channel(ch sideband.Channel) io.Writer {
+	return channelWriter(func(p []byte) (int, error) {
+		return m.write(ch, p)
+	})
+}
+
+func (m *muxer) write(ch sideband.Channel, p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.m.WriteChannel(ch, p)
+}
+
+type channelWriter func(p []byte) (int, error)
+
+func (w channelWriter) Write(p []byte) (int, error) {
+	return w(p)
+}
+
+// splitCommits splits the given objects into commits, tags and any other
+// objects, peeling the tags: their targets are split as well.
+func splitCommits(s storer.EncodedObjectStorer, hashes []plumbing.Hash) (commits, tags, others []plumbing.Hash, err error) {
+	for _, h := range hashes {
+		chain, target, err := peelTag(s, h)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		tags = append(tags, chain...)
+		o, err := s.EncodedObject(plumbing.AnyObject, target)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if o.Type() == plumbing.CommitObject {
+			commits = append(commits, target)
+		} else {
+			others = append(others, target)
+		}
+	}
+
+	return commits, tags, others, nil
+}
+
+// peelTag returns the chain of tags starting at the given object, empty if
+// it isn't a tag, and the object they finally point to.
+func peelTag(s storer.EncodedObjectStorer, h plumbing.Hash) (chain []plumbing.Hash, target plumbing.Hash, err error) {
+	for {
+		o, err := s.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+
+		if o.Type() != plumbing.TagObject {
+			return chain, h, nil
+		}
+
+		t, err := object.DecodeTag(s, o)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+
+		chain = append(chain, h)
+		h = t.Target
+	}
+}
+
+// walkCommits returns the commits reachable from the given ones but the ones
+// in stop, without walking the parents of the ones in boundary.
+func walkCommits(s storer.EncodedObjectStorer, from []plumbing.Hash, boundary map[plumbing.Hash]bool, stop map[plumbing.Hash]*object.Commit) (map[plumbing.Hash]*object.Commit, error) {
+	commits := make(map[plumbing.Hash]*object.Commit)
+	pending := append([]plumbing.Hash(nil), from...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := commits[h]; ok {
+			continue
+		}
+
+		if _, ok := stop[h]; ok {
+			continue
+		}
+
+		c, err := object.GetCommit(s, h)
+		if err != nil {
+			return nil, err
+		}
+
+		commits[h] = c
+		if !boundary[h] {
+			pending = append(pending, c.ParentHashes...)
+		}
+	}
+
+	return commits, nil
+}
+
+// trees returns the trees of the given commits.
+func trees(commits map[plumbing.Hash]*object.Commit) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	for _, c := range commits {
+		hashes = append(hashes, c.TreeHash)
+	}
+
+	return hashes
+}
+
+func hashSet(hashes []plumbing.Hash) map[plumbing.Hash]bool {
+	set := make(map[plumbing.Hash]bool, len(hashes))
+	for _, h := range hashes {
+		set[h] = true
+	}
+
+	return set
+}
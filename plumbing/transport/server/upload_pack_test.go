@@ -1,9 +1,20 @@
 package server_test
 
 import (
+	"bytes"
+	"context"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 )
 
 type UploadPackSuite struct {
@@ -48,3 +59,162 @@ func (s *ClientLikeUploadPackSuite) SetUpSuite(c *C) {
 func (s *ClientLikeUploadPackSuite) TestAdvertisedReferencesEmpty(c *C) {
 	s.UploadPackSuite.TestAdvertisedReferencesEmpty(c)
 }
+
+// GitUploadPackSuite tests the negotiation of the upload-pack sessions.
+type GitUploadPackSuite struct {
+	GitBaseSuite
+}
+
+var _ = Suite(&GitUploadPackSuite{})
+
+func (s *GitUploadPackSuite) newSession(c *C) server.UploadPackSession {
+	srv := server.NewServer(server.NewFilesystemLoader(osfs.New(s.base)))
+	r, err := srv.NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	return r.(server.UploadPackSession)
+}
+
+// newRequest returns a request of the last commit with the given
+// capabilities.
+func (s *GitUploadPackSuite) newRequest(c *C, caps ...capability.Capability) *packp.UploadPackRequest {
+	req := packp.NewUploadPackRequest()
+	for _, name := range caps {
+		c.Assert(req.Capabilities.Set(name), IsNil)
+	}
+
+	req.Wants = []plumbing.Hash{s.commits[3]}
+	return req
+}
+
+// uploadPack returns the storage of the objects sent in response to the
+// request.
+func (s *GitUploadPackSuite) uploadPack(c *C, r server.UploadPackSession, req *packp.UploadPackRequest) *memory.Storage {
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	sto := memory.NewStorage()
+	c.Assert(packfile.UpdateObjectStorage(sto, resp, nil), IsNil)
+	c.Assert(resp.Close(), IsNil)
+	return sto
+}
+
+func (s *GitUploadPackSuite) TestNegotiateMultiACKDetailed(c *C) {
+	r := s.newSession(c)
+	su, err := r.ReceiveWants(&s.newRequest(c, capability.MultiACKDetailed).UploadRequest)
+	c.Assert(err, IsNil)
+	c.Assert(su, IsNil)
+
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	rr, err := r.Negotiate([]plumbing.Hash{missing})
+	c.Assert(err, IsNil)
+	c.Assert(rr.ACKs, HasLen, 0)
+	c.Assert(rr.Ready, Equals, false)
+
+	rr, err = r.Negotiate([]plumbing.Hash{s.commits[1]})
+	c.Assert(err, IsNil)
+	c.Assert(rr.ACKs, DeepEquals, []packp.ACK{
+		{Hash: s.commits[1], Status: packp.ACKCommon},
+		{Hash: s.commits[1], Status: packp.ACKReady},
+	})
+	c.Assert(rr.Ready, Equals, true)
+
+	rr, err = r.Negotiate([]plumbing.Hash{missing})
+	c.Assert(err, IsNil)
+	c.Assert(rr.ACKs, DeepEquals, []packp.ACK{{Hash: missing, Status: packp.ACKReady}})
+	c.Assert(rr.Ready, Equals, true)
+}
+
+func (s *GitUploadPackSuite) TestNegotiateMultiACK(c *C) {
+	r := s.newSession(c)
+	_, err := r.ReceiveWants(&s.newRequest(c, capability.MultiACK).UploadRequest)
+	c.Assert(err, IsNil)
+
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	rr, err := r.Negotiate([]plumbing.Hash{s.commits[1], missing})
+	c.Assert(err, IsNil)
+	c.Assert(rr.ACKs, DeepEquals, []packp.ACK{
+		{Hash: s.commits[1], Status: packp.ACKContinue},
+		{Hash: missing, Status: packp.ACKContinue},
+	})
+	c.Assert(rr.Ready, Equals, false)
+}
+
+func (s *GitUploadPackSuite) TestNegotiateWithoutMultiACK(c *C) {
+	r := s.newSession(c)
+	req := s.newRequest(c)
+	_, err := r.ReceiveWants(&req.UploadRequest)
+	c.Assert(err, IsNil)
+
+	rr, err := r.Negotiate([]plumbing.Hash{s.commits[0], s.commits[1]})
+	c.Assert(err, IsNil)
+	c.Assert(rr.ACKs, HasLen, 0)
+
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.ACKs, DeepEquals, []plumbing.Hash{s.commits[0]})
+	c.Assert(resp.Close(), IsNil)
+}
+
+func (s *GitUploadPackSuite) TestNegotiateWantsNotReceived(c *C) {
+	_, err := s.newSession(c).Negotiate([]plumbing.Hash{s.commits[0]})
+	c.Assert(err, Equals, server.ErrWantsNotReceived)
+}
+
+func (s *GitUploadPackSuite) TestUploadPackHaves(c *C) {
+	req := s.newRequest(c)
+	req.Haves = []plumbing.Hash{s.commits[1]}
+	sto := s.uploadPack(c, s.newSession(c), req)
+
+	c.Assert(sto.HasEncodedObject(s.commits[3]), IsNil)
+	c.Assert(sto.HasEncodedObject(s.commits[2]), IsNil)
+	c.Assert(sto.HasEncodedObject(s.commits[1]), Equals, plumbing.ErrObjectNotFound)
+	c.Assert(sto.HasEncodedObject(s.tag), Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *GitUploadPackSuite) TestUploadPackReachableWant(c *C) {
+	req := s.newRequest(c)
+	req.Wants = []plumbing.Hash{s.commits[1]}
+	sto := s.uploadPack(c, s.newSession(c), req)
+	c.Assert(sto.HasEncodedObject(s.commits[0]), IsNil)
+
+	req.Wants = []plumbing.Hash{plumbing.NewHash("1111111111111111111111111111111111111111")}
+	_, err := s.newSession(c).ReceiveWants(&req.UploadRequest)
+	c.Assert(err, ErrorMatches, "not our ref 1111111111111111111111111111111111111111")
+}
+
+func (s *GitUploadPackSuite) TestUploadPackIncludeTag(c *C) {
+	sto := s.uploadPack(c, s.newSession(c), s.newRequest(c))
+	c.Assert(sto.HasEncodedObject(s.tag), Equals, plumbing.ErrObjectNotFound)
+
+	sto = s.uploadPack(c, s.newSession(c), s.newRequest(c, capability.IncludeTag))
+	c.Assert(sto.HasEncodedObject(s.tag), IsNil)
+}
+
+func (s *GitUploadPackSuite) TestUploadPackSideband(c *C) {
+	for _, t := range []struct {
+		caps     []capability.Capability
+		progress string
+	}{
+		{[]capability.Capability{capability.Sideband64k}, "(?s)Enumerating objects: 12, done.\n.*Counting objects: 100% \\(12/12\\), done.\n.*"},
+		{[]capability.Capability{capability.Sideband, capability.NoProgress}, ""},
+	} {
+		req := s.newRequest(c, t.caps...)
+		resp, err := s.newSession(c).UploadPack(context.Background(), req)
+		c.Assert(err, IsNil)
+
+		st := sideband.Sideband64k
+		if req.Capabilities.Supports(capability.Sideband) {
+			st = sideband.Sideband
+		}
+
+		var progress bytes.Buffer
+		d := sideband.NewDemuxer(st, resp)
+		d.Progress = &progress
+
+		sto := memory.NewStorage()
+		c.Assert(packfile.UpdateObjectStorage(sto, d, nil), IsNil)
+		c.Assert(resp.Close(), IsNil)
+		c.Assert(sto.HasEncodedObject(s.commits[0]), IsNil)
+		c.Assert(progress.String(), Matches, t.progress)
+	}
+}
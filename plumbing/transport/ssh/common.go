@@ -74,6 +74,11 @@ func (c *command) setAuth(auth transport.AuthMethod) error {
 }
 
 func (c *command) Start() error {
+	if v := c.endpoint.GitProtocol(c.command); v != "" {
+		// the servers not accepting the variable speak the version 0
+		_ = c.Session.Setenv("GIT_PROTOCOL", v)
+	}
+
 	return c.Session.Start(endpointToCommand(c.command, c.endpoint))
 }
 
@@ -0,0 +1,402 @@
+// Package server implements a git server speaking the SSH protocol, on top of
+// the transport-independent implementation of the server package of the
+// transport.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/internal/common"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrMissingHostKey is returned by Options.Validate without HostKeys.
+	ErrMissingHostKey = errors.New("host key required")
+	// ErrMissingPublicKeyCallback is returned by Options.Validate without
+	// PublicKeyCallback.
+	ErrMissingPublicKeyCallback = errors.New("public key callback required")
+	// ErrServerClosed is returned by Server.Serve after Server.Close.
+	ErrServerClosed = errors.New("ssh: server closed")
+	// ErrUnsupportedCommand is reported to the clients executing a command
+	// other than git-upload-pack or git-receive-pack with a single argument.
+	ErrUnsupportedCommand = errors.New("unsupported command")
+)
+
+// exit statuses of the commands, as git reports them
+const (
+	exitSuccess = 0
+	exitFailure = 128
+)
+
+// AuthFunc authorizes the user of an authenticated connection to run the given
+// service on the repository of the endpoint,
+// transport.UploadPackServiceName or transport.ReceivePackServiceName. It
+// returns transport.ErrAuthorizationFailed to deny it.
+type AuthFunc func(conn *ssh.ServerConn, service string, ep *transport.Endpoint) error
+
+// Options configures the servers returned by NewServer.
+type Options struct {
+	// HostKeys are the private keys identifying the server, at least one is
+	// required.
+	HostKeys []ssh.Signer
+	// PublicKeyCallback authenticates the users by their public key, as
+	// ssh.ServerConfig does. The permissions returned are available to Auth
+	// in the connection.
+	PublicKeyCallback func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+	// Auth authorizes the commands of the users, all of them are allowed if
+	// nil.
+	Auth AuthFunc
+	// Server configures the upload-pack and receive-pack sessions.
+	Server server.Options
+}
+
+// Validate validates the fields and sets the default values.
+func (o *Options) Validate() error {
+	if len(o.HostKeys) == 0 {
+		return ErrMissingHostKey
+	}
+
+	if o.PublicKeyCallback == nil {
+		return ErrMissingPublicKeyCallback
+	}
+
+	return o.Server.Validate()
+}
+
+// Server is a git server accepting SSH connections, serving the repositories
+// of a server.Loader. The repositories are loaded with the endpoint of the
+// path requested by the clients, the user of the connection and its local
+// address, e.g. ssh://git@127.0.0.1:2222/foo.git when running
+// git-upload-pack '/foo.git'.
+type Server struct {
+	server transport.Transport
+	config *ssh.ServerConfig
+	auth   AuthFunc
+
+	mu     sync.Mutex
+	closed bool
+	// closers are the listeners and the connections being served.
+	closers map[io.Closer]struct{}
+}
+
+// NewServer returns a Server serving the repositories of the loader.
+func NewServer(loader server.Loader, o *Options) (*Server, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	srv, err := server.NewServerWithOptions(loader, &o.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ServerConfig{PublicKeyCallback: o.PublicKeyCallback}
+	for _, key := range o.HostKeys {
+		config.AddHostKey(key)
+	}
+
+	return &Server{
+		server:  srv,
+		config:  config,
+		auth:    o.Auth,
+		closers: make(map[io.Closer]struct{}),
+	}, nil
+}
+
+// Serve accepts the connections of the listener, serving each of them in its
+// own goroutine, until the listener fails. It returns ErrServerClosed once the
+// server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+
+	defer s.untrack(l)
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves the given connection until the client closes it, running
+// the commands of its sessions.
+func (s *Server) ServeConn(c net.Conn) {
+	// the ssh package closes the connection on its own once the client is
+	// gone, before it's untracked
+	c = &onceCloseConn{Conn: c}
+	if !s.track(c) {
+		_ = c.Close()
+		return
+	}
+
+	defer s.untrack(c)
+
+	conn, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		_ = c.Close()
+		return
+	}
+
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+
+		go s.serveSession(conn, ch, reqs)
+	}
+}
+
+// Close closes the listeners and the connections being served.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	var firstErr error
+	for c := range s.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// serveSession runs the command of the exec request of the session, the other
+// requests are rejected.
+func (s *Server) serveSession(conn *ssh.ServerConn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+
+		status := exitSuccess
+		if err := s.exec(conn, ch, payload.Command); err != nil {
+			fmt.Fprintf(ch.Stderr(), "fatal: %s\n", err)
+			status = exitFailure
+		}
+
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		return
+	}
+}
+
+// exec runs the given git-upload-pack or git-receive-pack command.
+func (s *Server) exec(conn *ssh.ServerConn, ch ssh.Channel, command string) error {
+	service, repo, err := parseCommand(command)
+	if err != nil {
+		return err
+	}
+
+	ep, err := endpoint(conn, repo)
+	if err != nil {
+		return err
+	}
+
+	if s.auth != nil {
+		if err := s.auth(conn, service, ep); err != nil {
+			return err
+		}
+	}
+
+	cmd := common.ServerCommand{
+		// the channel isn't closed along with the input
+		Stdin:  struct{ io.Reader }{ch},
+		Stdout: ioutil.WriteNopCloser(ch),
+		Stderr: ch.Stderr(),
+	}
+
+	if service == transport.UploadPackServiceName {
+		return s.uploadPack(cmd, ep, repo)
+	}
+
+	return s.receivePack(cmd, ep, repo)
+}
+
+func (s *Server) uploadPack(cmd common.ServerCommand, ep *transport.Endpoint, repo string) (err error) {
+	sess, err := s.server.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return sessionError(err, repo)
+	}
+
+	defer ioutil.CheckClose(sess, &err)
+	return common.ServeUploadPack(cmd, sess)
+}
+
+func (s *Server) receivePack(cmd common.ServerCommand, ep *transport.Endpoint, repo string) (err error) {
+	sess, err := s.server.NewReceivePackSession(ep, nil)
+	if err != nil {
+		return sessionError(err, repo)
+	}
+
+	defer ioutil.CheckClose(sess, &err)
+	return common.ServeReceivePack(cmd, sess)
+}
+
+// track adds a listener or a connection to the ones closed by Close, it
+// returns false if the server is already closed.
+func (s *Server) track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.closers[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.closers, c)
+}
+
+// onceCloseConn is a net.Conn that can be closed more than once, only the
+// first Close closes the underlying connection.
+type onceCloseConn struct {
+	net.Conn
+	once sync.Once
+	err  error
+}
+
+func (c *onceCloseConn) Close() error {
+	c.once.Do(func() { c.err = c.Conn.Close() })
+	return c.err
+}
+
+// endpoint returns the endpoint of the given repository of the connection.
+func endpoint(conn *ssh.ServerConn, repo string) (*transport.Endpoint, error) {
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transport.Endpoint{
+		Protocol: "ssh",
+		User:     conn.User(),
+		Host:     host,
+		Port:     p,
+		Path:     path.Clean("/" + repo),
+	}, nil
+}
+
+// sessionError returns the error reported when the session of the given
+// repository can't be created, the clients recognize the one of git for the
+// repositories not found.
+func sessionError(err error, repo string) error {
+	if err == transport.ErrRepositoryNotFound {
+		return fmt.Errorf("'%s' does not appear to be a git repository", repo)
+	}
+
+	return err
+}
+
+// parseCommand returns the service and the repository of a command, as run
+// by the clients: the service followed by the quoted path of the repository.
+func parseCommand(command string) (service, repo string, err error) {
+	i := strings.IndexByte(command, ' ')
+	if i == -1 {
+		return "", "", ErrUnsupportedCommand
+	}
+
+	service = command[:i]
+	if service != transport.UploadPackServiceName && service != transport.ReceivePackServiceName {
+		return "", "", ErrUnsupportedCommand
+	}
+
+	repo, err = unquote(strings.TrimSpace(command[i+1:]))
+	if err != nil {
+		return "", "", err
+	}
+
+	return service, repo, nil
+}
+
+// unquote removes the shell quoting of an argument: the single quotes around
+// any part of it, and the backslashes escaping the characters outside them.
+func unquote(arg string) (string, error) {
+	var buf []byte
+	quoted := false
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case quoted && c == '\'':
+			quoted = false
+		case quoted:
+			buf = append(buf, c)
+		case c == '\'':
+			quoted = true
+		case c == '\\' && i+1 < len(arg):
+			i++
+			buf = append(buf, arg[i])
+		case c == ' ' || c == '\t' || c == '\n' || c == '\\':
+			return "", ErrUnsupportedCommand
+		default:
+			buf = append(buf, c)
+		}
+	}
+
+	if quoted || len(buf) == 0 {
+		return "", ErrUnsupportedCommand
+	}
+
+	return string(buf), nil
+}
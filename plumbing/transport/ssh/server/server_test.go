@@ -0,0 +1,393 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	"golang.org/x/crypto/ssh"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ServerSuite struct {
+	base     string
+	key      *ecdsa.PrivateKey
+	auth     *gitssh.PublicKeys
+	server   *Server
+	done     chan error
+	endpoint *transport.Endpoint
+}
+
+var _ = Suite(&ServerSuite{})
+
+// SetUpTest serves a bare repository of two commits, at /repo.git, to the
+// users with the key of the suite.
+func (s *ServerSuite) SetUpTest(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	s.base = c.MkDir()
+	work := filepath.Join(s.base, "work")
+	_, err := git.PlainInit(work, false)
+	c.Assert(err, IsNil)
+	_, err = git.PlainInit(filepath.Join(s.base, "repo.git"), true)
+	c.Assert(err, IsNil)
+
+	s.commit(c, work, "foo")
+	s.commit(c, work, "bar")
+	s.publish(c)
+
+	s.key = s.newKey(c)
+	signer, err := ssh.NewSignerFromKey(s.key)
+	c.Assert(err, IsNil)
+	s.auth = &gitssh.PublicKeys{User: "git", Signer: signer}
+	s.auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	s.listen(c, &Options{})
+}
+
+func (s *ServerSuite) TearDownTest(c *C) {
+	if s.server == nil {
+		return
+	}
+
+	c.Assert(s.server.Close(), IsNil)
+	c.Assert(<-s.done, Equals, ErrServerClosed)
+}
+
+// listen starts a server with the given options, authenticating the key of
+// the suite.
+func (s *ServerSuite) listen(c *C, o *Options) {
+	hostKey, err := ssh.NewSignerFromKey(s.newKey(c))
+	c.Assert(err, IsNil)
+
+	o.HostKeys = []ssh.Signer{hostKey}
+	o.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if !bytes.Equal(key.Marshal(), s.auth.Signer.PublicKey().Marshal()) {
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		}
+
+		return &ssh.Permissions{Extensions: map[string]string{"user": conn.User()}}, nil
+	}
+
+	s.server, err = NewServer(server.NewFilesystemLoader(osfs.New(s.base)), o)
+	c.Assert(err, IsNil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	s.done = make(chan error, 1)
+	go func() { s.done <- s.server.Serve(l) }()
+
+	s.endpoint, err = transport.NewEndpoint(fmt.Sprintf("ssh://git@%s/repo.git", l.Addr()))
+	c.Assert(err, IsNil)
+}
+
+func (s *ServerSuite) newKey(c *C) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	return key
+}
+
+func (s *ServerSuite) git(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=foo", "-c", "user.email=foo@foo.foo",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_SSH_COMMAND="+s.sshCommand(c))
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return strings.TrimSpace(string(out))
+}
+
+// sshCommand returns an OpenSSH command authenticating with the key of the
+// suite.
+func (s *ServerSuite) sshCommand(c *C) string {
+	if s.key == nil {
+		return ""
+	}
+
+	der, err := x509.MarshalECPrivateKey(s.key)
+	c.Assert(err, IsNil)
+
+	path := filepath.Join(s.base, "id_ecdsa")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	c.Assert(err, IsNil)
+
+	return fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR", path)
+}
+
+// commit commits the file name, with its name as content, to the repository
+// at dir, returning the hash of the commit.
+func (s *ServerSuite) commit(c *C, dir, name string) plumbing.Hash {
+	r, err := git.PlainOpen(dir)
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644), IsNil)
+	_, err = w.Add(name)
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit(name, &git.CommitOptions{Author: &object.Signature{
+		Name:  "foo",
+		Email: "foo@foo.foo",
+		When:  time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}})
+	c.Assert(err, IsNil)
+	return h
+}
+
+// publish copies the objects and the master branch of the work repository
+// to the served one.
+func (s *ServerSuite) publish(c *C) {
+	src, err := git.PlainOpen(filepath.Join(s.base, "work"))
+	c.Assert(err, IsNil)
+	dst, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+
+	iter, err := src.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	c.Assert(iter.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := dst.Storer.SetEncodedObject(obj)
+		return err
+	}), IsNil)
+
+	ref, err := src.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+	c.Assert(dst.Storer.SetReference(ref), IsNil)
+}
+
+// reference returns the hash of the given reference of the served
+// repository.
+func (s *ServerSuite) reference(c *C, name plumbing.ReferenceName) (plumbing.Hash, error) {
+	r, err := git.PlainOpen(filepath.Join(s.base, "repo.git"))
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference(name, false)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ref.Hash(), nil
+}
+
+func (s *ServerSuite) head(c *C) plumbing.Hash {
+	h, err := s.reference(c, plumbing.Master)
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *ServerSuite) TestAdvertisedReferences(c *C) {
+	r, err := gitssh.DefaultClient.NewUploadPackSession(s.endpoint, s.auth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.References["refs/heads/master"], Equals, s.head(c))
+	c.Assert(*ar.Head, Equals, s.head(c))
+}
+
+func (s *ServerSuite) TestUploadPack(c *C) {
+	r, err := gitssh.DefaultClient.NewUploadPackSession(s.endpoint, s.auth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = []plumbing.Hash{s.head(c)}
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	c.Assert(req.Capabilities.Supports(capability.Sideband64k), Equals, true)
+	sto := memory.NewStorage()
+	d := sideband.NewDemuxer(sideband.Sideband64k, resp)
+	c.Assert(packfile.UpdateObjectStorage(sto, d, nil), IsNil)
+	c.Assert(resp.Close(), IsNil)
+	c.Assert(sto.HasEncodedObject(s.head(c)), IsNil)
+}
+
+func (s *ServerSuite) TestReceivePack(c *C) {
+	r, err := gitssh.DefaultClient.NewReceivePackSession(s.endpoint, s.auth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	var pack bytes.Buffer
+	_, err = packfile.NewEncoder(&pack, memory.NewStorage(), false).Encode(nil, 10, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewReferenceUpdateRequestFromCapabilities(ar.Capabilities)
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = []*packp.Command{{Name: "refs/heads/branch", New: s.head(c)}}
+	req.Packfile = ioutil.NopCloser(&pack)
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	branch, err := s.reference(c, "refs/heads/branch")
+	c.Assert(err, IsNil)
+	c.Assert(branch, Equals, s.head(c))
+}
+
+func (s *ServerSuite) TestCloneAndPushWithGit(c *C) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		c.Skip("ssh not found")
+	}
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", s.endpoint.String(), "clone")
+	clone := filepath.Join(dir, "clone")
+	c.Assert(s.git(c, clone, "rev-parse", "HEAD"), Equals, s.head(c).String())
+
+	head := s.commit(c, clone, "qux")
+	s.git(c, clone, "push", "-q", "origin", "master", "master:refs/heads/branch")
+
+	c.Assert(s.head(c), Equals, head)
+	branch, err := s.reference(c, "refs/heads/branch")
+	c.Assert(err, IsNil)
+	c.Assert(branch, Equals, head)
+
+	s.git(c, clone, "push", "-q", "origin", ":branch")
+	_, err = s.reference(c, "refs/heads/branch")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ServerSuite) TestShallowCloneWithGit(c *C) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		c.Skip("ssh not found")
+	}
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "-q", "--depth", "1", s.endpoint.String(), "clone")
+	clone := filepath.Join(dir, "clone")
+	c.Assert(s.git(c, clone, "rev-list", "--count", "HEAD"), Equals, "1")
+
+	s.commit(c, filepath.Join(s.base, "work"), "qux")
+	s.publish(c)
+
+	s.git(c, clone, "fetch", "-q", "--deepen", "1", "origin")
+	c.Assert(s.git(c, clone, "rev-list", "--count", "origin/master"), Equals, "3")
+	c.Assert(s.git(c, clone, "rev-parse", "--is-shallow-repository"), Equals, "true")
+
+	s.git(c, clone, "fetch", "-q", "--unshallow", "origin")
+	c.Assert(s.git(c, clone, "rev-parse", "--is-shallow-repository"), Equals, "false")
+}
+
+func (s *ServerSuite) TestRepositoryNotFound(c *C) {
+	ep := *s.endpoint
+	ep.Path = "/foo.git"
+
+	r, err := gitssh.DefaultClient.NewUploadPackSession(&ep, s.auth)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *ServerSuite) TestUnknownKey(c *C) {
+	signer, err := ssh.NewSignerFromKey(s.newKey(c))
+	c.Assert(err, IsNil)
+	auth := &gitssh.PublicKeys{User: "git", Signer: signer}
+	auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	_, err = gitssh.DefaultClient.NewUploadPackSession(s.endpoint, auth)
+	c.Assert(err, ErrorMatches, ".*unable to authenticate.*")
+}
+
+func (s *ServerSuite) TestAuth(c *C) {
+	c.Assert(s.server.Close(), IsNil)
+	c.Assert(<-s.done, Equals, ErrServerClosed)
+
+	s.listen(c, &Options{Auth: func(conn *ssh.ServerConn, service string, ep *transport.Endpoint) error {
+		c.Assert(conn.Permissions.Extensions["user"], Equals, "git")
+		c.Assert(ep.User, Equals, "git")
+		c.Assert(ep.Path, Equals, "/repo.git")
+		if service == transport.ReceivePackServiceName {
+			return transport.ErrAuthorizationFailed
+		}
+
+		return nil
+	}})
+
+	r, err := gitssh.DefaultClient.NewUploadPackSession(s.endpoint, s.auth)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	rp, err := gitssh.DefaultClient.NewReceivePackSession(s.endpoint, s.auth)
+	c.Assert(err, IsNil)
+	_, err = rp.AdvertisedReferences()
+	c.Assert(err, ErrorMatches, ".*authorization failed")
+}
+
+func (s *ServerSuite) TestOptionsValidate(c *C) {
+	o := &Options{}
+	c.Assert(o.Validate(), Equals, ErrMissingHostKey)
+
+	o.HostKeys = []ssh.Signer{s.auth.Signer}
+	c.Assert(o.Validate(), Equals, ErrMissingPublicKeyCallback)
+}
+
+func (s *ServerSuite) TestParseCommand(c *C) {
+	for _, t := range []struct {
+		command, service, repo string
+	}{
+		{"git-upload-pack '/foo.git'", "git-upload-pack", "/foo.git"},
+		{"git-receive-pack 'foo.git'", "git-receive-pack", "foo.git"},
+		{"git-upload-pack /foo.git", "git-upload-pack", "/foo.git"},
+		{`git-upload-pack '/it'\''s.git'`, "git-upload-pack", "/it's.git"},
+		{`git-upload-pack '/foo'\!'.git'`, "git-upload-pack", "/foo!.git"},
+	} {
+		service, repo, err := parseCommand(t.command)
+		c.Assert(err, IsNil)
+		c.Assert(service, Equals, t.service)
+		c.Assert(repo, Equals, t.repo)
+	}
+
+	for _, command := range []string{
+		"git-upload-pack",
+		"git-upload-archive '/foo.git'",
+		"git-upload-pack '/foo.git",
+		"git-upload-pack '/foo.git' bar",
+		"git-upload-pack ''",
+	} {
+		_, _, err := parseCommand(command)
+		c.Assert(err, Equals, ErrUnsupportedCommand, Commentf(command))
+	}
+}
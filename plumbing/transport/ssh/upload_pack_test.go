@@ -58,6 +58,20 @@ func (s *UploadPackSuite) SetUpSuite(c *C) {
 	}()
 }
 
+type UploadPackV2Suite struct {
+	UploadPackSuite
+}
+
+var _ = Suite(&UploadPackV2Suite{})
+
+func (s *UploadPackV2Suite) SetUpSuite(c *C) {
+	s.UploadPackSuite.SetUpSuite(c)
+
+	s.Endpoint.ProtocolVersion = transport.ProtocolV2
+	s.EmptyEndpoint.ProtocolVersion = transport.ProtocolV2
+	s.NonExistentEndpoint.ProtocolVersion = transport.ProtocolV2
+}
+
 func (s *UploadPackSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
 	fs := f.DotGit()
 
@@ -87,6 +101,7 @@ func handlerSSH(s ssh.Session) {
 		return
 	}
 
+	cmd.Env = append(os.Environ(), s.Environ()...)
 	if err := cmd.Start(); err != nil {
 		fmt.Println(err)
 		return
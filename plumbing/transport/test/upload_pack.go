@@ -8,6 +8,7 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -69,6 +70,26 @@ func (s *UploadPackSuite) TestCallAdvertisedReferenceTwice(c *C) {
 	c.Assert(ar2, DeepEquals, ar1)
 }
 
+func (s *UploadPackSuite) TestAdvertisedReferencesWithPrefixes(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := transport.AdvertisedReferencesWithPrefixes(r, []string{"refs/heads/"})
+	c.Assert(err, IsNil)
+	_, ok := ar.References["refs/heads/master"]
+	c.Assert(ok, Equals, true)
+
+	if s.Endpoint.ProtocolVersion != transport.ProtocolV2 {
+		return
+	}
+
+	c.Assert(ar.Head, IsNil)
+	for name := range ar.References {
+		c.Assert(strings.HasPrefix(name, "refs/heads/"), Equals, true)
+	}
+}
+
 func (s *UploadPackSuite) TestDefaultBranch(c *C) {
 	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
 	c.Assert(err, IsNil)
@@ -0,0 +1,125 @@
+package git
+
+import (
+	"context"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// setPartialClone sets the given remote as the promisor remote of the
+// repository, making it a partial clone.
+func (r *Repository) setPartialClone(remote string) error {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Extensions.PartialClone = remote
+	return r.Storer.SetConfig(cfg)
+}
+
+// SetPromisorAuth sets the auth method used to fetch the objects missing in a
+// partial clone from its promisor remote, as BlobObject and Checkout do. The
+// repositories cloned with a filter use the auth method of the clone, the
+// ones opened afterwards are accessed without credentials until it's set.
+func (r *Repository) SetPromisorAuth(auth transport.AuthMethod) {
+	r.promisorAuth = auth
+}
+
+// promisorRemote returns the promisor remote of a partial clone, or nil if
+// the repository isn't a partial clone.
+func (r *Repository) promisorRemote() (*Remote, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	name := cfg.Extensions.PartialClone
+	if name == "" {
+		return nil, nil
+	}
+
+	remote, err := r.Remote(name)
+	if err == ErrRemoteNotFound {
+		return nil, nil
+	}
+
+	return remote, err
+}
+
+// fetchMissingObjects fetches the given objects missing in a partial clone
+// from its promisor remote, it returns true if any was fetched. Nothing is
+// fetched if the repository isn't a partial clone.
+func (r *Repository) fetchMissingObjects(ctx context.Context, hashes []plumbing.Hash) (bool, error) {
+	if len(hashes) == 0 {
+		return false, nil
+	}
+
+	remote, err := r.promisorRemote()
+	if err != nil || remote == nil {
+		return false, err
+	}
+
+	seen := make(map[plumbing.Hash]bool, len(hashes))
+	var missing []plumbing.Hash
+	for _, h := range hashes {
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		err := r.Storer.HasEncodedObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			missing = append(missing, h)
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	if err := remote.fetchObjects(ctx, missing, r.promisorAuth); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// fetchObjects fetches the given objects from the remote, without updating
+// any reference, the way the objects missing in a partial clone are fetched.
+// The server has to allow requesting objects not advertised.
+func (r *Remote) fetchObjects(ctx context.Context, hashes []plumbing.Hash, auth transport.AuthMethod) (err error) {
+	s, err := newUploadPackSession(r.c.URLs[0], auth, r.protocolVersion())
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	ar, err := transport.AdvertisedReferencesWithPrefixes(s, []string{plumbing.HEAD.String()})
+	if err != nil {
+		return err
+	}
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = hashes
+
+	reader, err := s.UploadPack(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(reader, &err)
+
+	return packfile.UpdatePromisorObjectStorage(r.s,
+		buildSidebandIfSupported(req.Capabilities, reader, nil), nil)
+}
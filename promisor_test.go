@@ -0,0 +1,285 @@
+package git
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type PromisorSuite struct {
+	BaseSuite
+	url  string
+	old  plumbing.Hash
+	head plumbing.Hash
+}
+
+var _ = Suite(&PromisorSuite{})
+
+// SetUpTest creates a repository of two commits, modifying the file foo,
+// which allows partial clones.
+func (s *PromisorSuite) SetUpTest(c *C) {
+	s.url = c.MkDir()
+	r, err := PlainInit(s.url, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	when := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(content string) {
+		c.Assert(util.WriteFile(w.Filesystem, "foo", []byte(content), 0644), IsNil)
+		c.Assert(util.WriteFile(w.Filesystem, "bar", []byte("bar"), 0644), IsNil)
+		_, err := w.Add(".")
+		c.Assert(err, IsNil)
+
+		when = when.Add(time.Hour)
+		s.head, err = w.Commit(content, &CommitOptions{
+			Author: &object.Signature{Name: "foo", Email: "foo@foo.foo", When: when},
+		})
+		c.Assert(err, IsNil)
+	}
+
+	commit("foo")
+	s.old = plumbing.ComputeHash(plumbing.BlobObject, []byte("foo"))
+	commit("qux")
+
+	s.allowFilter(c, r, true)
+}
+
+func (s *PromisorSuite) allowFilter(c *C, r *Repository, allow bool) {
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+
+	value := "false"
+	if allow {
+		value = "true"
+	}
+
+	cfg.Raw.Section("uploadpack").SetOption("allowFilter", value)
+	cfg.Raw.Section("uploadpack").SetOption("allowAnySHA1InWant", value)
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+}
+
+func (s *PromisorSuite) TestClone(c *C) {
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{
+		URL:    s.url,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Extensions.PartialClone, Equals, DefaultRemoteName)
+	c.Assert(cfg.Remotes[DefaultRemoteName].Promisor, Equals, true)
+	c.Assert(cfg.Remotes[DefaultRemoteName].PartialCloneFilter, Equals, "blob:none")
+
+	// the blobs checked out are fetched on demand
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	f, err := w.Filesystem.Open("foo")
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(string(content), Equals, "qux")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	dot := dotgit.New(osfs.New(filepath.Join(dir, GitDirName)))
+	packs, err := dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 2)
+	for _, h := range packs {
+		promisor, err := dot.IsPromisorPack(h)
+		c.Assert(err, IsNil)
+		c.Assert(promisor, Equals, true)
+	}
+}
+
+func (s *PromisorSuite) TestBlobObject(c *C) {
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL:    s.url,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Storer.HasEncodedObject(s.old), Equals, plumbing.ErrObjectNotFound)
+
+	b, err := r.BlobObject(s.old)
+	c.Assert(err, IsNil)
+	c.Assert(b.Hash, Equals, s.old)
+	c.Assert(r.Storer.HasEncodedObject(s.old), IsNil)
+
+	_, err = r.BlobObject(plumbing.NewHash("1111111111111111111111111111111111111111"))
+	c.Assert(err, NotNil)
+}
+
+func (s *PromisorSuite) TestFetch(c *C) {
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL:    s.url,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	src, err := PlainOpen(s.url)
+	c.Assert(err, IsNil)
+	w, err := src.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "baz", []byte("baz"), 0644), IsNil)
+	_, err = w.Add("baz")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("baz", &CommitOptions{
+		Author: &object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Now()},
+	})
+	c.Assert(err, IsNil)
+
+	// the filter of the clone is used by default
+	c.Assert(r.Fetch(&FetchOptions{}), IsNil)
+	baz := plumbing.ComputeHash(plumbing.BlobObject, []byte("baz"))
+	c.Assert(r.Storer.HasEncodedObject(baz), Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *PromisorSuite) TestCloneBlobLimit(c *C) {
+	src, err := PlainOpen(s.url)
+	c.Assert(err, IsNil)
+	w, err := src.Worktree()
+	c.Assert(err, IsNil)
+
+	big := strings.Repeat("big\n", 256)
+	c.Assert(util.WriteFile(w.Filesystem, "dir/big", []byte(big), 0644), IsNil)
+	_, err = w.Add("dir/big")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("big", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	bigHash := plumbing.ComputeHash(plumbing.BlobObject, []byte(big))
+	barHash := plumbing.ComputeHash(plumbing.BlobObject, []byte("bar"))
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL:    s.url,
+		Filter: packp.FilterBlobLimit(512),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.HasEncodedObject(bigHash), Equals, plumbing.ErrObjectNotFound)
+	c.Assert(r.Storer.HasEncodedObject(barHash), IsNil)
+
+	r, err = PlainClone(c.MkDir(), false, &CloneOptions{
+		URL:    s.url,
+		Filter: packp.FilterBlobLimit(512),
+	})
+	c.Assert(err, IsNil)
+
+	w, err = r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, w.Filesystem, "dir/big"), Equals, big)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *PromisorSuite) TestCloneTreeFilter(c *C) {
+	for _, depth := range []uint{0, 1} {
+		_, err := PlainClone(c.MkDir(), false, &CloneOptions{
+			URL:    s.url,
+			Filter: packp.FilterTreeDepth(depth),
+		})
+		c.Assert(err, Equals, ErrTreeFilterNotSupported)
+	}
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{URL: s.url})
+	c.Assert(err, IsNil)
+
+	err = r.Fetch(&FetchOptions{Filter: packp.FilterTreeDepth(0)})
+	c.Assert(err, Equals, ErrTreeFilterNotSupported)
+}
+
+func (s *PromisorSuite) TestCloneFilterNotSupported(c *C) {
+	src, err := PlainOpen(s.url)
+	c.Assert(err, IsNil)
+	s.allowFilter(c, src, false)
+
+	_, err = PlainClone(c.MkDir(), true, &CloneOptions{
+		URL:    s.url,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, Equals, ErrFilterNotSupported)
+}
+
+func (s *PromisorSuite) TestCloneInvalidFilter(c *C) {
+	_, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL:    s.url,
+		Filter: "foo",
+	})
+	c.Assert(err, Equals, packp.ErrUnsupportedFilter)
+}
+
+func (s *PromisorSuite) TestNotPartialClone(c *C) {
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{URL: s.url})
+	c.Assert(err, IsNil)
+
+	_, err = r.BlobObject(plumbing.NewHash("1111111111111111111111111111111111111111"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *PromisorSuite) TestAuth(c *C) {
+	out, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		c.Skip("git command not found")
+	}
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(out)), "git-http-backend"),
+		Env:  []string{"GIT_HTTP_EXPORT_ALL=true", "GIT_PROJECT_ROOT=" + s.url},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "foo" || password != "bar" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="foo"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		backend.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	auth := &githttp.BasicAuth{Username: "foo", Password: "bar"}
+	dir := c.MkDir()
+	_, err = PlainClone(dir, false, &CloneOptions{
+		URL:    srv.URL + "/" + GitDirName,
+		Filter: packp.FilterBlobNone(),
+		Auth:   auth,
+	})
+	c.Assert(err, IsNil)
+
+	r, err := PlainOpen(dir)
+	c.Assert(err, IsNil)
+
+	_, err = r.BlobObject(s.old)
+	c.Assert(err, Equals, transport.ErrAuthenticationRequired)
+
+	r.SetPromisorAuth(auth)
+	b, err := r.BlobObject(s.old)
+	c.Assert(err, IsNil)
+	c.Assert(b.Hash, Equals, s.old)
+}
@@ -0,0 +1,289 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/internal/revision"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	formatcfg "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// ErrReflogNotSupported is returned when a reflog is required but the storer
+// doesn't keep reflogs.
+var ErrReflogNotSupported = errors.New("reflog not supported by the storer")
+
+// setReferenceWithLog sets the given reference, recording the update in the
+// reflog with the given message, see logReferenceUpdate.
+func (r *Repository) setReferenceWithLog(ref *plumbing.Reference, sig *object.Signature, msg string) error {
+	old := resolvedReferenceHash(r.Storer, ref.Name())
+	if err := r.Storer.SetReference(ref); err != nil {
+		return err
+	}
+
+	return r.logReferenceUpdate(ref.Name(), old, resolvedReferenceHash(r.Storer, ref.Name()), sig, msg)
+}
+
+// logReferenceUpdate records the update of the given reference from old to
+// new in its reflog and, if HEAD points to it, in the reflog of HEAD. Nothing
+// is recorded if the storer doesn't support reflogs or if the reference is
+// excluded by core.logAllRefUpdates. If sig is nil, the identity of the user
+// section of the config is used.
+func (r *Repository) logReferenceUpdate(
+	name plumbing.ReferenceName,
+	old, new plumbing.Hash,
+	sig *object.Signature,
+	msg string,
+) error {
+	rs, ok := r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil
+	}
+
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return err
+	}
+
+	if !shouldLogReference(cfg.Raw, cfg.Core.IsBare, name) {
+		return nil
+	}
+
+	if sig == nil {
+		if sig, err = r.configSignature(); err != nil {
+			return err
+		}
+	}
+
+	e := &reflog.Entry{
+		Old:     old,
+		New:     new,
+		Name:    sig.Name,
+		Email:   sig.Email,
+		When:    sig.When,
+		Message: msg,
+	}
+
+	if err := rs.AppendReflog(name, e); err != nil {
+		return err
+	}
+
+	if name == plumbing.HEAD {
+		return nil
+	}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference || head.Target() != name {
+		return nil
+	}
+
+	return rs.AppendReflog(plumbing.HEAD, e)
+}
+
+// shouldLogReference returns true if the updates of the given reference are
+// recorded in the reflog, as configured by core.logAllRefUpdates. By default
+// the updates of HEAD, the branches, the remote branches and the notes are
+// recorded, except in bare repositories.
+func shouldLogReference(raw *formatcfg.Config, isBare bool, name plumbing.ReferenceName) bool {
+	var value string
+	if raw != nil {
+		value = strings.ToLower(configOption(raw, "core", "logAllRefUpdates"))
+	}
+
+	switch value {
+	case "always":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	case "":
+		if isBare {
+			return false
+		}
+	}
+
+	s := name.String()
+	return name == plumbing.HEAD ||
+		strings.HasPrefix(s, "refs/heads/") ||
+		strings.HasPrefix(s, "refs/remotes/") ||
+		strings.HasPrefix(s, "refs/notes/")
+}
+
+// configSignature returns a signature with the current time and the identity
+// of the user section of the configuration of the repository, the global and
+// system configurations are only read if it's not complete.
+func (r *Repository) configSignature() (*object.Signature, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	name := configOption(cfg.Raw, "user", "name")
+	email := configOption(cfg.Raw, "user", "email")
+	if name == "" || email == "" {
+		cfg, err = r.ConfigScoped(config.SystemScope)
+		if err != nil {
+			return nil, err
+		}
+
+		name = configOption(cfg.Raw, "user", "name")
+		email = configOption(cfg.Raw, "user", "email")
+	}
+
+	return &object.Signature{
+		Name:  name,
+		Email: email,
+		When:  time.Now(),
+	}, nil
+}
+
+// resolvedReferenceHash returns the hash the given reference points to, or
+// the zero hash if it doesn't exist.
+func resolvedReferenceHash(s storer.ReferenceStorer, name plumbing.ReferenceName) plumbing.Hash {
+	ref, err := storer.ResolveReference(s, name)
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+
+	return ref.Hash()
+}
+
+// reflogMessageSubject returns the first line of a commit message, as used in
+// the reflog messages.
+func reflogMessageSubject(msg string) string {
+	if i := strings.IndexByte(msg, '\n'); i != -1 {
+		return msg[:i]
+	}
+
+	return msg
+}
+
+// resolveReflogRevision resolves the @{n}, @{<date>} and @{-n} revisions,
+// the first two using the reflog of the given ref or, if empty, of the
+// current branch.
+func (r *Repository) resolveReflogRevision(ref revision.Ref, item revision.Revisioner) (plumbing.Hash, error) {
+	if at, ok := item.(revision.AtCheckout); ok {
+		return r.resolvePreviousCheckout(at.Depth)
+	}
+
+	name, err := r.reflogReferenceName(ref)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries, err := r.reflog(name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(entries) == 0 {
+		return plumbing.ZeroHash, fmt.Errorf("log for '%s' is empty", name.Short())
+	}
+
+	switch item := item.(type) {
+	case revision.AtReflog:
+		if item.Depth >= len(entries) {
+			return plumbing.ZeroHash, fmt.Errorf("log for '%s' only has %d entries", name.Short(), len(entries))
+		}
+
+		return entries[len(entries)-1-item.Depth].New, nil
+	case revision.AtDate:
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !entries[i].When.After(item.Date) {
+				return entries[i].New, nil
+			}
+		}
+
+		// the date is older than the reflog, its oldest value is used
+		if !entries[0].Old.IsZero() {
+			return entries[0].Old, nil
+		}
+
+		return entries[0].New, nil
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("unsupported revision %T", item)
+}
+
+// reflogReferenceName returns the full name of the given ref or, if empty,
+// the name of the current branch.
+func (r *Repository) reflogReferenceName(ref revision.Ref) (plumbing.ReferenceName, error) {
+	if ref == "" {
+		head, err := r.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return "", err
+		}
+
+		if head.Type() == plumbing.SymbolicReference {
+			return head.Target(), nil
+		}
+
+		return plumbing.HEAD, nil
+	}
+
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		name := plumbing.ReferenceName(fmt.Sprintf(rule, ref))
+		if _, err := r.Storer.Reference(name); err == nil {
+			return name, nil
+		}
+	}
+
+	return "", plumbing.ErrReferenceNotFound
+}
+
+// resolvePreviousCheckout returns the commit of the n-th branch or commit
+// checked out before the current one, as recorded in the reflog of HEAD.
+func (r *Repository) resolvePreviousCheckout(n int) (plumbing.Hash, error) {
+	entries, err := r.reflog(plumbing.HEAD)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	const prefix = "checkout: moving from "
+	remaining := n
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+
+		if remaining--; remaining > 0 {
+			continue
+		}
+
+		from := msg[len(prefix):]
+		if i := strings.LastIndex(from, " to "); i != -1 {
+			from = from[:i]
+		}
+
+		if h := plumbing.NewHash(from); h.String() == from {
+			return h, nil
+		}
+
+		ref, err := storer.ResolveReference(r.Storer, plumbing.ReferenceName("refs/heads/"+from))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return ref.Hash(), nil
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("@{-%d} not found, only %d checkouts in the reflog", n, n-remaining)
+}
+
+// reflog returns the entries of the reflog of the given reference, the
+// oldest first, or ErrReflogNotSupported if the storer doesn't keep reflogs.
+func (r *Repository) reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	rs, ok := r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil, ErrReflogNotSupported
+	}
+
+	return rs.Reflog(name)
+}
@@ -0,0 +1,218 @@
+package git
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type ReflogSuite struct {
+	r *Repository
+	w *Worktree
+}
+
+var _ = Suite(&ReflogSuite{})
+
+func (s *ReflogSuite) SetUpTest(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	s.r = r
+	s.w, err = r.Worktree()
+	c.Assert(err, IsNil)
+}
+
+func (s *ReflogSuite) commit(c *C, content string) plumbing.Hash {
+	err := util.WriteFile(s.w.Filesystem, "foo", []byte(content), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = s.w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err := s.w.Commit(content+"\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *ReflogSuite) reflog(c *C, name plumbing.ReferenceName) []*reflog.Entry {
+	entries, err := s.r.Storer.(storer.ReflogStorer).Reflog(name)
+	c.Assert(err, IsNil)
+	return entries
+}
+
+func (s *ReflogSuite) messages(entries []*reflog.Entry) []string {
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+
+	return msgs
+}
+
+func (s *ReflogSuite) resolve(c *C, rev string) plumbing.Hash {
+	h, err := s.r.ResolveRevision(plumbing.Revision(rev))
+	c.Assert(err, IsNil, Commentf(rev))
+	return *h
+}
+
+func (s *ReflogSuite) TestCommitAndReset(c *C) {
+	first := s.commit(c, "first")
+	second := s.commit(c, "second")
+
+	err := s.w.Reset(&ResetOptions{Mode: HardReset, Commit: first})
+	c.Assert(err, IsNil)
+
+	entries := s.reflog(c, plumbing.Master)
+	c.Assert(s.messages(entries), DeepEquals, []string{
+		"commit (initial): first",
+		"commit: second",
+		"reset: moving to " + first.String(),
+	})
+
+	c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[0].New, Equals, first)
+	c.Assert(entries[1].Old, Equals, first)
+	c.Assert(entries[1].New, Equals, second)
+	c.Assert(entries[2].Old, Equals, second)
+	c.Assert(entries[2].New, Equals, first)
+	c.Assert(entries[0].Name, Equals, "foo")
+
+	c.Assert(s.messages(s.reflog(c, plumbing.HEAD)), DeepEquals, s.messages(entries))
+
+	// the lost commit can be recovered from the reflog
+	c.Assert(s.resolve(c, "HEAD@{1}"), Equals, second)
+	c.Assert(s.resolve(c, "master@{2}"), Equals, first)
+	c.Assert(s.resolve(c, "@{1}"), Equals, second)
+	c.Assert(s.resolve(c, "HEAD@{0}"), Equals, first)
+
+	_, err = s.r.ResolveRevision("HEAD@{3}")
+	c.Assert(err, ErrorMatches, "log for 'HEAD' only has 3 entries")
+}
+
+func (s *ReflogSuite) TestCheckout(c *C) {
+	first := s.commit(c, "first")
+
+	err := s.w.Checkout(&CheckoutOptions{Branch: "refs/heads/feature", Create: true})
+	c.Assert(err, IsNil)
+
+	second := s.commit(c, "second")
+
+	err = s.w.Checkout(&CheckoutOptions{Branch: plumbing.Master})
+	c.Assert(err, IsNil)
+
+	err = s.w.Checkout(&CheckoutOptions{Hash: second})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.messages(s.reflog(c, plumbing.HEAD)), DeepEquals, []string{
+		"commit (initial): first",
+		"checkout: moving from master to feature",
+		"commit: second",
+		"checkout: moving from feature to master",
+		"checkout: moving from master to " + second.String(),
+	})
+
+	c.Assert(s.messages(s.reflog(c, "refs/heads/feature")), DeepEquals, []string{
+		"branch: Created from HEAD",
+		"commit: second",
+	})
+
+	c.Assert(s.resolve(c, "@{-1}"), Equals, first)
+	c.Assert(s.resolve(c, "@{-2}"), Equals, second)
+	c.Assert(s.resolve(c, "@{-3}"), Equals, first)
+
+	_, err = s.r.ResolveRevision("@{-4}")
+	c.Assert(err, NotNil)
+}
+
+func (s *ReflogSuite) TestResolveRevisionByDate(c *C) {
+	first := s.commit(c, "first")
+	second := s.commit(c, "second")
+	third := s.commit(c, "third")
+
+	when := func(date string) time.Time {
+		t, err := time.Parse("2006-01-02T15:04:05Z", date)
+		c.Assert(err, IsNil)
+		return t
+	}
+
+	err := s.r.Storer.(storer.ReflogStorer).SetReflog(plumbing.Master, []*reflog.Entry{
+		{New: first, When: when("2018-01-01T00:00:00Z"), Message: "commit (initial): first"},
+		{Old: first, New: second, When: when("2018-02-01T00:00:00Z"), Message: "commit: second"},
+		{Old: second, New: third, When: when("2018-03-01T00:00:00Z"), Message: "commit: third"},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.resolve(c, "master@{2018-02-15T00:00:00Z}"), Equals, second)
+	c.Assert(s.resolve(c, "master@{2018-02-01T00:00:00Z}"), Equals, second)
+	c.Assert(s.resolve(c, "master@{2018-04-01T00:00:00Z}"), Equals, third)
+	c.Assert(s.resolve(c, "master@{2017-01-01T00:00:00Z}"), Equals, first)
+	c.Assert(s.resolve(c, "master@{yesterday}"), Equals, third)
+	c.Assert(s.resolve(c, "@{2018-01-15T00:00:00Z}"), Equals, first)
+}
+
+func (s *ReflogSuite) TestLogAllRefUpdatesDisabled(c *C) {
+	cfg, err := s.r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("core").SetOption("logAllRefUpdates", "false")
+	c.Assert(s.r.Storer.SetConfig(cfg), IsNil)
+
+	s.commit(c, "first")
+	c.Assert(s.reflog(c, plumbing.HEAD), HasLen, 0)
+
+	_, err = s.r.ResolveRevision("HEAD@{0}")
+	c.Assert(err, ErrorMatches, "log for 'HEAD' is empty")
+}
+
+func (s *ReflogSuite) TestBareRepository(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, plumbing.ZeroHash))
+	c.Assert(err, IsNil)
+
+	err = r.setReferenceWithLog(plumbing.NewHashReference(plumbing.Master, plumbing.ZeroHash), nil, "test")
+	c.Assert(err, IsNil)
+
+	entries, err := r.Storer.(storer.ReflogStorer).Reflog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *ReflogSuite) TestFilesystemStorage(c *C) {
+	dot := memfs.New()
+	st, err := filesystem.NewStorage(dot)
+	c.Assert(err, IsNil)
+
+	s.r, err = Init(st, memfs.New())
+	c.Assert(err, IsNil)
+
+	s.w, err = s.r.Worktree()
+	c.Assert(err, IsNil)
+
+	cfg, err := s.r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("user").SetOption("name", "bar").SetOption("email", "bar@bar.bar")
+	c.Assert(s.r.Storer.SetConfig(cfg), IsNil)
+
+	first := s.commit(c, "first")
+
+	err = s.w.Checkout(&CheckoutOptions{Branch: "refs/heads/feature", Create: true})
+	c.Assert(err, IsNil)
+
+	_, err = dot.Stat("logs/refs/heads/master")
+	c.Assert(err, IsNil)
+
+	entries := s.reflog(c, plumbing.HEAD)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[1].Name, Equals, "bar")
+	c.Assert(entries[1].Email, Equals, "bar@bar.bar")
+	c.Assert(s.resolve(c, "HEAD@{1}"), Equals, first)
+}
@@ -1,11 +1,16 @@
 package git
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 
+	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -28,24 +33,78 @@ var (
 	NoErrAlreadyUpToDate     = errors.New("already up-to-date")
 	ErrDeleteRefNotSupported = errors.New("server does not support delete-refs")
 	ErrForceNeeded           = errors.New("some refs were not updated")
+	ErrFilterNotSupported    = errors.New("server does not support filters")
+	// ErrShallowSinceNotSupported, ErrShallowExcludeNotSupported and
+	// ErrDeepenNotSupported are returned when the server doesn't support
+	// the deepen-since, deepen-not or deepen-relative capabilities
+	// required by FetchOptions.ShallowSince, ShallowExclude and Deepen.
+	ErrShallowSinceNotSupported   = errors.New("server does not support shallow since")
+	ErrShallowExcludeNotSupported = errors.New("server does not support shallow exclude")
+	ErrDeepenNotSupported         = errors.New("server does not support deepen")
+	// ErrDumbShallowNotSupported is returned when any of the shallow options
+	// of FetchOptions is given fetching from a server speaking the dumb HTTP
+	// protocol.
+	ErrDumbShallowNotSupported = errors.New("dumb http transport does not support shallow capabilities")
+	// ErrAtomicNotSupported and ErrPushOptionsNotSupported are returned when
+	// the server doesn't support PushOptions.Atomic or Options.
+	ErrAtomicNotSupported      = errors.New("server does not support atomic pushes")
+	ErrPushOptionsNotSupported = errors.New("server does not support push options")
+	// ErrStaleLease is the error wrapped by the StaleLeaseError returned
+	// when a reference doesn't have the value expected by
+	// PushOptions.ForceWithLease.
+	ErrStaleLease = errors.New("stale info")
+	// ErrPushCertNotSupported is returned when the server doesn't support
+	// the push certificates required by PushOptions.SignKey.
+	ErrPushCertNotSupported = errors.New("server does not support push certificates")
 )
 
+// StaleLeaseError is returned by Push when the remote reference Name doesn't
+// have the value expected by PushOptions.ForceWithLease.
+type StaleLeaseError struct {
+	Name plumbing.ReferenceName
+}
+
+func (e *StaleLeaseError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrStaleLease, e.Name)
+}
+
+// Unwrap returns ErrStaleLease.
+func (e *StaleLeaseError) Unwrap() error {
+	return ErrStaleLease
+}
+
 const (
 	// This describes the maximum number of commits to walk when
 	// computing the haves to send to a server, for each ref in the
 	// repo containing this remote, when not using the multi-ack
 	// protocol.  Setting this to 0 means there is no limit.
 	maxHavesToVisitPerRef = 100
+
+	// infiniteDepth is the depth requested to unshallow a repository, as
+	// git does.
+	infiniteDepth = 0x7fffffff
+
+	protocolSection = "protocol"
+	versionKey      = "version"
+
+	// tagsPrefix is the prefix of the tags, requested to the servers
+	// speaking the protocol version 2 when the tags are fetched.
+	tagsPrefix = "refs/tags/"
 )
 
 // Remote represents a connection to a remote repository.
 type Remote struct {
 	c *config.RemoteConfig
 	s storage.Storer
+	// repo is the repository of the remote, used to record the updates of
+	// the references in the reflog.
+	repo *Repository
 }
 
+// newRemote returns a remote of a repository of the given storer, see
+// Repository.newRemote.
 func newRemote(s storage.Storer, c *config.RemoteConfig) *Remote {
-	return &Remote{s: s, c: c}
+	return newRepository(s, nil).newRemote(c)
 }
 
 // Config returns the RemoteConfig object used to instantiate this Remote.
@@ -169,7 +228,15 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		}
 	}
 
-	rs, err := pushHashes(ctx, s, r.s, req, hashesToPush, o.StatusChan)
+	var bases []plumbing.Hash
+	if !ar.Capabilities.Supports(capability.NoThin) {
+		bases, err = thinPackBases(r.s, hashesToPush)
+		if err != nil {
+			return err
+		}
+	}
+
+	rs, err := pushHashes(ctx, s, r.s, req, hashesToPush, bases, o.StatusChan)
 	if err != nil {
 		return err
 	}
@@ -205,13 +272,363 @@ func (r *Remote) newReferenceUpdateRequest(
 		}
 	}
 
-	if err := r.addReferencesToUpdate(o.RefSpecs, localRefs, remoteRefs, req); err != nil {
+	if o.Atomic {
+		if !ar.Capabilities.Supports(capability.Atomic) {
+			return nil, ErrAtomicNotSupported
+		}
+
+		req.Capabilities.Set(capability.Atomic)
+	}
+
+	if len(o.Options) != 0 {
+		if !ar.Capabilities.Supports(capability.PushOptions) {
+			return nil, ErrPushOptionsNotSupported
+		}
+
+		req.Capabilities.Set(capability.PushOptions)
+		req.Options = pushOptions(o.Options)
+	}
+
+	if err := r.addReferencesToUpdate(o.RefSpecs, localRefs, remoteRefs, req,
+		o.ForceWithLease != nil); err != nil {
 		return nil, err
 	}
 
+	if o.Prune {
+		if err := r.addPrunedReferences(o.RefSpecs, localRefs, remoteRefs, req); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.FollowTags {
+		if err := r.addFollowedTags(localRefs, remoteRefs, req); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, cmd := range req.Commands {
+		if cmd.Action() == packp.Delete && !ar.Capabilities.Supports(capability.DeleteRefs) {
+			return nil, ErrDeleteRefNotSupported
+		}
+	}
+
+	if o.ForceWithLease != nil {
+		if err := r.checkLeases(o.ForceWithLease, req.Commands); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.SignKey != nil {
+		if !ar.Capabilities.Supports(capability.PushCert) {
+			return nil, ErrPushCertNotSupported
+		}
+
+		if len(req.Commands) != 0 {
+			cert, err := newPushCertificate(o.SignKey, r.c.URLs[0],
+				ar.Capabilities.Get(capability.PushCert)[0], req)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Certificate = cert
+		}
+	}
+
 	return req, nil
 }
 
+// newPushCertificate returns the push certificate of the commands and options
+// of the request, pushed to the given URL with the nonce advertised by the
+// server, signed with the given key. The pusher is the primary identity of
+// the key.
+func newPushCertificate(
+	signKey *openpgp.Entity,
+	url, nonce string,
+	req *packp.ReferenceUpdateRequest,
+) (*packp.PushCertificate, error) {
+	pusher := &object.Signature{When: time.Now()}
+	if id := primaryIdentity(signKey); id != nil {
+		pusher.Name, pusher.Email = id.UserId.Name, id.UserId.Email
+	}
+
+	var b bytes.Buffer
+	if err := pusher.Encode(&b); err != nil {
+		return nil, err
+	}
+
+	cert := &packp.PushCertificate{
+		Pusher:   b.String(),
+		Pushee:   anonymizeURL(url),
+		Nonce:    nonce,
+		Options:  req.Options,
+		Commands: req.Commands,
+	}
+
+	return cert, cert.Sign(signKey)
+}
+
+// primaryIdentity returns the identity of the entity flagged as primary, or
+// the first one by name if none is, nil if it has no identities.
+func primaryIdentity(e *openpgp.Entity) *openpgp.Identity {
+	var names []string
+	for name, id := range e.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil &&
+			*id.SelfSignature.IsPrimaryId {
+			return id
+		}
+
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	sort.Strings(names)
+	return e.Identities[names[0]]
+}
+
+// anonymizeURL returns the given URL without its credentials, as git does for
+// the pushee of the push certificates.
+func anonymizeURL(url string) string {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return url
+	}
+
+	ep.User, ep.Password = "", ""
+	return ep.String()
+}
+
+// pushOptions returns the given push options sorted by key.
+func pushOptions(opts map[string]string) []*packp.Option {
+	var keys []string
+	for k := range opts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var result []*packp.Option
+	for _, k := range keys {
+		result = append(result, &packp.Option{Key: k, Value: opts[k]})
+	}
+
+	return result
+}
+
+// checkLeases returns an error if any of the remote references to update
+// doesn't have the value expected by the lease.
+func (r *Remote) checkLeases(lease *ForceWithLease, cmds []*packp.Command) error {
+	for _, cmd := range cmds {
+		expected, ok := lease.Expected[cmd.Name]
+		if !ok {
+			var err error
+			expected, err = r.remoteTrackingHash(cmd.Name)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cmd.Old != expected {
+			return &StaleLeaseError{Name: cmd.Name}
+		}
+	}
+
+	return nil
+}
+
+// remoteTrackingHash returns the value of the remote-tracking reference of
+// the given remote reference, or the zero hash if there is none.
+func (r *Remote) remoteTrackingHash(name plumbing.ReferenceName) (plumbing.Hash, error) {
+	for _, spec := range r.c.Fetch {
+		if !spec.Match(name) {
+			continue
+		}
+
+		ref, err := r.s.Reference(spec.Dst(name))
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		}
+
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return ref.Hash(), nil
+	}
+
+	return plumbing.ZeroHash, nil
+}
+
+// addPrunedReferences adds the commands deleting the remote references
+// matched by the destination of the refspecs without a local reference
+// matching their source.
+func (r *Remote) addPrunedReferences(
+	refspecs []config.RefSpec,
+	localRefs []*plumbing.Reference,
+	remoteRefs storer.ReferenceStorer,
+	req *packp.ReferenceUpdateRequest,
+) error {
+	local := make(map[plumbing.ReferenceName]bool, len(localRefs))
+	for _, ref := range localRefs {
+		local[ref.Name()] = true
+	}
+
+	updated := make(map[plumbing.ReferenceName]bool, len(req.Commands))
+	for _, cmd := range req.Commands {
+		updated[cmd.Name] = true
+	}
+
+	iter, err := remoteRefs.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	return iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || updated[ref.Name()] {
+			return nil
+		}
+
+		var matched bool
+		for _, rs := range refspecs {
+			if rs.IsDelete() {
+				continue
+			}
+
+			reverse := rs.Reverse()
+			if !reverse.Match(ref.Name()) {
+				continue
+			}
+
+			if local[reverse.Dst(ref.Name())] {
+				return nil
+			}
+
+			matched = true
+		}
+
+		if matched {
+			req.Commands = append(req.Commands, &packp.Command{
+				Name: ref.Name(),
+				Old:  ref.Hash(),
+				New:  plumbing.ZeroHash,
+			})
+		}
+
+		return nil
+	})
+}
+
+// addFollowedTags adds the commands creating the annotated tags missing in
+// the remote pointing to commits reachable from the ones pushed.
+func (r *Remote) addFollowedTags(
+	localRefs []*plumbing.Reference,
+	remoteRefs storer.ReferenceStorer,
+	req *packp.ReferenceUpdateRequest,
+) error {
+	var pushed []*object.Commit
+	updated := make(map[plumbing.ReferenceName]bool, len(req.Commands))
+	for _, cmd := range req.Commands {
+		updated[cmd.Name] = true
+		if cmd.Action() == packp.Delete {
+			continue
+		}
+
+		c, err := peelToCommit(r.s, cmd.New)
+		if err != nil {
+			return err
+		}
+
+		if c != nil {
+			pushed = append(pushed, c)
+		}
+	}
+
+	reachable, err := r.pushedCommits(pushed, remoteRefs)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range localRefs {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference || updated[ref.Name()] {
+			continue
+		}
+
+		_, err := remoteRefs.Reference(ref.Name())
+		if err == nil {
+			continue
+		}
+
+		if err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		follow, err := r.isTagReachable(ref.Hash(), reachable)
+		if err != nil {
+			return err
+		}
+
+		if follow {
+			req.Commands = append(req.Commands, &packp.Command{
+				Name: ref.Name(),
+				Old:  plumbing.ZeroHash,
+				New:  ref.Hash(),
+			})
+		}
+	}
+
+	return nil
+}
+
+// pushedCommits returns the set of the given commits and their ancestors,
+// walking their history once and stopping at the commits referenced by the
+// remote, which already has them.
+func (r *Remote) pushedCommits(
+	commits []*object.Commit,
+	remoteRefs storer.ReferenceStorer,
+) (map[plumbing.Hash]bool, error) {
+	seen, err := getRemoteRefsFromStorer(remoteRefs)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[plumbing.Hash]bool)
+	for _, c := range commits {
+		walker := object.NewCommitPreorderIter(c, seen, nil)
+		err := walker.ForEach(func(c *object.Commit) error {
+			reachable[c.Hash] = true
+			seen[c.Hash] = true
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return reachable, nil
+}
+
+// isTagReachable returns true if h is an annotated tag pointing to any of
+// the given reachable commits.
+func (r *Remote) isTagReachable(h plumbing.Hash, reachable map[plumbing.Hash]bool) (bool, error) {
+	if _, err := object.GetTag(r.s, h); err != nil {
+		if err == plumbing.ErrObjectNotFound {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	target, err := peelToCommit(r.s, h)
+	if err != nil || target == nil {
+		return false, err
+	}
+
+	return reachable[target.Hash], nil
+}
+
 func (r *Remote) updateRemoteReferenceStorage(
 	req *packp.ReferenceUpdateRequest,
 	result *packp.ReportStatus,
@@ -227,13 +644,19 @@ func (r *Remote) updateRemoteReferenceStorage(
 			ref := plumbing.NewHashReference(local, c.New)
 			switch c.Action() {
 			case packp.Create, packp.Update:
-				if err := r.s.SetReference(ref); err != nil {
+				if err := r.repo.setReferenceWithLog(ref, nil, "update by push"); err != nil {
 					return err
 				}
 			case packp.Delete:
 				if err := r.s.RemoveReference(local); err != nil {
 					return err
 				}
+
+				if rs, ok := r.s.(storer.ReflogStorer); ok {
+					if err := rs.RemoveReflog(local); err != nil {
+						return err
+					}
+				}
 			}
 		}
 	}
@@ -277,18 +700,29 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		o.RefSpecs = r.c.Fetch
 	}
 
-	s, err := newUploadPackSession(r.c.URLs[0], o.Auth)
+	if o.Filter == "" && r.c.Promisor {
+		o.Filter = packp.Filter(r.c.PartialCloneFilter)
+		if err := validateFilter(o.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := newUploadPackSession(r.c.URLs[0], o.Auth, r.protocolVersion())
 	if err != nil {
 		return nil, err
 	}
 
 	defer ioutil.CheckClose(s, &err)
 
-	ar, err := s.AdvertisedReferences()
+	ar, err := transport.AdvertisedReferencesWithPrefixes(s, fetchPrefixes(o))
 	if err != nil {
 		return nil, err
 	}
 
+	if ds, ok := s.(transport.DumbSession); ok && ds.IsDumb() && o.isShallow() {
+		return nil, ErrDumbShallowNotSupported
+	}
+
 	req, err := r.newUploadPackRequest(o, ar)
 	if err != nil {
 		return nil, err
@@ -309,9 +743,19 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		return nil, err
 	}
 
-	req.Wants, err = getWants(r.s, refs)
+	shallows, err := r.s.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Wants, err = getWants(r.s, refs, !req.Depth.IsZero())
 	if len(req.Wants) > 0 {
-		req.Haves, err = getHaves(localRefs, remoteRefs, r.s)
+		if isMultiACK(req.Capabilities) {
+			req.Negotiator, err = getNegotiator(localRefs, remoteRefs, r.s)
+		} else {
+			req.Haves, err = getHaves(localRefs, remoteRefs, r.s)
+		}
+
 		if err != nil {
 			return nil, err
 		}
@@ -332,6 +776,14 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		return nil, err
 	}
 
+	if !updated {
+		// deepening only changes the shallow commits
+		updated, err = r.isShallowUpdated(shallows)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	defer func() {
 		o.StatusChan.SendUpdate(plumbing.StatusUpdate{
 			Stage: plumbing.StatusDone,
@@ -345,15 +797,66 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 	return remoteRefs, nil
 }
 
-func newUploadPackSession(url string, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+func newUploadPackSession(url string, auth transport.AuthMethod, v transport.ProtocolVersion) (transport.UploadPackSession, error) {
 	c, ep, err := newClient(url)
 	if err != nil {
 		return nil, err
 	}
 
+	ep.ProtocolVersion = v
 	return c.NewUploadPackSession(ep, auth)
 }
 
+// protocolVersion returns the version of the wire protocol requested to the
+// remote, set by protocol.version in the config, the version 2 by default.
+func (r *Remote) protocolVersion() transport.ProtocolVersion {
+	if r.s == nil {
+		return transport.ProtocolV2
+	}
+
+	cfg, err := r.s.Config()
+	if err != nil {
+		return transport.ProtocolV2
+	}
+
+	switch cfg.Raw.Section(protocolSection).Option(versionKey) {
+	case "0":
+		return transport.ProtocolV0
+	case "1":
+		return transport.ProtocolV1
+	}
+
+	return transport.ProtocolV2
+}
+
+// fetchPrefixes returns the prefixes of the references requested to the
+// remote to fetch: the ones matched by the refspecs, HEAD, needed to clone,
+// and the tags unless they aren't fetched.
+func fetchPrefixes(o *FetchOptions) []string {
+	prefixes := append(refSpecsPrefixes(o.RefSpecs), plumbing.HEAD.String())
+	if o.Tags != NoTags {
+		prefixes = append(prefixes, tagsPrefix)
+	}
+
+	return prefixes
+}
+
+// refSpecsPrefixes returns the prefixes of the references matched by the
+// sources of the refspecs.
+func refSpecsPrefixes(specs []config.RefSpec) []string {
+	var prefixes []string
+	for _, rs := range specs {
+		src := rs.Src()
+		if rs.IsWildcard() {
+			src = src[:strings.Index(src, "*")]
+		}
+
+		prefixes = append(prefixes, src)
+	}
+
+	return prefixes
+}
+
 func newSendPackSession(url string, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
 	c, ep, err := newClient(url)
 	if err != nil {
@@ -380,6 +883,10 @@ func newClient(url string) (transport.Transport, *transport.Endpoint, error) {
 func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.UploadPackSession,
 	req *packp.UploadPackRequest) (err error) {
 
+	if ds, ok := s.(transport.DumbSession); ok && ds.IsDumb() {
+		return ds.FetchObjects(ctx, req.Wants, r.s)
+	}
+
 	reader, err := s.UploadPack(ctx, req)
 	if err != nil {
 		return err
@@ -387,11 +894,16 @@ func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.Upl
 
 	defer ioutil.CheckClose(reader, &err)
 
-	if err = r.updateShallow(o, reader); err != nil {
+	if err = r.updateShallow(reader); err != nil {
 		return err
 	}
 
-	if err = packfile.UpdateObjectStorage(r.s,
+	update := packfile.UpdateObjectStorage
+	if req.Filter != "" || r.c.Promisor {
+		update = packfile.UpdatePromisorObjectStorage
+	}
+
+	if err = update(r.s,
 		buildSidebandIfSupported(req.Capabilities, reader, o.Progress),
 		o.StatusChan,
 	); err != nil {
@@ -406,6 +918,7 @@ func (r *Remote) addReferencesToUpdate(
 	localRefs []*plumbing.Reference,
 	remoteRefs storer.ReferenceStorer,
 	req *packp.ReferenceUpdateRequest,
+	forceWithLease bool,
 ) error {
 	// This references dictionary will be used to search references by name.
 	refsDict := make(map[string]*plumbing.Reference)
@@ -419,7 +932,7 @@ func (r *Remote) addReferencesToUpdate(
 				return err
 			}
 		} else {
-			err := r.addOrUpdateReferences(rs, localRefs, refsDict, remoteRefs, req)
+			err := r.addOrUpdateReferences(rs, localRefs, refsDict, remoteRefs, req, forceWithLease)
 			if err != nil {
 				return err
 			}
@@ -435,6 +948,7 @@ func (r *Remote) addOrUpdateReferences(
 	refsDict map[string]*plumbing.Reference,
 	remoteRefs storer.ReferenceStorer,
 	req *packp.ReferenceUpdateRequest,
+	forceWithLease bool,
 ) error {
 	// If it is not a wilcard refspec we can directly search for the reference
 	// in the references dictionary.
@@ -444,11 +958,11 @@ func (r *Remote) addOrUpdateReferences(
 			return nil
 		}
 
-		return r.addReferenceIfRefSpecMatches(rs, remoteRefs, ref, req)
+		return r.addReferenceIfRefSpecMatches(rs, remoteRefs, ref, req, forceWithLease)
 	}
 
 	for _, ref := range localRefs {
-		err := r.addReferenceIfRefSpecMatches(rs, remoteRefs, ref, req)
+		err := r.addReferenceIfRefSpecMatches(rs, remoteRefs, ref, req, forceWithLease)
 		if err != nil {
 			return err
 		}
@@ -485,7 +999,7 @@ func (r *Remote) deleteReferences(rs config.RefSpec,
 
 func (r *Remote) addReferenceIfRefSpecMatches(rs config.RefSpec,
 	remoteRefs storer.ReferenceStorer, localRef *plumbing.Reference,
-	req *packp.ReferenceUpdateRequest) error {
+	req *packp.ReferenceUpdateRequest, forceWithLease bool) error {
 
 	if localRef.Type() != plumbing.HashReference {
 		return nil
@@ -517,7 +1031,8 @@ func (r *Remote) addReferenceIfRefSpecMatches(rs config.RefSpec,
 		return nil
 	}
 
-	if !rs.IsForceUpdate() {
+	// the leases are checked instead
+	if !rs.IsForceUpdate() && !forceWithLease {
 		if err := checkFastForwardUpdate(r.s, remoteRefs, cmd); err != nil {
 			return err
 		}
@@ -597,9 +1112,9 @@ func getHavesFromRef(
 		return nil
 	}
 
-	// Until go-git supports proper commit negotiation during an
-	// upload pack request, include up to `maxHavesToVisitPerRef`
-	// commits from the history of each ref.
+	// Without the commit negotiation of the multi_ack modes, see
+	// getNegotiator, include up to `maxHavesToVisitPerRef` commits
+	// from the history of each ref.
 	walker := object.NewCommitPreorderIter(commit, haves, nil)
 	toVisit := maxHavesToVisitPerRef
 	return walker.ForEach(func(c *object.Commit) error {
@@ -653,6 +1168,26 @@ func getHaves(
 	return result, nil
 }
 
+// getNegotiator returns the negotiator of the haves in the multi_ack and
+// multi_ack_detailed modes, which walks the whole history of the local
+// references instead of a limited number of commits, see getHaves.
+func getNegotiator(
+	localRefs []*plumbing.Reference,
+	remoteRefStorer storer.ReferenceStorer,
+	s storage.Storer,
+) (packp.Negotiator, error) {
+	remoteRefs, err := getRemoteRefsFromStorer(remoteRefStorer)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommitNegotiator(s, localRefs, remoteRefs)
+}
+
+func isMultiACK(l *capability.List) bool {
+	return l.Supports(capability.MultiACK) || l.Supports(capability.MultiACKDetailed)
+}
+
 const refspecAllTags = "+refs/tags/*:refs/tags/*"
 
 func calculateRefs(
@@ -722,9 +1257,12 @@ func doCalculateRefs(
 	return err
 }
 
+// getWants returns the hashes of the references missing in the local
+// storage, or all of them when deepening, since their histories change.
 func getWants(
 	localStorer storage.Storer,
-	refs memory.ReferenceStorage) ([]plumbing.Hash, error) {
+	refs memory.ReferenceStorage,
+	deepen bool) ([]plumbing.Hash, error) {
 	wants := map[plumbing.Hash]bool{}
 	for _, ref := range refs {
 		hash := ref.Hash()
@@ -733,7 +1271,7 @@ func getWants(
 			return nil, err
 		}
 
-		if !exists {
+		if !exists || deepen {
 			wants[hash] = true
 		}
 	}
@@ -787,17 +1325,16 @@ func isFastForward(s storer.EncodedObjectStorer, old, new plumbing.Hash) (bool,
 		return false, err
 	}
 
-	found := false
-	iter := object.NewCommitPreorderIter(c, nil, nil)
-	err = iter.ForEach(func(c *object.Commit) error {
-		if c.Hash != old {
-			return nil
-		}
+	parent, err := object.GetCommit(s, old)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
 
-		found = true
-		return storer.ErrStop
-	})
-	return found, err
+	if err != nil {
+		return false, err
+	}
+
+	return parent.IsAncestor(c)
 }
 
 func (r *Remote) newUploadPackRequest(o *FetchOptions,
@@ -805,9 +1342,17 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 
 	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
 
-	if o.Depth != 0 {
-		req.Depth = packp.DepthCommits(o.Depth)
-		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+	if err := r.setUploadPackDepth(o, ar, req); err != nil {
+		return nil, err
+	}
+
+	if o.Filter != "" {
+		if !ar.Capabilities.Supports(capability.Filter) {
+			return nil, ErrFilterNotSupported
+		}
+
+		req.Filter = o.Filter
+		if err := req.Capabilities.Set(capability.Filter); err != nil {
 			return nil, err
 		}
 	}
@@ -835,6 +1380,72 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 	return req, nil
 }
 
+// setUploadPackDepth sets the shallows of the repository and the depth
+// requested by the options to the request, along with the capabilities
+// required by them.
+func (r *Remote) setUploadPackDepth(o *FetchOptions, ar *packp.AdvRefs,
+	req *packp.UploadPackRequest) error {
+
+	shallows, err := r.s.Shallow()
+	if err != nil {
+		return err
+	}
+
+	if len(shallows) != 0 && ar.Capabilities.Supports(capability.Shallow) {
+		req.Shallows = shallows
+	}
+
+	caps := []capability.Capability{capability.Shallow}
+	switch {
+	case o.Depth != 0:
+		req.Depth = packp.DepthCommits(o.Depth)
+	case o.Deepen != 0:
+		if !ar.Capabilities.Supports(capability.DeepenRelative) {
+			return ErrDeepenNotSupported
+		}
+
+		req.Depth = packp.DepthCommits(o.Deepen)
+		caps = append(caps, capability.DeepenRelative)
+	case o.Unshallow:
+		if len(shallows) != 0 {
+			req.Depth = packp.DepthCommits(infiniteDepth)
+		}
+	case !o.ShallowSince.IsZero() || len(o.ShallowExclude) != 0:
+		if !o.ShallowSince.IsZero() {
+			if !ar.Capabilities.Supports(capability.DeepenSince) {
+				return ErrShallowSinceNotSupported
+			}
+
+			caps = append(caps, capability.DeepenSince)
+		}
+
+		if len(o.ShallowExclude) != 0 {
+			if !ar.Capabilities.Supports(capability.DeepenNot) {
+				return ErrShallowExcludeNotSupported
+			}
+
+			caps = append(caps, capability.DeepenNot)
+		}
+
+		req.Depth = packp.DepthRevisions{
+			Since: o.ShallowSince,
+			Not:   o.ShallowExclude,
+		}
+	}
+
+	if req.Depth.IsZero() && len(req.Shallows) == 0 {
+		return nil
+	}
+
+	for _, c := range caps {
+		if err := req.Capabilities.Set(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func buildSidebandIfSupported(l *capability.List, reader io.Reader, p sideband.Progress) io.Reader {
 	var t sideband.Type
 
@@ -933,6 +1544,9 @@ func (r *Remote) updateLocalReferenceStorage(
 
 			if refUpdated {
 				updated = true
+				if err := r.logFetchUpdate(old, new); err != nil {
+					return updated, err
+				}
 			}
 		}
 	}
@@ -961,6 +1575,28 @@ func (r *Remote) updateLocalReferenceStorage(
 	return
 }
 
+// logFetchUpdate records in the reflog the update of a reference by a fetch.
+func (r *Remote) logFetchUpdate(old, new *plumbing.Reference) error {
+	action := "storing head"
+	oldHash := plumbing.ZeroHash
+	if old != nil {
+		oldHash = old.Hash()
+
+		ff, err := isFastForward(r.s, old.Hash(), new.Hash())
+		if err != nil {
+			return err
+		}
+
+		action = "fast-forward"
+		if !ff {
+			action = "forced-update"
+		}
+	}
+
+	msg := fmt.Sprintf("fetch %s: %s", r.c.Name, action)
+	return r.repo.logReferenceUpdate(new.Name(), oldHash, new.Hash(), nil, msg)
+}
+
 func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage) (updated bool, err error) {
 	for _, ref := range refs {
 		if !ref.Name().IsTag() {
@@ -989,16 +1625,21 @@ func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage) (updated bool, e
 	return
 }
 
-// List the references on the remote repository.
+// List the references on the remote repository, only the ones matched by the
+// refspecs of the options if any.
 func (r *Remote) List(o *ListOptions) (rfs []*plumbing.Reference, err error) {
-	s, err := newUploadPackSession(r.c.URLs[0], o.Auth)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	s, err := newUploadPackSession(r.c.URLs[0], o.Auth, r.protocolVersion())
 	if err != nil {
 		return nil, err
 	}
 
 	defer ioutil.CheckClose(s, &err)
 
-	ar, err := s.AdvertisedReferences()
+	ar, err := transport.AdvertisedReferencesWithPrefixes(s, refSpecsPrefixes(o.RefSpecs))
 	if err != nil {
 		return nil, err
 	}
@@ -1015,7 +1656,10 @@ func (r *Remote) List(o *ListOptions) (rfs []*plumbing.Reference, err error) {
 
 	var resultRefs []*plumbing.Reference
 	refs.ForEach(func(ref *plumbing.Reference) error {
-		resultRefs = append(resultRefs, ref)
+		if len(o.RefSpecs) == 0 || config.MatchAny(o.RefSpecs, ref.Name()) {
+			resultRefs = append(resultRefs, ref)
+		}
+
 		return nil
 	})
 
@@ -1062,9 +1706,15 @@ func pushHashes(
 	s storage.Storer,
 	req *packp.ReferenceUpdateRequest,
 	hs []plumbing.Hash,
+	bases []plumbing.Hash,
 	statusChan plumbing.StatusChan,
 ) (*packp.ReportStatus, error) {
 
+	if isDeleteOnly(req.Commands) {
+		// the server doesn't expect a packfile when only deleting references
+		return sess.ReceivePack(ctx, req)
+	}
+
 	rd, wr := io.Pipe()
 	req.Packfile = rd
 	config, err := s.Config()
@@ -1074,7 +1724,7 @@ func pushHashes(
 	done := make(chan error)
 	go func() {
 		e := packfile.NewEncoder(wr, s, false)
-		if _, err := e.Encode(hs, config.Pack.Window, statusChan); err != nil {
+		if _, err := e.EncodeThin(hs, bases, config.Pack.Window, statusChan); err != nil {
 			done <- wr.CloseWithError(err)
 			return
 		}
@@ -1094,8 +1744,118 @@ func pushHashes(
 	return rs, nil
 }
 
-func (r *Remote) updateShallow(o *FetchOptions, resp *packp.UploadPackResponse) error {
-	if o.Depth == 0 || len(resp.Shallows) == 0 {
+func isDeleteOnly(cmds []*packp.Command) bool {
+	for _, cmd := range cmds {
+		if cmd.Action() != packp.Delete {
+			return false
+		}
+	}
+
+	return true
+}
+
+// thinPackBases returns the objects the remote is expected to have that the
+// objects to push can be deltas of: the blobs and trees modified by the
+// pushed commits whose parents aren't pushed.
+func thinPackBases(s storer.EncodedObjectStorer, hs []plumbing.Hash) ([]plumbing.Hash, error) {
+	pushed := make(map[plumbing.Hash]bool, len(hs))
+	for _, h := range hs {
+		pushed[h] = true
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	var bases []plumbing.Hash
+	add := func(h plumbing.Hash) {
+		if !pushed[h] && !seen[h] {
+			seen[h] = true
+			bases = append(bases, h)
+		}
+	}
+
+	for _, h := range hs {
+		c, err := object.GetCommit(s, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		tree, err := c.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range c.ParentHashes {
+			if pushed[p] {
+				continue
+			}
+
+			parent, err := object.GetCommit(s, p)
+			if err == plumbing.ErrObjectNotFound {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			parentTree, err := parent.Tree()
+			if err != nil {
+				return nil, err
+			}
+
+			changes, err := object.DiffTree(parentTree, tree)
+			if err != nil {
+				return nil, err
+			}
+
+			add(parentTree.Hash)
+			for _, ch := range changes {
+				if ch.From.Tree == nil {
+					continue
+				}
+
+				add(ch.From.Tree.Hash)
+				add(ch.From.TreeEntry.Hash)
+			}
+		}
+	}
+
+	return bases, nil
+}
+
+// isShallowUpdated returns true if the shallow commits aren't the given ones
+// anymore.
+func (r *Remote) isShallowUpdated(old []plumbing.Hash) (bool, error) {
+	shallows, err := r.s.Shallow()
+	if err != nil {
+		return false, err
+	}
+
+	if len(shallows) != len(old) {
+		return true, nil
+	}
+
+	seen := make(map[plumbing.Hash]bool, len(old))
+	for _, h := range old {
+		seen[h] = true
+	}
+
+	for _, h := range shallows {
+		if !seen[h] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// updateShallow adds the new shallow commits of the response to the shallow
+// file and removes the ones unshallowed, the ones which history was fetched.
+func (r *Remote) updateShallow(resp *packp.UploadPackResponse) error {
+	if len(resp.Shallows) == 0 && len(resp.Unshallows) == 0 {
 		return nil
 	}
 
@@ -1104,15 +1864,21 @@ func (r *Remote) updateShallow(o *FetchOptions, resp *packp.UploadPackResponse)
 		return err
 	}
 
-outer:
-	for _, s := range resp.Shallows {
-		for _, oldS := range shallows {
-			if s == oldS {
-				continue outer
-			}
+	unshallows := make(map[plumbing.Hash]bool, len(resp.Unshallows))
+	for _, h := range resp.Unshallows {
+		unshallows[h] = true
+	}
+
+	var result []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool, len(shallows))
+	for _, h := range append(shallows, resp.Shallows...) {
+		if seen[h] || unshallows[h] {
+			continue
 		}
-		shallows = append(shallows, s)
+
+		seen[h] = true
+		result = append(result, h)
 	}
 
-	return r.s.SetShallow(shallows)
+	return r.s.SetShallow(result)
 }
@@ -3,14 +3,24 @@ package git
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
 
+	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 	"gopkg.in/src-d/go-git.v4/storage/memory"
@@ -757,6 +767,72 @@ func (s *RemoteSuite) TestList(c *C) {
 	}
 }
 
+func (s *RemoteSuite) TestListRefSpecs(c *C) {
+	for _, v := range []string{"0", "2"} {
+		sto := memory.NewStorage()
+		cfg, err := sto.Config()
+		c.Assert(err, IsNil)
+		cfg.Raw.Section("protocol").SetOption("version", v)
+		c.Assert(sto.SetConfig(cfg), IsNil)
+
+		remote := newRemote(sto, &config.RemoteConfig{
+			Name: DefaultRemoteName,
+			URLs: []string{s.GetBasicLocalRepositoryURL()},
+		})
+
+		refs, err := remote.List(&ListOptions{
+			RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"},
+		})
+		c.Assert(err, IsNil)
+
+		c.Assert(refs, Not(HasLen), 0)
+		for _, r := range refs {
+			c.Assert(r.Name().IsBranch(), Equals, true)
+		}
+	}
+}
+
+func (s *RemoteSuite) TestListInvalidRefSpec(c *C) {
+	remote := newRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	_, err := remote.List(&ListOptions{RefSpecs: []config.RefSpec{"foo"}})
+	c.Assert(err, Equals, config.ErrRefSpecMalformedSeparator)
+}
+
+func (s *RemoteSuite) TestFetchPrefixes(c *C) {
+	o := &FetchOptions{
+		RefSpecs: []config.RefSpec{
+			"+refs/heads/*:refs/remotes/origin/*",
+			"refs/pull/1/head:refs/remotes/origin/pr-1",
+		},
+		Tags: TagFollowing,
+	}
+
+	c.Assert(fetchPrefixes(o), DeepEquals, []string{
+		"refs/heads/", "refs/pull/1/head", "HEAD", "refs/tags/",
+	})
+
+	o.Tags = NoTags
+	c.Assert(fetchPrefixes(o), DeepEquals, []string{
+		"refs/heads/", "refs/pull/1/head", "HEAD",
+	})
+}
+
+func (s *RemoteSuite) TestProtocolVersion(c *C) {
+	sto := memory.NewStorage()
+	r := newRemote(sto, &config.RemoteConfig{Name: DefaultRemoteName})
+	c.Assert(r.protocolVersion(), Equals, transport.ProtocolV2)
+
+	cfg, err := sto.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("protocol").SetOption("version", "1")
+	c.Assert(sto.SetConfig(cfg), IsNil)
+	c.Assert(r.protocolVersion(), Equals, transport.ProtocolV1)
+}
+
 func (s *RemoteSuite) TestUpdateShallows(c *C) {
 	hashes := []plumbing.Hash{
 		plumbing.NewHash("0000000000000000000000000000000000000001"),
@@ -792,13 +868,9 @@ func (s *RemoteSuite) TestUpdateShallows(c *C) {
 	c.Assert(len(shallows), Equals, 0)
 
 	resp := new(packp.UploadPackResponse)
-	o := &FetchOptions{
-		Depth: 1,
-	}
-
 	for _, t := range tests {
 		resp.Shallows = t.hashes
-		err = remote.updateShallow(o, resp)
+		err = remote.updateShallow(resp)
 		c.Assert(err, IsNil)
 
 		shallow, err := remote.s.Shallow()
@@ -807,3 +879,428 @@ func (s *RemoteSuite) TestUpdateShallows(c *C) {
 		c.Assert(shallow, DeepEquals, t.result)
 	}
 }
+
+func (s *RemoteSuite) TestUpdateShallowsUnshallow(c *C) {
+	hashes := []plumbing.Hash{
+		plumbing.NewHash("0000000000000000000000000000000000000001"),
+		plumbing.NewHash("0000000000000000000000000000000000000002"),
+		plumbing.NewHash("0000000000000000000000000000000000000003"),
+	}
+
+	remote := newRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+	})
+	c.Assert(remote.s.SetShallow(hashes[0:2]), IsNil)
+
+	resp := new(packp.UploadPackResponse)
+	resp.Shallows = hashes[2:3]
+	resp.Unshallows = hashes[0:1]
+	c.Assert(remote.updateShallow(resp), IsNil)
+
+	shallows, err := remote.s.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallows, DeepEquals, hashes[1:3])
+
+	resp.Shallows = nil
+	resp.Unshallows = hashes[1:3]
+	c.Assert(remote.updateShallow(resp), IsNil)
+
+	shallows, err = remote.s.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallows, HasLen, 0)
+}
+
+// newLinearRepository returns the path of a new repository with a linear
+// history of the given number of commits, an hour apart, returned oldest
+// first. The tag v1 points to the second one.
+func (s *RemoteSuite) newLinearRepository(c *C, n int) (string, []plumbing.Hash) {
+	url := c.MkDir()
+	r, err := PlainInit(url, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	var commits []plumbing.Hash
+	when := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		when = when.Add(time.Hour)
+		h, err := w.Commit(fmt.Sprintf("commit %d", i), &CommitOptions{
+			Author: &object.Signature{Name: "foo", Email: "foo@foo.foo", When: when},
+		})
+		c.Assert(err, IsNil)
+		commits = append(commits, h)
+	}
+
+	_, err = r.CreateTag("v1", commits[1], nil)
+	c.Assert(err, IsNil)
+	return url, commits
+}
+
+func (s *RemoteSuite) testFetchShallow(c *C, version string) {
+	url, commits := s.newLinearRepository(c, 6)
+
+	r, err := PlainInit(c.MkDir(), true)
+	c.Assert(err, IsNil)
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section(protocolSection).SetOption(versionKey, version)
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name:  DefaultRemoteName,
+		URLs:  []string{url},
+		Fetch: []config.RefSpec{config.RefSpec(fmt.Sprintf(config.DefaultFetchRefSpec, DefaultRemoteName))},
+	})
+	c.Assert(err, IsNil)
+
+	assertShallow := func(o *FetchOptions, expected ...plumbing.Hash) {
+		err := r.Fetch(o)
+		c.Assert(err, IsNil)
+
+		shallows, err := r.Storer.Shallow()
+		c.Assert(err, IsNil)
+		c.Assert(shallows, DeepEquals, expected)
+
+		// the history is complete up to the shallow commit
+		first := commitIndex(commits, expected)
+		for i, h := range commits {
+			_, err := r.CommitObject(h)
+			if i < first {
+				c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+			} else {
+				c.Assert(err, IsNil)
+			}
+		}
+	}
+
+	assertShallow(&FetchOptions{Depth: 2}, commits[4])
+	assertShallow(&FetchOptions{Deepen: 1}, commits[3])
+	assertShallow(&FetchOptions{ShallowExclude: []string{"v1"}}, commits[2])
+	assertShallow(&FetchOptions{
+		ShallowSince: time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC),
+	}, commits[1])
+	assertShallow(&FetchOptions{Unshallow: true})
+
+	_, err = os.Stat(filepath.Join(r.Storer.(*filesystem.Storage).Filesystem().Root(), "shallow"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+// commitIndex returns the index of the first of the given commits, or 0 if
+// there are none.
+func commitIndex(commits []plumbing.Hash, hs []plumbing.Hash) int {
+	for i, h := range commits {
+		if len(hs) != 0 && h == hs[0] {
+			return i
+		}
+	}
+
+	return 0
+}
+
+func (s *RemoteSuite) TestFetchDumbHTTP(c *C) {
+	url, commits := s.newLinearRepository(c, 6)
+	cmd := exec.Command("git", "update-server-info")
+	cmd.Dir = url
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(filepath.Join(url, GitDirName))))
+	defer srv.Close()
+
+	_, err = PlainClone(c.MkDir(), true, &CloneOptions{URL: srv.URL, Depth: 1})
+	c.Assert(err, Equals, ErrDumbShallowNotSupported)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{URL: srv.URL})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, commits[5])
+
+	tag, err := r.Reference("refs/tags/v1", false)
+	c.Assert(err, IsNil)
+	c.Assert(tag.Hash(), Equals, commits[1])
+
+	for _, h := range commits {
+		_, err := r.CommitObject(h)
+		c.Assert(err, IsNil)
+	}
+
+	err = r.Fetch(&FetchOptions{})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+
+	for _, o := range []*FetchOptions{
+		{Depth: 1},
+		{Deepen: 1},
+		{ShallowSince: time.Now()},
+		{ShallowExclude: []string{"v1"}},
+	} {
+		c.Assert(r.Fetch(o), Equals, ErrDumbShallowNotSupported)
+	}
+}
+
+func (s *RemoteSuite) TestFetchShallowV0(c *C) {
+	s.testFetchShallow(c, "0")
+}
+
+func (s *RemoteSuite) TestFetchShallowV2(c *C) {
+	s.testFetchShallow(c, "2")
+}
+
+// newPushRepositories returns a repository with the history of
+// newLinearRepository, which remote origin is an empty bare repository,
+// returned along with its path.
+func (s *RemoteSuite) newPushRepositories(c *C) (*Repository, string, []plumbing.Hash) {
+	url, commits := s.newLinearRepository(c, 6)
+	r, err := PlainOpen(url)
+	c.Assert(err, IsNil)
+
+	dst := c.MkDir()
+	_, err = PlainInit(dst, true)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name:  DefaultRemoteName,
+		URLs:  []string{dst},
+		Fetch: []config.RefSpec{config.RefSpec(fmt.Sprintf(config.DefaultFetchRefSpec, DefaultRemoteName))},
+	})
+	c.Assert(err, IsNil)
+
+	return r, dst, commits
+}
+
+// setRemoteConfig sets the given option of the receive section of the
+// repository at url.
+func setRemoteConfig(c *C, url, key, value string) {
+	r, err := PlainOpen(url)
+	c.Assert(err, IsNil)
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("receive").SetOption(key, value)
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+}
+
+// setRemoteHook writes the given shell script as a hook of the bare
+// repository at url.
+func setRemoteHook(c *C, url, name, script string) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	hooks := filepath.Join(url, "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(
+		filepath.Join(hooks, name), []byte("#!/bin/sh\n"+script), 0755), IsNil)
+}
+
+func remoteReference(c *C, url string, name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	r, err := PlainOpen(url)
+	c.Assert(err, IsNil)
+	return r.Reference(name, false)
+}
+
+func (s *RemoteSuite) TestPushAtomic(c *C) {
+	r, dst, _ := s.newPushRepositories(c)
+	setRemoteHook(c, dst, "update", `test "$1" != refs/heads/bar`)
+
+	err := r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{
+			"refs/heads/master:refs/heads/foo",
+			"refs/heads/master:refs/heads/bar",
+		},
+		Atomic: true,
+	})
+	c.Assert(err, NotNil)
+
+	_, err = remoteReference(c, dst, "refs/heads/foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RemoteSuite) TestPushOptions(c *C) {
+	r, dst, _ := s.newPushRepositories(c)
+	setRemoteConfig(c, dst, "advertisePushOptions", "true")
+	setRemoteHook(c, dst, "post-receive",
+		`echo "$GIT_PUSH_OPTION_COUNT $GIT_PUSH_OPTION_0 $GIT_PUSH_OPTION_1" > options`)
+
+	err := r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		Options:  map[string]string{"ci.variable": "FOO=bar", "ci.skip": ""},
+	})
+	c.Assert(err, IsNil)
+
+	options, err := ioutil.ReadFile(filepath.Join(dst, "options"))
+	c.Assert(err, IsNil)
+	c.Assert(string(options), Equals, "2 ci.skip ci.variable=FOO=bar\n")
+}
+
+func (s *RemoteSuite) TestPushOptionsNotSupported(c *C) {
+	r, _, _ := s.newPushRepositories(c)
+
+	err := r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		Options:  map[string]string{"ci.skip": ""},
+	})
+	c.Assert(err, Equals, ErrPushOptionsNotSupported)
+}
+
+func (s *RemoteSuite) TestPushForceWithLease(c *C) {
+	r, dst, commits := s.newPushRepositories(c)
+	c.Assert(r.Push(&PushOptions{}), IsNil)
+
+	_, err := r.CreateTag("old", commits[3], nil)
+	c.Assert(err, IsNil)
+
+	spec := []config.RefSpec{"refs/tags/old:refs/heads/master"}
+
+	// the remote-tracking reference is expected by default
+	err = r.Push(&PushOptions{RefSpecs: spec, ForceWithLease: &ForceWithLease{}})
+	c.Assert(err, IsNil)
+
+	ref, err := remoteReference(c, dst, "refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, commits[3])
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		ForceWithLease: &ForceWithLease{Expected: map[plumbing.ReferenceName]plumbing.Hash{
+			"refs/heads/master": commits[5],
+		}},
+	})
+	c.Assert(err, ErrorMatches, "stale info: refs/heads/master")
+	c.Assert(err, DeepEquals, &StaleLeaseError{Name: "refs/heads/master"})
+	c.Assert(err.(*StaleLeaseError).Unwrap(), Equals, ErrStaleLease)
+
+	ref, err = remoteReference(c, dst, "refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, commits[3])
+}
+
+func (s *RemoteSuite) TestPushPrune(c *C) {
+	r, dst, commits := s.newPushRepositories(c)
+
+	c.Assert(r.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/foo", commits[1])), IsNil)
+	spec := []config.RefSpec{"refs/heads/*:refs/heads/*"}
+	c.Assert(r.Push(&PushOptions{RefSpecs: spec}), IsNil)
+
+	c.Assert(r.Storer.RemoveReference("refs/heads/foo"), IsNil)
+	c.Assert(r.Push(&PushOptions{RefSpecs: spec, Prune: true}), IsNil)
+
+	_, err := remoteReference(c, dst, "refs/heads/foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	_, err = remoteReference(c, dst, "refs/heads/master")
+	c.Assert(err, IsNil)
+}
+
+func (s *RemoteSuite) TestPushFollowTags(c *C) {
+	r, dst, commits := s.newPushRepositories(c)
+
+	tagger := &object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Now()}
+	_, err := r.CreateTag("annotated", commits[2], &CreateTagOptions{
+		Tagger: tagger, Message: "annotated",
+	})
+	c.Assert(err, IsNil)
+	_, err = r.CreateTag("unreachable", commits[5], &CreateTagOptions{
+		Tagger: tagger, Message: "unreachable",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/old", commits[3])), IsNil)
+
+	err = r.Push(&PushOptions{
+		RefSpecs:   []config.RefSpec{"refs/heads/old:refs/heads/old"},
+		FollowTags: true,
+	})
+	c.Assert(err, IsNil)
+
+	ref, err := remoteReference(c, dst, "refs/tags/annotated")
+	c.Assert(err, IsNil)
+	tag, err := r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(tag.Target, Equals, commits[2])
+
+	_, err = remoteReference(c, dst, "refs/tags/unreachable")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	// the lightweight tags aren't followed
+	_, err = remoteReference(c, dst, "refs/tags/v1")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RemoteSuite) TestPushFollowTagsRemoteCommits(c *C) {
+	r, dst, commits := s.newPushRepositories(c)
+	c.Assert(r.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/old", commits[3])), IsNil)
+	c.Assert(r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/old:refs/heads/old"},
+	}), IsNil)
+
+	tagger := &object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Now()}
+	_, err := r.CreateTag("old", commits[2], &CreateTagOptions{
+		Tagger: tagger, Message: "old",
+	})
+	c.Assert(err, IsNil)
+	_, err = r.CreateTag("new", commits[4], &CreateTagOptions{
+		Tagger: tagger, Message: "new",
+	})
+	c.Assert(err, IsNil)
+
+	err = r.Push(&PushOptions{
+		RefSpecs:   []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		FollowTags: true,
+	})
+	c.Assert(err, IsNil)
+
+	_, err = remoteReference(c, dst, "refs/tags/new")
+	c.Assert(err, IsNil)
+
+	// the commits the remote already has aren't walked
+	_, err = remoteReference(c, dst, "refs/tags/old")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RemoteSuite) TestPushSigned(c *C) {
+	r, dst, commits := s.newPushRepositories(c)
+	setRemoteConfig(c, dst, "certNonceSeed", "foo")
+	setRemoteHook(c, dst, "pre-receive", `echo "$GIT_PUSH_CERT_NONCE_STATUS" > nonce
+git cat-file blob "$GIT_PUSH_CERT" > cert`)
+
+	key := commitSignKey(c, true)
+	err := r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		SignKey:  key,
+	})
+	c.Assert(err, IsNil)
+
+	status, err := ioutil.ReadFile(filepath.Join(dst, "nonce"))
+	c.Assert(err, IsNil)
+	c.Assert(string(status), Equals, "OK\n")
+
+	cert, err := ioutil.ReadFile(filepath.Join(dst, "cert"))
+	c.Assert(err, IsNil)
+	c.Assert(string(cert), Matches, fmt.Sprintf(`(?s)certificate version 0\.1
+pusher foo bar <foo@foo\.foo> \d+ [+-]\d{4}
+pushee file://.*
+nonce \d+-[0-9a-f]{40}
+
+%s %s refs/heads/master
+-----BEGIN PGP SIGNATURE-----
+.*`, plumbing.ZeroHash, commits[5]))
+
+	i := bytes.Index(cert, []byte("-----BEGIN PGP SIGNATURE-----"))
+	_, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{key},
+		bytes.NewReader(cert[:i]), bytes.NewReader(cert[i:]))
+	c.Assert(err, IsNil)
+}
+
+func (s *RemoteSuite) TestPushSignedNotSupported(c *C) {
+	r, _, _ := s.newPushRepositories(c)
+
+	err := r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		SignKey:  commitSignKey(c, true),
+	})
+	c.Assert(err, Equals, ErrPushCertNotSupported)
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -8,14 +9,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/internal/revision"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	formatcfg "gopkg.in/src-d/go-git.v4/plumbing/format/config"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
@@ -41,6 +46,10 @@ var (
 	ErrIsBareRepository          = errors.New("worktree not available in a bare repository")
 	ErrUnableToResolveCommit     = errors.New("unable to resolve commit")
 	ErrPackedObjectsNotSupported = errors.New("Packed objects not supported")
+	// ErrTagExists an error stating the specified tag already exists
+	ErrTagExists = errors.New("tag already exists")
+	// ErrTagNotFound an error stating the specified tag does not exist
+	ErrTagNotFound = errors.New("tag not found")
 )
 
 // Repository represents a git repository
@@ -49,6 +58,14 @@ type Repository struct {
 
 	r  map[string]*Remote
 	wt billy.Filesystem
+	// scoped caches the system and global configs, see ConfigScoped.
+	scoped map[scopedConfigKey]*formatcfg.Config
+	// configMu serializes ConfigScoped, which marshals the config of the
+	// storer into its raw config and fills scoped.
+	configMu sync.Mutex
+	// promisorAuth is the auth method of the promisor remote, see
+	// SetPromisorAuth.
+	promisorAuth transport.AuthMethod
 }
 
 // Init creates an empty git repository, based on the given Storer and worktree.
@@ -361,14 +378,21 @@ func newRepository(s storage.Storer, worktree billy.Filesystem) *Repository {
 	}
 }
 
-// Config return the repository config
+// newRemote returns a remote of the repository with the given config.
+func (r *Repository) newRemote(c *config.RemoteConfig) *Remote {
+	return &Remote{s: r.Storer, c: c, repo: r}
+}
+
+// Config return the repository config, see ConfigScoped to read also the
+// global and system configs.
 func (r *Repository) Config() (*config.Config, error) {
 	return r.Storer.Config()
 }
 
-// Remote return a remote if exists
+// Remote return a remote if exists, the url.<base>.insteadOf rules of the
+// config, the global and system configs included, are applied to its URLs.
 func (r *Repository) Remote(name string) (*Remote, error) {
-	cfg, err := r.Storer.Config()
+	cfg, err := r.ConfigScoped(config.SystemScope)
 	if err != nil {
 		return nil, err
 	}
@@ -378,12 +402,12 @@ func (r *Repository) Remote(name string) (*Remote, error) {
 		return nil, ErrRemoteNotFound
 	}
 
-	return newRemote(r.Storer, c), nil
+	return r.newRemote(c), nil
 }
 
-// Remotes returns a list with all the remotes
+// Remotes returns a list with all the remotes, see Remote.
 func (r *Repository) Remotes() ([]*Remote, error) {
-	cfg, err := r.Storer.Config()
+	cfg, err := r.ConfigScoped(config.SystemScope)
 	if err != nil {
 		return nil, err
 	}
@@ -392,7 +416,7 @@ func (r *Repository) Remotes() ([]*Remote, error) {
 
 	var i int
 	for _, c := range cfg.Remotes {
-		remotes[i] = newRemote(r.Storer, c)
+		remotes[i] = r.newRemote(c)
 		i++
 	}
 
@@ -405,7 +429,7 @@ func (r *Repository) CreateRemote(c *config.RemoteConfig) (*Remote, error) {
 		return nil, err
 	}
 
-	remote := newRemote(r.Storer, c)
+	remote := r.newRemote(c)
 
 	cfg, err := r.Storer.Config()
 	if err != nil {
@@ -510,20 +534,33 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 	}
 
 	c := &config.RemoteConfig{
-		Name: o.RemoteName,
-		URLs: []string{o.URL},
+		Name:               o.RemoteName,
+		URLs:               []string{o.URL},
+		Promisor:           o.Filter != "",
+		PartialCloneFilter: string(o.Filter),
 	}
 
 	if _, err := r.CreateRemote(c); err != nil {
 		return err
 	}
 
+	if o.Filter != "" {
+		if err := r.setPartialClone(c.Name); err != nil {
+			return err
+		}
+
+		r.SetPromisorAuth(o.Auth)
+	}
+
 	ref, err := r.fetchAndUpdateReferences(ctx, &FetchOptions{
-		RefSpecs: r.cloneRefSpec(o, c),
-		Depth:    o.Depth,
-		Auth:     o.Auth,
-		Progress: o.Progress,
-		Tags:     o.Tags,
+		RefSpecs:       r.cloneRefSpec(o, c),
+		Depth:          o.Depth,
+		ShallowSince:   o.ShallowSince,
+		ShallowExclude: o.ShallowExclude,
+		Auth:           o.Auth,
+		Progress:       o.Progress,
+		Tags:           o.Tags,
+		Filter:         o.Filter,
 	}, o.ReferenceName)
 	if err != nil {
 		return err
@@ -540,10 +577,10 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 			return err
 		}
 
-		if err := w.Reset(&ResetOptions{
+		if err := w.reset(&ResetOptions{
 			Mode:   MergeReset,
 			Commit: head.Hash(),
-		}); err != nil {
+		}, ""); err != nil {
 			return err
 		}
 
@@ -659,7 +696,12 @@ func (r *Repository) fetchAndUpdateReferences(
 		return nil, err
 	}
 
-	refsUpdated, err := r.updateReferences(remote.c.Fetch, resolvedRef)
+	var msg string
+	if len(remote.c.URLs) != 0 {
+		msg = "clone: from " + remote.c.URLs[0]
+	}
+
+	refsUpdated, err := r.updateReferences(remote.c.Fetch, resolvedRef, msg)
 	if err != nil {
 		return nil, err
 	}
@@ -672,7 +714,7 @@ func (r *Repository) fetchAndUpdateReferences(
 }
 
 func (r *Repository) updateReferences(spec []config.RefSpec,
-	resolvedRef *plumbing.Reference) (updated bool, err error) {
+	resolvedRef *plumbing.Reference, msg string) (updated bool, err error) {
 
 	if !resolvedRef.Name().IsBranch() {
 		// Detached HEAD mode
@@ -680,10 +722,19 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 		if err != nil {
 			return false, err
 		}
+
+		old := resolvedReferenceHash(r.Storer, plumbing.HEAD)
 		head := plumbing.NewHashReference(plumbing.HEAD, h)
-		return updateReferenceStorerIfNeeded(r.Storer, head)
+		updated, err := updateReferenceStorerIfNeeded(r.Storer, head)
+		if err != nil || !updated {
+			return updated, err
+		}
+
+		return true, r.logReferenceUpdate(plumbing.HEAD, old, h, nil, msg)
 	}
 
+	old := resolvedReferenceHash(r.Storer, resolvedRef.Name())
+
 	refs := []*plumbing.Reference{
 		// Create local reference for the resolved ref
 		resolvedRef,
@@ -704,7 +755,11 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 		}
 	}
 
-	return
+	if old == resolvedRef.Hash() {
+		return
+	}
+
+	return updated, r.logReferenceUpdate(resolvedRef.Name(), old, resolvedRef.Hash(), nil, msg)
 }
 
 func (r *Repository) calculateRemoteHeadReference(spec []config.RefSpec,
@@ -919,9 +974,61 @@ func (r *Repository) CommitObjects() (object.CommitIter, error) {
 	return object.NewCommitIter(r.Storer, iter), nil
 }
 
+// MergeBase returns the best common ancestors of the given commits, see
+// object.Commit.MergeBase. If more than two commits are given, the common
+// ancestors of all of them are returned, like `git merge-base --octopus`.
+func (r *Repository) MergeBase(hashes ...plumbing.Hash) ([]*object.Commit, error) {
+	commits := make([]*object.Commit, 0, len(hashes))
+	for _, h := range hashes {
+		c, err := r.CommitObject(h)
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, c)
+	}
+
+	if len(commits) == 2 {
+		return commits[0].MergeBase(commits[1])
+	}
+
+	return object.MergeBaseOctopus(commits)
+}
+
+// IsAncestor returns true if the commit a is reachable from the commit b, a
+// commit is considered an ancestor of itself.
+func (r *Repository) IsAncestor(a, b plumbing.Hash) (bool, error) {
+	ca, err := r.CommitObject(a)
+	if err != nil {
+		return false, err
+	}
+
+	cb, err := r.CommitObject(b)
+	if err != nil {
+		return false, err
+	}
+
+	return ca.IsAncestor(cb)
+}
+
 // BlobObject returns a Blob with the given hash. If not found
-// plumbing.ErrObjectNotFound is returned.
+// plumbing.ErrObjectNotFound is returned. The blobs missing in a partial clone
+// are fetched from its promisor remote.
 func (r *Repository) BlobObject(h plumbing.Hash) (*object.Blob, error) {
+	b, err := object.GetBlob(r.Storer, h)
+	if err != plumbing.ErrObjectNotFound {
+		return b, err
+	}
+
+	fetched, ferr := r.fetchMissingObjects(context.Background(), []plumbing.Hash{h})
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	if !fetched {
+		return nil, err
+	}
+
 	return object.GetBlob(r.Storer, h)
 }
 
@@ -935,6 +1042,111 @@ func (r *Repository) BlobObjects() (*object.BlobIter, error) {
 	return object.NewBlobIter(r.Storer, iter), nil
 }
 
+// CreateTag creates a tag named name pointing to the object with the given
+// hash. If opts is nil a lightweight tag is created, otherwise an annotated
+// tag object is created, and signed if opts.SignKey is set, and the tag
+// points to it. ErrTagExists is returned if the tag already exists.
+func (r *Repository) CreateTag(name string, hash plumbing.Hash, opts *CreateTagOptions) (*plumbing.Reference, error) {
+	rname := plumbing.ReferenceName("refs/tags/" + name)
+	if err := rname.Validate(); err != nil {
+		return nil, err
+	}
+
+	_, err := r.Storer.Reference(rname)
+	switch err {
+	case nil:
+		return nil, ErrTagExists
+	case plumbing.ErrReferenceNotFound:
+	default:
+		return nil, err
+	}
+
+	target := hash
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, err
+		}
+
+		if target, err = r.createTagObject(name, hash, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	ref := plumbing.NewHashReference(rname, target)
+	if err := r.Storer.SetReference(ref); err != nil {
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+func (r *Repository) createTagObject(name string, hash plumbing.Hash, opts *CreateTagOptions) (plumbing.Hash, error) {
+	obj, err := r.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	tag := &object.Tag{
+		Name:       name,
+		Tagger:     *opts.Tagger,
+		Message:    opts.Message,
+		TargetType: obj.Type(),
+		Target:     hash,
+	}
+
+	if opts.SignKey != nil {
+		sig, err := r.buildTagSignature(tag, opts.SignKey)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		tag.PGPSignature = sig
+	}
+
+	obj = r.Storer.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return r.Storer.SetEncodedObject(obj)
+}
+
+func (r *Repository) buildTagSignature(tag *object.Tag, signKey *openpgp.Entity) (string, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := tag.Encode(encoded); err != nil {
+		return "", err
+	}
+
+	rd, err := encoded.Reader()
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&b, signKey, rd, nil); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// DeleteTag deletes the tag with the given name, ErrTagNotFound is returned
+// if it doesn't exist. The annotated tag object, if any, is kept in the
+// storage.
+func (r *Repository) DeleteTag(name string) error {
+	rname := plumbing.ReferenceName("refs/tags/" + name)
+	_, err := r.Storer.Reference(rname)
+	if err == plumbing.ErrReferenceNotFound {
+		return ErrTagNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return r.Storer.RemoveReference(rname)
+}
+
 // TagObject returns a Tag with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned. This method only returns
 // annotated Tags, no lightweight Tags.
@@ -1009,7 +1221,9 @@ func (r *Repository) Worktree() (*Worktree, error) {
 // ResolveRevision resolves revision to corresponding hash.
 //
 // Implemented resolvers : HEAD, branch, tag, heads/branch, refs/heads/branch,
-// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug})
+// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug}),
+// reflog entries (HEAD@{2}, master@{yesterday}, @{1.week.ago}), previously checked out branches (@{-1})
+// and upstream and push branches (master@{upstream}, @{u}, @{push})
 func (r *Repository) ResolveRevision(rev plumbing.Revision) (*plumbing.Hash, error) {
 	p := revision.NewParserFromString(string(rev))
 
@@ -1020,11 +1234,13 @@ func (r *Repository) ResolveRevision(rev plumbing.Revision) (*plumbing.Hash, err
 	}
 
 	var commit *object.Commit
+	var lastRef revision.Ref
 
 	for _, item := range items {
 		switch item.(type) {
 		case revision.Ref:
 			revisionRef := item.(revision.Ref)
+			lastRef = revisionRef
 			var ref *plumbing.Reference
 			var hashCommit, refCommit *object.Commit
 			var rErr, hErr error
@@ -1038,7 +1254,11 @@ func (r *Repository) ResolveRevision(rev plumbing.Revision) (*plumbing.Hash, err
 			}
 
 			if ref != nil {
-				refCommit, rErr = r.CommitObject(ref.Hash())
+				// annotated tags are peeled to the commit they point to
+				var h plumbing.Hash
+				if h, rErr = r.resolveToCommitHash(ref.Hash()); rErr == nil {
+					refCommit, rErr = r.CommitObject(h)
+				}
 			} else {
 				rErr = plumbing.ErrReferenceNotFound
 			}
@@ -1059,6 +1279,26 @@ func (r *Repository) ResolveRevision(rev plumbing.Revision) (*plumbing.Hash, err
 			default:
 				return &plumbing.ZeroHash, plumbing.ErrReferenceNotFound
 			}
+		case revision.AtReflog, revision.AtDate, revision.AtCheckout:
+			h, err := r.resolveReflogRevision(lastRef, item)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+
+			commit, err = r.CommitObject(h)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+		case revision.AtUpstream, revision.AtPush:
+			h, err := r.resolveUpstreamRevision(lastRef, item)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+
+			commit, err = r.CommitObject(h)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
 		case revision.CaretPath:
 			depth := item.(revision.CaretPath).Depth
 
@@ -13,6 +13,8 @@ import (
 	"testing"
 	"time"
 
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
@@ -1275,6 +1277,137 @@ func (s *RepositorySuite) TestTags(c *C) {
 	c.Assert(count, Equals, 5)
 }
 
+// tagTestRepository returns a repository with a single commit.
+func tagTestRepository(c *C) (*Repository, plumbing.Hash) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(w.Filesystem, "foo", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	return r, h
+}
+
+func (s *RepositorySuite) TestCreateTagLightweight(c *C) {
+	r, h := tagTestRepository(c)
+
+	ref, err := r.CreateTag("v1.0.0", h, nil)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name(), Equals, plumbing.ReferenceName("refs/tags/v1.0.0"))
+	c.Assert(ref.Hash(), Equals, h)
+
+	stored, err := r.Reference("refs/tags/v1.0.0", false)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Hash(), Equals, h)
+
+	_, err = r.CreateTag("v1.0.0", h, nil)
+	c.Assert(err, Equals, ErrTagExists)
+
+	_, err = r.CreateTag("v1..0", h, nil)
+	c.Assert(err, Equals, plumbing.ErrInvalidReferenceName)
+}
+
+func (s *RepositorySuite) TestCreateTagAnnotated(c *C) {
+	r, h := tagTestRepository(c)
+
+	ref, err := r.CreateTag("v1.0.0", h, &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "  release v1.0.0 ",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Not(Equals), h)
+
+	tag, err := r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(tag.Name, Equals, "v1.0.0")
+	c.Assert(tag.Message, Equals, "release v1.0.0\n")
+	c.Assert(tag.Target, Equals, h)
+	c.Assert(tag.TargetType, Equals, plumbing.CommitObject)
+	c.Assert(tag.Tagger.Email, Equals, "foo@foo.foo")
+
+	commit, err := tag.Commit()
+	c.Assert(err, IsNil)
+	c.Assert(commit.Hash, Equals, h)
+
+	hash, err := r.ResolveRevision("v1.0.0")
+	c.Assert(err, IsNil)
+	c.Assert(*hash, Equals, h)
+}
+
+func (s *RepositorySuite) TestCreateTagInvalidOptions(c *C) {
+	r, h := tagTestRepository(c)
+
+	_, err := r.CreateTag("v1.0.0", h, &CreateTagOptions{Message: "foo"})
+	c.Assert(err, Equals, ErrMissingTagger)
+
+	_, err = r.CreateTag("v1.0.0", h, &CreateTagOptions{Tagger: defaultSignature()})
+	c.Assert(err, Equals, ErrMissingMessage)
+
+	_, err = r.CreateTag("v1.0.0", plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"), &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "foo",
+	})
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+
+	_, err = r.Reference("refs/tags/v1.0.0", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RepositorySuite) TestCreateTagSigned(c *C) {
+	r, h := tagTestRepository(c)
+
+	key := commitSignKey(c, true)
+	ref, err := r.CreateTag("v1.0.0", h, &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "foo",
+		SignKey: key,
+	})
+	c.Assert(err, IsNil)
+
+	pks := new(bytes.Buffer)
+	pkw, err := armor.Encode(pks, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key.Serialize(pkw), IsNil)
+	c.Assert(pkw.Close(), IsNil)
+
+	tag, err := r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+
+	actual, err := tag.Verify(pks.String())
+	c.Assert(err, IsNil)
+	c.Assert(actual.PrimaryKey, DeepEquals, key.PrimaryKey)
+}
+
+func (s *RepositorySuite) TestDeleteTag(c *C) {
+	r, h := tagTestRepository(c)
+
+	ref, err := r.CreateTag("v1.0.0", h, &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "foo",
+	})
+	c.Assert(err, IsNil)
+
+	err = r.DeleteTag("v1.0.0")
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference("refs/tags/v1.0.0", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	_, err = r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+
+	err = r.DeleteTag("v1.0.0")
+	c.Assert(err, Equals, ErrTagNotFound)
+}
+
 func (s *RepositorySuite) TestBranches(c *C) {
 	f := fixtures.ByURL("https://github.com/git-fixtures/root-references.git").One()
 	sto, err := filesystem.NewStorage(f.DotGit())
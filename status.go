@@ -44,8 +44,8 @@ func (s Status) String() string {
 			continue
 		}
 
-		if status.Staging == Renamed {
-			path = fmt.Sprintf("%s -> %s", path, status.Extra)
+		if status.Staging == Renamed || status.Staging == Copied {
+			path = fmt.Sprintf("%s -> %s", status.Extra, path)
 		}
 
 		fmt.Fprintf(buf, "%c%c %s\n", status.Staging, status.Worktree, path)
@@ -14,9 +14,11 @@ import (
 
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 
 	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
 )
 
 const (
@@ -29,11 +31,17 @@ const (
 	objectsPath    = "objects"
 	packPath       = "pack"
 	refsPath       = "refs"
+	logsPath       = "logs"
+
+	rebaseMergePath = "rebase-merge"
+	mergeMsgPath    = "MERGE_MSG"
 
 	tmpPackedRefsPrefix = "._packed-refs"
+	incomingPrefix      = "incoming-"
 
-	packExt = ".pack"
-	idxExt  = ".idx"
+	packExt     = ".pack"
+	idxExt      = ".idx"
+	promisorExt = ".promisor"
 )
 
 var (
@@ -55,12 +63,18 @@ var (
 	// targeting a non-existing object. This usually means the repository
 	// is corrupt.
 	ErrSymRefTargetNotFound = errors.New("symbolic reference target not found")
+	// ErrNotIncoming is returned by Migrate and RemoveIncoming when the
+	// DotGit isn't an incoming one, returned by Incoming.
+	ErrNotIncoming = errors.New("not an incoming object directory")
 )
 
 // The DotGit type represents a local git repository on disk. This
 // type is not zero-value-safe, use the New function to initialize it.
 type DotGit struct {
 	fs billy.Filesystem
+	// objects is the path of the object directory, objects unless the
+	// DotGit is an incoming one.
+	objects string
 
 	// incoming object directory information
 	incomingChecked bool
@@ -71,7 +85,7 @@ type DotGit struct {
 // be the absolute path of a git repository directory (e.g.
 // "/foo/bar/.git").
 func New(fs billy.Filesystem) *DotGit {
-	return &DotGit{fs: fs}
+	return &DotGit{fs: fs, objects: objectsPath}
 }
 
 // Initialize creates all the folder scaffolding.
@@ -126,6 +140,17 @@ func (d *DotGit) ShallowWriter() (billy.File, error) {
 	return d.fs.Create(shallowPath)
 }
 
+// RemoveShallow removes the shallow file, if any, making the repository a
+// complete one.
+func (d *DotGit) RemoveShallow() error {
+	err := d.fs.Remove(shallowPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
 // Shallow returns a file pointer for read to the shallow file
 func (d *DotGit) Shallow() (billy.File, error) {
 	f, err := d.fs.Open(shallowPath)
@@ -140,15 +165,126 @@ func (d *DotGit) Shallow() (billy.File, error) {
 	return f, nil
 }
 
+// ReflogWriter returns a file pointer for write to the reflog file of the
+// given reference, the file is truncated.
+func (d *DotGit) ReflogWriter(name plumbing.ReferenceName) (billy.File, error) {
+	return d.fs.Create(d.reflogPath(name))
+}
+
+// ReflogAppender returns a file pointer to append entries to the reflog file
+// of the given reference, the file is created if it doesn't exist.
+func (d *DotGit) ReflogAppender(name plumbing.ReferenceName) (billy.File, error) {
+	return d.fs.OpenFile(d.reflogPath(name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+}
+
+// Reflog returns a file pointer for read to the reflog file of the given
+// reference, if the reference has no reflog nil is returned.
+func (d *DotGit) Reflog(name plumbing.ReferenceName) (billy.File, error) {
+	f, err := d.fs.Open(d.reflogPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// RemoveReflog removes the reflog file of the given reference, if any.
+func (d *DotGit) RemoveReflog(name plumbing.ReferenceName) error {
+	err := d.fs.Remove(d.reflogPath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *DotGit) reflogPath(name plumbing.ReferenceName) string {
+	return d.fs.Join(logsPath, name.String())
+}
+
+// RebaseMergeFileWriter returns a file pointer for write to the given file of
+// the rebase-merge directory, the state of an in-progress rebase. The file is
+// truncated.
+func (d *DotGit) RebaseMergeFileWriter(name string) (billy.File, error) {
+	return d.fs.Create(d.fs.Join(rebaseMergePath, name))
+}
+
+// RebaseMergeFile returns a file pointer for read to the given file of the
+// rebase-merge directory, if the file doesn't exist nil is returned.
+func (d *DotGit) RebaseMergeFile(name string) (billy.File, error) {
+	f, err := d.fs.Open(d.fs.Join(rebaseMergePath, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// RemoveRebaseMerge removes the rebase-merge directory, if any.
+func (d *DotGit) RemoveRebaseMerge() error {
+	err := util.RemoveAll(d.fs, rebaseMergePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// MergeMsgWriter returns a file pointer for write to the MERGE_MSG file, the
+// message of an in-progress merge. The file is truncated.
+func (d *DotGit) MergeMsgWriter() (billy.File, error) {
+	return d.fs.Create(mergeMsgPath)
+}
+
+// MergeMsg returns a file pointer for read to the MERGE_MSG file, if the file
+// doesn't exist nil is returned.
+func (d *DotGit) MergeMsg() (billy.File, error) {
+	f, err := d.fs.Open(mergeMsgPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// RemoveMergeMsg removes the MERGE_MSG file, if any.
+func (d *DotGit) RemoveMergeMsg() error {
+	err := d.fs.Remove(mergeMsgPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
 // NewObjectPack return a writer for a new packfile, it saves the packfile to
 // disk and also generates and save the index for the given packfile.
 func (d *DotGit) NewObjectPack(statusChan plumbing.StatusChan) (*PackWriter, error) {
-	return newPackWrite(d.fs, statusChan)
+	return newPackWrite(d.fs, d.objects, nil, statusChan)
+}
+
+// NewThinObjectPack return a writer for a new packfile like NewObjectPack,
+// accepting thin packfiles: the bases of the deltas missing in the packfile
+// are read from the given storage and appended to it.
+func (d *DotGit) NewThinObjectPack(bases storer.EncodedObjectStorer, statusChan plumbing.StatusChan) (*PackWriter, error) {
+	return newPackWrite(d.fs, d.objects, bases, statusChan)
 }
 
 // ObjectPacks returns the list of availables packfiles
 func (d *DotGit) ObjectPacks() ([]plumbing.Hash, error) {
-	packDir := d.fs.Join(objectsPath, packPath)
+	packDir := d.fs.Join(d.objects, packPath)
 	files, err := d.fs.ReadDir(packDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -177,7 +313,7 @@ func (d *DotGit) ObjectPacks() ([]plumbing.Hash, error) {
 }
 
 func (d *DotGit) objectPackPath(hash plumbing.Hash, extension string) string {
-	return d.fs.Join(objectsPath, packPath, fmt.Sprintf("pack-%s.%s", hash.String(), extension))
+	return d.fs.Join(d.objects, packPath, fmt.Sprintf("pack-%s.%s", hash.String(), extension))
 }
 
 func (d *DotGit) objectPackOpen(hash plumbing.Hash, extension string) (billy.File, error) {
@@ -219,12 +355,30 @@ func (d *DotGit) DeleteOldObjectPackAndIndex(hash plumbing.Hash, t time.Time) er
 	if err != nil {
 		return err
 	}
+
+	err = d.fs.Remove(d.objectPackPath(hash, `promisor`))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	return d.fs.Remove(d.objectPackPath(hash, `idx`))
 }
 
+// IsPromisorPack returns true if the given packfile was fetched from a
+// promisor remote, the objects it references may be missing in the
+// repository.
+func (d *DotGit) IsPromisorPack(hash plumbing.Hash) (bool, error) {
+	_, err := d.fs.Stat(d.objectPackPath(hash, `promisor`))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
 // NewObject return a writer for a new object file.
 func (d *DotGit) NewObject() (*ObjectWriter, error) {
-	return newObjectWriter(d.fs)
+	return newObjectWriter(d.fs, d.objects)
 }
 
 // Objects returns a slice with the hashes of objects found under the
@@ -244,7 +398,7 @@ func (d *DotGit) Objects() ([]plumbing.Hash, error) {
 // Objects returns a slice with the hashes of objects found under the
 // .git/objects/ directory.
 func (d *DotGit) ForEachObjectHash(fun func(plumbing.Hash) error) error {
-	files, err := d.fs.ReadDir(objectsPath)
+	files, err := d.fs.ReadDir(d.objects)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -256,7 +410,7 @@ func (d *DotGit) ForEachObjectHash(fun func(plumbing.Hash) error) error {
 	for _, f := range files {
 		if f.IsDir() && len(f.Name()) == 2 && isHex(f.Name()) {
 			base := f.Name()
-			d, err := d.fs.ReadDir(d.fs.Join(objectsPath, base))
+			d, err := d.fs.ReadDir(d.fs.Join(d.objects, base))
 			if err != nil {
 				return err
 			}
@@ -280,7 +434,7 @@ func (d *DotGit) ForEachObjectHash(fun func(plumbing.Hash) error) error {
 
 func (d *DotGit) objectPath(h plumbing.Hash) string {
 	hash := h.String()
-	return d.fs.Join(objectsPath, hash[0:2], hash[2:40])
+	return d.fs.Join(d.objects, hash[0:2], hash[2:40])
 }
 
 // incomingObjectPath is intended to add support for a git pre-receive hook
@@ -295,20 +449,20 @@ func (d *DotGit) incomingObjectPath(h plumbing.Hash) string {
 	hString := h.String()
 
 	if d.incomingDirName == "" {
-		return d.fs.Join(objectsPath, hString[0:2], hString[2:40])
+		return d.fs.Join(d.objects, hString[0:2], hString[2:40])
 	}
 
-	return d.fs.Join(objectsPath, d.incomingDirName, hString[0:2], hString[2:40])
+	return d.fs.Join(d.objects, d.incomingDirName, hString[0:2], hString[2:40])
 }
 
 // hasIncomingObjects searches for an incoming directory and keeps its name
 // so it doesn't have to be found each time an object is accessed.
 func (d *DotGit) hasIncomingObjects() bool {
 	if !d.incomingChecked {
-		directoryContents, err := d.fs.ReadDir(objectsPath)
+		directoryContents, err := d.fs.ReadDir(d.objects)
 		if err == nil {
 			for _, file := range directoryContents {
-				if strings.HasPrefix(file.Name(), "incoming-") && file.IsDir() {
+				if strings.HasPrefix(file.Name(), incomingPrefix) && file.IsDir() {
 					d.incomingDirName = file.Name()
 				}
 			}
@@ -359,6 +513,90 @@ func (d *DotGit) ObjectDelete(h plumbing.Hash) error {
 	return err1
 }
 
+// Incoming returns a DotGit storing new objects in an incoming directory of
+// the object directory, objects/incoming-<random>, apart from the ones of the
+// repository until Migrate is called, as git-receive-pack quarantines the
+// objects it receives. Only its object methods are meant to be used.
+func (d *DotGit) Incoming() (*DotGit, error) {
+	dir, err := util.TempDir(d.fs, objectsPath, incomingPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.fs.MkdirAll(d.fs.Join(dir, packPath), os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &DotGit{fs: d.fs, objects: dir}, nil
+}
+
+// Migrate moves the objects of an incoming DotGit, returned by Incoming, to
+// the object directory of the repository and removes its incoming directory.
+// The indexes of the packfiles are moved last, so the packfiles are complete
+// once they are found.
+func (d *DotGit) Migrate() error {
+	if d.objects == objectsPath {
+		return ErrNotIncoming
+	}
+
+	r := New(d.fs)
+	packs, err := d.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range packs {
+		for _, ext := range []string{`pack`, `promisor`, `idx`} {
+			err := d.moveObjectFile(d.objectPackPath(h, ext), r.objectPackPath(h, ext))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = d.ForEachObjectHash(func(h plumbing.Hash) error {
+		return d.moveObjectFile(d.objectPath(h), r.objectPath(h))
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.RemoveIncoming()
+}
+
+// moveObjectFile moves the given file of an incoming directory, if any, unless
+// the repository already has it.
+func (d *DotGit) moveObjectFile(from, to string) error {
+	if _, err := d.fs.Stat(from); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	_, err := d.fs.Stat(to)
+	if err == nil {
+		return d.fs.Remove(from)
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	return d.fs.Rename(from, to)
+}
+
+// RemoveIncoming removes the incoming directory of an incoming DotGit,
+// returned by Incoming, along with the objects not migrated.
+func (d *DotGit) RemoveIncoming() error {
+	if d.objects == objectsPath {
+		return ErrNotIncoming
+	}
+
+	return util.RemoveAll(d.fs, d.objects)
+}
+
 func (d *DotGit) readReferenceFrom(rd io.Reader, name string) (ref *plumbing.Reference, err error) {
 	b, err := stdioutil.ReadAll(rd)
 	if err != nil {
@@ -590,6 +590,90 @@ func (s *SuiteDotGit) TestObjectDelete(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *SuiteDotGit) TestIncoming(c *C) {
+	tmp, err := ioutil.TempDir("", "dot-git")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(tmp)
+
+	fs := osfs.New(tmp)
+	dir := New(fs)
+	c.Assert(dir.Initialize(), IsNil)
+
+	incoming, err := dir.Incoming()
+	c.Assert(err, IsNil)
+
+	w, err := incoming.NewObject()
+	c.Assert(err, IsNil)
+	c.Assert(w.WriteHeader(plumbing.BlobObject, 14), IsNil)
+	_, err = w.Write([]byte("this is a test"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	hash := plumbing.NewHash("a8a940627d132695a9769df883f85992f0ff4a43")
+	pack := plumbing.NewHash("0eb8e1fbb7d1b1e8bd3a6d0e8ee9bbb2b45a6c1e")
+	for _, ext := range []string{"pack", "idx"} {
+		f, err := fs.Create(incoming.objectPackPath(pack, ext))
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+	}
+
+	objects, err := incoming.Objects()
+	c.Assert(err, IsNil)
+	c.Assert(objects, DeepEquals, []plumbing.Hash{hash})
+
+	objects, err = dir.Objects()
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 0)
+	packs, err := dir.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+
+	err = incoming.Migrate()
+	c.Assert(err, IsNil)
+
+	_, err = dir.ObjectStat(hash)
+	c.Assert(err, IsNil)
+	packs, err = dir.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, DeepEquals, []plumbing.Hash{pack})
+	_, err = fs.Stat(dir.objectPackPath(pack, "idx"))
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat(incoming.objects)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SuiteDotGit) TestRemoveIncoming(c *C) {
+	tmp, err := ioutil.TempDir("", "dot-git")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(tmp)
+
+	fs := osfs.New(tmp)
+	dir := New(fs)
+	c.Assert(dir.Initialize(), IsNil)
+
+	incoming, err := dir.Incoming()
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(incoming.objects, fs.Join("objects", "incoming-")), Equals, true)
+
+	w, err := incoming.NewObject()
+	c.Assert(err, IsNil)
+	c.Assert(w.WriteHeader(plumbing.BlobObject, 0), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	err = incoming.RemoveIncoming()
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat(incoming.objects)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	objects, err := dir.Objects()
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 0)
+
+	c.Assert(dir.Migrate(), Equals, ErrNotIncoming)
+	c.Assert(dir.RemoveIncoming(), Equals, ErrNotIncoming)
+}
+
 func (s *SuiteDotGit) TestObjectNotFound(c *C) {
 	fs := fixtures.ByTag(".git").ByTag("unpacked").One().DotGit()
 	dir := New(fs)
@@ -9,6 +9,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/objfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 
 	"gopkg.in/src-d/go-billy.v4"
 )
@@ -21,8 +22,13 @@ import (
 // location, if the PackWriter is not used, nothing is written
 type PackWriter struct {
 	Notify func(plumbing.Hash, *idxfile.Writer)
+	// Promisor marks the packfile as fetched from a promisor remote, the
+	// objects it references may be missing, see DotGit.IsPromisorPack.
+	Promisor bool
 
 	fs         billy.Filesystem
+	objects    string
+	bases      storer.EncodedObjectStorer
 	fr, fw     billy.File
 	synced     *syncedReader
 	checksum   plumbing.Hash
@@ -32,8 +38,8 @@ type PackWriter struct {
 	statusChan plumbing.StatusChan
 }
 
-func newPackWrite(fs billy.Filesystem, statusChan plumbing.StatusChan) (*PackWriter, error) {
-	fw, err := fs.TempFile(fs.Join(objectsPath, packPath), "tmp_pack_")
+func newPackWrite(fs billy.Filesystem, objects string, bases storer.EncodedObjectStorer, statusChan plumbing.StatusChan) (*PackWriter, error) {
+	fw, err := fs.TempFile(fs.Join(objects, packPath), "tmp_pack_")
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +51,8 @@ func newPackWrite(fs billy.Filesystem, statusChan plumbing.StatusChan) (*PackWri
 
 	writer := &PackWriter{
 		fs:         fs,
+		objects:    objects,
+		bases:      bases,
 		fw:         fw,
 		fr:         fr,
 		synced:     newSyncedReader(fw, fr),
@@ -60,7 +68,7 @@ func (w *PackWriter) buildIndex() {
 	s := packfile.NewScanner(w.synced)
 	w.writer = new(idxfile.Writer)
 	var err error
-	w.parser, err = packfile.NewParser(s, w.writer, packfile.NewStatusObserver(w.statusChan))
+	w.parser, err = packfile.NewParserWithBases(s, w.bases, w.writer, packfile.NewStatusObserver(w.statusChan))
 	if err != nil {
 		w.result <- err
 		return
@@ -111,6 +119,10 @@ func (w *PackWriter) Close() error {
 		return err
 	}
 
+	if err := w.thicken(); err != nil {
+		return err
+	}
+
 	if err := w.fr.Close(); err != nil {
 		return err
 	}
@@ -126,12 +138,37 @@ func (w *PackWriter) Close() error {
 	return w.save()
 }
 
+// thicken appends to a thin packfile the bases of its deltas missing in it,
+// adding them to the index.
+func (w *PackWriter) thicken() error {
+	if w.parser == nil || w.writer == nil || !w.writer.Finished() {
+		return nil
+	}
+
+	bases := w.parser.ExternalBases()
+	if len(bases) == 0 {
+		return nil
+	}
+
+	checksum, entries, err := packfile.Thicken(w.fw, w.bases, bases)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		w.writer.Add(e.Hash, e.Offset, e.CRC32)
+	}
+
+	w.checksum = checksum
+	return w.writer.OnFooter(checksum)
+}
+
 func (w *PackWriter) clean() error {
 	return w.fs.Remove(w.fw.Name())
 }
 
 func (w *PackWriter) save() error {
-	base := w.fs.Join(objectsPath, packPath, fmt.Sprintf("pack-%s", w.checksum))
+	base := w.fs.Join(w.objects, packPath, fmt.Sprintf("pack-%s", w.checksum))
 	idx, err := w.fs.Create(fmt.Sprintf("%s.idx", base))
 	if err != nil {
 		return err
@@ -145,9 +182,26 @@ func (w *PackWriter) save() error {
 		return err
 	}
 
+	if w.Promisor {
+		if err := w.savePromisor(base); err != nil {
+			return err
+		}
+	}
+
 	return w.fs.Rename(w.fw.Name(), fmt.Sprintf("%s.pack", base))
 }
 
+// savePromisor writes the empty .promisor file marking the packfile as a
+// promisor packfile, as git does.
+func (w *PackWriter) savePromisor(base string) error {
+	f, err := w.fs.Create(fmt.Sprintf("%s%s", base, promisorExt))
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
 func (w *PackWriter) encodeIdx(writer io.Writer) error {
 	idx, err := w.writer.Index()
 	if err != nil {
@@ -249,20 +303,22 @@ func (s *syncedReader) Close() error {
 
 type ObjectWriter struct {
 	objfile.Writer
-	fs billy.Filesystem
-	f  billy.File
+	fs      billy.Filesystem
+	objects string
+	f       billy.File
 }
 
-func newObjectWriter(fs billy.Filesystem) (*ObjectWriter, error) {
-	f, err := fs.TempFile(fs.Join(objectsPath, packPath), "tmp_obj_")
+func newObjectWriter(fs billy.Filesystem, objects string) (*ObjectWriter, error) {
+	f, err := fs.TempFile(fs.Join(objects, packPath), "tmp_obj_")
 	if err != nil {
 		return nil, err
 	}
 
 	return &ObjectWriter{
-		Writer: (*objfile.NewWriter(f)),
-		fs:     fs,
-		f:      f,
+		Writer:  (*objfile.NewWriter(f)),
+		fs:      fs,
+		objects: objects,
+		f:       f,
 	}, nil
 }
 
@@ -280,7 +336,7 @@ func (w *ObjectWriter) Close() error {
 
 func (w *ObjectWriter) save() error {
 	hash := w.Hash().String()
-	file := w.fs.Join(objectsPath, hash[0:2], hash[2:40])
+	file := w.fs.Join(w.objects, hash[0:2], hash[2:40])
 
 	return w.fs.Rename(w.f.Name(), file)
 }
@@ -1,16 +1,19 @@
 package dotgit
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/src-d/go-billy.v4/osfs"
@@ -146,7 +149,7 @@ func (s *SuiteDotGit) TestPackWriterUnusedNotify(c *C) {
 
 	fs := osfs.New(dir)
 
-	w, err := newPackWrite(fs, nil)
+	w, err := newPackWrite(fs, objectsPath, nil, nil)
 	c.Assert(err, IsNil)
 
 	w.Notify = func(h plumbing.Hash, idx *idxfile.Writer) {
@@ -155,3 +158,70 @@ func (s *SuiteDotGit) TestPackWriterUnusedNotify(c *C) {
 
 	c.Assert(w.Close(), IsNil)
 }
+
+func (s *SuiteDotGit) TestNewThinObjectPack(c *C) {
+	dir, err := ioutil.TempDir("", "example")
+	c.Assert(err, IsNil)
+
+	defer os.RemoveAll(dir)
+
+	bases := memory.NewStorage()
+	setBlob := func(content string) plumbing.Hash {
+		o := bases.NewEncodedObject()
+		o.SetType(plumbing.BlobObject)
+		ow, err := o.Writer()
+		c.Assert(err, IsNil)
+		_, err = ow.Write([]byte(content))
+		c.Assert(err, IsNil)
+		c.Assert(ow.Close(), IsNil)
+
+		h, err := bases.SetEncodedObject(o)
+		c.Assert(err, IsNil)
+		return h
+	}
+
+	content := strings.Repeat("content of the base\n", 100)
+	base := setBlob(content)
+	target := setBlob(content + "content of the target\n")
+
+	var buf bytes.Buffer
+	e := packfile.NewEncoder(&buf, bases, false)
+	_, err = e.EncodeThin([]plumbing.Hash{target}, []plumbing.Hash{base}, 10, nil)
+	c.Assert(err, IsNil)
+
+	fs := osfs.New(dir)
+	dot := New(fs)
+
+	w, err := dot.NewThinObjectPack(bases, nil)
+	c.Assert(err, IsNil)
+
+	_, err = io.Copy(w, &buf)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	packs, err := dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+
+	idxf, err := dot.ObjectPackIdx(packs[0])
+	c.Assert(err, IsNil)
+	defer idxf.Close()
+
+	idx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(idxf).Decode(idx), IsNil)
+	for _, h := range []plumbing.Hash{base, target} {
+		ok, err := idx.Contains(h)
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+	}
+
+	pf, err := dot.ObjectPack(packs[0])
+	c.Assert(err, IsNil)
+	defer pf.Close()
+
+	p, err := packfile.NewParser(packfile.NewScanner(pf))
+	c.Assert(err, IsNil)
+	checksum, err := p.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, packs[0])
+}
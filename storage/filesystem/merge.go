@@ -0,0 +1,50 @@
+package filesystem
+
+import (
+	stdioutil "io/ioutil"
+
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// MergeStorage stores the message of an in-progress merge in the MERGE_MSG
+// file of the .git directory, as git does.
+type MergeStorage struct {
+	dir *dotgit.DotGit
+}
+
+// MergeMessage returns the message of the in-progress merge, or an empty
+// string if there is no merge in progress.
+func (s *MergeStorage) MergeMessage() (msg string, err error) {
+	f, err := s.dir.MergeMsg()
+	if f == nil || err != nil {
+		return "", err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	b, err := stdioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// SetMergeMessage stores the message of the in-progress merge.
+func (s *MergeStorage) SetMergeMessage(msg string) (err error) {
+	f, err := s.dir.MergeMsgWriter()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	_, err = f.Write([]byte(msg))
+	return err
+}
+
+// RemoveMergeMessage deletes the message of the in-progress merge, if any.
+func (s *MergeStorage) RemoveMergeMessage() error {
+	return s.dir.RemoveMergeMsg()
+}
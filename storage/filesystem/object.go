@@ -77,15 +77,30 @@ func (s *ObjectStorage) NewEncodedObject() plumbing.EncodedObject {
 }
 
 func (s *ObjectStorage) PackfileWriter(statusChan plumbing.StatusChan) (io.WriteCloser, error) {
+	return s.packfileWriter(s, statusChan, false)
+}
+
+// PromisorPackfileWriter returns a writer for a packfile fetched from a
+// promisor remote, marked as a promisor packfile, see
+// storer.PromisorPackfileWriter.
+func (s *ObjectStorage) PromisorPackfileWriter(statusChan plumbing.StatusChan) (io.WriteCloser, error) {
+	return s.packfileWriter(s, statusChan, true)
+}
+
+// packfileWriter returns a writer for a packfile, the bases of its deltas
+// missing in it are read from the given storage.
+func (s *ObjectStorage) packfileWriter(bases storer.EncodedObjectStorer, statusChan plumbing.StatusChan, promisor bool) (*dotgit.PackWriter, error) {
 	if err := s.requireIndex(); err != nil {
 		return nil, err
 	}
 
-	w, err := s.dir.NewObjectPack(statusChan)
+	w, err := s.dir.NewThinObjectPack(bases, statusChan)
 	if err != nil {
 		return nil, err
 	}
 
+	w.Promisor = promisor
+
 	w.Notify = func(h plumbing.Hash, writer *idxfile.Writer) {
 		index, err := writer.Index()
 		if err == nil {
@@ -0,0 +1,111 @@
+package filesystem
+
+import (
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// Quarantine returns a quarantine storing the new objects in an incoming
+// directory of the object directory, see dotgit.DotGit.Incoming.
+func (s *ObjectStorage) Quarantine() (storer.Quarantine, error) {
+	dir, err := s.dir.Incoming()
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := NewObjectStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quarantine{ObjectStorage: o, storage: s}, nil
+}
+
+// quarantine is a storer.Quarantine of an ObjectStorage, the objects missing
+// in its incoming directory are read from the storage.
+type quarantine struct {
+	ObjectStorage
+	storage *ObjectStorage
+}
+
+// PackfileWriter returns a writer for a packfile written to the incoming
+// directory, the bases of the deltas of thin packfiles can be in the storage.
+func (q *quarantine) PackfileWriter(statusChan plumbing.StatusChan) (io.WriteCloser, error) {
+	return q.packfileWriter(q, statusChan, false)
+}
+
+// EncodedObject returns the object with the given hash, from the incoming
+// directory or the storage.
+func (q *quarantine) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.ObjectStorage.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.EncodedObject(t, h)
+	}
+
+	return obj, err
+}
+
+// DeltaObject returns the object with the given hash, from the incoming
+// directory or the storage, without resolving deltas.
+func (q *quarantine) DeltaObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.ObjectStorage.DeltaObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.DeltaObject(t, h)
+	}
+
+	return obj, err
+}
+
+// HasEncodedObject returns nil if the object exists in the incoming directory
+// or in the storage.
+func (q *quarantine) HasEncodedObject(h plumbing.Hash) error {
+	err := q.ObjectStorage.HasEncodedObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.HasEncodedObject(h)
+	}
+
+	return err
+}
+
+// EncodedObjectSize returns the plaintext size of the given object, from the
+// incoming directory or the storage.
+func (q *quarantine) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := q.ObjectStorage.EncodedObjectSize(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.EncodedObjectSize(h)
+	}
+
+	return size, err
+}
+
+// Migrate moves the objects of the incoming directory to the object directory,
+// making the packfiles moved available to the storage.
+func (q *quarantine) Migrate() error {
+	packs, err := q.dir.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	if err := q.dir.Migrate(); err != nil {
+		return err
+	}
+
+	if q.storage.index == nil {
+		return nil
+	}
+
+	for _, h := range packs {
+		if err := q.storage.loadIdxFile(h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Discard removes the incoming directory along with its objects.
+func (q *quarantine) Discard() error {
+	return q.dir.RemoveIncoming()
+}
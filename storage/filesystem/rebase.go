@@ -0,0 +1,171 @@
+package filesystem
+
+import (
+	"fmt"
+	stdioutil "io/ioutil"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/rebase"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+const (
+	rebaseHeadNameFile = "head-name"
+	rebaseOntoFile     = "onto"
+	rebaseOrigHeadFile = "orig-head"
+	rebaseTodoFile     = "git-rebase-todo"
+	rebaseDoneFile     = "done"
+	rebaseMsgNumFile   = "msgnum"
+	rebaseEndFile      = "end"
+	rebaseStoppedFile  = "stopped-sha"
+
+	// detachedHeadName is the head-name of a rebase started with HEAD
+	// detached.
+	detachedHeadName = "detached HEAD"
+)
+
+// RebaseStorage stores the state of an in-progress rebase in the
+// rebase-merge folder of the .git directory, as git does.
+type RebaseStorage struct {
+	dir *dotgit.DotGit
+}
+
+// RebaseState returns the state of the in-progress rebase, or nil if there is
+// no rebase in progress.
+func (s *RebaseStorage) RebaseState() (*rebase.State, error) {
+	headName, ok, err := s.readFile(rebaseHeadNameFile)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	st := &rebase.State{}
+	if headName != detachedHeadName {
+		st.HeadName = plumbing.ReferenceName(headName)
+	}
+
+	for name, h := range map[string]*plumbing.Hash{
+		rebaseOntoFile:     &st.Onto,
+		rebaseOrigHeadFile: &st.OrigHead,
+		rebaseStoppedFile:  &st.Stopped,
+	} {
+		v, _, err := s.readFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if v != "" {
+			*h = plumbing.NewHash(v)
+		}
+	}
+
+	if st.Todo, err = s.readCommands(rebaseTodoFile); err != nil {
+		return nil, err
+	}
+
+	if st.Done, err = s.readCommands(rebaseDoneFile); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// SetRebaseState stores the state of the in-progress rebase.
+func (s *RebaseStorage) SetRebaseState(st *rebase.State) error {
+	if err := s.writeCommands(rebaseTodoFile, st.Todo); err != nil {
+		return err
+	}
+
+	if err := s.writeCommands(rebaseDoneFile, st.Done); err != nil {
+		return err
+	}
+
+	headName := st.HeadName.String()
+	if headName == "" {
+		headName = detachedHeadName
+	}
+
+	stopped := ""
+	if !st.Stopped.IsZero() {
+		stopped = st.Stopped.String()
+	}
+
+	for _, f := range []struct {
+		name, content string
+	}{
+		{rebaseOntoFile, st.Onto.String()},
+		{rebaseOrigHeadFile, st.OrigHead.String()},
+		{rebaseMsgNumFile, fmt.Sprint(len(st.Done))},
+		{rebaseEndFile, fmt.Sprint(len(st.Done) + len(st.Todo))},
+		{rebaseStoppedFile, stopped},
+		// head-name is written the last one, its presence tells that there
+		// is a rebase in progress
+		{rebaseHeadNameFile, headName},
+	} {
+		if err := s.writeFile(f.name, f.content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveRebaseState deletes the state of the in-progress rebase, if any.
+func (s *RebaseStorage) RemoveRebaseState() error {
+	return s.dir.RemoveRebaseMerge()
+}
+
+// readFile returns the content of the given file of the rebase-merge
+// directory without the trailing line break, and false if it doesn't exist.
+func (s *RebaseStorage) readFile(name string) (content string, ok bool, err error) {
+	f, err := s.dir.RebaseMergeFile(name)
+	if f == nil || err != nil {
+		return "", false, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	b, err := stdioutil.ReadAll(f)
+	if err != nil {
+		return "", false, err
+	}
+
+	return strings.TrimSpace(string(b)), true, nil
+}
+
+func (s *RebaseStorage) writeFile(name, content string) (err error) {
+	f, err := s.dir.RebaseMergeFileWriter(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	if content == "" {
+		return nil
+	}
+
+	_, err = fmt.Fprintf(f, "%s\n", content)
+	return err
+}
+
+func (s *RebaseStorage) readCommands(name string) (cmds []*rebase.Command, err error) {
+	f, err := s.dir.RebaseMergeFile(name)
+	if f == nil || err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return rebase.NewDecoder(f).DecodeAll()
+}
+
+func (s *RebaseStorage) writeCommands(name string, cmds []*rebase.Command) (err error) {
+	f, err := s.dir.RebaseMergeFileWriter(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return rebase.NewEncoder(f).EncodeAll(cmds)
+}
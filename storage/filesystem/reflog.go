@@ -0,0 +1,59 @@
+package filesystem
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// ReflogStorage stores the reflogs in the logs folder of the .git directory.
+type ReflogStorage struct {
+	dir *dotgit.DotGit
+}
+
+// Reflog returns the entries of the reflog of the given reference.
+func (s *ReflogStorage) Reflog(n plumbing.ReferenceName) (entries []*reflog.Entry, err error) {
+	f, err := s.dir.Reflog(n)
+	if f == nil || err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewDecoder(f).DecodeAll()
+}
+
+// AppendReflog adds an entry at the end of the reflog of the given reference.
+func (s *ReflogStorage) AppendReflog(n plumbing.ReferenceName, e *reflog.Entry) (err error) {
+	f, err := s.dir.ReflogAppender(n)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewEncoder(f).Encode(e)
+}
+
+// SetReflog replaces all the entries of the reflog of the given reference.
+func (s *ReflogStorage) SetReflog(n plumbing.ReferenceName, entries []*reflog.Entry) (err error) {
+	f, err := s.dir.ReflogWriter(n)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	e := reflog.NewEncoder(f)
+	for _, entry := range entries {
+		if err := e.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveReflog deletes the reflog of the given reference.
+func (s *ReflogStorage) RemoveReflog(n plumbing.ReferenceName) error {
+	return s.dir.RemoveReflog(n)
+}
@@ -18,6 +18,9 @@ type Storage struct {
 	ReferenceStorage
 	IndexStorage
 	ShallowStorage
+	ReflogStorage
+	RebaseStorage
+	MergeStorage
 	ConfigStorage
 	ModuleStorage
 }
@@ -38,6 +41,9 @@ func NewStorage(fs billy.Filesystem) (*Storage, error) {
 		ReferenceStorage: ReferenceStorage{dir: dir},
 		IndexStorage:     IndexStorage{dir: dir},
 		ShallowStorage:   ShallowStorage{dir: dir},
+		ReflogStorage:    ReflogStorage{dir: dir},
+		RebaseStorage:    RebaseStorage{dir: dir},
+		MergeStorage:     MergeStorage{dir: dir},
 		ConfigStorage:    ConfigStorage{dir: dir},
 		ModuleStorage:    ModuleStorage{dir: dir},
 	}, nil
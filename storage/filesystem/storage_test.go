@@ -33,6 +33,7 @@ func (s *StorageSuite) SetUpTest(c *C) {
 	var _ storer.ShallowStorer = storage
 	var _ storer.DeltaObjectStorer = storage
 	var _ storer.PackfileWriter = storage
+	var _ storer.Quarantiner = storage
 
 	s.BaseStorageSuite = test.NewBaseStorageSuite(storage)
 	s.BaseStorageSuite.SetUpTest(c)
@@ -8,6 +8,8 @@ import (
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/rebase"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage"
 )
@@ -25,6 +27,9 @@ type Storage struct {
 	ShallowStorage
 	IndexStorage
 	ReferenceStorage
+	ReflogStorage
+	RebaseStorage
+	MergeStorage
 	ModuleStorage
 }
 
@@ -32,6 +37,7 @@ type Storage struct {
 func NewStorage() *Storage {
 	return &Storage{
 		ReferenceStorage: make(ReferenceStorage),
+		ReflogStorage:    make(ReflogStorage),
 		ConfigStorage:    ConfigStorage{},
 		ShallowStorage:   ShallowStorage{},
 		ObjectStorage: ObjectStorage{
@@ -240,6 +246,69 @@ func (tx *TxObjectStorage) Rollback() error {
 	return nil
 }
 
+// Quarantine returns a quarantine storing the new objects in memory, apart
+// from the ones of the storage.
+func (o *ObjectStorage) Quarantine() (storer.Quarantine, error) {
+	q := &QuarantineObjectStorage{Storage: o}
+	return q, q.Discard()
+}
+
+// QuarantineObjectStorage is a storer.Quarantine of an ObjectStorage, the
+// objects missing in it are read from the storage.
+type QuarantineObjectStorage struct {
+	ObjectStorage
+	Storage *ObjectStorage
+}
+
+func (q *QuarantineObjectStorage) HasEncodedObject(h plumbing.Hash) error {
+	err := q.ObjectStorage.HasEncodedObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.Storage.HasEncodedObject(h)
+	}
+
+	return err
+}
+
+func (q *QuarantineObjectStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := q.ObjectStorage.EncodedObjectSize(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.Storage.EncodedObjectSize(h)
+	}
+
+	return size, err
+}
+
+func (q *QuarantineObjectStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.ObjectStorage.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.Storage.EncodedObject(t, h)
+	}
+
+	return obj, err
+}
+
+func (q *QuarantineObjectStorage) Migrate() error {
+	for _, obj := range q.Objects {
+		if _, err := q.Storage.SetEncodedObject(obj); err != nil {
+			return err
+		}
+	}
+
+	return q.Discard()
+}
+
+func (q *QuarantineObjectStorage) Discard() error {
+	q.ObjectStorage = ObjectStorage{
+		Objects: make(map[plumbing.Hash]plumbing.EncodedObject),
+		Commits: make(map[plumbing.Hash]plumbing.EncodedObject),
+		Trees:   make(map[plumbing.Hash]plumbing.EncodedObject),
+		Blobs:   make(map[plumbing.Hash]plumbing.EncodedObject),
+		Tags:    make(map[plumbing.Hash]plumbing.EncodedObject),
+	}
+
+	return nil
+}
+
 type ReferenceStorage map[plumbing.ReferenceName]*plumbing.Reference
 
 func (r ReferenceStorage) SetReference(ref *plumbing.Reference) error {
@@ -318,6 +387,84 @@ func (s ShallowStorage) Shallow() ([]plumbing.Hash, error) {
 	return s, nil
 }
 
+type ReflogStorage map[plumbing.ReferenceName][]*reflog.Entry
+
+func (s ReflogStorage) Reflog(n plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	return append([]*reflog.Entry(nil), s[n]...), nil
+}
+
+func (s ReflogStorage) AppendReflog(n plumbing.ReferenceName, e *reflog.Entry) error {
+	s[n] = append(s[n], e)
+	return nil
+}
+
+func (s ReflogStorage) SetReflog(n plumbing.ReferenceName, entries []*reflog.Entry) error {
+	s[n] = append([]*reflog.Entry(nil), entries...)
+	return nil
+}
+
+func (s ReflogStorage) RemoveReflog(n plumbing.ReferenceName) error {
+	delete(s, n)
+	return nil
+}
+
+type RebaseStorage struct {
+	state *rebase.State
+}
+
+func (s *RebaseStorage) RebaseState() (*rebase.State, error) {
+	return copyRebaseState(s.state), nil
+}
+
+func (s *RebaseStorage) SetRebaseState(st *rebase.State) error {
+	s.state = copyRebaseState(st)
+	return nil
+}
+
+func (s *RebaseStorage) RemoveRebaseState() error {
+	s.state = nil
+	return nil
+}
+
+type MergeStorage struct {
+	msg string
+}
+
+func (s *MergeStorage) MergeMessage() (string, error) {
+	return s.msg, nil
+}
+
+func (s *MergeStorage) SetMergeMessage(msg string) error {
+	s.msg = msg
+	return nil
+}
+
+func (s *MergeStorage) RemoveMergeMessage() error {
+	s.msg = ""
+	return nil
+}
+
+func copyRebaseState(st *rebase.State) *rebase.State {
+	if st == nil {
+		return nil
+	}
+
+	c := *st
+	c.Todo = copyRebaseCommands(st.Todo)
+	c.Done = copyRebaseCommands(st.Done)
+	return &c
+}
+
+func copyRebaseCommands(cmds []*rebase.Command) []*rebase.Command {
+	var result []*rebase.Command
+	for _, cmd := range cmds {
+		c := *cmd
+		result = append(result, &c)
+	}
+
+	return result
+}
+
 type ModuleStorage map[string]*Storage
 
 func (s ModuleStorage) Module(name string) (storage.Storer, error) {
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/rebase"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage"
 
@@ -264,6 +267,119 @@ func (s *BaseStorageSuite) TestObjectStorerTxSetObjectAndRollback(c *C) {
 	c.Assert(err, Equals, io.EOF)
 }
 
+func (s *BaseStorageSuite) TestQuarantineSetEncodedObjectAndMigrate(c *C) {
+	qs, ok := s.Storer.(storer.Quarantiner)
+	if !ok {
+		c.Skip("not a storer.Quarantiner")
+	}
+
+	blob := s.testObjects[plumbing.BlobObject]
+	_, err := s.Storer.SetEncodedObject(blob.Object)
+	c.Assert(err, IsNil)
+
+	q, err := qs.Quarantine()
+	c.Assert(err, IsNil)
+	for _, o := range s.testObjects {
+		if o.Type == plumbing.BlobObject {
+			continue
+		}
+
+		h, err := q.SetEncodedObject(o.Object)
+		c.Assert(err, IsNil)
+		c.Assert(h.String(), Equals, o.Hash)
+	}
+
+	for _, o := range s.testObjects {
+		obj, err := q.EncodedObject(o.Type, plumbing.NewHash(o.Hash))
+		c.Assert(err, IsNil)
+		c.Assert(obj.Hash().String(), Equals, o.Hash)
+	}
+
+	c.Assert(objectsCount(c, q), Equals, 3)
+	c.Assert(objectsCount(c, s.Storer), Equals, 1)
+
+	err = q.Migrate()
+	c.Assert(err, IsNil)
+
+	for _, o := range s.testObjects {
+		obj, err := s.Storer.EncodedObject(o.Type, plumbing.NewHash(o.Hash))
+		c.Assert(err, IsNil)
+		c.Assert(obj.Hash().String(), Equals, o.Hash)
+	}
+}
+
+func (s *BaseStorageSuite) TestQuarantinePackfileWriterAndMigrate(c *C) {
+	qs, ok := s.Storer.(storer.Quarantiner)
+	if !ok {
+		c.Skip("not a storer.Quarantiner")
+	}
+
+	q, err := qs.Quarantine()
+	c.Assert(err, IsNil)
+
+	pwr, ok := q.(storer.PackfileWriter)
+	if !ok {
+		c.Skip("not a storer.PackWriter")
+	}
+
+	pw, err := pwr.PackfileWriter(nil)
+	c.Assert(err, IsNil)
+
+	f := fixtures.Basic().One()
+	_, err = io.Copy(pw, f.Packfile())
+	c.Assert(err, IsNil)
+
+	err = pw.Close()
+	c.Assert(err, IsNil)
+
+	c.Assert(objectsCount(c, q), Equals, 31)
+	c.Assert(objectsCount(c, s.Storer), Equals, 0)
+
+	err = q.Migrate()
+	c.Assert(err, IsNil)
+
+	c.Assert(objectsCount(c, s.Storer), Equals, 31)
+	_, err = s.Storer.EncodedObject(plumbing.CommitObject, f.Head)
+	c.Assert(err, IsNil)
+}
+
+func (s *BaseStorageSuite) TestQuarantineDiscard(c *C) {
+	qs, ok := s.Storer.(storer.Quarantiner)
+	if !ok {
+		c.Skip("not a storer.Quarantiner")
+	}
+
+	q, err := qs.Quarantine()
+	c.Assert(err, IsNil)
+	for _, o := range s.testObjects {
+		h, err := q.SetEncodedObject(o.Object)
+		c.Assert(err, IsNil)
+		c.Assert(h.String(), Equals, o.Hash)
+	}
+
+	err = q.Discard()
+	c.Assert(err, IsNil)
+
+	c.Assert(objectsCount(c, s.Storer), Equals, 0)
+	for _, o := range s.testObjects {
+		_, err := s.Storer.EncodedObject(o.Type, plumbing.NewHash(o.Hash))
+		c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+	}
+}
+
+func objectsCount(c *C, s storer.EncodedObjectStorer) int {
+	iter, err := s.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+
+	var count int
+	err = iter.ForEach(func(plumbing.EncodedObject) error {
+		count++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return count
+}
+
 func (s *BaseStorageSuite) TestSetReferenceAndGetReference(c *C) {
 	err := s.Storer.SetReference(
 		plumbing.NewReferenceFromStrings("foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
@@ -346,6 +462,132 @@ func (s *BaseStorageSuite) TestSetShallowAndShallow(c *C) {
 	c.Assert(result, DeepEquals, expected)
 }
 
+func (s *BaseStorageSuite) TestReflog(c *C) {
+	rs, ok := s.Storer.(storer.ReflogStorer)
+	if !ok {
+		c.Skip("not a storer.ReflogStorer")
+	}
+
+	name := plumbing.ReferenceName("refs/heads/foo")
+	entries, err := rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	expected := []*reflog.Entry{{
+		New:     plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
+		Name:    "foo",
+		Email:   "foo@foo.com",
+		When:    time.Unix(1257894000, 0).In(time.FixedZone("", 3600)),
+		Message: "branch: Created from HEAD",
+	}, {
+		Old:     plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
+		New:     plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733"),
+		Name:    "bar",
+		Email:   "bar@bar.com",
+		When:    time.Unix(1257895000, 0).In(time.FixedZone("", -7200)),
+		Message: "commit: foo",
+	}}
+
+	for _, e := range expected {
+		c.Assert(rs.AppendReflog(name, e), IsNil)
+	}
+
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	assertReflogEntries(c, entries, expected)
+
+	c.Assert(rs.SetReflog(name, expected[1:]), IsNil)
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	assertReflogEntries(c, entries, expected[1:])
+
+	c.Assert(rs.RemoveReflog(name), IsNil)
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func assertReflogEntries(c *C, obtained, expected []*reflog.Entry) {
+	c.Assert(obtained, HasLen, len(expected))
+	for i, e := range expected {
+		c.Assert(obtained[i].Old, Equals, e.Old)
+		c.Assert(obtained[i].New, Equals, e.New)
+		c.Assert(obtained[i].Name, Equals, e.Name)
+		c.Assert(obtained[i].Email, Equals, e.Email)
+		c.Assert(obtained[i].When.Equal(e.When), Equals, true)
+		c.Assert(obtained[i].Message, Equals, e.Message)
+	}
+}
+
+func (s *BaseStorageSuite) TestRebaseState(c *C) {
+	rs, ok := s.Storer.(storer.RebaseStorer)
+	if !ok {
+		c.Skip("not a storer.RebaseStorer")
+	}
+
+	st, err := rs.RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st, IsNil)
+
+	expected := &rebase.State{
+		HeadName: "refs/heads/foo",
+		Onto:     plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
+		OrigHead: plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733"),
+		Todo: []*rebase.Command{{
+			Action:  rebase.Pick,
+			Hash:    plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+			Subject: "foo",
+		}},
+		Done: []*rebase.Command{{
+			Action:  rebase.Pick,
+			Hash:    plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+			Subject: "bar",
+		}},
+		Stopped: plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+	}
+
+	c.Assert(rs.SetRebaseState(expected), IsNil)
+	st, err = rs.RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st, DeepEquals, expected)
+
+	expected.HeadName = ""
+	expected.Todo = nil
+	expected.Stopped = plumbing.ZeroHash
+	c.Assert(rs.SetRebaseState(expected), IsNil)
+	st, err = rs.RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st, DeepEquals, expected)
+
+	c.Assert(rs.RemoveRebaseState(), IsNil)
+	st, err = rs.RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st, IsNil)
+}
+
+func (s *BaseStorageSuite) TestMergeMessage(c *C) {
+	ms, ok := s.Storer.(storer.MergeStorer)
+	if !ok {
+		c.Skip("not a storer.MergeStorer")
+	}
+
+	msg, err := ms.MergeMessage()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "")
+
+	c.Assert(ms.SetMergeMessage("Merge branch 'foo'\n"), IsNil)
+	msg, err = ms.MergeMessage()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "Merge branch 'foo'\n")
+
+	c.Assert(ms.RemoveMergeMessage(), IsNil)
+	msg, err = ms.MergeMessage()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "")
+
+	c.Assert(ms.RemoveMergeMessage(), IsNil)
+}
+
 func (s *BaseStorageSuite) TestSetConfigAndConfig(c *C) {
 	expected := config.NewConfig()
 	expected.Core.IsBare = true
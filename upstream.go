@@ -0,0 +1,261 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/internal/revision"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	formatcfg "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrNoUpstream is returned when the branch doesn't have an upstream
+	// branch configured.
+	ErrNoUpstream = errors.New("no upstream configured for branch")
+	// ErrDetachedHEAD is returned when the current branch is required but
+	// HEAD doesn't point to a branch.
+	ErrDetachedHEAD = errors.New("HEAD does not point to a branch")
+)
+
+// BranchStatus describes how a local branch relates to its upstream branch.
+type BranchStatus struct {
+	// Branch is the full name of the local branch.
+	Branch plumbing.ReferenceName
+	// Upstream is the full name of the upstream branch, usually a remote
+	// branch, such as refs/remotes/origin/master.
+	Upstream plumbing.ReferenceName
+	// Ahead is the number of commits of the branch not in the upstream.
+	Ahead int
+	// Behind is the number of commits of the upstream not in the branch.
+	Behind int
+}
+
+// String returns the status in a human readable form, such as
+// "3 ahead, 1 behind origin/master".
+func (s *BranchStatus) String() string {
+	upstream := s.Upstream.Short()
+	switch {
+	case s.Ahead == 0 && s.Behind == 0:
+		return fmt.Sprintf("up to date with %s", upstream)
+	case s.Behind == 0:
+		return fmt.Sprintf("%d ahead of %s", s.Ahead, upstream)
+	case s.Ahead == 0:
+		return fmt.Sprintf("%d behind %s", s.Behind, upstream)
+	}
+
+	return fmt.Sprintf("%d ahead, %d behind %s", s.Ahead, s.Behind, upstream)
+}
+
+// BranchStatus returns the upstream branch of the local branch with the
+// given name, as configured in branch.<name>.remote and branch.<name>.merge,
+// and the number of commits the branch is ahead and behind it. If the branch
+// doesn't have an upstream ErrNoUpstream is returned.
+func (r *Repository) BranchStatus(name string) (*BranchStatus, error) {
+	branch := plumbing.ReferenceName("refs/heads/" + name)
+	local, err := storer.ResolveReference(r.Storer, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	upstream, err := r.upstream(name)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := storer.ResolveReference(r.Storer, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := r.CommitObject(local.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := r.CommitObject(remote.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	ahead, behind, err := a.AheadBehind(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BranchStatus{
+		Branch:   branch,
+		Upstream: upstream,
+		Ahead:    ahead,
+		Behind:   behind,
+	}, nil
+}
+
+// resolveUpstreamRevision resolves the @{upstream} and @{push} revisions of
+// the given ref or, if empty, of the current branch.
+func (r *Repository) resolveUpstreamRevision(ref revision.Ref, item revision.Revisioner) (plumbing.Hash, error) {
+	name, err := r.upstreamBranchName(ref)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var target plumbing.ReferenceName
+	if _, ok := item.(revision.AtPush); ok {
+		target, err = r.pushDestination(name)
+	} else {
+		target, err = r.upstream(name)
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	resolved, err := storer.ResolveReference(r.Storer, target)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return resolved.Hash(), nil
+}
+
+// upstreamBranchName returns the short name of the branch of the given ref
+// or, if empty, of the current branch.
+func (r *Repository) upstreamBranchName(ref revision.Ref) (string, error) {
+	if ref == "" || ref == "HEAD" {
+		head, err := r.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return "", err
+		}
+
+		if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+			return "", ErrDetachedHEAD
+		}
+
+		return head.Target().Short(), nil
+	}
+
+	name := strings.TrimPrefix(string(ref), "refs/heads/")
+	if _, err := r.Storer.Reference(plumbing.ReferenceName("refs/heads/" + name)); err != nil {
+		return "", fmt.Errorf("no such branch: '%s'", name)
+	}
+
+	return name, nil
+}
+
+// upstream returns the full name of the upstream branch of the local branch
+// with the given name, the remote branch where branch.<name>.merge is fetched
+// to or, if branch.<name>.remote is ".", the local branch it names.
+func (r *Repository) upstream(name string) (plumbing.ReferenceName, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return "", err
+	}
+
+	b, ok := cfg.Branches[name]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return "", ErrNoUpstream
+	}
+
+	if b.Remote == "." {
+		return b.Merge, nil
+	}
+
+	return remoteTrackingBranch(cfg, b.Remote, b.Merge)
+}
+
+// pushDestination returns the full name of the remote branch where the local
+// branch with the given name would be pushed to, as configured by
+// branch.<name>.pushRemote, remote.pushDefault and push.default.
+func (r *Repository) pushDestination(name string) (plumbing.ReferenceName, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return "", err
+	}
+
+	raw := cfg.Raw
+	if raw == nil {
+		raw = formatcfg.New()
+	}
+
+	var remote string
+	if b, ok := cfg.Branches[name]; ok {
+		remote = b.Remote
+	}
+
+	fetchRemote := remote
+	if v := configSubsectionOption(raw, "branch", name, "pushRemote"); v != "" {
+		remote = v
+	} else if v := configOption(raw, "remote", "pushDefault"); v != "" {
+		remote = v
+	}
+
+	if remote == "" {
+		return "", ErrNoUpstream
+	}
+
+	branch := plumbing.ReferenceName("refs/heads/" + name)
+	switch mode := configOption(raw, "push", "default"); mode {
+	case "nothing":
+		return "", errors.New("push has no destination (push.default is 'nothing')")
+	case "upstream", "tracking":
+		if remote != fetchRemote {
+			return "", errors.New("cannot resolve 'upstream' push to a different remote")
+		}
+
+		return r.upstream(name)
+	case "", "simple":
+		if remote != fetchRemote {
+			break
+		}
+
+		upstream, err := r.upstream(name)
+		if err != nil {
+			return "", err
+		}
+
+		b := cfg.Branches[name]
+		if remote != "." && b.Merge != branch {
+			return "", errors.New("cannot resolve 'simple' push to a single destination")
+		}
+
+		return upstream, nil
+	case "current", "matching":
+	default:
+		return "", fmt.Errorf("unknown push.default value: %s", mode)
+	}
+
+	if remote == "." {
+		return branch, nil
+	}
+
+	return remoteTrackingBranch(cfg, remote, branch)
+}
+
+// remoteTrackingBranch returns the remote branch where the given branch of
+// the given remote is stored when fetched, as configured by its fetch refspecs.
+func remoteTrackingBranch(cfg *config.Config, remote string, branch plumbing.ReferenceName) (plumbing.ReferenceName, error) {
+	if rc, ok := cfg.Remotes[remote]; ok {
+		for _, spec := range rc.Fetch {
+			if spec.Match(branch) {
+				return spec.Dst(branch), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("upstream branch '%s' not stored as a remote-tracking branch", branch)
+}
+
+// configSubsectionOption returns the value of the given key of the given
+// subsection, or an empty string if it isn't set.
+func configSubsectionOption(raw *formatcfg.Config, section, subsection, key string) string {
+	for _, s := range raw.Sections {
+		if s.IsName(section) && s.HasSubsection(subsection) {
+			return s.Subsection(subsection).Option(key)
+		}
+	}
+
+	return ""
+}
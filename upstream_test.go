@@ -0,0 +1,184 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type UpstreamSuite struct {
+	r *Repository
+	w *Worktree
+}
+
+var _ = Suite(&UpstreamSuite{})
+
+func (s *UpstreamSuite) SetUpTest(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	s.r = r
+	s.w, err = r.Worktree()
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{"https://example.com/foo.git"},
+	})
+	c.Assert(err, IsNil)
+
+	err = r.CreateBranch(&config.Branch{
+		Name:   "master",
+		Remote: "origin",
+		Merge:  plumbing.Master,
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *UpstreamSuite) commit(c *C, content string) plumbing.Hash {
+	err := util.WriteFile(s.w.Filesystem, "foo", []byte(content), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = s.w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err := s.w.Commit(content+"\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *UpstreamSuite) setReference(c *C, name plumbing.ReferenceName, h plumbing.Hash) {
+	err := s.r.Storer.SetReference(plumbing.NewHashReference(name, h))
+	c.Assert(err, IsNil)
+}
+
+func (s *UpstreamSuite) setOption(c *C, section, subsection, key, value string) {
+	cfg, err := s.r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.SetOption(section, subsection, key, value)
+	c.Assert(s.r.Storer.SetConfig(cfg), IsNil)
+}
+
+func (s *UpstreamSuite) TestBranchStatus(c *C) {
+	first := s.commit(c, "first")
+	second := s.commit(c, "second")
+	s.setReference(c, "refs/remotes/origin/master", first)
+
+	status, err := s.r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status.Branch, Equals, plumbing.Master)
+	c.Assert(status.Upstream, Equals, plumbing.ReferenceName("refs/remotes/origin/master"))
+	c.Assert(status.Ahead, Equals, 1)
+	c.Assert(status.Behind, Equals, 0)
+	c.Assert(status.String(), Equals, "1 ahead of origin/master")
+
+	s.setReference(c, "refs/remotes/origin/master", second)
+	err = s.w.Reset(&ResetOptions{Mode: HardReset, Commit: first})
+	c.Assert(err, IsNil)
+	s.commit(c, "third")
+	s.commit(c, "fourth")
+
+	status, err = s.r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status.Ahead, Equals, 2)
+	c.Assert(status.Behind, Equals, 1)
+	c.Assert(status.String(), Equals, "2 ahead, 1 behind origin/master")
+
+	s.setReference(c, plumbing.Master, second)
+	status, err = s.r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status.String(), Equals, "up to date with origin/master")
+}
+
+func (s *UpstreamSuite) TestBranchStatusNoUpstream(c *C) {
+	h := s.commit(c, "first")
+	s.setReference(c, "refs/heads/feature", h)
+
+	_, err := s.r.BranchStatus("feature")
+	c.Assert(err, Equals, ErrNoUpstream)
+
+	_, err = s.r.BranchStatus("missing")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *UpstreamSuite) TestResolveRevisionUpstream(c *C) {
+	first := s.commit(c, "first")
+	second := s.commit(c, "second")
+	s.setReference(c, "refs/remotes/origin/master", first)
+
+	for _, rev := range []string{"@{u}", "@{upstream}", "master@{u}", "refs/heads/master@{upstream}", "HEAD@{u}"} {
+		h, err := s.r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf(rev))
+		c.Assert(*h, Equals, first, Commentf(rev))
+	}
+
+	err := s.r.CreateBranch(&config.Branch{
+		Name:   "feature",
+		Remote: ".",
+		Merge:  plumbing.Master,
+	})
+	c.Assert(err, IsNil)
+	s.setReference(c, "refs/heads/feature", first)
+
+	h, err := s.r.ResolveRevision("feature@{u}")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, second)
+}
+
+func (s *UpstreamSuite) TestResolveRevisionUpstreamErrors(c *C) {
+	h := s.commit(c, "first")
+	s.setReference(c, "refs/heads/feature", h)
+
+	_, err := s.r.ResolveRevision("feature@{u}")
+	c.Assert(err, Equals, ErrNoUpstream)
+
+	_, err = s.r.CreateTag("v1.0.0", h, nil)
+	c.Assert(err, IsNil)
+
+	_, err = s.r.ResolveRevision("v1.0.0@{u}")
+	c.Assert(err, ErrorMatches, "no such branch: 'v1.0.0'")
+
+	_, err = s.r.ResolveRevision("master@{u}")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	err = s.w.Checkout(&CheckoutOptions{Hash: h})
+	c.Assert(err, IsNil)
+
+	_, err = s.r.ResolveRevision("@{u}")
+	c.Assert(err, Equals, ErrDetachedHEAD)
+}
+
+func (s *UpstreamSuite) TestResolveRevisionPush(c *C) {
+	first := s.commit(c, "first")
+	second := s.commit(c, "second")
+	s.setReference(c, "refs/remotes/origin/master", first)
+
+	_, err := s.r.CreateRemote(&config.RemoteConfig{
+		Name: "fork",
+		URLs: []string{"https://example.com/fork.git"},
+	})
+	c.Assert(err, IsNil)
+	s.setReference(c, "refs/remotes/fork/master", second)
+
+	h, err := s.r.ResolveRevision("@{push}")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, first)
+
+	s.setOption(c, "remote", "", "pushDefault", "fork")
+	h, err = s.r.ResolveRevision("master@{push}")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, second)
+
+	s.setOption(c, "branch", "master", "pushRemote", "origin")
+	h, err = s.r.ResolveRevision("master@{push}")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, first)
+
+	s.setOption(c, "push", "", "default", "nothing")
+	_, err = s.r.ResolveRevision("master@{push}")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,298 @@
+// Package merge implements line oriented three-way merges, similar to the
+// merge-file command of git.
+//
+// The changes from the common ancestor to each side are computed as line
+// diffs, the non overlapping changes are applied and the overlapping ones are
+// reported as conflicts, surrounded by the usual conflict markers.
+package merge
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	markerSize = 7
+
+	oursMarker   = '<'
+	baseMarker   = '|'
+	middleMarker = '='
+	theirsMarker = '>'
+)
+
+// Options controls how a three-way merge is performed and how the conflicts
+// are represented.
+type Options struct {
+	// OursLabel is appended to the conflict marker of our side, e.g. HEAD.
+	OursLabel string
+	// TheirsLabel is appended to the conflict marker of their side.
+	TheirsLabel string
+	// BaseLabel is appended to the conflict marker of the base, only used
+	// when Diff3 is true.
+	BaseLabel string
+	// Diff3 adds the content of the common ancestor to the conflicts, like
+	// the diff3 conflict style of git.
+	Diff3 bool
+}
+
+// Result is the outcome of a three-way merge.
+type Result struct {
+	// Content is the merged content, including the conflict markers if any.
+	Content string
+	// Conflicts is the number of conflicting regions found.
+	Conflicts int
+}
+
+// Do performs a three-way merge of ours and theirs, taking base as the common
+// ancestor of both. If o is nil the default Options are used.
+func Do(base, ours, theirs string, o *Options) *Result {
+	if o == nil {
+		o = &Options{}
+	}
+
+	baseLines := splitLines(base)
+	oursLines := splitLines(ours)
+	theirsLines := splitLines(theirs)
+
+	oh := hunks(base, ours)
+	th := hunks(base, theirs)
+
+	m := &merger{
+		o:      o,
+		base:   baseLines,
+		ours:   oursLines,
+		theirs: theirsLines,
+	}
+
+	m.merge(oh, th)
+	return &Result{Content: m.buf.String(), Conflicts: m.conflicts}
+}
+
+// hunk is a region of the base, [BaseStart, BaseEnd), that was replaced by
+// the region [Start, End) of a side.
+type hunk struct {
+	BaseStart, BaseEnd int
+	Start, End         int
+}
+
+func (h hunk) delta() int {
+	return (h.End - h.Start) - (h.BaseEnd - h.BaseStart)
+}
+
+// hunks returns the changes needed to turn src into dst, grouped by regions.
+func hunks(src, dst string) []hunk {
+	dmp := diffmatchpatch.New()
+	wSrc, wDst, _ := dmp.DiffLinesToRunes(src, dst)
+	diffs := dmp.DiffMainRunes(wSrc, wDst, false)
+
+	var result []hunk
+	var current *hunk
+	var b, s int
+	for _, d := range diffs {
+		// every rune represents a full line
+		n := utf8.RuneCountInString(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				result = append(result, *current)
+				current = nil
+			}
+
+			b += n
+			s += n
+			continue
+		}
+
+		if current == nil {
+			current = &hunk{BaseStart: b, BaseEnd: b, Start: s, End: s}
+		}
+
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			b += n
+			current.BaseEnd = b
+		case diffmatchpatch.DiffInsert:
+			s += n
+			current.End = s
+		}
+	}
+
+	if current != nil {
+		result = append(result, *current)
+	}
+
+	return result
+}
+
+type merger struct {
+	o                  *Options
+	base, ours, theirs []string
+
+	buf       bytes.Buffer
+	conflicts int
+}
+
+func (m *merger) merge(ours, theirs []hunk) {
+	var pos, oursOffset, theirsOffset int
+	for len(ours) > 0 || len(theirs) > 0 {
+		// the group of overlapping hunks starts at the first pending hunk
+		start := nextStart(ours, theirs)
+		end := start
+
+		var oi, ti int
+		var oursDelta, theirsDelta int
+		for {
+			progress := false
+			for oi < len(ours) && overlaps(ours[oi], start, end) {
+				end = max(end, ours[oi].BaseEnd)
+				oursDelta += ours[oi].delta()
+				oi++
+				progress = true
+			}
+
+			for ti < len(theirs) && overlaps(theirs[ti], start, end) {
+				end = max(end, theirs[ti].BaseEnd)
+				theirsDelta += theirs[ti].delta()
+				ti++
+				progress = true
+			}
+
+			if !progress {
+				break
+			}
+		}
+
+		m.write(m.base[pos:start])
+
+		oursRegion := m.ours[start+oursOffset : end+oursOffset+oursDelta]
+		theirsRegion := m.theirs[start+theirsOffset : end+theirsOffset+theirsDelta]
+		baseRegion := m.base[start:end]
+
+		switch {
+		case ti == 0:
+			m.write(oursRegion)
+		case oi == 0:
+			m.write(theirsRegion)
+		case equalLines(oursRegion, theirsRegion):
+			m.write(oursRegion)
+		default:
+			m.conflict(baseRegion, oursRegion, theirsRegion)
+		}
+
+		oursOffset += oursDelta
+		theirsOffset += theirsDelta
+		ours, theirs = ours[oi:], theirs[ti:]
+		pos = end
+	}
+
+	m.write(m.base[pos:])
+}
+
+// nextStart returns the lowest base position of the given pending hunks.
+func nextStart(ours, theirs []hunk) int {
+	switch {
+	case len(ours) == 0:
+		return theirs[0].BaseStart
+	case len(theirs) == 0:
+		return ours[0].BaseStart
+	}
+
+	return min(ours[0].BaseStart, theirs[0].BaseStart)
+}
+
+// overlaps returns true if h overlaps or is adjacent to the base region
+// [start, end), like git does, adjacent changes are considered conflicting.
+func overlaps(h hunk, start, end int) bool {
+	return h.BaseStart >= start && h.BaseStart <= end
+}
+
+func (m *merger) conflict(base, ours, theirs []string) {
+	m.conflicts++
+
+	m.marker(oursMarker, m.o.OursLabel)
+	m.writeSection(ours)
+	if m.o.Diff3 {
+		m.marker(baseMarker, m.o.BaseLabel)
+		m.writeSection(base)
+	}
+
+	m.marker(middleMarker, "")
+	m.writeSection(theirs)
+	m.marker(theirsMarker, m.o.TheirsLabel)
+}
+
+func (m *merger) marker(c byte, label string) {
+	m.buf.Write(bytes.Repeat([]byte{c}, markerSize))
+	if label != "" {
+		m.buf.WriteByte(' ')
+		m.buf.WriteString(label)
+	}
+
+	m.buf.WriteByte('\n')
+}
+
+// writeSection writes the given lines, ensuring that the section ends with a
+// new line, so the following conflict marker starts at the beginning of a
+// line.
+func (m *merger) writeSection(lines []string) {
+	m.write(lines)
+	if len(lines) != 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		m.buf.WriteByte('\n')
+	}
+}
+
+func (m *merger) write(lines []string) {
+	for _, l := range lines {
+		m.buf.WriteString(l)
+	}
+}
+
+// splitLines splits the text in lines keeping the line terminators, the same
+// way the lines are split by diffmatchpatch.DiffLinesToRunes.
+func splitLines(text string) []string {
+	var lines []string
+	for len(text) > 0 {
+		i := strings.IndexByte(text, '\n')
+		if i == -1 {
+			lines = append(lines, text)
+			break
+		}
+
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
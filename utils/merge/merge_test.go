@@ -0,0 +1,101 @@
+package merge_test
+
+import (
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/utils/merge"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MergeSuite struct{}
+
+var _ = Suite(&MergeSuite{})
+
+var cleanMergeTests = [...]struct {
+	base, ours, theirs string
+	expected           string
+}{
+	// no changes
+	{"", "", "", ""},
+	{"a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n"},
+	// changes only in one side
+	{"a\nb\n", "a\nB\n", "a\nb\n", "a\nB\n"},
+	{"a\nb\n", "a\nb\n", "A\nb\n", "A\nb\n"},
+	{"", "a\n", "", "a\n"},
+	{"a\n", "", "a\n", ""},
+	// the same change in both sides
+	{"a\nb\n", "a\nB\n", "a\nB\n", "a\nB\n"},
+	// changes in different regions
+	{"a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n"},
+	{"a\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\nf\n", "z\na\nb\nc\nd\ne\n", "z\na\nb\nc\nd\ne\nf\n"},
+	{"a\nb\nc\nd\ne\n", "a\nc\nd\ne\n", "a\nb\nc\nd\n", "a\nc\nd\n"},
+	// missing '\n'
+	{"a\nb\nc", "A\nb\nc", "a\nb\nC", "A\nb\nC"},
+}
+
+func (s *MergeSuite) TestClean(c *C) {
+	for i, t := range cleanMergeTests {
+		r := merge.Do(t.base, t.ours, t.theirs, nil)
+		c.Assert(r.Conflicts, Equals, 0, Commentf("subtest %d", i))
+		c.Assert(r.Content, Equals, t.expected, Commentf("subtest %d", i))
+	}
+}
+
+func (s *MergeSuite) TestConflict(c *C) {
+	r := merge.Do(
+		"a\nb\nc\nd\ne\n",
+		"a\nB\nc\nd\ne\n",
+		"a\nX\nc\nd\nE\n",
+		&merge.Options{OursLabel: "HEAD", TheirsLabel: "feature"},
+	)
+
+	c.Assert(r.Conflicts, Equals, 1)
+	c.Assert(r.Content, Equals, ""+
+		"a\n"+
+		"<<<<<<< HEAD\n"+
+		"B\n"+
+		"=======\n"+
+		"X\n"+
+		">>>>>>> feature\n"+
+		"c\nd\nE\n",
+	)
+}
+
+func (s *MergeSuite) TestConflictAdjacent(c *C) {
+	r := merge.Do("a\nb\nc\n", "a\nB\nc\n", "a\nb\nC\n", nil)
+
+	c.Assert(r.Conflicts, Equals, 1)
+	c.Assert(r.Content, Equals, "a\n<<<<<<<\nB\nc\n=======\nb\nC\n>>>>>>>\n")
+}
+
+func (s *MergeSuite) TestConflictBothAdded(c *C) {
+	r := merge.Do("", "foo", "bar\n", nil)
+
+	c.Assert(r.Conflicts, Equals, 1)
+	c.Assert(r.Content, Equals, "<<<<<<<\nfoo\n=======\nbar\n>>>>>>>\n")
+}
+
+func (s *MergeSuite) TestConflictDiff3(c *C) {
+	r := merge.Do("a\nb\nc\n", "a\nB\nc\n", "a\nX\nc\n", &merge.Options{
+		OursLabel:   "ours",
+		BaseLabel:   "base",
+		TheirsLabel: "theirs",
+		Diff3:       true,
+	})
+
+	c.Assert(r.Conflicts, Equals, 1)
+	c.Assert(r.Content, Equals, ""+
+		"a\n"+
+		"<<<<<<< ours\n"+
+		"B\n"+
+		"||||||| base\n"+
+		"b\n"+
+		"=======\n"+
+		"X\n"+
+		">>>>>>> theirs\n"+
+		"c\n",
+	)
+}
@@ -28,7 +28,11 @@ var (
 	ErrWorktreeNotClean  = errors.New("worktree is not clean")
 	ErrSubmoduleNotFound = errors.New("submodule not found")
 	ErrUnstagedChanges   = errors.New("worktree contains unstaged changes")
+	ErrUnmergedChanges   = errors.New("index contains unmerged changes")
 	ErrGitModulesSymlink = errors.New(gitmodulesFile + " is a symlink")
+	// ErrNonFastForwardUpdate is returned by Pull and Merge when the update
+	// can't be resolved as a fast-forward and merging is not allowed.
+	ErrNonFastForwardUpdate = errors.New("non-fast-forward update")
 )
 
 // Worktree represents a git worktree.
@@ -45,7 +49,8 @@ type Worktree struct {
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 // no changes to be fetched, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// By default Pull only supports merges where the can be resolved as a
+// fast-forward, see PullOptions.Mode.
 func (w *Worktree) Pull(o *PullOptions) error {
 	return w.PullContext(context.Background(), o)
 }
@@ -54,7 +59,8 @@ func (w *Worktree) Pull(o *PullOptions) error {
 // branch. Returns nil if the operation is successful, NoErrAlreadyUpToDate if
 // there are no changes to be fetched, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// By default Pull only supports merges where the can be resolved as a
+// fast-forward, see PullOptions.Mode.
 //
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects to the
@@ -70,11 +76,15 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 	}
 
 	fetchHead, err := remote.fetch(ctx, &FetchOptions{
-		RemoteName: o.RemoteName,
-		Depth:      o.Depth,
-		Auth:       o.Auth,
-		Progress:   o.Progress,
-		Force:      o.Force,
+		RemoteName:     o.RemoteName,
+		Depth:          o.Depth,
+		ShallowSince:   o.ShallowSince,
+		ShallowExclude: o.ShallowExclude,
+		Deepen:         o.Deepen,
+		Unshallow:      o.Unshallow,
+		Auth:           o.Auth,
+		Progress:       o.Progress,
+		Force:          o.Force,
 	})
 
 	updated := true
@@ -101,7 +111,11 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		}
 
 		if !ff {
-			return fmt.Errorf("non-fast-forward update")
+			if o.Mode != MergePull {
+				return ErrNonFastForwardUpdate
+			}
+
+			return w.pullMerge(remote, ref, o)
 		}
 	}
 
@@ -109,14 +123,14 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		return err
 	}
 
-	if err := w.updateHEAD(ref.Hash()); err != nil {
+	if err := w.updateHEAD(ref.Hash(), nil, "pull: Fast-forward"); err != nil {
 		return err
 	}
 
-	if err := w.Reset(&ResetOptions{
+	if err := w.reset(&ResetOptions{
 		Mode:   MergeReset,
 		Commit: ref.Hash(),
-	}); err != nil {
+	}, ""); err != nil {
 		return err
 	}
 
@@ -130,6 +144,44 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 	return nil
 }
 
+func (w *Worktree) pullMerge(remote *Remote, ref *plumbing.Reference, o *PullOptions) error {
+	_, err := w.Merge(&MergeOptions{
+		Commit:  ref.Hash(),
+		Author:  o.Author,
+		Message: pullMergeMessage(remote, ref),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if o.RecurseSubmodules != NoRecurseSubmodules {
+		return w.updateSubmodules(&SubmoduleUpdateOptions{
+			RecurseSubmodules: o.RecurseSubmodules,
+			Auth:              o.Auth,
+		})
+	}
+
+	return nil
+}
+
+func pullMergeMessage(remote *Remote, ref *plumbing.Reference) string {
+	what := fmt.Sprintf("commit '%s'", ref.Hash())
+	switch {
+	case ref.Name().IsBranch():
+		what = fmt.Sprintf("branch '%s'", ref.Name().Short())
+	case ref.Name().IsTag():
+		what = fmt.Sprintf("tag '%s'", ref.Name().Short())
+	}
+
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return fmt.Sprintf("Merge %s\n", what)
+	}
+
+	return fmt.Sprintf("Merge %s of %s\n", what, urls[0])
+}
+
 func (w *Worktree) updateSubmodules(o *SubmoduleUpdateOptions) error {
 	s, err := w.Submodules()
 	if err != nil {
@@ -172,7 +224,16 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 		ro.Mode = HardReset
 	}
 
+	from, err := w.r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	old := resolvedReferenceHash(w.r.Storer, plumbing.HEAD)
+
+	to := opts.Branch.Short()
 	if !opts.Hash.IsZero() && !opts.Create {
+		to = opts.Hash.String()
 		err = w.setHEADToCommit(opts.Hash)
 	} else {
 		err = w.setHEADToBranch(opts.Branch, c)
@@ -182,8 +243,24 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 		return err
 	}
 
-	return w.Reset(ro)
+	if err := w.reset(ro, ""); err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("checkout: moving from %s to %s", checkoutReflogName(from), to)
+	return w.r.logReferenceUpdate(plumbing.HEAD, old, c, nil, msg)
 }
+
+// checkoutReflogName returns the name of the branch HEAD points to or, if
+// detached, its hash, as used in the checkout reflog messages.
+func checkoutReflogName(head *plumbing.Reference) string {
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short()
+	}
+
+	return head.Hash().String()
+}
+
 func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 	_, err := w.r.Storer.Reference(opts.Branch)
 	if err == nil {
@@ -194,6 +271,7 @@ func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 		return err
 	}
 
+	from := "HEAD"
 	if opts.Hash.IsZero() {
 		ref, err := w.r.Head()
 		if err != nil {
@@ -201,10 +279,13 @@ func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 		}
 
 		opts.Hash = ref.Hash()
+	} else {
+		from = opts.Hash.String()
 	}
 
-	return w.r.Storer.SetReference(
+	return w.r.setReferenceWithLog(
 		plumbing.NewHashReference(opts.Branch, opts.Hash),
+		nil, "branch: Created from "+from,
 	)
 }
 
@@ -268,6 +349,35 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 		return err
 	}
 
+	return w.reset(opts, fmt.Sprintf("reset: moving to %s", opts.Commit))
+}
+
+// reset performs the reset described by the given options, recording the
+// update of HEAD in the reflog with the given message. If msg is empty the
+// update is not recorded, the callers moving HEAD as part of a bigger
+// operation record it with their own message.
+func (w *Worktree) reset(opts *ResetOptions, msg string) error {
+	return w.resetWithTracked(opts, msg, nil)
+}
+
+// resetKeepingUntracked performs the reset as reset does, but the files
+// untracked before it are kept in the worktree, as git stash does.
+func (w *Worktree) resetKeepingUntracked(opts *ResetOptions, msg string) error {
+	tracked, err := w.trackedFiles()
+	if err != nil {
+		return err
+	}
+
+	return w.resetWithTracked(opts, msg, tracked)
+}
+
+// resetWithTracked performs the reset, if tracked is not nil the files not
+// present in it are kept in the worktree.
+func (w *Worktree) resetWithTracked(opts *ResetOptions, msg string, tracked map[string]bool) error {
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
 	if opts.Mode == MergeReset {
 		unstaged, err := w.containsUnstagedChanges()
 		if err != nil {
@@ -279,7 +389,11 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 		}
 	}
 
-	if err := w.setHEADCommit(opts.Commit); err != nil {
+	if err := w.setHEADCommit(opts.Commit, msg); err != nil {
+		return err
+	}
+
+	if err := w.r.removeMergeState(); err != nil {
 		return err
 	}
 
@@ -299,7 +413,7 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 	}
 
 	if opts.Mode == MergeReset || opts.Mode == HardReset {
-		if err := w.resetWorktree(t); err != nil {
+		if err := w.resetWorktree(t, tracked); err != nil {
 			return err
 		}
 	}
@@ -307,6 +421,20 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 	return nil
 }
 
+func (w *Worktree) trackedFiles() (map[string]bool, error) {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool, len(idx.Entries))
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+	}
+
+	return tracked, nil
+}
+
 func (w *Worktree) resetIndex(t *object.Tree) error {
 	idx, err := w.r.Storer.Index()
 	if err != nil {
@@ -354,7 +482,7 @@ func (w *Worktree) resetIndex(t *object.Tree) error {
 	return w.r.Storer.SetIndex(idx)
 }
 
-func (w *Worktree) resetWorktree(t *object.Tree) error {
+func (w *Worktree) resetWorktree(t *object.Tree, tracked map[string]bool) error {
 	changes, err := w.diffStagingWithWorktree(true)
 	if err != nil {
 		return err
@@ -365,7 +493,15 @@ func (w *Worktree) resetWorktree(t *object.Tree) error {
 		return err
 	}
 
+	if err := w.fetchMissingBlobs(changes, t); err != nil {
+		return err
+	}
+
 	for _, ch := range changes {
+		if tracked != nil && ch.To == nil && !tracked[ch.From.String()] {
+			continue
+		}
+
 		if err := w.checkoutChange(ch, t, idx); err != nil {
 			return err
 		}
@@ -374,6 +510,28 @@ func (w *Worktree) resetWorktree(t *object.Tree) error {
 	return w.r.Storer.SetIndex(idx)
 }
 
+// fetchMissingBlobs fetches at once the blobs of the files to checkout
+// missing in a partial clone, instead of one by one.
+func (w *Worktree) fetchMissingBlobs(changes merkletrie.Changes, t *object.Tree) error {
+	var hashes []plumbing.Hash
+	for _, ch := range changes {
+		if ch.To == nil {
+			continue
+		}
+
+		// the entries not found are reported checking them out
+		e, err := t.FindEntry(ch.To.String())
+		if err != nil || e.Mode == filemode.Submodule {
+			continue
+		}
+
+		hashes = append(hashes, e.Hash)
+	}
+
+	_, err := w.r.fetchMissingObjects(context.Background(), hashes)
+	return err
+}
+
 func (w *Worktree) checkoutChange(ch merkletrie.Change, t *object.Tree, idx *index.Index) error {
 	a, err := ch.Action()
 	if err != nil {
@@ -426,15 +584,20 @@ func (w *Worktree) containsUnstagedChanges() (bool, error) {
 	return false, nil
 }
 
-func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
+func (w *Worktree) setHEADCommit(commit plumbing.Hash, msg string) error {
 	head, err := w.r.Reference(plumbing.HEAD, false)
 	if err != nil {
 		return err
 	}
 
+	old := resolvedReferenceHash(w.r.Storer, plumbing.HEAD)
 	if head.Type() == plumbing.HashReference {
 		head = plumbing.NewHashReference(plumbing.HEAD, commit)
-		return w.r.Storer.SetReference(head)
+		if err := w.r.Storer.SetReference(head); err != nil {
+			return err
+		}
+
+		return w.logHEADUpdate(plumbing.HEAD, old, commit, msg)
 	}
 
 	branch, err := w.r.Reference(head.Target(), false)
@@ -447,7 +610,19 @@ func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
 	}
 
 	branch = plumbing.NewHashReference(branch.Name(), commit)
-	return w.r.Storer.SetReference(branch)
+	if err := w.r.Storer.SetReference(branch); err != nil {
+		return err
+	}
+
+	return w.logHEADUpdate(branch.Name(), old, commit, msg)
+}
+
+func (w *Worktree) logHEADUpdate(name plumbing.ReferenceName, old, new plumbing.Hash, msg string) error {
+	if msg == "" {
+		return nil
+	}
+
+	return w.r.logReferenceUpdate(name, old, new, nil, msg)
 }
 
 func (w *Worktree) checkoutChangeSubmodule(name string,
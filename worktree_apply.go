@@ -0,0 +1,721 @@
+package git
+
+import (
+	"errors"
+	stdioutil "io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+	"gopkg.in/src-d/go-git.v4/utils/merge"
+)
+
+var (
+	// ErrPatchDoesNotApply is returned when the hunks of a patch aren't found
+	// in the patched files, or when the files created by the patch exist.
+	ErrPatchDoesNotApply = errors.New("patch does not apply")
+	// ErrPatchIndexMismatch is returned when a patch is applied to the index
+	// and a patched file differs between the index and the worktree.
+	ErrPatchIndexMismatch = errors.New("patched file does not match the index")
+	// ErrBinaryPatch is returned when a binary patch is applied and the blob
+	// it results in isn't found in the repository.
+	ErrBinaryPatch = errors.New("binary patch needs the resulting blob")
+)
+
+// Apply applies the given patch to the worktree or, with ApplyOptions.Index,
+// to the worktree and to the index. The patch can be decoded from a unified
+// diff with diff.UnifiedDecoder or be computed by go-git, such as the patch
+// between two commits. The hunks are searched around their original position,
+// so they apply even if lines were added or removed before them.
+//
+// Nothing is modified unless the whole patch applies, otherwise an error such
+// as ErrPatchDoesNotApply is returned. With ApplyOptions.ThreeWay, the files
+// that don't apply are merged and, if there are conflicts, ErrMergeConflict is
+// returned once the patch is applied, with the conflicts recorded in the index
+// and in the worktree.
+func (w *Worktree) Apply(patch diff.Patch, opts *ApplyOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	a := &patchApplier{w: w, idx: idx, opts: opts, files: make(map[string]*patchedFile)}
+	for _, fp := range patch.FilePatches() {
+		if err := a.apply(newApplyFilePatch(fp, opts.Reverse)); err != nil {
+			return err
+		}
+	}
+
+	if opts.Check {
+		return nil
+	}
+
+	return a.write()
+}
+
+// applyFilePatch is a file patch in the direction it's applied.
+type applyFilePatch struct {
+	from, to *applyFile
+	binary   bool
+	copy     bool
+	hunks    []*diff.Hunk
+}
+
+// applyFile is a side of a file patch, index is the hash of the blob as found
+// in the patch, possibly abbreviated.
+type applyFile struct {
+	path  string
+	mode  filemode.FileMode
+	hash  plumbing.Hash
+	index string
+}
+
+func newApplyFilePatch(fp diff.FilePatch, reverse bool) *applyFilePatch {
+	from, to := fp.Files()
+
+	var fromIndex, toIndex string
+	var hunks []*diff.Hunk
+	if ufp, ok := fp.(diff.UnifiedFilePatch); ok {
+		fromIndex, toIndex = ufp.Index()
+		hunks = ufp.Hunks()
+	} else {
+		hunks = chunksHunks(fp.Chunks())
+	}
+
+	p := &applyFilePatch{
+		from:   newApplyFile(from, fromIndex),
+		to:     newApplyFile(to, toIndex),
+		binary: fp.IsBinary(),
+		hunks:  hunks,
+	}
+
+	if rfp, ok := fp.(diff.RenameFilePatch); ok {
+		p.copy = rfp.IsCopy()
+	}
+
+	if reverse {
+		p.from, p.to = p.to, p.from
+		p.hunks = reverseHunks(p.hunks)
+	}
+
+	return p
+}
+
+func newApplyFile(f diff.File, index string) *applyFile {
+	if f == nil {
+		return nil
+	}
+
+	if index == "" && !f.Hash().IsZero() {
+		index = f.Hash().String()
+	}
+
+	return &applyFile{path: f.Path(), mode: f.Mode(), hash: f.Hash(), index: index}
+}
+
+// chunksHunks returns the chunks of a patch containing the whole files, such
+// as the ones computed by go-git, as a single hunk.
+func chunksHunks(chunks []diff.Chunk) []*diff.Hunk {
+	h := &diff.Hunk{}
+	for _, c := range chunks {
+		for _, l := range splitPatchLines(c.Content()) {
+			h.Lines = append(h.Lines, diff.HunkLine{Type: c.Type(), Content: l})
+			if c.Type() != diff.Add {
+				h.FromCount++
+			}
+
+			if c.Type() != diff.Delete {
+				h.ToCount++
+			}
+		}
+	}
+
+	if len(h.Lines) == 0 {
+		return nil
+	}
+
+	if h.FromCount != 0 {
+		h.FromLine = 1
+	}
+
+	if h.ToCount != 0 {
+		h.ToLine = 1
+	}
+
+	return []*diff.Hunk{h}
+}
+
+func reverseHunks(hunks []*diff.Hunk) []*diff.Hunk {
+	reversed := make([]*diff.Hunk, len(hunks))
+	for i, h := range hunks {
+		r := &diff.Hunk{
+			FromLine: h.ToLine, FromCount: h.ToCount,
+			ToLine: h.FromLine, ToCount: h.FromCount,
+			Section: h.Section,
+		}
+
+		for _, l := range h.Lines {
+			switch l.Type {
+			case diff.Add:
+				l.Type = diff.Delete
+			case diff.Delete:
+				l.Type = diff.Add
+			}
+
+			r.Lines = append(r.Lines, l)
+		}
+
+		reversed[i] = r
+	}
+
+	return reversed
+}
+
+// patchedFile is the content of a file once patched, the conflict entries
+// are set if the file was merged with conflicts.
+type patchedFile struct {
+	name     string
+	content  []byte
+	mode     filemode.FileMode
+	deleted  bool
+	conflict []*index.Entry
+}
+
+// patchApplier applies the file patches in memory, so the files patched
+// several times are read only once and nothing is written unless the whole
+// patch applies.
+type patchApplier struct {
+	w     *Worktree
+	idx   *index.Index
+	opts  *ApplyOptions
+	files map[string]*patchedFile
+	order []string
+}
+
+func (a *patchApplier) apply(p *applyFilePatch) error {
+	switch {
+	case p.from == nil && p.to == nil:
+		return nil
+	case p.from != nil && p.from.mode == filemode.Submodule,
+		p.to != nil && p.to.mode == filemode.Submodule:
+		// the submodules aren't patched, as git apply does without --index
+		return nil
+	case p.copy && a.opts.Reverse:
+		return ErrPatchDoesNotApply
+	}
+
+	current := &patchedFile{}
+	if p.from != nil {
+		f, err := a.read(p.from.path)
+		if err != nil {
+			return err
+		}
+
+		if f == nil {
+			return ErrPatchDoesNotApply
+		}
+
+		current = f
+	}
+
+	if p.to != nil && (p.from == nil || p.to.path != p.from.path) {
+		f, err := a.read(p.to.path)
+		if err != nil {
+			return err
+		}
+
+		if f != nil {
+			return ErrPatchDoesNotApply
+		}
+	}
+
+	content, conflict, err := a.patchContent(p, current)
+	if err != nil {
+		return err
+	}
+
+	if p.from != nil && !p.copy && (p.to == nil || p.to.path != p.from.path) {
+		a.set(&patchedFile{name: p.from.path, deleted: true})
+	}
+
+	if p.to == nil {
+		if len(content) != 0 {
+			return ErrPatchDoesNotApply
+		}
+
+		return nil
+	}
+
+	mode := current.mode
+	if p.from == nil || p.from.mode != p.to.mode {
+		mode = p.to.mode
+	}
+
+	a.set(&patchedFile{name: p.to.path, content: content, mode: mode, conflict: conflict})
+	return nil
+}
+
+// patchContent returns the content of the given file once patched.
+func (a *patchApplier) patchContent(p *applyFilePatch, current *patchedFile) ([]byte, []*index.Entry, error) {
+	if p.binary {
+		content, err := a.binaryContent(p, current)
+		return content, nil, err
+	}
+
+	content, err := applyHunks(string(current.content), p.hunks, a.opts.Fuzz)
+	if err == nil {
+		return []byte(content), nil, nil
+	}
+
+	if err != ErrPatchDoesNotApply || !a.opts.ThreeWay || p.from == nil || p.to == nil {
+		return nil, nil, err
+	}
+
+	return a.mergeContent(p, current)
+}
+
+// binaryContent returns the resulting blob of a binary patch, which can only
+// be applied if it's found in the repository.
+func (a *patchApplier) binaryContent(p *applyFilePatch, current *patchedFile) ([]byte, error) {
+	if p.from != nil && strings.Trim(p.from.index, "0") != "" {
+		h := plumbing.ComputeHash(plumbing.BlobObject, current.content)
+		if !strings.HasPrefix(h.String(), p.from.index) {
+			return nil, ErrPatchDoesNotApply
+		}
+	}
+
+	if p.to == nil {
+		return nil, nil
+	}
+
+	blob, err := a.w.patchBlob(p.to)
+	if err != nil {
+		return nil, ErrBinaryPatch
+	}
+
+	return blobContent(blob)
+}
+
+// mergeContent merges the current content of the file with the result of
+// applying the patch to the blob it was created from.
+func (a *patchApplier) mergeContent(p *applyFilePatch, current *patchedFile) ([]byte, []*index.Entry, error) {
+	base, err := a.w.patchBlob(p.from)
+	if err != nil {
+		return nil, nil, ErrPatchDoesNotApply
+	}
+
+	baseContent, err := blobContent(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	theirs, err := applyHunks(string(baseContent), p.hunks, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := merge.Do(string(baseContent), string(current.content), theirs,
+		&merge.Options{OursLabel: "ours", TheirsLabel: "theirs"})
+
+	if result.Conflicts == 0 {
+		return []byte(result.Content), nil, nil
+	}
+
+	m := &treeMerger{s: a.w.r.Storer}
+	ours, err := m.writeBlob(current.content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	theirsHash, err := m.writeBlob([]byte(theirs))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	theirsMode := current.mode
+	if p.from.mode != p.to.mode {
+		theirsMode = p.to.mode
+	}
+
+	return []byte(result.Content), []*index.Entry{
+		{Name: p.to.path, Hash: base.Hash, Mode: p.from.mode, Stage: index.AncestorMode},
+		{Name: p.to.path, Hash: ours, Mode: current.mode, Stage: index.OurMode},
+		{Name: p.to.path, Hash: theirsHash, Mode: theirsMode, Stage: index.TheirMode},
+	}, nil
+}
+
+// read returns the current content of the file, nil if it doesn't exist.
+func (a *patchApplier) read(name string) (*patchedFile, error) {
+	if f, ok := a.files[name]; ok {
+		if f.deleted {
+			return nil, nil
+		}
+
+		return f, nil
+	}
+
+	f, err := a.w.readPatchedFile(name)
+	if err != nil || !a.opts.Index {
+		return f, err
+	}
+
+	e, err := a.idx.Entry(name)
+	if err == index.ErrEntryNotFound {
+		if f != nil {
+			return nil, ErrPatchIndexMismatch
+		}
+
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f == nil || plumbing.ComputeHash(plumbing.BlobObject, f.content) != e.Hash {
+		return nil, ErrPatchIndexMismatch
+	}
+
+	return f, nil
+}
+
+func (a *patchApplier) set(f *patchedFile) {
+	if _, ok := a.files[f.name]; !ok {
+		a.order = append(a.order, f.name)
+	}
+
+	a.files[f.name] = f
+}
+
+// write writes the patched files to the worktree and, with Index, to the
+// index.
+func (a *patchApplier) write() error {
+	conflicts := false
+	for _, name := range a.order {
+		f := a.files[name]
+		if err := a.writeFile(f); err != nil {
+			return err
+		}
+
+		conflicts = conflicts || f.conflict != nil
+	}
+
+	if a.opts.Index {
+		if err := a.w.r.Storer.SetIndex(a.idx); err != nil {
+			return err
+		}
+	}
+
+	if conflicts {
+		return ErrMergeConflict
+	}
+
+	return nil
+}
+
+func (a *patchApplier) writeFile(f *patchedFile) error {
+	if a.opts.Index {
+		removeIndexEntries(a.idx, f.name)
+	}
+
+	if f.deleted {
+		err := rmFileAndDirIfEmpty(a.w.Filesystem, f.name)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	// the file is removed first, so its mode is updated
+	if err := a.w.deleteFromFilesystem(f.name); err != nil {
+		return err
+	}
+
+	if err := a.w.writeFileContent(f.name, f.mode, f.content); err != nil {
+		return err
+	}
+
+	if !a.opts.Index {
+		return nil
+	}
+
+	if f.conflict != nil {
+		a.idx.Entries = append(a.idx.Entries, f.conflict...)
+		return nil
+	}
+
+	h, err := a.w.copyFileToStorage(f.name)
+	if err != nil {
+		return err
+	}
+
+	return a.w.addIndexFromFile(f.name, h, a.idx)
+}
+
+// removeIndexEntries removes all the entries of the given file, including
+// the ones of the conflict stages.
+func removeIndexEntries(idx *index.Index, name string) {
+	for {
+		if _, err := idx.Remove(name); err != nil {
+			return
+		}
+	}
+}
+
+// readPatchedFile reads a file of the worktree, returning nil if it doesn't
+// exist.
+func (w *Worktree) readPatchedFile(name string) (f *patchedFile, err error) {
+	fi, err := w.Filesystem.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, ErrPatchDoesNotApply
+	}
+
+	f = &patchedFile{name: name}
+	if f.mode, err = filemode.NewFromOSFileMode(fi.Mode()); err != nil {
+		return nil, err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := w.Filesystem.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+
+		f.content = []byte(target)
+		return f, nil
+	}
+
+	r, err := w.Filesystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	f.content, err = stdioutil.ReadAll(r)
+	return f, err
+}
+
+// patchBlob returns the blob of a side of a file patch, looking it up by its
+// abbreviated hash if the complete one isn't known.
+func (w *Worktree) patchBlob(f *applyFile) (*object.Blob, error) {
+	if !f.hash.IsZero() {
+		return w.r.BlobObject(f.hash)
+	}
+
+	if strings.Trim(f.index, "0") == "" {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	iter, err := w.r.Storer.IterEncodedObjects(plumbing.BlobObject)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []plumbing.Hash
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		if strings.HasPrefix(obj.Hash().String(), f.index) {
+			found = append(found, obj.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(found) != 1 {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return w.r.BlobObject(found[0])
+}
+
+func blobContent(b *object.Blob) (content []byte, err error) {
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return stdioutil.ReadAll(r)
+}
+
+// applyHunks applies the hunks to the given content. Each hunk is searched at
+// its original position, shifted by the offset of the previous hunks, and then
+// further and further from it. If it isn't found, up to fuzz lines of context
+// are ignored at its beginning and end.
+func applyHunks(content string, hunks []*diff.Hunk, fuzz int) (string, error) {
+	lines := splitPatchLines(content)
+
+	var result []string
+	pos, offset := 0, 0
+	for _, h := range hunks {
+		preimage, postimage := hunkImages(h)
+		leading, trailing := hunkContext(h)
+
+		at := -1
+		var skip, skipEnd int
+		for f := 0; f <= fuzz && at == -1; f++ {
+			skip, skipEnd = min(f, leading), min(f, trailing)
+			if f != 0 && skip == 0 && skipEnd == 0 {
+				break
+			}
+
+			expected := h.FromLine - 1 + skip
+			if h.FromCount == 0 {
+				// the hunk is inserted after the given line
+				expected = h.FromLine
+			}
+
+			// as git does, the hunks without context at one side must match
+			// at the beginning or at the end of the file
+			matchBeginning := f == 0 && h.FromLine <= 1
+			matchEnd := f == 0 && trailing == 0 && leading != 0
+
+			at = findImage(lines, preimage[skip:len(preimage)-skipEnd], pos,
+				expected+offset, matchBeginning, matchEnd)
+			if at != -1 {
+				offset = at - expected
+			}
+		}
+
+		if at == -1 {
+			return "", ErrPatchDoesNotApply
+		}
+
+		result = append(result, lines[pos:at]...)
+		result = append(result, postimage[skip:len(postimage)-skipEnd]...)
+		pos = at + len(preimage) - skip - skipEnd
+	}
+
+	result = append(result, lines[pos:]...)
+	return strings.Join(result, ""), nil
+}
+
+// findImage returns the position of the image in the lines, from start on,
+// searching from the expected position and alternating after and before it,
+// -1 if it isn't found.
+func findImage(lines, image []string, start, expected int, matchBeginning, matchEnd bool) int {
+	last := len(lines) - len(image)
+	switch {
+	case last < start:
+		return -1
+	case matchBeginning:
+		if start == 0 && (!matchEnd || last == 0) && matchImage(lines, image, 0) {
+			return 0
+		}
+
+		return -1
+	case matchEnd:
+		if matchImage(lines, image, last) {
+			return last
+		}
+
+		return -1
+	}
+
+	if expected < start {
+		expected = start
+	}
+
+	if expected > last {
+		expected = last
+	}
+
+	for d := 0; expected+d <= last || expected-d >= start; d++ {
+		if expected+d <= last && matchImage(lines, image, expected+d) {
+			return expected + d
+		}
+
+		if d != 0 && expected-d >= start && matchImage(lines, image, expected-d) {
+			return expected - d
+		}
+	}
+
+	return -1
+}
+
+func matchImage(lines, image []string, at int) bool {
+	for i, l := range image {
+		if lines[at+i] != l {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hunkImages returns the lines of the hunk before and after applying it.
+func hunkImages(h *diff.Hunk) (preimage, postimage []string) {
+	for _, l := range h.Lines {
+		if l.Type != diff.Add {
+			preimage = append(preimage, l.Content)
+		}
+
+		if l.Type != diff.Delete {
+			postimage = append(postimage, l.Content)
+		}
+	}
+
+	return
+}
+
+// hunkContext returns the number of context lines at the beginning and at the
+// end of the hunk.
+func hunkContext(h *diff.Hunk) (leading, trailing int) {
+	for leading < len(h.Lines) && h.Lines[leading].Type == diff.Equal {
+		leading++
+	}
+
+	if leading == len(h.Lines) {
+		return leading, 0
+	}
+
+	for trailing < len(h.Lines) && h.Lines[len(h.Lines)-1-trailing].Type == diff.Equal {
+		trailing++
+	}
+
+	return leading, trailing
+}
+
+// splitPatchLines splits the text in lines keeping their terminators.
+func splitPatchLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i == -1 {
+			return append(lines, s)
+		}
+
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+
+	return lines
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
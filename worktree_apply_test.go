@@ -0,0 +1,388 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func decodeApplyTestPatch(c *C, patch string) diff.Patch {
+	p, err := diff.NewUnifiedDecoder(strings.NewReader(patch)).Decode()
+	c.Assert(err, IsNil)
+	return p
+}
+
+const applyTestPatch = `diff --git a/foo b/foo
+--- a/foo
++++ b/foo
+@@ -3,5 +3,5 @@
+ c
+ d
+-e
++E
+ f
+ g
+`
+
+func (s *WorktreeSuite) TestApply(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "x\ny\na\nb\nc\nd\ne\nf\ng\nh\n",
+	})
+
+	err := w.Apply(decodeApplyTestPatch(c, applyTestPatch), &ApplyOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "x\ny\na\nb\nc\nd\nE\nf\ng\nh\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Worktree, Equals, Modified)
+	c.Assert(status.File("foo").Staging, Equals, Unmodified)
+}
+
+func (s *WorktreeSuite) TestApplyFuzz(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "a\nb\nC\nd\ne\nf\nG\nh\n",
+	})
+
+	patch := decodeApplyTestPatch(c, applyTestPatch)
+	err := w.Apply(patch, &ApplyOptions{})
+	c.Assert(err, Equals, ErrPatchDoesNotApply)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nC\nd\ne\nf\nG\nh\n")
+
+	err = w.Apply(patch, &ApplyOptions{Fuzz: 1})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nC\nd\nE\nf\nG\nh\n")
+}
+
+func (s *WorktreeSuite) TestApplyReverse(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "a\nb\nc\nd\nE\nf\ng\nh\n",
+	})
+
+	patch := decodeApplyTestPatch(c, applyTestPatch)
+	err := w.Apply(patch, &ApplyOptions{})
+	c.Assert(err, Equals, ErrPatchDoesNotApply)
+
+	err = w.Apply(patch, &ApplyOptions{Reverse: true})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nc\nd\ne\nf\ng\nh\n")
+}
+
+func (s *WorktreeSuite) TestApplyIndex(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "a\nb\nc\nd\ne\nf\ng\nh\n",
+	})
+
+	patch := decodeApplyTestPatch(c, applyTestPatch)
+	err := w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nc\nd\nE\nf\ng\nh\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Worktree, Equals, Unmodified)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+
+	err = util.WriteFile(fs, "foo", []byte("a\nb\nc\nd\nE\nf\ng\nh\ni\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, &ApplyOptions{Index: true, Reverse: true})
+	c.Assert(err, Equals, ErrPatchIndexMismatch)
+}
+
+func (s *WorktreeSuite) TestApplyCheck(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "a\nb\nc\nd\ne\nf\ng\nh\n",
+	})
+
+	err := w.Apply(decodeApplyTestPatch(c, applyTestPatch), &ApplyOptions{Check: true})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nc\nd\ne\nf\ng\nh\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestApplyCreateDeleteRename(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "foo\n",
+		"bar": "bar\n",
+	})
+
+	patch := decodeApplyTestPatch(c, `diff --git a/foo b/foo
+deleted file mode 100644
+--- a/foo
++++ /dev/null
+@@ -1 +0,0 @@
+-foo
+diff --git a/bar b/dir/qux
+similarity index 100%
+rename from bar
+rename to dir/qux
+diff --git a/baz b/baz
+new file mode 100755
+--- /dev/null
++++ b/baz
+@@ -0,0 +1,2 @@
++baz
++baz
+`)
+
+	err := w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("foo")
+	c.Assert(err, NotNil)
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+	c.Assert(readMergeTestFile(c, fs, "dir/qux"), Equals, "bar\n")
+	c.Assert(readMergeTestFile(c, fs, "baz"), Equals, "baz\nbaz\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Deleted)
+	c.Assert(status.File("dir/qux").Staging, Equals, Renamed)
+	c.Assert(status.File("dir/qux").Extra, Equals, "bar")
+	c.Assert(status.File("baz").Staging, Equals, Added)
+	c.Assert(status.File("baz").Worktree, Equals, Unmodified)
+
+	err = w.Apply(patch, &ApplyOptions{Index: true, Reverse: true})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestApplyDoesNotApply(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "a\nb\nc\nd\ne\nf\ng\nh\n",
+	})
+
+	patch := decodeApplyTestPatch(c, applyTestPatch+`diff --git a/bar b/bar
+--- a/bar
++++ b/bar
+@@ -1 +1 @@
+-bar
++BAR
+`)
+
+	err := w.Apply(patch, &ApplyOptions{})
+	c.Assert(err, Equals, ErrPatchDoesNotApply)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nc\nd\ne\nf\ng\nh\n")
+
+	err = w.Apply(decodeApplyTestPatch(c, `diff --git a/foo b/foo
+new file mode 100644
+--- /dev/null
++++ b/foo
+@@ -0,0 +1 @@
++foo
+`), &ApplyOptions{})
+	c.Assert(err, Equals, ErrPatchDoesNotApply)
+}
+
+func (s *WorktreeSuite) TestApplyCommitPatch(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "a\nb\nc\nd\n"})
+
+	base, err := r.Head()
+	c.Assert(err, IsNil)
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"foo": "X\nb\nc\nd\n", "bar": "bar\n"})
+
+	from, err := r.CommitObject(base.Hash())
+	c.Assert(err, IsNil)
+	to, err := r.CommitObject(feature)
+	c.Assert(err, IsNil)
+	patch, err := from.Patch(to)
+	c.Assert(err, IsNil)
+
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	commitMergeTestFiles(c, w, map[string]string{"foo": "a\nb\nc\nD\n"})
+
+	err = w.Apply(patch, &ApplyOptions{})
+	c.Assert(err, Equals, ErrPatchDoesNotApply)
+
+	err = w.Apply(patch, &ApplyOptions{ThreeWay: true})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "X\nb\nc\nD\n")
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+	c.Assert(status.File("bar").Staging, Equals, Added)
+}
+
+func (s *WorktreeSuite) TestApplyThreeWayConflict(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "a\nb\nc\nd\n"})
+	commitMergeTestFiles(c, w, map[string]string{"foo": "A\nb\nc\nd\n"})
+
+	base := plumbing.ComputeHash(plumbing.BlobObject, []byte("a\nb\nc\nd\n"))
+	patch := decodeApplyTestPatch(c, fmt.Sprintf(`diff --git a/foo b/foo
+index %s..0123456 100644
+--- a/foo
++++ b/foo
+@@ -1,4 +1,4 @@
+-a
++X
+ b
+ c
+ d
+`, base.String()[:7]))
+
+	err := w.Apply(patch, &ApplyOptions{ThreeWay: true})
+	c.Assert(err, Equals, ErrMergeConflict)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals,
+		"<<<<<<< ours\nA\n=======\nX\n>>>>>>> theirs\nb\nc\nd\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	stages := make(map[index.Stage]plumbing.Hash)
+	for _, e := range idx.Entries {
+		if e.Name == "foo" {
+			stages[e.Stage] = e.Hash
+		}
+	}
+
+	c.Assert(stages, HasLen, 3)
+	c.Assert(stages[index.AncestorMode], Equals, base)
+	c.Assert(stages[index.OurMode], Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("A\nb\nc\nd\n")))
+	c.Assert(stages[index.TheirMode], Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("X\nb\nc\nd\n")))
+}
+
+func (s *WorktreeSuite) TestApplyUnifiedRoundTrip(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "a\nb\nc\nd\ne\nf\ng\nh\n"})
+
+	base, err := r.Head()
+	c.Assert(err, IsNil)
+	h := commitMergeTestFiles(c, w, map[string]string{"foo": "a\nB\nc\nd\ne\nf\nG\nh\n", "bar": "bar\n"})
+
+	from, err := r.CommitObject(base.Hash())
+	c.Assert(err, IsNil)
+	to, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	patch, err := from.Patch(to)
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	err = diff.NewUnifiedEncoder(buf, 1).Encode(patch)
+	c.Assert(err, IsNil)
+
+	err = w.Apply(decodeApplyTestPatch(c, buf.String()), &ApplyOptions{Index: true, Reverse: true})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nc\nd\ne\nf\ng\nh\n")
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+}
+
+const applyTestMailbox = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: John Doe <john@doe.com>
+Date: Mon, 1 Jan 2018 10:00:00 +0100
+Subject: [PATCH 1/2] Add bar
+
+Adds bar.
+---
+ bar | 1 +
+ 1 file changed, 1 insertion(+)
+
+diff --git a/bar b/bar
+new file mode 100644
+index 0000000..5716ca5
+--- /dev/null
++++ b/bar
+@@ -0,0 +1 @@
++bar
+--
+2.17.0
+
+From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Jane Doe <jane@doe.com>
+Date: Tue, 2 Jan 2018 10:00:00 +0100
+Subject: [PATCH 2/2] Change foo
+
+---
+ foo | 2 +-
+ 1 file changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/foo b/foo
+--- a/foo
++++ b/foo
+@@ -1,2 +1,2 @@
+-foo
++FOO
+ bar
+--
+2.17.0
+`
+
+func (s *WorktreeSuite) TestApplyMailbox(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\nbar\n"})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	commits, err := w.ApplyMailbox(strings.NewReader(applyTestMailbox), &ApplyMailboxOptions{
+		Committer: rebaseTestCommitter(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(commits, HasLen, 2)
+
+	ref, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name(), Equals, plumbing.Master)
+	c.Assert(ref.Hash(), Equals, commits[1])
+
+	first, err := r.CommitObject(commits[0])
+	c.Assert(err, IsNil)
+	c.Assert(first.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+	c.Assert(first.Author.Name, Equals, "John Doe")
+	c.Assert(first.Author.Email, Equals, "john@doe.com")
+	c.Assert(first.Author.When.Equal(time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)), Equals, true)
+	c.Assert(first.Committer.Name, Equals, "committer")
+	c.Assert(first.Message, Equals, "Add bar\n\nAdds bar.\n")
+
+	second, err := r.CommitObject(commits[1])
+	c.Assert(err, IsNil)
+	c.Assert(second.ParentHashes, DeepEquals, []plumbing.Hash{first.Hash})
+	c.Assert(second.Author.Name, Equals, "Jane Doe")
+	c.Assert(second.Message, Equals, "Change foo\n")
+
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "FOO\nbar\n")
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestApplyMailboxDoesNotApply(c *C) {
+	r, w, _ := mergeTestRepository(c, map[string]string{"foo": "foo\nbaz\n"})
+
+	commits, err := w.ApplyMailbox(strings.NewReader(applyTestMailbox), &ApplyMailboxOptions{
+		Committer: rebaseTestCommitter(),
+	})
+	c.Assert(err, Equals, ErrPatchDoesNotApply)
+	c.Assert(commits, HasLen, 1)
+
+	ref, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, commits[0])
+}
+
+func (s *WorktreeSuite) TestApplyMailboxEmptyPatch(c *C) {
+	_, w, _ := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	_, err := w.ApplyMailbox(strings.NewReader("From: foo@foo.com\nSubject: foo\n\nbar\n"),
+		&ApplyMailboxOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrEmptyPatch)
+}
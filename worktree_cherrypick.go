@@ -0,0 +1,209 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var (
+	// ErrMainlineRequired is returned when a merge commit is cherry-picked or
+	// reverted without a mainline parent.
+	ErrMainlineRequired = errors.New("commit is a merge but no mainline was given")
+	// ErrEmptyCommit is returned when cherry-picking or reverting a commit
+	// doesn't change the tree of HEAD.
+	ErrEmptyCommit = errors.New("the resulting commit would be empty")
+)
+
+// CherryPick applies the changes introduced by the given commit on top of
+// HEAD and commits them, preserving the author and the message of the commit,
+// and returns the hash of the new commit. The changes are computed against
+// the first parent of the commit or, for merge commits, against the parent
+// given by opts.Mainline. ErrEmptyCommit is returned if the changes are
+// already applied.
+//
+// If the changes can't be applied cleanly, ErrMergeConflict is returned and
+// no commit is created: the conflicts are recorded in the index and in the
+// worktree as Merge does. Once they are resolved and the files added, the
+// cherry-pick can be concluded with Commit.
+func (w *Worktree) CherryPick(commit plumbing.Hash, opts *CherryPickOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	c, err := w.r.CommitObject(commit)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parent, err := mainlineParent(c, opts.Mainline)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	ours, result, err := w.mergeCommitChanges(parent, c, commitLabel(c))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if opts.NoCommit {
+		return plumbing.ZeroHash, w.writeIndexEntries(result.Index)
+	}
+
+	msg := c.Message
+	if opts.RecordOrigin {
+		msg = fmt.Sprintf("%s\n\n(cherry picked from commit %s)\n", strings.TrimRight(msg, "\n"), c.Hash)
+	}
+
+	h, err := w.commitMergeResult(ours, result, msg, &CommitOptions{
+		Author:    &c.Author,
+		Committer: opts.Committer,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return h, w.reset(&ResetOptions{Mode: MergeReset, Commit: h},
+		fmt.Sprintf("cherry-pick: %s", reflogMessageSubject(c.Message)))
+}
+
+// Revert applies the reverse of the changes introduced by the given commit on
+// top of HEAD and commits them, returning the hash of the new commit. The
+// changes are computed against the first parent of the commit or, for merge
+// commits, against the parent given by opts.Mainline. ErrEmptyCommit is
+// returned if the changes are already reverted.
+//
+// If the changes can't be reverted cleanly, ErrMergeConflict is returned and
+// no commit is created: the conflicts are recorded in the index and in the
+// worktree as Merge does. Once they are resolved and the files added, the
+// revert can be concluded with Commit.
+func (w *Worktree) Revert(commit plumbing.Hash, opts *RevertOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	c, err := w.r.CommitObject(commit)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parent, err := mainlineParent(c, opts.Mainline)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	label := fmt.Sprintf("parent of %s", commitLabel(c))
+	ours, result, err := w.mergeCommitChanges(c, parent, label)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if opts.NoCommit {
+		return plumbing.ZeroHash, w.writeIndexEntries(result.Index)
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = revertMessage(c, parent)
+	}
+
+	h, err := w.commitMergeResult(ours, result, msg, &CommitOptions{
+		Author:    opts.Author,
+		Committer: opts.Committer,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return h, w.reset(&ResetOptions{Mode: MergeReset, Commit: h},
+		fmt.Sprintf("revert: %s", reflogMessageSubject(msg)))
+}
+
+// mergeCommitChanges applies the changes from base to theirs to HEAD with a
+// three-way merge, returning the commit of HEAD and the result of the merge.
+// If there are conflicts they are written to the index and the worktree and
+// ErrMergeConflict is returned.
+func (w *Worktree) mergeCommitChanges(base, theirs *object.Commit, theirsLabel string) (*object.Commit, *treeMergeResult, error) {
+	head, err := w.r.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ours, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := w.mergeCommits(base, ours, theirs, "HEAD", theirsLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(result.Conflicts) != 0 {
+		return nil, nil, w.writeMergeConflicts(result)
+	}
+
+	return ours, result, nil
+}
+
+// commitMergeResult creates a commit, child of ours, with the merged files of
+// the given result. ErrEmptyCommit is returned if the tree is the one of ours.
+func (w *Worktree) commitMergeResult(ours *object.Commit, result *treeMergeResult, msg string, opts *CommitOptions) (plumbing.Hash, error) {
+	h := &buildTreeHelper{fs: w.Filesystem, s: w.r.Storer}
+	tree, err := h.BuildTree(result.Index)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if tree == ours.TreeHash {
+		return plumbing.ZeroHash, ErrEmptyCommit
+	}
+
+	opts.Parents = []plumbing.Hash{ours.Hash}
+	return w.buildCommitObject(msg, opts, tree)
+}
+
+// mainlineParent returns the parent of the given commit the changes are
+// computed against, nil for root commits.
+func mainlineParent(c *object.Commit, mainline int) (*object.Commit, error) {
+	switch {
+	case c.NumParents() > 1 && mainline == 0:
+		return nil, ErrMainlineRequired
+	case c.NumParents() <= 1 && mainline != 0,
+		mainline > c.NumParents():
+		return nil, ErrInvalidMainline
+	case c.NumParents() == 0:
+		return nil, nil
+	case mainline == 0:
+		return c.Parent(0)
+	}
+
+	return c.Parent(mainline - 1)
+}
+
+// revertMessage returns the default message of the commit reverting c.
+func revertMessage(c, parent *object.Commit) string {
+	msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", reflogMessageSubject(c.Message), c.Hash)
+	if c.NumParents() > 1 {
+		msg += fmt.Sprintf(", reversing\nchanges made to %s", parent.Hash)
+	}
+
+	return msg + ".\n"
+}
+
+// commitLabel returns the abbreviated hash and the subject of the commit, as
+// used in the conflict markers.
+func commitLabel(c *object.Commit) string {
+	return fmt.Sprintf("%s (%s)", c.Hash.String()[:7], reflogMessageSubject(c.Message))
+}
@@ -0,0 +1,195 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WorktreeSuite) TestCherryPick(c *C) {
+	r, w, fs := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	feature, err := r.Head()
+	c.Assert(err, IsNil)
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	master, err := r.Head()
+	c.Assert(err, IsNil)
+
+	h, err := w.CherryPick(feature.Hash(), &CherryPickOptions{
+		Committer:    rebaseTestCommitter(),
+		RecordOrigin: true,
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, h)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master.Hash()})
+	c.Assert(commit.Author.Name, Equals, "D")
+	c.Assert(commit.Committer.Name, Equals, "committer")
+	c.Assert(commit.Message, Equals, "D\n\n(cherry picked from commit "+feature.Hash().String()+")\n")
+
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "A\nb\nc\nD\n")
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	_, err = w.CherryPick(feature.Hash(), &CherryPickOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrEmptyCommit)
+}
+
+func (s *WorktreeSuite) TestCherryPickNoCommit(c *C) {
+	r, w, fs := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	feature, err := r.Head()
+	c.Assert(err, IsNil)
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	master, err := r.Head()
+	c.Assert(err, IsNil)
+
+	h, err := w.CherryPick(feature.Hash(), &CherryPickOptions{NoCommit: true})
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, plumbing.ZeroHash)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, master.Hash())
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "A\nb\nc\nD\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+	c.Assert(status.File("foo").Worktree, Equals, Unmodified)
+}
+
+func (s *WorktreeSuite) TestCherryPickConflict(c *C) {
+	r, w, fs := rebaseTestRepository(c, "X\nb\nc\nd\n")
+
+	feature, err := r.Head()
+	c.Assert(err, IsNil)
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	master, err := r.Head()
+	c.Assert(err, IsNil)
+
+	_, err = w.CherryPick(feature.Hash(), &CherryPickOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, master.Hash())
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals,
+		"<<<<<<< HEAD\nA\n=======\nX\n>>>>>>> "+feature.Hash().String()[:7]+" (D)\nb\nc\nd\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	stages := 0
+	for _, e := range idx.Entries {
+		if e.Name == "foo" {
+			stages++
+		}
+	}
+
+	c.Assert(stages, Equals, 3)
+}
+
+func (s *WorktreeSuite) TestCherryPickMergeCommit(c *C) {
+	r, w, fs := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	feature, err := r.Head()
+	c.Assert(err, IsNil)
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	base, err := r.Head()
+	c.Assert(err, IsNil)
+
+	merge, err := w.Merge(&MergeOptions{
+		Commit:        feature.Hash(),
+		Author:        rebaseTestCommitter(),
+		NoFastForward: true,
+	})
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Mode: HardReset, Commit: base.Hash()})
+	c.Assert(err, IsNil)
+	commitRebaseTestFiles(c, w, "E", map[string]string{"baz": "baz\n"})
+
+	_, err = w.CherryPick(merge, &CherryPickOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMainlineRequired)
+
+	_, err = w.CherryPick(merge, &CherryPickOptions{Committer: rebaseTestCommitter(), Mainline: 3})
+	c.Assert(err, Equals, ErrInvalidMainline)
+
+	_, err = w.CherryPick(feature.Hash(), &CherryPickOptions{Committer: rebaseTestCommitter(), Mainline: 1})
+	c.Assert(err, Equals, ErrInvalidMainline)
+
+	_, err = w.CherryPick(merge, &CherryPickOptions{Committer: rebaseTestCommitter(), Mainline: 1})
+	c.Assert(err, IsNil)
+
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "A\nb\nc\nD\n")
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+	c.Assert(readMergeTestFile(c, fs, "baz"), Equals, "baz\n")
+}
+
+func (s *WorktreeSuite) TestRevert(c *C) {
+	r, w, fs := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	feature, err := r.Head()
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(feature.Hash())
+	c.Assert(err, IsNil)
+	reverted, err := commit.Parent(0)
+	c.Assert(err, IsNil)
+
+	h, err := w.Revert(reverted.Hash, &RevertOptions{Author: rebaseTestCommitter()})
+	c.Assert(err, IsNil)
+
+	commit, err = r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{feature.Hash()})
+	c.Assert(commit.Author.Name, Equals, "committer")
+	c.Assert(commit.Message, Equals, "Revert \"C\"\n\nThis reverts commit "+reverted.Hash.String()+".\n")
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "a\nb\nc\nD\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	_, err = w.Revert(reverted.Hash, &RevertOptions{Author: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrEmptyCommit)
+}
+
+func (s *WorktreeSuite) TestRevertConflict(c *C) {
+	r, w, fs := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	feature, err := r.Head()
+	c.Assert(err, IsNil)
+	commitRebaseTestFiles(c, w, "E", map[string]string{"foo": "a\nb\nc\nE\n"})
+
+	_, err = w.Revert(feature.Hash(), &RevertOptions{Author: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMergeConflict)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals,
+		"a\nb\nc\n<<<<<<< HEAD\nE\n=======\nd\n>>>>>>> parent of "+feature.Hash().String()[:7]+" (D)\n")
+}
+
+func (s *WorktreeSuite) TestRevertMissingAuthor(c *C) {
+	r, w, _ := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	_, err = w.Revert(head.Hash(), &RevertOptions{})
+	c.Assert(err, Equals, ErrMissingAuthor)
+
+	_, err = w.Revert(head.Hash(), &RevertOptions{Author: rebaseTestCommitter(), Mainline: -1})
+	c.Assert(err, Equals, ErrInvalidMainline)
+}
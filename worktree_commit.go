@@ -22,6 +22,15 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
+	merging, mergeMsg, err := w.r.mergeState()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if msg == "" && !merging.IsZero() {
+		msg = mergeMsg
+	}
+
 	if opts.All {
 		if err := w.autoAddModifiedAndDeleted(); err != nil {
 			return plumbing.ZeroHash, err
@@ -33,6 +42,12 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return plumbing.ZeroHash, ErrUnmergedChanges
+		}
+	}
+
 	h := &buildTreeHelper{
 		fs: w.Filesystem,
 		s:  w.r.Storer,
@@ -48,7 +63,29 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
-	return commit, w.updateHEAD(commit)
+	if err := w.updateHEAD(commit, opts.Committer, commitReflogMessage(msg, opts.Parents)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if merging.IsZero() {
+		return commit, nil
+	}
+
+	return commit, w.r.removeMergeState()
+}
+
+// commitReflogMessage returns the reflog message of a commit with the given
+// message and parents.
+func commitReflogMessage(msg string, parents []plumbing.Hash) string {
+	action := "commit"
+	switch {
+	case len(parents) == 0:
+		action = "commit (initial)"
+	case len(parents) > 1:
+		action = "commit (merge)"
+	}
+
+	return action + ": " + reflogMessageSubject(msg)
 }
 
 func (w *Worktree) autoAddModifiedAndDeleted() error {
@@ -70,7 +107,9 @@ func (w *Worktree) autoAddModifiedAndDeleted() error {
 	return nil
 }
 
-func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
+// updateHEAD moves HEAD, or the branch it points to, to the given commit,
+// recording the update in the reflog with the given signature and message.
+func (w *Worktree) updateHEAD(commit plumbing.Hash, sig *object.Signature, msg string) error {
 	head, err := w.r.Storer.Reference(plumbing.HEAD)
 	if err != nil {
 		return err
@@ -82,7 +121,7 @@ func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
 	}
 
 	ref := plumbing.NewHashReference(name, commit)
-	return w.r.Storer.SetReference(ref)
+	return w.r.setReferenceWithLog(ref, sig, msg)
 }
 
 func (w *Worktree) buildCommitObject(msg string, opts *CommitOptions, tree plumbing.Hash) (plumbing.Hash, error) {
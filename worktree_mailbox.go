@@ -0,0 +1,108 @@
+package git
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/mbox"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var (
+	// ErrEmptyPatch is returned when a message of a mailbox contains no
+	// patch.
+	ErrEmptyPatch = errors.New("patch is empty")
+)
+
+// ApplyMailbox applies the patches of a mailbox, such as the ones generated by
+// git format-patch, and commits each one of them on top of HEAD, as git am
+// does, returning the hashes of the new commits. The author, the date and the
+// message of the commits are taken from the messages.
+//
+// The worktree must be clean. If a patch doesn't apply, its error is returned
+// along with the commits created so far; with ApplyMailboxOptions.ThreeWay the
+// conflicts are left in the index and the worktree, and once resolved the
+// patch can be concluded with Commit.
+func (w *Worktree) ApplyMailbox(r io.Reader, opts *ApplyMailboxOptions) ([]plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return nil, err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return nil, err
+	}
+
+	var commits []plumbing.Hash
+	d := mbox.NewDecoder(r)
+	for {
+		m, err := d.Decode()
+		if err == io.EOF {
+			return commits, nil
+		}
+
+		if err != nil {
+			return commits, err
+		}
+
+		h, err := w.applyMessage(m, opts)
+		if err != nil {
+			return commits, err
+		}
+
+		commits = append(commits, h)
+	}
+}
+
+// applyMessage applies the patch of the message and commits it.
+func (w *Worktree) applyMessage(m *mbox.Message, opts *ApplyMailboxOptions) (plumbing.Hash, error) {
+	info, err := m.Info()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	patch, err := diff.NewUnifiedDecoder(strings.NewReader(info.Patch)).Decode()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(patch.FilePatches()) == 0 {
+		return plumbing.ZeroHash, ErrEmptyPatch
+	}
+
+	err = w.Apply(patch, &ApplyOptions{Index: true, ThreeWay: opts.ThreeWay, Fuzz: opts.Fuzz})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	author := &object.Signature{Name: info.Name, Email: info.Email, When: info.Date}
+	if author.When.IsZero() {
+		author.When = time.Now()
+	}
+
+	commitOpts := &CommitOptions{Author: author, Committer: opts.Committer}
+	if err := commitOpts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	h := &buildTreeHelper{fs: w.Filesystem, s: w.r.Storer}
+	tree, err := h.BuildTree(idx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit, err := w.buildCommitObject(info.Message, commitOpts, tree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return commit, w.updateHEAD(commit, opts.Committer, "am: "+info.Subject)
+}
@@ -0,0 +1,352 @@
+package git
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// mergeHead is the reference pointing to the commit being merged by a merge
+// stopped to resolve conflicts.
+const mergeHead plumbing.ReferenceName = "MERGE_HEAD"
+
+// Merge incorporates the changes from the given commit into the current
+// branch. If the merge can be resolved as a fast-forward HEAD is moved to the
+// given commit, otherwise a three-way merge between HEAD, the commit and their
+// merge base is performed and, if clean, a merge commit is created and its hash
+// returned. NoErrAlreadyUpToDate is returned if the commit is already
+// reachable from HEAD.
+//
+// As git's resolve strategy does, only one merge base is used: when HEAD and
+// the commit have several best common ancestors, as in criss-cross merges, the
+// first one is picked and no virtual base is built from all of them, so the
+// merge can conflict where the recursive strategy wouldn't.
+//
+// If the merge results in conflicts, ErrMergeConflict is returned and no
+// commit is created: the conflicting files are recorded in the index at the
+// stages 1, 2 and 3 and are written to the worktree with conflict markers.
+// The merged commit is recorded in MERGE_HEAD and the message in MERGE_MSG,
+// as git does. Once the conflicts are resolved and the files added, the merge
+// is concluded with Commit, which uses HEAD and MERGE_HEAD as parents and,
+// when no message is given, MERGE_MSG. Reset discards the merge.
+func (w *Worktree) Merge(opts *MergeOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	ours, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	theirs, err := w.r.CommitObject(opts.Commit)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(bases) == 0 {
+		return plumbing.ZeroHash, ErrUnrelatedHistories
+	}
+
+	base := bases[0]
+	if base.Hash == theirs.Hash {
+		return plumbing.ZeroHash, NoErrAlreadyUpToDate
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if base.Hash == ours.Hash && !opts.NoFastForward {
+		return theirs.Hash, w.reset(&ResetOptions{
+			Mode:   MergeReset,
+			Commit: theirs.Hash,
+		}, fmt.Sprintf("merge %s: Fast-forward", opts.Commit))
+	}
+
+	if opts.FastForwardOnly {
+		return plumbing.ZeroHash, ErrNonFastForwardUpdate
+	}
+
+	result, err := w.mergeCommits(base, ours, theirs, head.Name().Short(), opts.Commit.String())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(result.Conflicts) != 0 {
+		if err := w.r.setMergeState(theirs.Hash, opts.Message); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return plumbing.ZeroHash, w.writeMergeConflicts(result)
+	}
+
+	h := &buildTreeHelper{fs: w.Filesystem, s: w.r.Storer}
+	tree, err := h.BuildTree(result.Index)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit, err := w.buildCommitObject(opts.Message, &CommitOptions{
+		Author:    opts.Author,
+		Committer: opts.Committer,
+		Parents:   []plumbing.Hash{ours.Hash, theirs.Hash},
+		SignKey:   opts.SignKey,
+	}, tree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return commit, w.reset(&ResetOptions{
+		Mode:   MergeReset,
+		Commit: commit,
+	}, fmt.Sprintf("merge %s: Merge made by the 'resolve' strategy.", opts.Commit))
+}
+
+// setMergeState records the commit being merged, in MERGE_HEAD, and the
+// message of the merge, in MERGE_MSG if the storer supports it, for a merge
+// stopped to resolve conflicts.
+func (r *Repository) setMergeState(commit plumbing.Hash, msg string) error {
+	if err := r.Storer.SetReference(plumbing.NewHashReference(mergeHead, commit)); err != nil {
+		return err
+	}
+
+	if ms, ok := r.Storer.(storer.MergeStorer); ok {
+		return ms.SetMergeMessage(msg)
+	}
+
+	return nil
+}
+
+// mergeState returns the commit being merged and the message of the merge in
+// progress, or a zero hash if there is no merge in progress.
+func (r *Repository) mergeState() (plumbing.Hash, string, error) {
+	ref, err := r.Storer.Reference(mergeHead)
+	if err == plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, "", nil
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+
+	var msg string
+	if ms, ok := r.Storer.(storer.MergeStorer); ok {
+		if msg, err = ms.MergeMessage(); err != nil {
+			return plumbing.ZeroHash, "", err
+		}
+	}
+
+	return ref.Hash(), msg, nil
+}
+
+// removeMergeState removes the state of the merge in progress, if any.
+func (r *Repository) removeMergeState() error {
+	_, err := r.Storer.Reference(mergeHead)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := r.Storer.RemoveReference(mergeHead); err != nil {
+		return err
+	}
+
+	if ms, ok := r.Storer.(storer.MergeStorer); ok {
+		return ms.RemoveMergeMessage()
+	}
+
+	return nil
+}
+
+// mergeCommits performs a three-way merge of the trees of the given commits.
+// base and theirs can be nil, in which case an empty tree is used.
+func (w *Worktree) mergeCommits(base, ours, theirs *object.Commit, oursLabel, theirsLabel string) (*treeMergeResult, error) {
+	baseTree, err := commitTree(base)
+	if err != nil {
+		return nil, err
+	}
+
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	theirsTree, err := commitTree(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &treeMerger{s: w.r.Storer, ours: oursLabel, theirs: theirsLabel}
+	return m.Merge(baseTree, oursTree, theirsTree)
+}
+
+// commitTree returns the tree of the given commit, or nil if the commit is nil.
+func commitTree(c *object.Commit) (*object.Tree, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	return c.Tree()
+}
+
+// checkCleanForMerge returns ErrWorktreeNotClean if there are changes staged
+// or tracked files modified in the worktree, untracked files are allowed.
+func (w *Worktree) checkCleanForMerge() error {
+	s, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, fs := range s {
+		if fs.Worktree == Untracked {
+			continue
+		}
+
+		if fs.Worktree != Unmodified || fs.Staging != Unmodified {
+			return ErrWorktreeNotClean
+		}
+	}
+
+	return nil
+}
+
+// writeMergeConflicts writes the result of a merge with conflicts to the
+// index and the worktree, the worktree should contain the version of HEAD, and
+// returns ErrMergeConflict.
+func (w *Worktree) writeMergeConflicts(r *treeMergeResult) error {
+	if err := w.writeIndexEntries(r.Index); err != nil {
+		return err
+	}
+
+	for _, c := range r.Conflicts {
+		if c.Content == nil {
+			continue
+		}
+
+		if err := w.writeFileContent(c.Name, c.Mode, c.Content); err != nil {
+			return err
+		}
+	}
+
+	return ErrMergeConflict
+}
+
+// writeIndexEntries replaces the entries of the index by the given ones,
+// checking out in the worktree the files at stage 0 that differ from the
+// current index and removing the files not present in the new one.
+func (w *Worktree) writeIndexEntries(newIdx *index.Index) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]*index.Entry, len(idx.Entries))
+	for _, e := range idx.Entries {
+		current[e.Name] = e
+	}
+
+	names := make(map[string]bool, len(newIdx.Entries))
+	entries := make([]*index.Entry, 0, len(newIdx.Entries))
+	for _, e := range newIdx.Entries {
+		names[e.Name] = true
+		if e.Stage != index.Merged {
+			entries = append(entries, e)
+			continue
+		}
+
+		if c, ok := current[e.Name]; ok && c.Hash == e.Hash && c.Mode == e.Mode {
+			entries = append(entries, c)
+			continue
+		}
+
+		if e.Mode == filemode.Submodule {
+			entries = append(entries, e)
+			continue
+		}
+
+		if err := w.checkoutMergedEntry(e); err != nil {
+			return err
+		}
+
+		updated := &index.Index{}
+		if err := w.addIndexFromFile(e.Name, e.Hash, updated); err != nil {
+			return err
+		}
+
+		updated.Entries[0].Mode = e.Mode
+		entries = append(entries, updated.Entries[0])
+	}
+
+	for name := range current {
+		if names[name] {
+			continue
+		}
+
+		if err := rmFileAndDirIfEmpty(w.Filesystem, name); err != nil {
+			return err
+		}
+	}
+
+	idx.Entries = entries
+	return w.r.Storer.SetIndex(idx)
+}
+
+func (w *Worktree) checkoutMergedEntry(e *index.Entry) error {
+	blob, err := w.r.BlobObject(e.Hash)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Filesystem.Lstat(e.Name); err == nil {
+		if err := w.Filesystem.Remove(e.Name); err != nil {
+			return err
+		}
+	}
+
+	return w.checkoutFile(object.NewFile(e.Name, e.Mode, blob))
+}
+
+func (w *Worktree) writeFileContent(name string, m filemode.FileMode, content []byte) (err error) {
+	if m == filemode.Symlink {
+		if err := w.deleteFromFilesystem(name); err != nil {
+			return err
+		}
+
+		return w.Filesystem.Symlink(string(content), name)
+	}
+
+	mode, err := m.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	f, err := w.Filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	_, err = f.Write(content)
+	return
+}
@@ -0,0 +1,438 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// mergeTestRepository creates a repository with a master branch containing
+// the given files and a feature branch starting at the same commit.
+func mergeTestRepository(c *C, files map[string]string) (*Repository, *Worktree, billy.Filesystem) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitMergeTestFiles(c, w, files)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = r.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", head.Hash()))
+	c.Assert(err, IsNil)
+
+	return r, w, fs
+}
+
+func commitMergeTestFiles(c *C, w *Worktree, files map[string]string) plumbing.Hash {
+	for name, content := range files {
+		var err error
+		if content == "" {
+			_, err = w.Remove(name)
+		} else {
+			err = util.WriteFile(w.Filesystem, name, []byte(content), 0644)
+			c.Assert(err, IsNil)
+			_, err = w.Add(name)
+		}
+
+		c.Assert(err, IsNil)
+	}
+
+	h, err := w.Commit("changes\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func readMergeTestFile(c *C, fs billy.Filesystem, name string) string {
+	f, err := fs.Open(name)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	return string(content)
+}
+
+func checkoutMergeTestBranch(c *C, w *Worktree, name string) {
+	err := w.Checkout(&CheckoutOptions{Branch: plumbing.ReferenceName(name)})
+	c.Assert(err, IsNil)
+}
+
+func (s *WorktreeSuite) TestMergeInvalidOptions(c *C) {
+	_, w, _ := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	_, err := w.Merge(&MergeOptions{Author: defaultSignature()})
+	c.Assert(err, Equals, ErrMissingMergeCommit)
+
+	_, err = w.Merge(&MergeOptions{Commit: plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")})
+	c.Assert(err, Equals, ErrMissingAuthor)
+}
+
+func (s *WorktreeSuite) TestMergeAlreadyUpToDate(c *C) {
+	r, w, _ := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	commitMergeTestFiles(c, w, map[string]string{"bar": "bar\n"})
+
+	base, err := r.Reference("refs/heads/master", true)
+	c.Assert(err, IsNil)
+
+	_, err = w.Merge(&MergeOptions{Commit: base.Hash(), Author: defaultSignature()})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
+func (s *WorktreeSuite) TestMergeFastForward(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"bar": "bar\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+
+	h, err := w.Merge(&MergeOptions{Commit: feature, Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, feature)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name().String(), Equals, "refs/heads/master")
+	c.Assert(head.Hash(), Equals, feature)
+
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+}
+
+func (s *WorktreeSuite) TestMergeFastForwardOnly(c *C) {
+	_, w, _ := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"bar": "bar\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	commitMergeTestFiles(c, w, map[string]string{"qux": "qux\n"})
+
+	_, err := w.Merge(&MergeOptions{
+		Commit:          feature,
+		Author:          defaultSignature(),
+		FastForwardOnly: true,
+	})
+
+	c.Assert(err, Equals, ErrNonFastForwardUpdate)
+}
+
+func (s *WorktreeSuite) TestMergeClean(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{
+		"foo":     "a\nb\nc\nd\ne\nf\n",
+		"bar":     "bar\n",
+		"dir/qux": "qux\n",
+	})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{
+		"foo":     "A\nb\nc\nd\ne\nf\n",
+		"dir/baz": "baz\n",
+		"bar":     "",
+	})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	master := commitMergeTestFiles(c, w, map[string]string{
+		"foo": "a\nb\nc\nd\ne\nF\n",
+		"new": "new\n",
+	})
+
+	h, err := w.Merge(&MergeOptions{Commit: feature, Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master, feature})
+	c.Assert(commit.Message, Equals, "Merge commit '"+feature.String()+"'\n")
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name().String(), Equals, "refs/heads/master")
+	c.Assert(head.Hash(), Equals, h)
+
+	entries, err := r.Storer.(storer.ReflogStorer).Reflog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(entries[len(entries)-1].Message, Equals,
+		"merge "+feature.String()+": Merge made by the 'resolve' strategy.")
+
+	expected := map[string]string{
+		"foo":     "A\nb\nc\nd\ne\nF\n",
+		"new":     "new\n",
+		"dir/qux": "qux\n",
+		"dir/baz": "baz\n",
+	}
+
+	for name, content := range expected {
+		f, err := commit.File(name)
+		c.Assert(err, IsNil)
+
+		fromCommit, err := f.Contents()
+		c.Assert(err, IsNil)
+		c.Assert(fromCommit, Equals, content)
+
+		c.Assert(readMergeTestFile(c, fs, name), Equals, content)
+	}
+
+	_, err = commit.File("bar")
+	c.Assert(err, NotNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestMergeConflict(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "a\nb\nc\n",
+		"bar": "bar\n",
+	})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{
+		"foo": "a\nX\nc\n",
+		"bar": "BAR\n",
+	})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	master := commitMergeTestFiles(c, w, map[string]string{
+		"foo": "a\nB\nc\n",
+	})
+
+	h, err := w.Merge(&MergeOptions{Commit: feature, Author: defaultSignature()})
+	c.Assert(err, Equals, ErrMergeConflict)
+	c.Assert(h.IsZero(), Equals, true)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, master)
+
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, ""+
+		"a\n"+
+		"<<<<<<< master\n"+
+		"B\n"+
+		"=======\n"+
+		"X\n"+
+		">>>>>>> "+feature.String()+"\n"+
+		"c\n",
+	)
+
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "BAR\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	var stages []index.Stage
+	for _, e := range idx.Entries {
+		if e.Name == "foo" {
+			stages = append(stages, e.Stage)
+		}
+	}
+
+	c.Assert(stages, DeepEquals, []index.Stage{
+		index.AncestorMode, index.OurMode, index.TheirMode,
+	})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, UpdatedButUnmerged)
+	c.Assert(status.File("bar").Staging, Equals, Modified)
+
+	_, err = w.Commit("merge\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, Equals, ErrUnmergedChanges)
+
+	err = util.WriteFile(fs, "foo", []byte("a\nBX\nc\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+
+	h, err = w.Commit("", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master, feature})
+	c.Assert(commit.Message, Equals, "Merge commit '"+feature.String()+"'\n")
+
+	_, err = r.Reference(mergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	msg, err := r.Storer.(storer.MergeStorer).MergeMessage()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "")
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestMergeConflictReset(c *C) {
+	r, w, _ := mergeTestRepository(c, map[string]string{"foo": "a\nb\nc\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"foo": "a\nX\nc\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	master := commitMergeTestFiles(c, w, map[string]string{"foo": "a\nB\nc\n"})
+
+	_, err := w.Merge(&MergeOptions{
+		Commit:  feature,
+		Author:  defaultSignature(),
+		Message: "foo\n",
+	})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	ref, err := r.Reference(mergeHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, feature)
+	msg, err := r.Storer.(storer.MergeStorer).MergeMessage()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "foo\n")
+
+	c.Assert(w.Reset(&ResetOptions{Mode: HardReset}), IsNil)
+
+	_, err = r.Reference(mergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	h, err := w.Commit("bar\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master})
+}
+
+func (s *WorktreeSuite) TestMergeConfigAuthor(c *C) {
+	r, w, _ := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"bar": "bar\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	commitMergeTestFiles(c, w, map[string]string{"qux": "qux\n"})
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("user").SetOption("name", "foo")
+	cfg.Raw.Section("user").SetOption("email", "foo@foo.foo")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	h, err := w.Merge(&MergeOptions{Commit: feature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "foo")
+	c.Assert(commit.Author.Email, Equals, "foo@foo.foo")
+	c.Assert(commit.Committer.Name, Equals, "foo")
+}
+
+func (s *WorktreeSuite) TestMergeModifyDeleteConflict(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "foo\n",
+		"bar": "bar\n",
+	})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"foo": "FOO\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	commitMergeTestFiles(c, w, map[string]string{"foo": ""})
+
+	_, err := w.Merge(&MergeOptions{Commit: feature, Author: defaultSignature()})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "FOO\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	var stages []index.Stage
+	for _, e := range idx.Entries {
+		if e.Name == "foo" {
+			stages = append(stages, e.Stage)
+		}
+	}
+
+	c.Assert(stages, DeepEquals, []index.Stage{index.AncestorMode, index.TheirMode})
+}
+
+func (s *WorktreeSuite) TestMergeNotClean(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	feature := commitMergeTestFiles(c, w, map[string]string{"bar": "bar\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+	commitMergeTestFiles(c, w, map[string]string{"qux": "qux\n"})
+
+	err := util.WriteFile(fs, "foo", []byte("modified\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Merge(&MergeOptions{Commit: feature, Author: defaultSignature()})
+	c.Assert(err, Equals, ErrWorktreeNotClean)
+}
+
+func (s *WorktreeSuite) TestPullMerge(c *C) {
+	url := c.MkDir()
+	server, err := PlainInit(url, false)
+	c.Assert(err, IsNil)
+
+	sw, err := server.Worktree()
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(url, "foo"), []byte("foo\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = sw.Add("foo")
+	c.Assert(err, IsNil)
+	_, err = sw.Commit("foo", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(url, "bar"), []byte("bar\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = sw.Add("bar")
+	c.Assert(err, IsNil)
+	remoteHash, err := sw.Commit("bar", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "qux"), []byte("qux\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("qux")
+	c.Assert(err, IsNil)
+	localHash, err := w.Commit("qux", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	err = w.Pull(&PullOptions{})
+	c.Assert(err, Equals, ErrNonFastForwardUpdate)
+
+	err = w.Pull(&PullOptions{Mode: MergePull, Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{localHash, remoteHash})
+	c.Assert(commit.Message, Equals, "Merge branch 'master' of "+url+"\n")
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "bar"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "bar\n")
+}
@@ -0,0 +1,516 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/rebase"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// origHead is the reference pointing to the commit HEAD pointed to before a
+// rebase.
+const origHead plumbing.ReferenceName = "ORIG_HEAD"
+
+var (
+	// ErrRebaseNotSupported is returned when the storer can't keep the state
+	// of a rebase.
+	ErrRebaseNotSupported = errors.New("rebase not supported by the storer")
+	// ErrRebaseInProgress is returned when a rebase is started while another
+	// one is in progress.
+	ErrRebaseInProgress = errors.New("a rebase is already in progress")
+	// ErrNoRebaseInProgress is returned when a rebase is continued, skipped
+	// or aborted but there isn't any rebase in progress.
+	ErrNoRebaseInProgress = errors.New("no rebase in progress")
+)
+
+// Rebase replays the commits of the current branch, or of opts.Branch, not
+// reachable from opts.Upstream onto opts.Onto, one by one and preserving their
+// authors, and moves the branch to the last replayed commit. The merge commits
+// are not replayed and the commits whose changes are already applied are
+// dropped. NoErrAlreadyUpToDate is returned if the branch is already based on
+// opts.Onto.
+//
+// If the changes of a commit can't be applied cleanly, the rebase stops and
+// ErrMergeConflict is returned: the conflicts are recorded in the index and in
+// the worktree as Merge does. Once the conflicts are resolved and the files
+// added, the rebase can be resumed with RebaseContinue, or the commit can be
+// dropped with RebaseSkip. RebaseAbort restores the branch as it was before
+// the rebase. The state of the rebase is kept by the storer, in the
+// rebase-merge directory in the case of the filesystem storage, so a stopped
+// rebase can be resumed by another process.
+func (w *Worktree) Rebase(opts *RebaseOptions) error {
+	rs, err := w.rebaseStorer()
+	if err != nil {
+		return err
+	}
+
+	if st, err := rs.RebaseState(); err != nil || st != nil {
+		if err == nil {
+			err = ErrRebaseInProgress
+		}
+
+		return err
+	}
+
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return err
+	}
+
+	if opts.Branch != "" {
+		if err := w.Checkout(&CheckoutOptions{Branch: opts.Branch}); err != nil {
+			return err
+		}
+	}
+
+	head, err := w.r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	var headName plumbing.ReferenceName
+	if head.Type() == plumbing.SymbolicReference {
+		headName = head.Target()
+	}
+
+	headCommit, err := w.r.CommitObject(resolvedReferenceHash(w.r.Storer, plumbing.HEAD))
+	if err != nil {
+		return err
+	}
+
+	upstream, err := w.r.CommitObject(opts.Upstream)
+	if err != nil {
+		return err
+	}
+
+	commits, err := rebaseCommits(headCommit, upstream)
+	if err != nil {
+		return err
+	}
+
+	if isBasedOn(headCommit.Hash, opts.Onto, commits) {
+		return NoErrAlreadyUpToDate
+	}
+
+	st := &rebase.State{
+		HeadName: headName,
+		Onto:     opts.Onto,
+		OrigHead: headCommit.Hash,
+	}
+
+	for _, c := range commits {
+		st.Todo = append(st.Todo, &rebase.Command{
+			Action:  rebase.Pick,
+			Hash:    c.Hash,
+			Subject: reflogMessageSubject(c.Message),
+		})
+	}
+
+	err = w.r.Storer.SetReference(plumbing.NewHashReference(origHead, headCommit.Hash))
+	if err != nil {
+		return err
+	}
+
+	// HEAD is detached while the commits are replayed
+	err = w.r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, headCommit.Hash))
+	if err != nil {
+		return err
+	}
+
+	err = w.reset(&ResetOptions{
+		Mode:   MergeReset,
+		Commit: opts.Onto,
+	}, fmt.Sprintf("rebase (start): checkout %s", opts.Onto))
+	if err != nil {
+		return err
+	}
+
+	if err := rs.SetRebaseState(st); err != nil {
+		return err
+	}
+
+	return w.rebaseRun(rs, st, opts.Committer)
+}
+
+// RebaseContinue resumes a rebase stopped by a conflict. The changes in the
+// index are committed, preserving the author and the message of the commit
+// that couldn't be applied, and the remaining commits are replayed. If the
+// index doesn't contain changes the commit is dropped.
+func (w *Worktree) RebaseContinue(opts *RebaseContinueOptions) error {
+	rs, st, err := w.rebaseState()
+	if err != nil {
+		return err
+	}
+
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
+	if !st.Stopped.IsZero() {
+		if err := w.rebaseCommitIndex(st.Stopped, opts.Committer); err != nil {
+			return err
+		}
+
+		st.Stopped = plumbing.ZeroHash
+		if err := rs.SetRebaseState(st); err != nil {
+			return err
+		}
+	}
+
+	return w.rebaseRun(rs, st, opts.Committer)
+}
+
+// RebaseSkip resumes a rebase stopped by a conflict, dropping the commit that
+// couldn't be applied and discarding the changes in the index and the
+// worktree.
+func (w *Worktree) RebaseSkip(opts *RebaseContinueOptions) error {
+	rs, st, err := w.rebaseState()
+	if err != nil {
+		return err
+	}
+
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
+	if err := w.resetUnmerged(""); err != nil {
+		return err
+	}
+
+	st.Stopped = plumbing.ZeroHash
+	if err := rs.SetRebaseState(st); err != nil {
+		return err
+	}
+
+	return w.rebaseRun(rs, st, opts.Committer)
+}
+
+// RebaseAbort stops the rebase in progress, restoring HEAD, the index and the
+// worktree as they were before the rebase.
+func (w *Worktree) RebaseAbort() error {
+	rs, st, err := w.rebaseState()
+	if err != nil {
+		return err
+	}
+
+	err = w.r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, st.OrigHead))
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetUnmerged(fmt.Sprintf("rebase (abort): returning to %s", rebaseHeadName(st))); err != nil {
+		return err
+	}
+
+	if st.HeadName != "" {
+		err := w.r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, st.HeadName))
+		if err != nil {
+			return err
+		}
+	}
+
+	return rs.RemoveRebaseState()
+}
+
+// rebaseRun replays the pending commits of the rebase, storing its state after
+// every one, and finishes the rebase.
+func (w *Worktree) rebaseRun(rs storer.RebaseStorer, st *rebase.State, committer *object.Signature) error {
+	for len(st.Todo) != 0 {
+		cmd := st.Todo[0]
+		err := w.rebasePick(cmd.Hash, committer)
+		if err != nil && err != ErrMergeConflict {
+			return err
+		}
+
+		st.Todo = st.Todo[1:]
+		st.Done = append(st.Done, cmd)
+		if err == ErrMergeConflict {
+			st.Stopped = cmd.Hash
+		}
+
+		if err := rs.SetRebaseState(st); err != nil {
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.rebaseFinish(rs, st)
+}
+
+// rebasePick applies the changes of the given commit on top of HEAD and
+// commits them. ErrMergeConflict is returned if they can't be applied cleanly.
+func (w *Worktree) rebasePick(h plumbing.Hash, committer *object.Signature) error {
+	commit, err := w.r.CommitObject(h)
+	if err != nil {
+		return err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("rebase (pick): %s", reflogMessageSubject(commit.Message))
+
+	// the commit is reused when it's already based on HEAD
+	if len(commit.ParentHashes) != 0 && commit.ParentHashes[0] == head.Hash() {
+		return w.reset(&ResetOptions{Mode: MergeReset, Commit: commit.Hash}, msg)
+	}
+
+	var base *object.Commit
+	if len(commit.ParentHashes) != 0 {
+		if base, err = commit.Parent(0); err != nil {
+			return err
+		}
+	}
+
+	ours, result, err := w.mergeCommitChanges(base, commit, commitLabel(commit))
+	if err != nil {
+		return err
+	}
+
+	replayed, err := w.commitMergeResult(ours, result, commit.Message, &CommitOptions{
+		Author:    &commit.Author,
+		Committer: committer,
+	})
+
+	// the changes are already applied
+	if err == ErrEmptyCommit {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return w.reset(&ResetOptions{Mode: MergeReset, Commit: replayed}, msg)
+}
+
+// rebaseCommitIndex commits the index, with the author and message of the
+// given commit, on top of HEAD. Nothing is committed if the index doesn't
+// contain changes.
+func (w *Worktree) rebaseCommitIndex(h plumbing.Hash, committer *object.Signature) error {
+	commit, err := w.r.CommitObject(h)
+	if err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return ErrUnmergedChanges
+		}
+	}
+
+	unstaged, err := w.containsUnstagedChanges()
+	if err != nil {
+		return err
+	}
+
+	if unstaged {
+		return ErrUnstagedChanges
+	}
+
+	head, err := w.r.CommitObject(resolvedReferenceHash(w.r.Storer, plumbing.HEAD))
+	if err != nil {
+		return err
+	}
+
+	bth := &buildTreeHelper{fs: w.Filesystem, s: w.r.Storer}
+	tree, err := bth.BuildTree(idx)
+	if err != nil {
+		return err
+	}
+
+	if tree == head.TreeHash {
+		return nil
+	}
+
+	replayed, err := w.buildCommitObject(commit.Message, &CommitOptions{
+		Author:    &commit.Author,
+		Committer: committer,
+		Parents:   []plumbing.Hash{head.Hash},
+	}, tree)
+	if err != nil {
+		return err
+	}
+
+	return w.setHEADCommit(replayed, fmt.Sprintf("rebase (continue): %s", reflogMessageSubject(commit.Message)))
+}
+
+// rebaseFinish moves the rebased branch to HEAD, checks it out and removes
+// the state of the rebase.
+func (w *Worktree) rebaseFinish(rs storer.RebaseStorer, st *rebase.State) error {
+	if st.HeadName != "" {
+		head := resolvedReferenceHash(w.r.Storer, plumbing.HEAD)
+		err := w.r.setReferenceWithLog(
+			plumbing.NewHashReference(st.HeadName, head), nil,
+			fmt.Sprintf("rebase (finish): %s onto %s", st.HeadName, st.Onto),
+		)
+		if err != nil {
+			return err
+		}
+
+		err = w.r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, st.HeadName))
+		if err != nil {
+			return err
+		}
+
+		err = w.r.logReferenceUpdate(plumbing.HEAD, head, head, nil,
+			fmt.Sprintf("rebase (finish): returning to %s", st.HeadName),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rs.RemoveRebaseState()
+}
+
+// resetUnmerged performs a hard reset to HEAD, discarding the conflicts
+// recorded in the index and the worktree.
+func (w *Worktree) resetUnmerged(msg string) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	// the conflicting files are recorded as a single entry, in order to be
+	// restored, or removed if they aren't in HEAD, by the reset
+	seen := make(map[string]bool, len(idx.Entries))
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if seen[e.Name] {
+			continue
+		}
+
+		seen[e.Name] = true
+		e.Stage = index.Merged
+		entries = append(entries, e)
+	}
+
+	idx.Entries = entries
+	if err := w.r.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	head := resolvedReferenceHash(w.r.Storer, plumbing.HEAD)
+	return w.reset(&ResetOptions{Mode: HardReset, Commit: head}, msg)
+}
+
+// rebaseState returns the storer and the state of the rebase in progress, or
+// ErrNoRebaseInProgress.
+func (w *Worktree) rebaseState() (storer.RebaseStorer, *rebase.State, error) {
+	rs, err := w.rebaseStorer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := rs.RebaseState()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if st == nil {
+		return nil, nil, ErrNoRebaseInProgress
+	}
+
+	return rs, st, nil
+}
+
+func (w *Worktree) rebaseStorer() (storer.RebaseStorer, error) {
+	rs, ok := w.r.Storer.(storer.RebaseStorer)
+	if !ok {
+		return nil, ErrRebaseNotSupported
+	}
+
+	return rs, nil
+}
+
+// rebaseCommits returns the commits reachable from head and not from
+// upstream, parents first, except the merge commits.
+func rebaseCommits(head, upstream *object.Commit) ([]*object.Commit, error) {
+	bases, err := head.MergeBase(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(map[plumbing.Hash]bool, len(bases))
+	for _, b := range bases {
+		stop[b.Hash] = true
+	}
+
+	var commits []*object.Commit
+	var merges bool
+
+	var walk func(c *object.Commit) error
+	walk = func(c *object.Commit) error {
+		if stop[c.Hash] {
+			return nil
+		}
+
+		stop[c.Hash] = true
+
+		// while the history is linear the walk ends at the merge base, after a
+		// merge the commits can be reachable from upstream by other paths
+		if merges {
+			reachable, err := c.IsAncestor(upstream)
+			if err != nil || reachable {
+				return err
+			}
+		}
+
+		if c.NumParents() > 1 {
+			merges = true
+		}
+
+		if err := c.Parents().ForEach(walk); err != nil {
+			return err
+		}
+
+		if c.NumParents() <= 1 {
+			commits = append(commits, c)
+		}
+
+		return nil
+	}
+
+	return commits, walk(head)
+}
+
+// isBasedOn returns true if the given commits are a linear history based on
+// onto and ending at head, so replaying them onto it would result in head.
+func isBasedOn(head, onto plumbing.Hash, commits []*object.Commit) bool {
+	parent := onto
+	for _, c := range commits {
+		if len(c.ParentHashes) == 0 || c.ParentHashes[0] != parent {
+			return false
+		}
+
+		parent = c.Hash
+	}
+
+	return parent == head
+}
+
+func rebaseHeadName(st *rebase.State) string {
+	if st.HeadName == "" {
+		return st.OrigHead.String()
+	}
+
+	return st.HeadName.String()
+}
@@ -0,0 +1,376 @@
+package git
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// rebaseTestRepository creates a repository with the following history, where
+// B changes the first line of foo, C adds bar and D writes the given content
+// to foo:
+//
+//	A---B  master
+//	 \
+//	  C---D  feature
+//
+// The feature branch is checked out.
+func rebaseTestRepository(c *C, foo string) (*Repository, *Worktree, billy.Filesystem) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "a\nb\nc\nd\n"})
+
+	commitRebaseTestFiles(c, w, "B", map[string]string{"foo": "A\nb\nc\nd\n"})
+
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	commitRebaseTestFiles(c, w, "C", map[string]string{"bar": "bar\n"})
+	commitRebaseTestFiles(c, w, "D", map[string]string{"foo": foo})
+
+	return r, w, fs
+}
+
+func commitRebaseTestFiles(c *C, w *Worktree, msg string, files map[string]string) plumbing.Hash {
+	for name, content := range files {
+		err := writeRebaseTestFile(w.Filesystem, name, content)
+		c.Assert(err, IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	author := &object.Signature{
+		Name:  msg,
+		Email: "author@example.com",
+		When:  time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	h, err := w.Commit(msg+"\n", &CommitOptions{Author: author})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func writeRebaseTestFile(fs billy.Filesystem, name, content string) error {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write([]byte(content)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func rebaseTestCommitter() *object.Signature {
+	return &object.Signature{
+		Name:  "committer",
+		Email: "committer@example.com",
+		When:  time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// rebaseTestLog returns the messages of the first parents of HEAD.
+func rebaseTestLog(c *C, r *Repository) []string {
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+
+	var msgs []string
+	for {
+		msgs = append([]string{commit.Message}, msgs...)
+		if commit.NumParents() == 0 {
+			return msgs
+		}
+
+		commit, err = commit.Parent(0)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *WorktreeSuite) TestRebase(c *C) {
+	r, w, fs := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = w.Rebase(&RebaseOptions{
+		Upstream:  master.Hash(),
+		Committer: rebaseTestCommitter(),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.SymbolicReference)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+
+	c.Assert(rebaseTestLog(c, r), DeepEquals, []string{"changes\n", "B\n", "C\n", "D\n"})
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "A\nb\nc\nD\n")
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+
+	tip, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(tip.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "D")
+	c.Assert(commit.Committer.Name, Equals, "committer")
+
+	origHead, err := r.Reference(origHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(origHead.Hash(), Equals, orig.Hash())
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	st, err := r.Storer.(storer.RebaseStorer).RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st, IsNil)
+
+	entries, err := r.Storer.(storer.ReflogStorer).Reflog("refs/heads/feature")
+	c.Assert(err, IsNil)
+	c.Assert(entries[len(entries)-1].Message, Equals,
+		"rebase (finish): refs/heads/feature onto "+master.Hash().String())
+
+	err = w.Rebase(&RebaseOptions{
+		Upstream:  master.Hash(),
+		Committer: rebaseTestCommitter(),
+	})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
+func (s *WorktreeSuite) TestRebaseBranchWithConfiguredUpstream(c *C) {
+	r, w, _ := rebaseTestRepository(c, "a\nb\nc\nD\n")
+	checkoutMergeTestBranch(c, w, "refs/heads/master")
+
+	err := w.Rebase(&RebaseOptions{
+		Branch:    "refs/heads/feature",
+		Committer: rebaseTestCommitter(),
+	})
+	c.Assert(err, Equals, ErrNoUpstream)
+
+	err = r.CreateBranch(&config.Branch{
+		Name:   "feature",
+		Remote: ".",
+		Merge:  plumbing.Master,
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.SetOption("user", "", "name", "bar")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	err = w.Rebase(&RebaseOptions{Branch: "refs/heads/feature"})
+	c.Assert(err, IsNil)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+	c.Assert(rebaseTestLog(c, r), DeepEquals, []string{"changes\n", "B\n", "C\n", "D\n"})
+
+	tip, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(tip.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Committer.Name, Equals, "bar")
+}
+
+func (s *WorktreeSuite) TestRebaseFastForward(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+	master := commitRebaseTestFiles(c, w, "B", map[string]string{"foo": "foo master\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+
+	err := w.Rebase(&RebaseOptions{Upstream: master, Committer: rebaseTestCommitter()})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+	c.Assert(head.Hash(), Equals, master)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo master\n")
+}
+
+func (s *WorktreeSuite) TestRebaseDropsAppliedCommits(c *C) {
+	r, w, _ := rebaseTestRepository(c, "A\nb\nc\nd\n")
+
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master.Hash(), Committer: rebaseTestCommitter()})
+	c.Assert(err, IsNil)
+	c.Assert(rebaseTestLog(c, r), DeepEquals, []string{"changes\n", "B\n", "C\n"})
+}
+
+func (s *WorktreeSuite) TestRebaseConflictAndContinue(c *C) {
+	r, w, fs := rebaseTestRepository(c, "X\nb\nc\nd\n")
+
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master.Hash(), Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	st, err := r.Storer.(storer.RebaseStorer).RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st.HeadName, Equals, plumbing.ReferenceName("refs/heads/feature"))
+	c.Assert(st.Todo, HasLen, 0)
+	c.Assert(st.Done, HasLen, 2)
+	c.Assert(st.Stopped, Equals, st.Done[1].Hash)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.HashReference)
+
+	c.Assert(readMergeTestFile(c, fs, "foo"), Matches, "(?s)<<<<<<< HEAD\nA\n=======\nX\n>>>>>>> [0-9a-f]{7} \\(D\\)\nb\nc\nd\n")
+
+	err = w.Rebase(&RebaseOptions{Upstream: master.Hash(), Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrRebaseInProgress)
+
+	err = w.RebaseContinue(&RebaseContinueOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrUnmergedChanges)
+
+	err = writeRebaseTestFile(fs, "foo", "foo resolved\n")
+	c.Assert(err, IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	err = w.RebaseContinue(&RebaseContinueOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, IsNil)
+
+	c.Assert(rebaseTestLog(c, r), DeepEquals, []string{"changes\n", "B\n", "C\n", "D\n"})
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo resolved\n")
+
+	head, err = r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+
+	tip, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(tip.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "D")
+
+	err = w.RebaseContinue(&RebaseContinueOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrNoRebaseInProgress)
+}
+
+func (s *WorktreeSuite) TestRebaseSkip(c *C) {
+	r, w, fs := rebaseTestRepository(c, "X\nb\nc\nd\n")
+
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master.Hash(), Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	err = w.RebaseSkip(&RebaseContinueOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, IsNil)
+
+	c.Assert(rebaseTestLog(c, r), DeepEquals, []string{"changes\n", "B\n", "C\n"})
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "A\nb\nc\nd\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestRebaseAbort(c *C) {
+	r, w, fs := rebaseTestRepository(c, "X\nb\nc\nd\n")
+
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	err = w.RebaseAbort()
+	c.Assert(err, Equals, ErrNoRebaseInProgress)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master.Hash(), Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	err = w.RebaseAbort()
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+	c.Assert(head.Hash(), Equals, orig.Hash())
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "X\nb\nc\nd\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	st, err := r.Storer.(storer.RebaseStorer).RebaseState()
+	c.Assert(err, IsNil)
+	c.Assert(st, IsNil)
+}
+
+func (s *WorktreeSuite) TestRebaseResumeFromFilesystem(c *C) {
+	dot, fs := memfs.New(), memfs.New()
+	st, err := filesystem.NewStorage(dot)
+	c.Assert(err, IsNil)
+
+	r, err := Init(st, fs)
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	base := commitRebaseTestFiles(c, w, "A", map[string]string{"foo": "foo\n"})
+	err = r.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", base))
+	c.Assert(err, IsNil)
+	master := commitRebaseTestFiles(c, w, "B", map[string]string{"foo": "foo master\n"})
+	checkoutMergeTestBranch(c, w, "refs/heads/feature")
+	commitRebaseTestFiles(c, w, "C", map[string]string{"foo": "foo feature\n"})
+	commitRebaseTestFiles(c, w, "D", map[string]string{"bar": "bar\n"})
+
+	err = w.Rebase(&RebaseOptions{Upstream: master, Committer: rebaseTestCommitter()})
+	c.Assert(err, Equals, ErrMergeConflict)
+
+	_, err = dot.Stat("rebase-merge/git-rebase-todo")
+	c.Assert(err, IsNil)
+
+	// a new process opens the repository and resumes the rebase
+	st, err = filesystem.NewStorage(dot)
+	c.Assert(err, IsNil)
+	r, err = Open(st, fs)
+	c.Assert(err, IsNil)
+	w, err = r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = writeRebaseTestFile(fs, "foo", "foo resolved\n")
+	c.Assert(err, IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	err = w.RebaseContinue(&RebaseContinueOptions{Committer: rebaseTestCommitter()})
+	c.Assert(err, IsNil)
+
+	c.Assert(rebaseTestLog(c, r), DeepEquals, []string{"A\n", "B\n", "C\n", "D\n"})
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+
+	_, err = dot.Stat("rebase-merge")
+	c.Assert(err, NotNil)
+}
+
+func (s *WorktreeSuite) TestRebaseInvalidOptions(c *C) {
+	_, w, _ := rebaseTestRepository(c, "a\nb\nc\nD\n")
+
+	err := w.Rebase(&RebaseOptions{Branch: "refs/tags/v1.0.0"})
+	c.Assert(err, Equals, ErrInvalidReference)
+
+	err = w.Rebase(&RebaseOptions{Upstream: plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")})
+	c.Assert(err, Equals, ErrMissingCommitter)
+}
@@ -0,0 +1,588 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// StashRefName is the reference pointing to the most recent stash entry, the
+// older ones are kept in its reflog.
+const StashRefName plumbing.ReferenceName = "refs/stash"
+
+var (
+	// ErrNoLocalChanges is returned by StashPush when there are no changes to
+	// stash.
+	ErrNoLocalChanges = errors.New("no local changes to save")
+	// ErrStashNotFound is returned when the requested stash entry doesn't
+	// exist.
+	ErrStashNotFound = errors.New("stash entry not found")
+	// ErrStashIndexConflict is returned when the changes of the index of a
+	// stash entry can't be restored without conflicts.
+	ErrStashIndexConflict = errors.New("conflicts in index, try without restoring the index")
+	// ErrUntrackedFileExists is returned when applying a stash entry would
+	// overwrite an untracked file of the worktree.
+	ErrUntrackedFileExists = errors.New("untracked file already exists")
+)
+
+// StashEntry is an entry of the stash list.
+type StashEntry struct {
+	// Index of the entry, 0 being the most recent one, as in stash@{0}.
+	Index int
+	// Hash of the commit holding the state of the worktree.
+	Hash plumbing.Hash
+	// Message describing the entry.
+	Message string
+}
+
+func (e *StashEntry) String() string {
+	return fmt.Sprintf("stash@{%d}: %s", e.Index, e.Message)
+}
+
+// StashPush records the changes of the index and the worktree in a new stash
+// entry and reverts them to HEAD. The entry is the standard git stash commit:
+// its first parent is HEAD, the second one a commit with the content of the
+// index and, if opts.IncludeUntracked is set, the third one a commit with the
+// untracked files. The hash of the entry is returned and recorded in
+// refs/stash, the previous entries are kept in its reflog. ErrNoLocalChanges
+// is returned if there is nothing to stash.
+func (w *Worktree) StashPush(opts *StashOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	headCommit, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return plumbing.ZeroHash, ErrUnmergedChanges
+		}
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var changed bool
+	var untracked []string
+	for path, fs := range status {
+		switch {
+		case fs.Staging == Untracked && fs.Worktree == Untracked:
+			untracked = append(untracked, path)
+		case fs.Worktree != Unmodified || fs.Staging != Unmodified:
+			changed = true
+		}
+	}
+
+	if !opts.IncludeUntracked {
+		untracked = nil
+	}
+
+	if !changed && len(untracked) == 0 {
+		return plumbing.ZeroHash, ErrNoLocalChanges
+	}
+
+	sort.Strings(untracked)
+
+	branch := "(no branch)"
+	if head.Name().IsBranch() {
+		branch = head.Name().Short()
+	}
+
+	desc := fmt.Sprintf("%s: %s %s", branch, head.Hash().String()[:7], reflogMessageSubject(headCommit.Message))
+	commitOpts := &CommitOptions{Author: opts.Author, Committer: opts.Committer}
+
+	indexTree, err := w.buildStashTree(idx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commitOpts.Parents = []plumbing.Hash{head.Hash()}
+	indexCommit, err := w.buildCommitObject("index on "+desc+"\n", commitOpts, indexTree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parents := []plumbing.Hash{head.Hash(), indexCommit}
+	if len(untracked) != 0 {
+		untrackedIdx := &index.Index{Version: 2}
+		for _, path := range untracked {
+			if err := w.addStashFile(untrackedIdx, path); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+
+		tree, err := w.buildStashTree(untrackedIdx)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		commitOpts.Parents = nil
+		commit, err := w.buildCommitObject("untracked files on "+desc+"\n", commitOpts, tree)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		parents = append(parents, commit)
+	}
+
+	worktreeIdx := &index.Index{Version: 2}
+	for _, e := range idx.Entries {
+		entry := *e
+		worktreeIdx.Entries = append(worktreeIdx.Entries, &entry)
+	}
+
+	for path, fs := range status {
+		switch {
+		case fs.Worktree == Modified,
+			// removed from the index but kept in the worktree
+			fs.Worktree == Untracked && fs.Staging != Untracked:
+			if err := w.addStashFile(worktreeIdx, path); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		case fs.Worktree == Deleted:
+			if _, err := worktreeIdx.Remove(path); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+	}
+
+	worktreeTree, err := w.buildStashTree(worktreeIdx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	msg := "WIP on " + desc
+	if opts.Message != "" {
+		msg = fmt.Sprintf("On %s: %s", branch, opts.Message)
+	}
+
+	commitOpts.Parents = parents
+	stash, err := w.buildCommitObject(msg+"\n", commitOpts, worktreeTree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.updateStashRef(stash, opts.Committer, msg); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.resetKeepingUntracked(&ResetOptions{Mode: HardReset, Commit: head.Hash()}, "reset: moving to HEAD"); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, path := range untracked {
+		if err := rmFileAndDirIfEmpty(w.Filesystem, path); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	if opts.KeepIndex {
+		idx, err := w.treeIndex(indexTree)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if err := w.writeIndexEntries(idx); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return stash, nil
+}
+
+// addStashFile stores the given file of the worktree and adds it to idx.
+func (w *Worktree) addStashFile(idx *index.Index, path string) error {
+	h, err := w.copyFileToStorage(path)
+	if err != nil {
+		return err
+	}
+
+	return w.addOrUpdateFileToIndex(idx, path, h)
+}
+
+// buildStashTree builds the tree of the given index, BuildTree expects the
+// entries sorted by name.
+func (w *Worktree) buildStashTree(idx *index.Index) (plumbing.Hash, error) {
+	sorted := &index.Index{Version: idx.Version}
+	sorted.Entries = append(sorted.Entries, idx.Entries...)
+	sort.Slice(sorted.Entries, func(i, j int) bool {
+		return sorted.Entries[i].Name < sorted.Entries[j].Name
+	})
+
+	h := &buildTreeHelper{fs: w.Filesystem, s: w.r.Storer}
+	return h.BuildTree(sorted)
+}
+
+// treeIndex returns an index with the files of the given tree.
+func (w *Worktree) treeIndex(h plumbing.Hash) (*index.Index, error) {
+	t, err := w.r.TreeObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := treeFiles(t)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index.Index{Version: 2}
+	for name, e := range files {
+		idx.Entries = append(idx.Entries, &index.Entry{
+			Name: name,
+			Hash: e.Hash,
+			Mode: e.Mode,
+		})
+	}
+
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		return idx.Entries[i].Name < idx.Entries[j].Name
+	})
+
+	return idx, nil
+}
+
+func (w *Worktree) updateStashRef(stash plumbing.Hash, committer *object.Signature, msg string) error {
+	old := plumbing.ZeroHash
+	ref, err := w.r.Storer.Reference(StashRefName)
+	switch err {
+	case nil:
+		old = ref.Hash()
+	case plumbing.ErrReferenceNotFound:
+	default:
+		return err
+	}
+
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(StashRefName, stash)); err != nil {
+		return err
+	}
+
+	rs, ok := w.r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil
+	}
+
+	return rs.AppendReflog(StashRefName, &reflog.Entry{
+		Old:     old,
+		New:     stash,
+		Name:    committer.Name,
+		Email:   committer.Email,
+		When:    committer.When,
+		Message: msg,
+	})
+}
+
+// StashList returns the stash entries, the most recent first.
+func (w *Worktree) StashList() ([]*StashEntry, error) {
+	ref, err := w.r.Storer.Reference(StashRefName)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := w.stashReflog()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		// without reflog only the most recent entry is known
+		commit, err := w.r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		return []*StashEntry{{Hash: ref.Hash(), Message: reflogMessageSubject(commit.Message)}}, nil
+	}
+
+	list := make([]*StashEntry, len(entries))
+	for i := range entries {
+		e := entries[len(entries)-1-i]
+		list[i] = &StashEntry{Index: i, Hash: e.New, Message: e.Message}
+	}
+
+	return list, nil
+}
+
+func (w *Worktree) stashReflog() ([]*reflog.Entry, error) {
+	rs, ok := w.r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil, nil
+	}
+
+	return rs.Reflog(StashRefName)
+}
+
+func (w *Worktree) stashEntry(n int) (*StashEntry, error) {
+	list, err := w.StashList()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n >= len(list) {
+		return nil, ErrStashNotFound
+	}
+
+	return list[n], nil
+}
+
+// StashApply restores the changes recorded in a stash entry, merging them
+// with the current HEAD. The worktree must not contain changes in the tracked
+// files. The changes are left unstaged, except the new files, unless
+// opts.RestoreIndex is set. If the changes can't be merged, ErrMergeConflict
+// is returned and the conflicts are recorded in the index and the worktree,
+// as Merge does. If opts is nil the most recent entry is applied.
+func (w *Worktree) StashApply(opts *StashApplyOptions) error {
+	if opts == nil {
+		opts = &StashApplyOptions{}
+	}
+
+	entry, err := w.stashEntry(opts.Index)
+	if err != nil {
+		return err
+	}
+
+	stash, err := w.r.CommitObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+
+	if len(stash.ParentHashes) < 2 {
+		return fmt.Errorf("%s is not a stash commit", stash.Hash)
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	ours, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	base, err := w.r.CommitObject(stash.ParentHashes[0])
+	if err != nil {
+		return err
+	}
+
+	var untracked map[string]*object.TreeEntry
+	if len(stash.ParentHashes) > 2 {
+		if untracked, err = w.stashUntrackedFiles(stash.ParentHashes[2]); err != nil {
+			return err
+		}
+	}
+
+	var restored *treeMergeResult
+	if opts.RestoreIndex {
+		indexCommit, err := w.r.CommitObject(stash.ParentHashes[1])
+		if err != nil {
+			return err
+		}
+
+		restored, err = w.mergeCommits(base, ours, indexCommit, "Updated upstream", "Stashed changes")
+		if err != nil {
+			return err
+		}
+
+		if len(restored.Conflicts) != 0 {
+			return ErrStashIndexConflict
+		}
+	}
+
+	result, err := w.mergeCommits(base, ours, stash, "Updated upstream", "Stashed changes")
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) != 0 {
+		if err := w.writeMergeConflicts(result); err != ErrMergeConflict {
+			return err
+		}
+
+		if err := w.writeStashUntrackedFiles(untracked); err != nil {
+			return err
+		}
+
+		return ErrMergeConflict
+	}
+
+	if err := w.writeIndexEntries(result.Index); err != nil {
+		return err
+	}
+
+	if err := w.resetStashIndex(ours, result, restored); err != nil {
+		return err
+	}
+
+	return w.writeStashUntrackedFiles(untracked)
+}
+
+// resetStashIndex leaves in the index, after applying a stash entry, the
+// files of HEAD plus the files added by the stash or, if restored is not nil,
+// its files.
+func (w *Worktree) resetStashIndex(head *object.Commit, result, restored *treeMergeResult) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]*index.Entry, len(idx.Entries))
+	for _, e := range idx.Entries {
+		current[e.Name] = e
+	}
+
+	target := restored
+	if target == nil {
+		tree, err := head.Tree()
+		if err != nil {
+			return err
+		}
+
+		files, err := treeFiles(tree)
+		if err != nil {
+			return err
+		}
+
+		target = &treeMergeResult{Index: &index.Index{}}
+		for name, e := range files {
+			target.add(name, e)
+		}
+
+		for _, e := range result.Index.Entries {
+			if _, ok := files[e.Name]; !ok {
+				target.Index.Entries = append(target.Index.Entries, e)
+			}
+		}
+	}
+
+	entries := make([]*index.Entry, 0, len(target.Index.Entries))
+	for _, e := range target.Index.Entries {
+		if c, ok := current[e.Name]; ok && c.Hash == e.Hash && c.Mode == e.Mode {
+			e = c
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	idx.Entries = entries
+	return w.r.Storer.SetIndex(idx)
+}
+
+func (w *Worktree) stashUntrackedFiles(commit plumbing.Hash) (map[string]*object.TreeEntry, error) {
+	c, err := w.r.CommitObject(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := treeFiles(t)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range files {
+		if _, err := w.Filesystem.Lstat(name); err == nil {
+			return nil, ErrUntrackedFileExists
+		}
+	}
+
+	return files, nil
+}
+
+func (w *Worktree) writeStashUntrackedFiles(files map[string]*object.TreeEntry) error {
+	for name, e := range files {
+		if err := w.checkoutMergedEntry(&index.Entry{Name: name, Hash: e.Hash, Mode: e.Mode}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StashPop applies the stash entry with the given index, 0 being the most
+// recent one, and drops it if it was applied without conflicts.
+func (w *Worktree) StashPop(opts *StashApplyOptions) error {
+	if opts == nil {
+		opts = &StashApplyOptions{}
+	}
+
+	if err := w.StashApply(opts); err != nil {
+		return err
+	}
+
+	return w.StashDrop(opts.Index)
+}
+
+// StashDrop removes the stash entry with the given index, 0 being the most
+// recent one.
+func (w *Worktree) StashDrop(n int) error {
+	if _, err := w.stashEntry(n); err != nil {
+		return err
+	}
+
+	entries, err := w.stashReflog()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) <= 1 {
+		if err := w.r.Storer.RemoveReference(StashRefName); err != nil {
+			return err
+		}
+
+		if rs, ok := w.r.Storer.(storer.ReflogStorer); ok {
+			return rs.RemoveReflog(StashRefName)
+		}
+
+		return nil
+	}
+
+	i := len(entries) - 1 - n
+	if i+1 < len(entries) {
+		entries[i+1].Old = entries[i].Old
+	}
+
+	entries = append(entries[:i], entries[i+1:]...)
+	last := entries[len(entries)-1]
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(StashRefName, last.New)); err != nil {
+		return err
+	}
+
+	return w.r.Storer.(storer.ReflogStorer).SetReflog(StashRefName, entries)
+}
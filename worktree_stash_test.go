@@ -0,0 +1,368 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *WorktreeSuite) TestStashPushAndPop(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "foo\n",
+		"bar": "bar\n",
+		"qux": "qux\n",
+	})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(fs, "foo", []byte("foo staged\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "bar", []byte("bar modified\n"), 0644)
+	c.Assert(err, IsNil)
+	err = fs.Remove("qux")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "new", []byte("new\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("new")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "untracked", []byte("untracked\n"), 0644)
+	c.Assert(err, IsNil)
+
+	h, err := w.StashPush(&StashOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference(StashRefName, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, h)
+
+	stash, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(stash.ParentHashes, HasLen, 2)
+	c.Assert(stash.ParentHashes[0], Equals, head.Hash())
+	c.Assert(stash.Message, Equals, "WIP on master: "+head.Hash().String()[:7]+" changes\n")
+
+	for name, expected := range map[string]string{
+		"foo": "foo staged\n",
+		"bar": "bar modified\n",
+		"new": "new\n",
+	} {
+		f, err := stash.File(name)
+		c.Assert(err, IsNil)
+		content, err := f.Contents()
+		c.Assert(err, IsNil)
+		c.Assert(content, Equals, expected)
+	}
+
+	_, err = stash.File("qux")
+	c.Assert(err, NotNil)
+
+	indexCommit, err := r.CommitObject(stash.ParentHashes[1])
+	c.Assert(err, IsNil)
+	f, err := indexCommit.File("bar")
+	c.Assert(err, IsNil)
+	content, err := f.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "bar\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.IsUntracked("untracked"), Equals, true)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo\n")
+	c.Assert(readMergeTestFile(c, fs, "qux"), Equals, "qux\n")
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+	c.Assert(list[0].Hash, Equals, h)
+	c.Assert(list[0].String(), Equals, "stash@{0}: WIP on master: "+head.Hash().String()[:7]+" changes")
+
+	err = w.StashPop(nil)
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Unmodified)
+	c.Assert(status.File("foo").Worktree, Equals, Modified)
+	c.Assert(status.File("bar").Worktree, Equals, Modified)
+	c.Assert(status.File("qux").Worktree, Equals, Deleted)
+	c.Assert(status.File("new").Staging, Equals, Added)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo staged\n")
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar modified\n")
+
+	list, err = w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+
+	_, err = r.Reference(StashRefName, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *WorktreeSuite) TestStashPushNothingToStash(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	err := util.WriteFile(fs, "untracked", []byte("untracked\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: defaultSignature()})
+	c.Assert(err, Equals, ErrNoLocalChanges)
+
+	_, err = w.StashPush(&StashOptions{})
+	c.Assert(err, Equals, ErrMissingAuthor)
+}
+
+func (s *WorktreeSuite) TestStashPushIncludeUntracked(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	err := util.WriteFile(fs, "dir/untracked", []byte("untracked\n"), 0644)
+	c.Assert(err, IsNil)
+
+	h, err := w.StashPush(&StashOptions{
+		Author:           defaultSignature(),
+		Message:          "my changes",
+		IncludeUntracked: true,
+	})
+	c.Assert(err, IsNil)
+
+	stash, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(stash.ParentHashes, HasLen, 3)
+	c.Assert(stash.Message, Equals, "On master: my changes\n")
+
+	untracked, err := r.CommitObject(stash.ParentHashes[2])
+	c.Assert(err, IsNil)
+	c.Assert(untracked.ParentHashes, HasLen, 0)
+	_, err = untracked.File("dir/untracked")
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("dir/untracked")
+	c.Assert(err, NotNil)
+
+	err = util.WriteFile(fs, "dir/untracked", []byte("other\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = w.StashApply(nil)
+	c.Assert(err, Equals, ErrUntrackedFileExists)
+
+	err = fs.Remove("dir/untracked")
+	c.Assert(err, IsNil)
+
+	err = w.StashApply(nil)
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "dir/untracked"), Equals, "untracked\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsUntracked("dir/untracked"), Equals, true)
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+}
+
+func (s *WorktreeSuite) TestStashPushRemovedFromIndex(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n", "bar": "bar\n"})
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	_, err = idx.Remove("foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.SetIndex(idx), IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Deleted)
+	c.Assert(status.File("foo").Worktree, Equals, Untracked)
+
+	h, err := w.StashPush(&StashOptions{
+		Author:           defaultSignature(),
+		IncludeUntracked: true,
+	})
+	c.Assert(err, IsNil)
+
+	stash, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(stash.ParentHashes, HasLen, 2)
+	_, err = stash.File("foo")
+	c.Assert(err, IsNil)
+
+	indexCommit, err := r.CommitObject(stash.ParentHashes[1])
+	c.Assert(err, IsNil)
+	_, err = indexCommit.File("foo")
+	c.Assert(err, NotNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo\n")
+
+	err = w.StashPop(&StashApplyOptions{RestoreIndex: true})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("foo").Staging, Equals, Deleted)
+	c.Assert(status.File("foo").Worktree, Equals, Untracked)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo\n")
+}
+
+func (s *WorktreeSuite) TestStashPushKeepIndex(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n", "bar": "bar\n"})
+
+	err := util.WriteFile(fs, "foo", []byte("foo staged\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "bar", []byte("bar modified\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: defaultSignature(), KeepIndex: true})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+	c.Assert(status.File("foo").Worktree, Equals, Unmodified)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "foo staged\n")
+	c.Assert(readMergeTestFile(c, fs, "bar"), Equals, "bar\n")
+}
+
+func (s *WorktreeSuite) TestStashApplyRestoreIndex(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n", "bar": "bar\n"})
+
+	err := util.WriteFile(fs, "foo", []byte("foo staged\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "bar", []byte("bar modified\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	err = w.StashApply(&StashApplyOptions{RestoreIndex: true})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+	c.Assert(status.File("foo").Worktree, Equals, Unmodified)
+	c.Assert(status.File("bar").Staging, Equals, Unmodified)
+	c.Assert(status.File("bar").Worktree, Equals, Modified)
+}
+
+func (s *WorktreeSuite) TestStashApplyOnNewHead(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{
+		"foo": "1\n2\n3\n4\n5\n",
+		"bar": "bar\n",
+	})
+
+	err := util.WriteFile(fs, "foo", []byte("1\n2\n3\n4\nfive\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commitMergeTestFiles(c, w, map[string]string{
+		"foo": "one\n2\n3\n4\n5\n",
+		"bar": "",
+	})
+
+	err = w.StashApply(nil)
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "one\n2\n3\n4\nfive\n")
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("foo").Worktree, Equals, Modified)
+}
+
+func (s *WorktreeSuite) TestStashApplyConflict(c *C) {
+	_, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	err := util.WriteFile(fs, "foo", []byte("stashed\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commitMergeTestFiles(c, w, map[string]string{"foo": "committed\n"})
+
+	err = w.StashPop(nil)
+	c.Assert(err, Equals, ErrMergeConflict)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals,
+		"<<<<<<< Updated upstream\ncommitted\n=======\nstashed\n>>>>>>> Stashed changes\n")
+
+	idx, err := w.r.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 3)
+	c.Assert(idx.Entries[0].Stage, Equals, index.AncestorMode)
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+
+	err = w.StashApply(nil)
+	c.Assert(err, Equals, ErrWorktreeNotClean)
+}
+
+func (s *WorktreeSuite) TestStashListAndDrop(c *C) {
+	r, w, fs := mergeTestRepository(c, map[string]string{"foo": "foo\n"})
+
+	var hashes []plumbing.Hash
+	for _, content := range []string{"one\n", "two\n", "three\n"} {
+		err := util.WriteFile(fs, "foo", []byte(content), 0644)
+		c.Assert(err, IsNil)
+
+		h, err := w.StashPush(&StashOptions{Author: defaultSignature(), Message: content[:len(content)-1]})
+		c.Assert(err, IsNil)
+		hashes = append(hashes, h)
+	}
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 3)
+	c.Assert(list[0].String(), Equals, "stash@{0}: On master: three")
+	c.Assert(list[1].String(), Equals, "stash@{1}: On master: two")
+	c.Assert(list[2].Hash, Equals, hashes[0])
+
+	err = w.StashDrop(3)
+	c.Assert(err, Equals, ErrStashNotFound)
+
+	err = w.StashDrop(1)
+	c.Assert(err, IsNil)
+
+	list, err = w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].Hash, Equals, hashes[2])
+	c.Assert(list[1].Hash, Equals, hashes[0])
+
+	entries, err := r.Storer.(storer.ReflogStorer).Reflog(StashRefName)
+	c.Assert(err, IsNil)
+	c.Assert(entries[1].Old, Equals, hashes[0])
+
+	err = w.StashDrop(0)
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference(StashRefName, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, hashes[0])
+
+	err = w.StashApply(&StashApplyOptions{Index: 0})
+	c.Assert(err, IsNil)
+	c.Assert(readMergeTestFile(c, fs, "foo"), Equals, "one\n")
+}
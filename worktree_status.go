@@ -2,15 +2,21 @@ package git
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	formatcfg "gopkg.in/src-d/go-git.v4/plumbing/format/config"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
@@ -30,7 +36,9 @@ var (
 	ErrGlobNoMatches = errors.New("glob pattern did not match any files")
 )
 
-// Status returns the working tree status.
+// Status returns the working tree status. Staged renames and copies are
+// detected honoring the status.renames and diff.renames options, like git
+// status does.
 func (w *Worktree) Status() (Status, error) {
 	var hash plumbing.Hash
 
@@ -54,12 +62,21 @@ func (w *Worktree) status(commit plumbing.Hash) (Status, error) {
 		return nil, err
 	}
 
+	renames, err := w.detectStagedRenames(left)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, ch := range left {
 		a, err := ch.Action()
 		if err != nil {
 			return nil, err
 		}
 
+		if a == merkletrie.Delete && renames.sources[ch.From.String()] {
+			continue
+		}
+
 		fs := s.File(nameFromAction(&ch))
 		fs.Worktree = Unmodified
 
@@ -67,7 +84,15 @@ func (w *Worktree) status(commit plumbing.Hash) (Status, error) {
 		case merkletrie.Delete:
 			s.File(ch.From.String()).Staging = Deleted
 		case merkletrie.Insert:
-			s.File(ch.To.String()).Staging = Added
+			fs.Staging = Added
+			if r, ok := renames.destinations[ch.To.String()]; ok {
+				fs.Staging = Renamed
+				if r.Copy {
+					fs.Staging = Copied
+				}
+
+				fs.Extra = r.From.Name
+			}
 		case merkletrie.Modify:
 			s.File(ch.To.String()).Staging = Modified
 		}
@@ -94,15 +119,168 @@ func (w *Worktree) status(commit plumbing.Hash) (Status, error) {
 			fs.Worktree = Deleted
 		case merkletrie.Insert:
 			fs.Worktree = Untracked
-			fs.Staging = Untracked
+			if fs.Staging == Unmodified {
+				fs.Staging = Untracked
+			}
 		case merkletrie.Modify:
 			fs.Worktree = Modified
 		}
 	}
 
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage == index.Merged {
+			continue
+		}
+
+		fs := s.File(e.Name)
+		fs.Staging = UpdatedButUnmerged
+		fs.Worktree = UpdatedButUnmerged
+	}
+
 	return s, nil
 }
 
+// stagedRenames contains the renames and copies found in the staging area.
+type stagedRenames struct {
+	// sources contains the paths renamed.
+	sources map[string]bool
+	// destinations contains the rename or copy of each destination path.
+	destinations map[string]*object.Change
+}
+
+// detectStagedRenames finds the renames and copies between the given changes
+// from HEAD to the staging area, honoring the status.renames, diff.renames,
+// status.renameLimit and diff.renameLimit options, like git status does.
+func (w *Worktree) detectStagedRenames(changes merkletrie.Changes) (*stagedRenames, error) {
+	r := &stagedRenames{
+		sources:      make(map[string]bool),
+		destinations: make(map[string]*object.Change),
+	}
+
+	opts, err := w.statusRenameOptions()
+	if err != nil || !opts.DetectRenames {
+		return r, err
+	}
+
+	var candidates object.Changes
+	for _, ch := range changes {
+		candidates = append(candidates, &object.Change{
+			From: noderChangeEntry(ch.From),
+			To:   noderChangeEntry(ch.To),
+		})
+	}
+
+	detected, err := object.DetectRenames(w.r.Storer, candidates, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ch := range detected {
+		if !ch.IsRename() && !ch.IsCopy() {
+			continue
+		}
+
+		if !ch.Copy {
+			r.sources[ch.From.Name] = true
+		}
+
+		r.destinations[ch.To.Name] = ch
+	}
+
+	return r, nil
+}
+
+// excludesFilePatterns returns the patterns of the excludes file configured
+// in core.excludesfile, with a lower priority than the ones of the .gitignore
+// files.
+func (w *Worktree) excludesFilePatterns() ([]gitignore.Pattern, error) {
+	cfg, err := w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, err
+	}
+
+	path := configOption(cfg.Raw, "core", "excludesfile")
+	return gitignore.LoadExcludesFile(osfs.New(""), path)
+}
+
+func (w *Worktree) statusRenameOptions() (*object.DiffTreeOptions, error) {
+	cfg, err := w.r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := *object.DefaultDiffTreeOptions
+	renames := configOption(cfg.Raw, "status", "renames")
+	if renames == "" {
+		renames = configOption(cfg.Raw, "diff", "renames")
+	}
+
+	switch strings.ToLower(renames) {
+	case "false", "no", "off", "0":
+		opts.DetectRenames = false
+	case "copies", "copy":
+		opts.DetectCopies = true
+	}
+
+	limit := configOption(cfg.Raw, "status", "renameLimit")
+	if limit == "" {
+		limit = configOption(cfg.Raw, "diff", "renameLimit")
+	}
+
+	if limit != "" {
+		n, err := strconv.ParseUint(limit, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.RenameLimit = uint(n)
+	}
+
+	return &opts, nil
+}
+
+// configOption returns the value of the given option, without adding the
+// section to the config if it doesn't exist.
+func configOption(raw *formatcfg.Config, section, key string) string {
+	for _, s := range raw.Sections {
+		if s.IsName(section) {
+			return s.Option(key)
+		}
+	}
+
+	return ""
+}
+
+// noderChangeEntry returns an object.ChangeEntry with the path, hash and mode
+// of the given tree or index node, the Tree of the entry is not set.
+func noderChangeEntry(p noder.Path) object.ChangeEntry {
+	if p == nil {
+		return object.ChangeEntry{}
+	}
+
+	h := p.Last().Hash()
+	if len(h) != 24 {
+		return object.ChangeEntry{Name: p.String()}
+	}
+
+	var hash plumbing.Hash
+	copy(hash[:], h[:20])
+
+	return object.ChangeEntry{
+		Name: p.String(),
+		TreeEntry: object.TreeEntry{
+			Name: p.Last().Name(),
+			Hash: hash,
+			Mode: filemode.FileMode(binary.LittleEndian.Uint32(h[20:])),
+		},
+	}
+}
+
 func nameFromAction(ch *merkletrie.Change) string {
 	name := ch.To.String()
 	if name == "" {
@@ -137,16 +315,26 @@ func (w *Worktree) diffStagingWithWorktree(reverse bool) (merkletrie.Changes, er
 		return nil, err
 	}
 
-	return w.excludeIgnoredChanges(c), nil
+	return w.excludeIgnoredChanges(c)
 }
 
-func (w *Worktree) excludeIgnoredChanges(changes merkletrie.Changes) merkletrie.Changes {
+func (w *Worktree) excludeIgnoredChanges(changes merkletrie.Changes) (merkletrie.Changes, error) {
+	excludes, err := w.excludesFilePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	// a worktree not created yet has no .gitignore files
 	patterns, err := gitignore.ReadPatterns(w.Filesystem, nil)
-	if err != nil || len(patterns) == 0 {
-		return changes
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
 
+	patterns = append(excludes, patterns...)
 	patterns = append(patterns, w.Excludes...)
+	if len(patterns) == 0 {
+		return changes, nil
+	}
 
 	m := gitignore.NewMatcher(patterns)
 
@@ -169,7 +357,7 @@ func (w *Worktree) excludeIgnoredChanges(changes merkletrie.Changes) merkletrie.
 		}
 		res = append(res, ch)
 	}
-	return res
+	return res, nil
 }
 
 func (w *Worktree) getSubmodulesStatus() (map[string]plumbing.Hash, error) {
@@ -384,6 +572,13 @@ func (w *Worktree) doAddFile(idx *index.Index, s Status, path string) (added boo
 		return false, h, nil
 	}
 
+	if s.File(path).Staging == UpdatedButUnmerged {
+		// the conflict stages are replaced by the resolved file
+		if _, err := w.deleteFromIndex(idx, path); err != nil {
+			return false, h, err
+		}
+	}
+
 	h, err = w.copyFileToStorage(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -577,6 +772,11 @@ func (w *Worktree) deleteFromIndex(idx *index.Index, path string) (plumbing.Hash
 		return plumbing.ZeroHash, err
 	}
 
+	// removes the remaining stages of an unmerged path
+	for err == nil {
+		_, err = idx.Remove(path)
+	}
+
 	return e.Hash, nil
 }
 
@@ -1530,9 +1530,9 @@ func (s *WorktreeSuite) TestMove(c *C) {
 
 	status, err := w.Status()
 	c.Assert(err, IsNil)
-	c.Assert(status, HasLen, 2)
-	c.Assert(status.File("LICENSE").Staging, Equals, Deleted)
-	c.Assert(status.File("foo").Staging, Equals, Added)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("foo").Staging, Equals, Renamed)
+	c.Assert(status.File("foo").Extra, Equals, "LICENSE")
 
 }
 
@@ -1910,3 +1910,60 @@ func (s *WorktreeSuite) TestAddAndCommit(c *C) {
 	})
 	c.Assert(err, IsNil)
 }
+
+func (s *WorktreeSuite) TestStatusRenamed(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	content := []byte("foo\nbar\nqux\nquux\ncorge\n")
+	c.Assert(util.WriteFile(w.Filesystem, "foo", content, 0644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	_, err = w.Move("foo", "bar")
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("bar").Staging, Equals, Renamed)
+	c.Assert(status.File("bar").Worktree, Equals, Unmodified)
+	c.Assert(status.File("bar").Extra, Equals, "foo")
+	c.Assert(status.String(), Equals, "R  foo -> bar\n")
+
+	c.Assert(util.WriteFile(w.Filesystem, "baz", content, 0644), IsNil)
+	_, err = w.Add("baz")
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("baz").Staging, Equals, Added)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.SetOption("status", "", "renames", "copies")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("bar").Staging, Equals, Renamed)
+	c.Assert(status.File("baz").Staging, Equals, Copied)
+	c.Assert(status.File("baz").Extra, Equals, "foo")
+
+	cfg.Raw.SetOption("status", "", "renames", "false")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 3)
+	c.Assert(status.File("foo").Staging, Equals, Deleted)
+	c.Assert(status.File("bar").Staging, Equals, Added)
+}